@@ -191,7 +191,7 @@ func (cmd *Command) dump() error {
 			tsEncoding := timeEnc[int(ts[0]>>4)]
 			vEncoding := encDescs[int(blockType+1)][values[0]>>4]
 
-			typeDesc := blockTypes[blockType]
+			typeDesc := tsm1.BlockTypeToInfluxQLDataType(blockType).String()
 
 			blockStats.inc(0, ts[0]>>4)
 			blockStats.inc(int(blockType+1), values[0]>>4)
@@ -284,10 +284,7 @@ Usage: influx_inspect dumptsm [flags] <path
 
 var (
 	fieldType = []string{
-		"timestamp", "float", "int", "bool", "string",
-	}
-	blockTypes = []string{
-		"float64", "int64", "bool", "string",
+		"timestamp", "float", "integer", "boolean", "string",
 	}
 	timeEnc = []string{
 		"none", "s8b", "rle",
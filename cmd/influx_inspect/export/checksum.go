@@ -0,0 +1,277 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// writeChecksums writes a digest of the points of each series in each
+// exported database and retention policy, instead of exporting the points,
+// followed by a digest of each database rolling up the digests of its
+// series. The points of a series are merged across its shards and WAL
+// segments and sorted by field and time before they are hashed, with later
+// files replacing the values of earlier ones, so two stores holding the same
+// points have the same digests however their files are laid out.
+//
+// Each series is written as a line holding the database, retention policy,
+// series key and digest, and each database as a line holding "database",
+// the database and its digest, separated by tabs. No padding is added, so the
+// output of two nodes can be compared with diff.
+func (cmd *Command) writeChecksums(w io.Writer) error {
+	keys := make([]string, 0, len(cmd.manifest))
+	for key := range cmd.manifest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var db string
+	var rollup hash.Hash
+	writeRollup := func() {
+		if rollup != nil {
+			fmt.Fprintf(w, "database\t%s\t%s\n", db, hex.EncodeToString(rollup.Sum(nil)))
+		}
+	}
+	for _, key := range keys {
+		dbrp := strings.Split(key, string(byte(os.PathSeparator)))
+		if rollup == nil || dbrp[0] != db {
+			writeRollup()
+			db, rollup = dbrp[0], sha256.New()
+		}
+
+		sums, err := cmd.seriesChecksums(key)
+		if err != nil {
+			return err
+		}
+		for _, s := range sums {
+			line := strings.Join([]string{dbrp[0], dbrp[1], s.series, s.sum}, "\t") + "\n"
+			rollup.Write([]byte(line))
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	writeRollup()
+	return nil
+}
+
+// seriesChecksum is the digest of the points of a series.
+type seriesChecksum struct {
+	series string
+	sum    string
+}
+
+// seriesChecksums returns the digest of each series of the database and
+// retention policy key, ordered by series. The keys of TSM files are sorted,
+// so the files are read side by side and each series is hashed as soon as
+// its values are merged, holding only one key's values and the digests in
+// memory. WAL segments are not sorted, so their values, bounded by the size
+// of the cache, are read up front.
+func (cmd *Command) seriesChecksums(key string) ([]seriesChecksum, error) {
+	wal := make(map[string][]tsm1.Value)
+	for _, f := range cmd.walFiles[key] {
+		if err := cmd.readWAL(f, func(k string, values []tsm1.Value) {
+			if cmd.selected([]byte(k)) {
+				wal[k] = append(wal[k], values...)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	walKeys := make([]string, 0, len(wal))
+	for k := range wal {
+		walKeys = append(walKeys, k)
+	}
+	sort.Strings(walKeys)
+
+	var readers []*tsmCursor
+	defer func() {
+		for _, c := range readers {
+			c.close()
+		}
+	}()
+	for _, f := range cmd.tsmFiles[key] {
+		c, err := openTSMCursor(f)
+		if err != nil {
+			return nil, err
+		} else if c != nil {
+			readers = append(readers, c)
+		}
+	}
+
+	var sums []seriesChecksum
+	var series string
+	var h *seriesHash
+	finish := func() {
+		if h != nil {
+			sums = append(sums, seriesChecksum{
+				series: string(cmd.redactSeries([]byte(series))),
+				sum:    hex.EncodeToString(h.Sum()),
+			})
+		}
+	}
+
+	for {
+		if cmd.interrupted() {
+			return nil, ErrInterrupted
+		}
+
+		// Values of the smallest key left in any file are merged in file
+		// order, followed by the WAL, so later values replace earlier ones.
+		var k string
+		for _, c := range readers {
+			if ck, ok := c.key(); ok && (k == "" || ck < k) {
+				k = ck
+			}
+		}
+		if len(walKeys) > 0 && (k == "" || walKeys[0] < k) {
+			k = walKeys[0]
+		}
+		if k == "" {
+			break
+		}
+
+		selected := cmd.selected([]byte(k))
+		var values []tsm1.Value
+		for _, c := range readers {
+			if ck, ok := c.key(); !ok || ck != k {
+				continue
+			}
+			c.i++
+			if !selected {
+				continue
+			}
+			a, err := cmd.readValues(c.r, k)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, a...)
+		}
+		if len(walKeys) > 0 && walKeys[0] == k {
+			values = append(values, wal[k]...)
+			walKeys = walKeys[1:]
+		}
+		if !selected {
+			continue
+		}
+		values = tsm1.Values(values).Deduplicate().Include(cmd.startTime, cmd.endTime)
+
+		s, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+		if h == nil || string(s) != series {
+			finish()
+			series, h = string(s), newSeriesHash()
+		}
+		h.writeField(string(field), values)
+	}
+	finish()
+
+	sort.Sort(seriesChecksumSlice(sums))
+	return sums, nil
+}
+
+type seriesChecksumSlice []seriesChecksum
+
+func (a seriesChecksumSlice) Len() int           { return len(a) }
+func (a seriesChecksumSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a seriesChecksumSlice) Less(i, j int) bool { return a[i].series < a[j].series }
+
+// tsmCursor reads the keys of a TSM file in order.
+type tsmCursor struct {
+	f *os.File
+	r *tsm1.TSMReader
+	i int
+}
+
+// openTSMCursor returns a cursor at the first key of the TSM file at path.
+// It returns nil if the file can not be read as a TSM file, which the export
+// skips as well.
+func openTSMCursor(path string) (*tsmCursor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil
+	}
+	return &tsmCursor{f: f, r: r}, nil
+}
+
+// key returns the key at the cursor, or false once all keys are read.
+func (c *tsmCursor) key() (string, bool) {
+	if c.i >= c.r.KeyCount() {
+		return "", false
+	}
+	k, _ := c.r.KeyAt(c.i)
+	return string(k), true
+}
+
+func (c *tsmCursor) close() {
+	c.r.Close()
+	c.f.Close()
+}
+
+// seriesHash computes the SHA-256 digest of the points of the fields of a
+// series, written in order of field and time. Each value is hashed with its
+// type and exact binary representation, so formatting does not affect the
+// digest.
+type seriesHash struct {
+	h   hash.Hash
+	buf [8]byte
+}
+
+func newSeriesHash() *seriesHash {
+	return &seriesHash{h: sha256.New()}
+}
+
+// writeField hashes the name of a field and its values, sorted by time.
+func (h *seriesHash) writeField(name string, values []tsm1.Value) {
+	h.writeString(name)
+	h.writeUint64(uint64(len(values)))
+	for _, v := range values {
+		h.writeUint64(uint64(v.UnixNano()))
+		switch v := v.Value().(type) {
+		case float64:
+			h.h.Write([]byte{'f'})
+			h.writeUint64(math.Float64bits(v))
+		case int64:
+			h.h.Write([]byte{'i'})
+			h.writeUint64(uint64(v))
+		case bool:
+			h.h.Write([]byte{'b'})
+			if v {
+				h.writeUint64(1)
+			} else {
+				h.writeUint64(0)
+			}
+		case string:
+			h.h.Write([]byte{'s'})
+			h.writeString(v)
+		}
+	}
+}
+
+// Sum returns the digest of the fields written so far.
+func (h *seriesHash) Sum() []byte {
+	return h.h.Sum(nil)
+}
+
+func (h *seriesHash) writeString(s string) {
+	h.writeUint64(uint64(len(s)))
+	io.WriteString(h.h, s)
+}
+
+func (h *seriesHash) writeUint64(v uint64) {
+	binary.BigEndian.PutUint64(h.buf[:], v)
+	h.h.Write(h.buf[:])
+}
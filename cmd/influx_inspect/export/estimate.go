@@ -0,0 +1,140 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/retailnext/hllpp"
+)
+
+// sizeEstimate is the estimated size of the export of a database.
+type sizeEstimate struct {
+	series *hllpp.HLLPP
+	lines  int64
+	bytes  int64
+}
+
+// writeEstimate writes, for each exported database, the estimated number of
+// series and of lines and uncompressed bytes an export would write, without
+// exporting anything. Points are counted from the timestamps of the TSM
+// blocks overlapping the time range, so no values are decoded except for
+// the first block of each series and field, whose lines are formatted to
+// estimate the size of the others. WAL segments are read in full. Deleted
+// points, points outside the time range in blocks that overlap it, and
+// lines added by -null-policy zero are not accounted for.
+func (cmd *Command) writeEstimate(w io.Writer) error {
+	estimates := make(map[string]*sizeEstimate)
+	keys := make([]string, 0, len(cmd.manifest))
+	for key := range cmd.manifest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		db := strings.Split(key, string(byte(os.PathSeparator)))[0]
+		e := estimates[db]
+		if e == nil {
+			e = &sizeEstimate{series: hllpp.New()}
+			estimates[db] = e
+		}
+
+		for _, f := range cmd.tsmFiles[key] {
+			if err := cmd.readTSM(f, func(r *tsm1.TSMReader) error {
+				return cmd.estimateTSM(r, e)
+			}); err != nil {
+				return err
+			}
+		}
+		for _, f := range cmd.walFiles[key] {
+			if err := cmd.readWAL(f, func(k string, values []tsm1.Value) {
+				if !cmd.selected([]byte(k)) {
+					return
+				}
+				series, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+				e.series.Add(series)
+				for _, v := range values {
+					if t := v.UnixNano(); t >= cmd.startTime && t <= cmd.endTime {
+						e.lines++
+						e.bytes += cmd.lineSize(series, field, v)
+					}
+				}
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	dbs := make([]string, 0, len(estimates))
+	for db := range estimates {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	tw := tabwriter.NewWriter(w, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Database", "Series (est)", "Lines", "Est. Bytes"}, "\t"))
+	var lines, bytes int64
+	for _, db := range dbs {
+		e := estimates[db]
+		fmt.Fprintln(tw, strings.Join([]string{
+			db,
+			strconv.FormatUint(e.series.Count(), 10),
+			strconv.FormatInt(e.lines, 10),
+			strconv.FormatInt(e.bytes, 10),
+		}, "\t"))
+		lines += e.lines
+		bytes += e.bytes
+	}
+	fmt.Fprintln(tw, strings.Join([]string{"Total", "", strconv.FormatInt(lines, 10), strconv.FormatInt(bytes, 10)}, "\t"))
+	return tw.Flush()
+}
+
+// estimateTSM adds the lines and bytes an export of the TSM file read by r
+// would write to e.
+func (cmd *Command) estimateTSM(r *tsm1.TSMReader, e *sizeEstimate) error {
+	var key string
+	var size int64
+	itr := r.BlockIterator()
+	for itr.Next() {
+		k, min, max, _, buf, err := itr.Read()
+		if err != nil {
+			return err
+		}
+		if max < cmd.startTime || min > cmd.endTime || !cmd.selected([]byte(k)) {
+			continue
+		}
+
+		// Format the first block of each series and field to estimate the
+		// average size of its lines.
+		if k != key {
+			values, err := tsm1.DecodeBlock(buf, nil)
+			if err != nil {
+				return err
+			}
+			series, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+			e.series.Add(series)
+
+			var n int64
+			for _, v := range values {
+				n += cmd.lineSize(series, field, v)
+			}
+			key, size = k, n/int64(len(values))
+		}
+
+		n := int64(tsm1.BlockCount(buf))
+		e.lines += n
+		e.bytes += n * size
+	}
+	return nil
+}
+
+// lineSize returns the size of the line exporting the value v of a field of
+// series.
+func (cmd *Command) lineSize(series []byte, field string, v tsm1.Value) int64 {
+	return int64(len(cmd.redactSeries(series)) + len(cmd.formatField(field, v.Value())) + len(strconv.FormatInt(v.UnixNano(), 10)) + 3)
+}
@@ -1,43 +1,151 @@
 package export
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/influxdata/influxdb/cmd/influx_inspect/storemeta"
 	"github.com/influxdata/influxdb/influxql"
-	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 )
 
+// ErrInterrupted is returned when an export is stopped by an interrupt.
+var ErrInterrupted = errors.New("export interrupted")
+
+// ErrTruncated is returned when an export is stopped because the output
+// reached the size set by -max-output-size.
+var ErrTruncated = errors.New("export truncated")
+
+// Export format versions. Version 1 is the format written before exports
+// recorded their version. Version 2 adds the # FORMAT-VERSION header, so
+// importers can reject exports in formats newer than they understand.
+// Version 3 adds the # PRECISION header naming the unit of the timestamps,
+// and is required by -precision, -escape-newlines and -v2, whose output an
+// importer of an older version would misread. Any change to how points are
+// written that an importer of an older version would misread must add a new
+// version.
+const (
+	minFormatVersion    = 1
+	latestFormatVersion = 3
+)
+
+// precisions maps each -precision to the number of nanoseconds in its unit.
+var precisions = map[string]int64{
+	"ns": 1,
+	"us": int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+}
+
+// defaultBufferSize is the default size of the buffer in front of the
+// output file.
+const defaultBufferSize = 32000000
+
 // Command represents the program execution for "influx_inspect export".
 type Command struct {
 	// Standard input/output, overridden for testing.
 	Stderr io.Writer
 	Stdout io.Writer
 
+	// Interrupt receives signals that stop the export early. The output is
+	// still flushed and closed so that it holds a complete prefix of the
+	// export.
+	Interrupt chan os.Signal
+	stopped   bool
+
 	dataDir         string
 	walDir          string
+	metaDir         string
 	out             string
 	database        string
 	retentionPolicy string
 	startTime       int64
 	endTime         int64
 	compress        bool
+	gzipLevel       int
+	bufferSize      int
+	escapeNewlines  bool
+	formatVersion   int
+	withDDL         bool
+	v2              bool
+	follow          bool
+	followInterval  time.Duration
+	maxOutputSize   int64
+	nullPolicy      string
+	redactTags      keyList
+	redactFields    keyList
+
+	// precision is the unit timestamps are written in, and unit the
+	// number of nanoseconds in it.
+	precision string
+	unit      int64
+
+	// measurementFilter and fieldFilter, if set, limit the export to the
+	// matching measurements and the listed fields, and tagFilter to the
+	// series with every listed tag value.
+	measurementFilter *regexp.Regexp
+	fieldFilter       map[string]struct{}
+	tagFilter         tagList
+
+	gaps             bool
+	expectedInterval time.Duration
+
+	// reverse writes the points of each series newest first.
+	reverse bool
+
+	// estimate reports the size of the export instead of exporting.
+	estimate bool
+
+	// checksum writes a digest of each series instead of exporting.
+	checksum bool
+
+	// redacted holds series keys with their tag values redacted, keyed by
+	// the original series key.
+	redacted map[string][]byte
 
 	manifest map[string]struct{}
 	tsmFiles map[string][]string
 	walFiles map[string][]string
+
+	// fields indexes the fields of the series being exported, for the
+	// null policy.
+	fields *fieldIndex
+
+	// When following, seen holds the latest timestamp written for each
+	// series and field, and since holds the values of seen at the start of
+	// the current pass.
+	seen    map[string]int64
+	since   map[string]int64
+	written int
+
+	// size is the number of uncompressed bytes of output so far, and
+	// lastKey and lastTime identify the last point written.
+	size     int64
+	lastKey  string
+	lastTime int64
+
+	// unreadable is the number of keys of TSM files skipped because their
+	// blocks could not be read.
+	unreadable int
 }
 
 // NewCommand returns a new instance of Command.
@@ -46,24 +154,54 @@ func NewCommand() *Command {
 		Stderr: os.Stderr,
 		Stdout: os.Stdout,
 
+		Interrupt: make(chan os.Signal, 1),
+
 		manifest: make(map[string]struct{}),
 		tsmFiles: make(map[string][]string),
 		walFiles: make(map[string][]string),
+		seen:     make(map[string]int64),
+
+		tagFilter:    make(tagList),
+		redactTags:   make(keyList),
+		redactFields: make(keyList),
+		redacted:     make(map[string][]byte),
 	}
 }
 
 // Run executes the command.
 func (cmd *Command) Run(args ...string) error {
-	var start, end string
+	var start, end, measurement, fields string
 	fs := flag.NewFlagSet("export", flag.ExitOnError)
 	fs.StringVar(&cmd.dataDir, "datadir", os.Getenv("HOME")+"/.influxdb/data", "Data storage path. [$HOME/.influxdb/data]")
 	fs.StringVar(&cmd.walDir, "waldir", os.Getenv("HOME")+"/.influxdb/wal", "Wal storage path. [$HOME/.influxdb/wal]")
+	fs.StringVar(&cmd.metaDir, "metadir", os.Getenv("HOME")+"/.influxdb/meta", "Meta storage path, used by -with-ddl. [$HOME/.influxdb/meta]")
 	fs.StringVar(&cmd.out, "out", os.Getenv("HOME")+"/.influxdb/export", "Destination file to export to")
 	fs.StringVar(&cmd.database, "database", "", "Optional: the database to export")
 	fs.StringVar(&cmd.retentionPolicy, "retention", "", "Optional: the retention policy to export (requires db parameter to be specified)")
-	fs.StringVar(&start, "start", "", "Optional: the start time to export")
-	fs.StringVar(&end, "end", "", "Optional: the end time to export")
+	fs.StringVar(&measurement, "measurement", "", "Optional: only export measurements matching this regular expression")
+	fs.StringVar(&fields, "field", "", "Optional: comma-delimited list of the fields to export")
+	fs.Var(cmd.tagFilter, "tag", "Optional: only export series with this tag value, given as key=value (may be repeated, all must match)")
+	fs.StringVar(&start, "start", "", "Optional: the start time to export, in RFC3339 format or as a Unix timestamp in nanoseconds")
+	fs.StringVar(&end, "end", "", "Optional: the end time to export, in RFC3339 format or as a Unix timestamp in nanoseconds")
 	fs.BoolVar(&cmd.compress, "compress", false, "Compress the output")
+	fs.IntVar(&cmd.gzipLevel, "gzip-level", gzip.DefaultCompression, "Gzip compression level used by -compress, from 0 (none) to 9 (smallest output), or -1 for the default")
+	fs.IntVar(&cmd.bufferSize, "buffer-size", defaultBufferSize, "Size in bytes of the buffer in front of the output file")
+	fs.BoolVar(&cmd.withDDL, "with-ddl", false, "Write statements creating the databases and retention policies from the metadata")
+	fs.BoolVar(&cmd.escapeNewlines, "escape-newlines", false, "Escape newlines in string field values")
+	fs.StringVar(&cmd.precision, "precision", "ns", "Precision of the exported timestamps: ns, us, ms or s")
+	fs.BoolVar(&cmd.v2, "v2", false, "Name the bucket of each database and retention policy in the context headers, for InfluxDB 2 ingestion")
+	fs.IntVar(&cmd.formatVersion, "output-format-version", latestFormatVersion, "Version of the export format to write, for importers that only read older versions")
+	fs.BoolVar(&cmd.follow, "follow", false, "Keep exporting new points as they are written, until interrupted")
+	fs.DurationVar(&cmd.followInterval, "follow-interval", 5*time.Second, "How often to check for new points when following")
+	fs.StringVar(&cmd.nullPolicy, "null-policy", nullPolicyEmpty, "How to export points missing fields of their series: empty, skip or zero")
+	fs.Int64Var(&cmd.maxOutputSize, "max-output-size", 0, "Stop once this many uncompressed bytes have been written (0 for no limit)")
+	fs.Var(cmd.redactTags, "redact-tags", "Replace the values of this tag key with a stable hash (may be repeated)")
+	fs.Var(cmd.redactFields, "redact-fields", "Replace the values of this field with a stable hash (may be repeated)")
+	fs.BoolVar(&cmd.gaps, "gaps", false, "Report gaps between consecutive points of each series longer than -expected-interval instead of exporting")
+	fs.DurationVar(&cmd.expectedInterval, "expected-interval", 0, "Expected time between consecutive points of a series, used by -gaps")
+	fs.BoolVar(&cmd.estimate, "estimate", false, "Report the estimated lines and bytes the export would write for each database, without exporting")
+	fs.BoolVar(&cmd.checksum, "checksum", false, "Write a digest of the points of each series and of each database instead of exporting")
+	fs.BoolVar(&cmd.reverse, "reverse", false, "Export the points of each series newest first, reading WAL files before TSM files")
 
 	fs.SetOutput(cmd.Stdout)
 	fs.Usage = cmd.printUsage
@@ -74,43 +212,125 @@ func (cmd *Command) Run(args ...string) error {
 
 	// set defaults
 	if start != "" {
-		s, err := time.Parse(time.RFC3339, start)
+		s, err := parseTime(start)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid start time: %s", err)
 		}
-		cmd.startTime = s.UnixNano()
+		cmd.startTime = s
 	} else {
 		cmd.startTime = math.MinInt64
 	}
 	if end != "" {
-		e, err := time.Parse(time.RFC3339, end)
+		e, err := parseTime(end)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid end time: %s", err)
 		}
-		cmd.endTime = e.UnixNano()
+		cmd.endTime = e
 	} else {
 		// set end time to max if it is not set.
 		cmd.endTime = math.MaxInt64
 	}
 
+	if err := cmd.parseFilters(measurement, fields); err != nil {
+		return err
+	}
+
 	if err := cmd.validate(); err != nil {
 		return err
 	}
+	cmd.unit = precisions[cmd.precision]
+
+	signal.Notify(cmd.Interrupt, os.Interrupt)
+	defer signal.Stop(cmd.Interrupt)
 
 	return cmd.export()
 }
 
+// parseTime returns the time s in nanoseconds, given either in RFC3339
+// format or as a Unix timestamp in nanoseconds.
+func parseTime(s string) (int64, error) {
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ns, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither an RFC3339 time nor a Unix timestamp in nanoseconds", s)
+	}
+	return t.UnixNano(), nil
+}
+
 func (cmd *Command) validate() error {
 	// validate args
 	if cmd.retentionPolicy != "" && cmd.database == "" {
 		return fmt.Errorf("must specify a db")
 	}
-	if cmd.startTime != 0 && cmd.endTime != 0 && cmd.endTime < cmd.startTime {
+	if cmd.endTime < cmd.startTime {
 		return fmt.Errorf("end time before start time")
 	}
+	if cmd.follow && cmd.followInterval <= 0 {
+		return fmt.Errorf("follow interval must be positive")
+	}
+	switch cmd.nullPolicy {
+	case nullPolicyEmpty, nullPolicySkip, nullPolicyZero:
+	default:
+		return fmt.Errorf("invalid null policy %q, expected empty, skip or zero", cmd.nullPolicy)
+	}
+	if _, ok := precisions[cmd.precision]; !ok {
+		return fmt.Errorf("invalid precision %q, expected ns, us, ms or s", cmd.precision)
+	}
+	if cmd.v2 && cmd.withDDL {
+		return fmt.Errorf("-v2 can not be used with -with-ddl")
+	}
+	if cmd.bufferSize <= 0 {
+		return fmt.Errorf("buffer size must be positive")
+	}
+	if cmd.maxOutputSize < 0 {
+		return fmt.Errorf("max output size must not be negative")
+	}
+	if cmd.gaps && cmd.expectedInterval <= 0 {
+		return fmt.Errorf("-gaps requires a positive -expected-interval")
+	}
+	if cmd.gaps && cmd.follow {
+		return fmt.Errorf("-gaps can not be used with -follow")
+	}
+	if cmd.reverse && cmd.follow {
+		return fmt.Errorf("-reverse can not be used with -follow")
+	}
+	if cmd.estimate && (cmd.follow || cmd.gaps) {
+		return fmt.Errorf("-estimate can not be used with -follow or -gaps")
+	}
+	if cmd.checksum && (cmd.follow || cmd.gaps || cmd.estimate) {
+		return fmt.Errorf("-checksum can not be used with -follow, -gaps or -estimate")
+	}
+	if cmd.gzipLevel < gzip.DefaultCompression || cmd.gzipLevel > gzip.BestCompression {
+		return fmt.Errorf("invalid gzip level %d, expected %d to %d", cmd.gzipLevel, gzip.DefaultCompression, gzip.BestCompression)
+	}
+	if cmd.gzipLevel != gzip.DefaultCompression && !cmd.compress {
+		return fmt.Errorf("-gzip-level requires -compress")
+	}
+	if cmd.formatVersion < minFormatVersion || cmd.formatVersion > latestFormatVersion {
+		return fmt.Errorf("unsupported output format version %d, expected %d to %d", cmd.formatVersion, minFormatVersion, latestFormatVersion)
+	}
+	if cmd.formatVersion < 3 && (cmd.precision != "ns" || cmd.escapeNewlines || cmd.v2) {
+		return fmt.Errorf("-precision, -escape-newlines and -v2 require -output-format-version 3 or later")
+	}
 	return nil
 }
 
+// interrupted returns true once a signal has been received on Interrupt.
+func (cmd *Command) interrupted() bool {
+	if cmd.stopped {
+		return true
+	}
+
+	select {
+	case <-cmd.Interrupt:
+		cmd.stopped = true
+	default:
+	}
+	return cmd.stopped
+}
+
 func (cmd *Command) export() error {
 	if err := cmd.walkTSMFiles(); err != nil {
 		return err
@@ -118,7 +338,25 @@ func (cmd *Command) export() error {
 	if err := cmd.walkWALFiles(); err != nil {
 		return err
 	}
-	return cmd.writeFiles()
+	if cmd.gaps {
+		return cmd.writeGaps(cmd.Stdout)
+	} else if cmd.estimate {
+		return cmd.writeEstimate(cmd.Stdout)
+	} else if cmd.checksum {
+		return cmd.writeChecksums(cmd.Stdout)
+	}
+	err := cmd.writeFiles()
+	if cmd.unreadable > 0 {
+		fmt.Fprintf(cmd.Stderr, "%d keys of TSM files could not be read and were skipped\n", cmd.unreadable)
+	}
+	if err == ErrTruncated {
+		if cmd.lastKey == "" {
+			fmt.Fprintf(cmd.Stderr, "output truncated at %d bytes before any points were written\n", cmd.size)
+		} else {
+			fmt.Fprintf(cmd.Stderr, "output truncated at %d bytes, last point written: %s at %d\n", cmd.size, cmd.lastKey, cmd.lastTime)
+		}
+	}
+	return err
 }
 
 func (cmd *Command) walkTSMFiles() error {
@@ -195,58 +433,284 @@ func (cmd *Command) walkWALFiles() error {
 	return nil
 }
 
-func (cmd *Command) writeFiles() error {
+func (cmd *Command) writeFiles() (err error) {
 	// open our output file and create an output buffer
-	var w io.WriteCloser
-	w, err := os.Create(cmd.out)
+	f, err := os.Create(cmd.out)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	// The buffer must be flushed before the file is closed, and a failed
+	// flush, such as on a full disk, fails the export.
+	bw := bufio.NewWriterSize(f, cmd.bufferSize)
+	defer func() {
+		if e := bw.Flush(); err == nil {
+			err = e
+		}
+	}()
+
+	// The gzip writer must be closed before the buffer is flushed so the
+	// stream footer is written; a failure to do so leaves a truncated
+	// archive.
+	var w io.Writer = bw
+	flush := bw.Flush
 	if cmd.compress {
-		w = gzip.NewWriter(w)
-		defer w.Close()
+		gw, err := gzip.NewWriterLevel(bw, cmd.gzipLevel)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if e := gw.Close(); err == nil {
+				err = e
+			}
+		}()
+		w = gw
+		flush = func() error {
+			if err := gw.Flush(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
 	}
+	w = &countingWriter{w: w, n: &cmd.size}
 
 	s, e := time.Unix(0, cmd.startTime).Format(time.RFC3339), time.Unix(0, cmd.endTime).Format(time.RFC3339)
 	fmt.Fprintf(w, "# INFLUXDB EXPORT: %s - %s\n", s, e)
+	if cmd.formatVersion >= 2 {
+		fmt.Fprintf(w, "# FORMAT-VERSION:%d\n", cmd.formatVersion)
+	}
+	if cmd.formatVersion >= 3 {
+		fmt.Fprintf(w, "# PRECISION:%s\n", cmd.precision)
+	}
+	if cmd.escapeNewlines {
+		fmt.Fprintln(w, "# ESCAPED-NEWLINES")
+	}
 
-	// Write out all the DDL
-	fmt.Fprintln(w, "# DDL")
-	for key := range cmd.manifest {
-		keys := strings.Split(key, string(byte(os.PathSeparator)))
-		db, rp := influxql.QuoteIdent(keys[0]), influxql.QuoteIdent(keys[1])
-		fmt.Fprintf(w, "CREATE DATABASE %s WITH NAME %s\n", db, rp)
+	// Write out all the DDL. Buckets are created separately, so there is
+	// none with -v2.
+	if !cmd.v2 {
+		fmt.Fprintln(w, "# DDL")
+		if err := cmd.writeDDL(w); err != nil {
+			return err
+		}
 	}
 
 	fmt.Fprintln(w, "# DML")
 	for key := range cmd.manifest {
-		keys := strings.Split(key, string(byte(os.PathSeparator)))
-		fmt.Fprintf(w, "# CONTEXT-DATABASE:%s\n", keys[0])
-		fmt.Fprintf(w, "# CONTEXT-RETENTION-POLICY:%s\n", keys[1])
-		if files, ok := cmd.tsmFiles[key]; ok {
-			fmt.Printf("writing out tsm file data for %s...", key)
-			if err := cmd.writeTsmFiles(w, files); err != nil {
+		cmd.writeContext(w, key)
+		if err := cmd.indexFields(key); err != nil {
+			return err
+		}
+		writeTSM := func() error {
+			if files, ok := cmd.tsmFiles[key]; ok {
+				fmt.Printf("writing out tsm file data for %s...", key)
+				if err := cmd.writeTsmFiles(w, files, key); err != nil {
+					return err
+				}
+				fmt.Println("complete.")
+			}
+			return nil
+		}
+		writeWAL := func() error {
+			if _, ok := cmd.walFiles[key]; ok {
+				fmt.Printf("writing out wal file data for %s...", key)
+				if err := cmd.writeWALFiles(w, cmd.walFiles[key], key); err != nil {
+					return err
+				}
+				fmt.Println("complete.")
+			}
+			return nil
+		}
+
+		// The WAL holds the newest points, so it comes first in reverse.
+		writes := []func() error{writeTSM, writeWAL}
+		if cmd.reverse {
+			writes[0], writes[1] = writeWAL, writeTSM
+		}
+		for _, write := range writes {
+			if err := write(); err != nil {
 				return err
 			}
-			fmt.Println("complete.")
 		}
-		if _, ok := cmd.walFiles[key]; ok {
-			fmt.Printf("writing out wal file data for %s...", key)
-			if err := cmd.writeWALFiles(w, cmd.walFiles[key], key); err != nil {
+		if err := cmd.writeZeroFields(w, key); err != nil {
+			return err
+		}
+	}
+
+	if cmd.follow {
+		return cmd.followFiles(w, flush)
+	}
+	return nil
+}
+
+// writeDDL writes statements creating each exported database and retention
+// policy. With -with-ddl they are written as by tsdb.WriteExportDDL, with
+// the settings in the metadata when it is available. Otherwise each
+// retention policy is created as the default of its database.
+func (cmd *Command) writeDDL(w io.Writer) error {
+	if !cmd.withDDL {
+		for key := range cmd.manifest {
+			keys := strings.Split(key, string(byte(os.PathSeparator)))
+			db, rp := influxql.QuoteIdent(keys[0]), influxql.QuoteIdent(keys[1])
+			fmt.Fprintf(w, "CREATE DATABASE %s WITH NAME %s\n", db, rp)
+		}
+		return nil
+	}
+
+	var metaClient tsdb.MetaClient
+	if _, err := os.Stat(filepath.Join(cmd.metaDir, "meta.db")); err == nil {
+		c := meta.NewClient(&meta.Config{Dir: cmd.metaDir})
+		if err := c.Load(); err != nil {
+			return fmt.Errorf("load meta: %s", err)
+		}
+		metaClient = storemeta.NewClient(c)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	rps := make(map[string][]string)
+	for key := range cmd.manifest {
+		keys := strings.Split(key, string(byte(os.PathSeparator)))
+		rps[keys[0]] = append(rps[keys[0]], keys[1])
+	}
+	return tsdb.WriteExportDDL(w, rps, metaClient)
+}
+
+// followFiles periodically rescans the data and WAL directories, picking up
+// any files created by compactions, and writes points newer than the latest
+// point already written for their series. It returns once interrupted.
+func (cmd *Command) followFiles(w io.Writer, flush func() error) error {
+	for {
+		if err := flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-cmd.Interrupt:
+			cmd.stopped = true
+			return nil
+		case <-time.After(cmd.followInterval):
+		}
+
+		cmd.manifest = make(map[string]struct{})
+		cmd.tsmFiles = make(map[string][]string)
+		cmd.walFiles = make(map[string][]string)
+		if err := cmd.walkTSMFiles(); err != nil {
+			return err
+		}
+		if err := cmd.walkWALFiles(); err != nil {
+			return err
+		}
+
+		cmd.since = make(map[string]int64, len(cmd.seen))
+		for k, v := range cmd.seen {
+			cmd.since[k] = v
+		}
+
+		for key := range cmd.manifest {
+			// Buffer the pass so context is only written for new points.
+			// Buffered bytes count towards the output size as they are
+			// written, and are uncounted again before being copied to w.
+			var buf bytes.Buffer
+			bw := &countingWriter{w: &buf, n: &cmd.size}
+			written := cmd.written
+			err := cmd.indexFields(key)
+			if err == nil {
+				err = cmd.writeTsmFiles(bw, cmd.tsmFiles[key], key)
+			}
+			if err == nil {
+				err = cmd.writeWALFiles(bw, cmd.walFiles[key], key)
+			}
+			if err == nil {
+				err = cmd.writeZeroFields(bw, key)
+			}
+			if err == ErrInterrupted {
+				return nil
+			} else if err != nil && err != ErrTruncated {
+				return err
+			}
+			cmd.size -= int64(buf.Len())
+			if cmd.written == written {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			cmd.writeContext(w, key)
+			if _, err := buf.WriteTo(w); err != nil {
+				return err
+			}
+			if err != nil {
 				return err
 			}
-			fmt.Println("complete.")
 		}
 	}
+}
+
+// track records a point written for the series and field in the database
+// and retention policy key. It returns false if the point is not newer than
+// the points written by previous passes when following.
+func (cmd *Command) track(key, seriesField string, t int64) bool {
+	if !cmd.follow {
+		return true
+	}
+
+	k := key + "\x00" + seriesField
+	if min, ok := cmd.since[k]; ok && t <= min {
+		return false
+	}
+	if max, ok := cmd.seen[k]; !ok || t > max {
+		cmd.seen[k] = t
+	}
+	cmd.written++
+	return true
+}
+
+// writeContext writes the headers naming the database and retention policy
+// of key, whose points follow. With -v2 the header names the bucket they map
+// to instead, "database/retention_policy".
+func (cmd *Command) writeContext(w io.Writer, key string) {
+	keys := strings.Split(key, string(byte(os.PathSeparator)))
+	if cmd.v2 {
+		fmt.Fprintf(w, "# CONTEXT-BUCKET:%s/%s\n", keys[0], keys[1])
+		return
+	}
+	tsdb.WriteExportContext(w, keys[0], keys[1])
+}
+
+// writePoint writes a point for the series and field in seriesField. It
+// returns ErrTruncated, without writing the point, if the point would take
+// the output past the maximum output size.
+func (cmd *Command) writePoint(w io.Writer, seriesField string, series []byte, pairs string, t int64) error {
+	if len(cmd.redactTags) > 0 {
+		// Don't reveal the tag values in the truncation notice either.
+		series = cmd.redactSeries(series)
+		_, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(seriesField))
+		seriesField = tsm1.SeriesFieldKey(string(series), field)
+	}
+
+	line := tsdb.FormatExportLine(series, pairs, t/cmd.unit)
+	if cmd.maxOutputSize > 0 && cmd.size+int64(len(line)) > cmd.maxOutputSize {
+		return ErrTruncated
+	}
+	if _, err := io.WriteString(w, line); err != nil {
+		return err
+	}
+	cmd.lastKey, cmd.lastTime = seriesField, t
 	return nil
 }
 
-func (cmd *Command) writeTsmFiles(w io.WriteCloser, files []string) error {
+func (cmd *Command) writeTsmFiles(w io.Writer, files []string, key string) error {
 	fmt.Fprintln(w, "# writing tsm data")
 
 	// we need to make sure we write the same order that the files were written
-	sort.Strings(files)
+	files = cmd.sortFiles(files)
 
 	// use a function here to close the files in the defers and not let them accumulate in the loop
 	write := func(f string) error {
@@ -267,30 +731,41 @@ func (cmd *Command) writeTsmFiles(w io.WriteCloser, files []string) error {
 		}
 
 		for i := 0; i < reader.KeyCount(); i++ {
-			var pairs string
-			key, typ := reader.KeyAt(i)
-			values, _ := reader.ReadAll(string(key))
-			measurement, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			if cmd.interrupted() {
+				return ErrInterrupted
+			}
+
+			k, _ := reader.KeyAt(i)
+			if !cmd.selected(k) {
+				continue
+			}
+			seriesField := string(k)
+			values, err := cmd.readValues(reader, seriesField)
+			if err != nil {
+				// A corrupt block loses its key in this file rather
+				// than the whole export.
+				fmt.Fprintf(cmd.Stderr, "unable to read key %q in %s, skipping: %s\n", seriesField, f, err)
+				cmd.unreadable++
+				continue
+			}
+			if cmd.reverse {
+				reverseValues(values)
+			}
+			measurement, field := tsm1.SeriesAndFieldFromCompositeKey(k)
 
 			for _, value := range values {
 				if (value.UnixNano() < cmd.startTime) || (value.UnixNano() > cmd.endTime) {
 					continue
+				} else if cmd.skip(f, measurement, value.UnixNano()) {
+					continue
+				} else if !cmd.track(key, seriesField, value.UnixNano()) {
+					continue
 				}
 
-				switch typ {
-				case tsm1.BlockFloat64:
-					pairs = field + "=" + fmt.Sprintf("%v", value.Value())
-				case tsm1.BlockInteger:
-					pairs = field + "=" + fmt.Sprintf("%vi", value.Value())
-				case tsm1.BlockBoolean:
-					pairs = field + "=" + fmt.Sprintf("%v", value.Value())
-				case tsm1.BlockString:
-					pairs = field + "=" + fmt.Sprintf("%q", models.EscapeStringField(fmt.Sprintf("%s", value.Value())))
-				default:
-					pairs = field + "=" + fmt.Sprintf("%v", value.Value())
+				pairs := cmd.formatField(field, value.Value())
+				if err := cmd.writePoint(w, seriesField, measurement, pairs, value.UnixNano()); err != nil {
+					return err
 				}
-
-				fmt.Fprintln(w, string(measurement), pairs, value.UnixNano())
 			}
 		}
 		return nil
@@ -305,11 +780,57 @@ func (cmd *Command) writeTsmFiles(w io.WriteCloser, files []string) error {
 	return nil
 }
 
-func (cmd *Command) writeWALFiles(w io.WriteCloser, files []string, key string) error {
+// readValues returns the values of key in the TSM file read by r that are
+// within the exported time range, without deleted values. Only the blocks
+// overlapping the time range are decoded.
+func (cmd *Command) readValues(r *tsm1.TSMReader, key string) ([]tsm1.Value, error) {
+	if cmd.startTime == math.MinInt64 && cmd.endTime == math.MaxInt64 {
+		return r.ReadAll(key)
+	}
+
+	tombstones := r.TombstoneRange(key)
+	var values []tsm1.Value
+	for _, e := range r.Entries(key) {
+		// The blocks of a key are ordered by time, so none of the
+		// remaining blocks are in range either.
+		if e.MinTime > cmd.endTime {
+			break
+		} else if !e.OverlapsTimeRange(cmd.startTime, cmd.endTime) {
+			continue
+		}
+
+		a, err := r.ReadAt(&e, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tombstones {
+			a = tsm1.Values(a).Exclude(t.Min, t.Max)
+		}
+		values = append(values, tsm1.Values(a).Include(cmd.startTime, cmd.endTime)...)
+	}
+	return values, nil
+}
+
+// formatField returns the line protocol representation of a field and value,
+// as by tsdb.FormatExportField, after redacting the value.
+func (cmd *Command) formatField(field string, v interface{}) string {
+	return tsdb.FormatExportField(field, cmd.redactField(field, v), cmd.escapeNewlines)
+}
+
+// skip returns true if the point at t in the series is to be skipped because
+// it is missing fields, for the TSM or WAL file at path.
+func (cmd *Command) skip(path string, series []byte, t int64) bool {
+	if cmd.nullPolicy != nullPolicySkip || cmd.fields == nil {
+		return false
+	}
+	return !cmd.fields.complete(shardID(path), series, t)
+}
+
+func (cmd *Command) writeWALFiles(w io.Writer, files []string, key string) error {
 	fmt.Fprintln(w, "# writing wal data")
 
 	// we need to make sure we write the same order that the wal received the data
-	sort.Strings(files)
+	files = cmd.sortFiles(files)
 
 	var once sync.Once
 	warn := func() {
@@ -321,6 +842,10 @@ func (cmd *Command) writeWALFiles(w io.WriteCloser, files []string, key string)
 		fmt.Fprintln(cmd.Stderr, msg)
 	}
 
+	handle := func(f string, entry tsm1.WALEntry) error {
+		return cmd.writeWALEntry(w, key, f, entry, func() { once.Do(warn) })
+	}
+
 	// use a function here to close the files in the defers and not let them accumulate in the loop
 	write := func(f string) error {
 		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
@@ -331,47 +856,30 @@ func (cmd *Command) writeWALFiles(w io.WriteCloser, files []string, key string)
 
 		reader := tsm1.NewWALSegmentReader(file)
 		defer reader.Close()
+
+		// In reverse, the entries of the segment are read before any is
+		// written, so they can be written newest first.
+		var entries []tsm1.WALEntry
 		for reader.Next() {
+			if cmd.interrupted() {
+				return ErrInterrupted
+			}
+
 			entry, err := reader.Read()
 			if err != nil {
 				n := reader.Count()
 				fmt.Fprintf(os.Stderr, "file %s corrupt at position %d", file.Name(), n)
 				break
 			}
-
-			switch t := entry.(type) {
-			case *tsm1.DeleteWALEntry:
-				once.Do(warn)
-				continue
-			case *tsm1.DeleteRangeWALEntry:
-				once.Do(warn)
-				continue
-			case *tsm1.WriteWALEntry:
-				var pairs string
-
-				for key, values := range t.Values {
-					measurement, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
-
-					for _, value := range values {
-						if (value.UnixNano() < cmd.startTime) || (value.UnixNano() > cmd.endTime) {
-							continue
-						}
-
-						switch value.Value().(type) {
-						case float64:
-							pairs = field + "=" + fmt.Sprintf("%v", value.Value())
-						case int64:
-							pairs = field + "=" + fmt.Sprintf("%vi", value.Value())
-						case bool:
-							pairs = field + "=" + fmt.Sprintf("%v", value.Value())
-						case string:
-							pairs = field + "=" + fmt.Sprintf("%q", models.EscapeStringField(fmt.Sprintf("%s", value.Value())))
-						default:
-							pairs = field + "=" + fmt.Sprintf("%v", value.Value())
-						}
-						fmt.Fprintln(w, string(measurement), pairs, value.UnixNano())
-					}
-				}
+			if cmd.reverse {
+				entries = append(entries, entry)
+			} else if err := handle(f, entry); err != nil {
+				return err
+			}
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			if err := handle(f, entries[i]); err != nil {
+				return err
 			}
 		}
 		return nil
@@ -386,6 +894,63 @@ func (cmd *Command) writeWALFiles(w io.WriteCloser, files []string, key string)
 	return nil
 }
 
+// writeWALEntry writes the points of a WAL entry read from the file f, warning
+// once through warn if the entry deletes data.
+func (cmd *Command) writeWALEntry(w io.Writer, key, f string, entry tsm1.WALEntry, warn func()) error {
+	switch t := entry.(type) {
+	case *tsm1.DeleteWALEntry, *tsm1.DeleteRangeWALEntry:
+		warn()
+	case *tsm1.WriteWALEntry:
+		for k, values := range t.Values {
+			if !cmd.selected([]byte(k)) {
+				continue
+			}
+			measurement, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+			if cmd.reverse {
+				values = append([]tsm1.Value(nil), values...)
+				reverseValues(values)
+			}
+
+			for _, value := range values {
+				if (value.UnixNano() < cmd.startTime) || (value.UnixNano() > cmd.endTime) {
+					continue
+				} else if cmd.skip(f, measurement, value.UnixNano()) {
+					continue
+				} else if !cmd.track(key, k, value.UnixNano()) {
+					continue
+				}
+
+				pairs := cmd.formatField(field, value.Value())
+				if err := cmd.writePoint(w, k, measurement, pairs, value.UnixNano()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sortFiles sorts files in the order they were written, or newest first in
+// reverse.
+func (cmd *Command) sortFiles(files []string) []string {
+	sort.Strings(files)
+	if cmd.reverse {
+		a := make([]string, len(files))
+		for i, f := range files {
+			a[len(files)-1-i] = f
+		}
+		return a
+	}
+	return files
+}
+
+// reverseValues reverses the order of a in place.
+func reverseValues(a []tsm1.Value) {
+	for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+		a[i], a[j] = a[j], a[i]
+	}
+}
+
 // printUsage prints the usage message to STDERR.
 func (cmd *Command) printUsage() {
 	usage := fmt.Sprintf(`Exports TSM files into InfluxDB line protocol format.
@@ -398,6 +963,9 @@ Usage: influx_inspect export [flags]
     -waldir <path>
             WAL storage path
             Defaults to "%[1]s/.influxdb/wal".
+    -metadir <path>
+            Meta storage path, used by -with-ddl.
+            Defaults to "%[1]s/.influxdb/meta".
     -out <path>
             Destination file to export to.
             Defaults to "%[1]s/.influxdb/export".
@@ -405,13 +973,111 @@ Usage: influx_inspect export [flags]
             Optional. Database to export.
     -retention <name>
             Optional. the retention policy to export (requires db parameter to be specified).
-    -start-time <time>
-            Optional. the start time to export.
-    -end-time <time>
-            Optional. the end time to export.
+    -measurement <regexp>
+            Optional. Only export measurements whose name matches this
+            regular expression, such as '^cpu$'.
+    -field <names>
+            Optional. Comma-delimited list of the fields to export.
+            Defaults to all fields.
+    -tag <key=value>
+            Optional. Only export series with this tag value. May be
+            repeated, in which case a series must match every tag.
+    -start <time>
+            Optional. the start time to export, in RFC3339 format or
+            as a Unix timestamp in nanoseconds.
+    -end <time>
+            Optional. the end time to export, in RFC3339 format or as
+            a Unix timestamp in nanoseconds.
     -compress
             Optional. Compress the output.  Defaults to "false".
-`, os.Getenv("HOME"))
+    -gzip-level <level>
+            Optional. The gzip compression level used by -compress, from
+            0 (no compression, fastest) to 9 (smallest output), or -1
+            for the default level.  Defaults to -1.
+    -buffer-size <bytes>
+            Optional. Size of the buffer in front of the output file.
+            A smaller buffer suits small exports, a larger one slow
+            network filesystems.  Defaults to "%[3]d".
+    -with-ddl
+            Optional. Write statements creating the databases and
+            retention policies, using the settings in the metadata.
+            Defaults to "false".
+    -follow
+            Optional. After the export, keep checking for and exporting
+            new points until interrupted.  Defaults to "false".
+    -follow-interval <duration>
+            Optional. How often to check for new points when following.
+            Defaults to "5s".
+    -escape-newlines
+            Optional. Escape newlines in string field values so each point
+            stays on one line.  Defaults to "false".
+    -precision <unit>
+            Optional. The precision of the exported timestamps: "ns",
+            "us", "ms" or "s". Timestamps are truncated to the unit, and
+            the export must be imported with the same precision.
+            Defaults to "ns".
+    -v2
+            Optional. Write a "# CONTEXT-BUCKET:<database>/<retention
+            policy>" header before the points of each database and
+            retention policy instead of the database and retention
+            policy headers, and no DDL, for InfluxDB 2 ingestion. Can
+            not be used with -with-ddl.  Defaults to "false".
+    -output-format-version <version>
+            Optional. Version of the export format to write. Version 1
+            has no version header, and version 2 no precision header.
+            -precision, -escape-newlines and -v2 require version 3.
+            Defaults to "%[2]d", the latest.
+    -null-policy <policy>
+            Optional. How to export a point missing fields that other
+            points in its series have: "empty" leaves the fields out,
+            "skip" skips the point and "zero" fills the fields with the
+            zero value of their type.  Defaults to "empty".
+    -max-output-size <bytes>
+            Optional. Stop the export once the uncompressed output would
+            grow past this many bytes, and exit with status 2. The last
+            point written is printed so a later export can resume from it.
+            Defaults to "0", no limit.
+    -redact-tags <key>
+            Optional. Replace the values of the tag key with a stable
+            hash, so series stay distinct without revealing the values.
+            May be repeated.
+    -redact-fields <key>
+            Optional. Replace the values of the field with a stable
+            hash of the same type. Booleans are not redacted. May be
+            repeated.
+    -gaps
+            Optional. Instead of exporting, report each gap between
+            consecutive points of a series longer than
+            -expected-interval, which shows where data was deleted or
+            never written.  Defaults to "false".
+    -expected-interval <duration>
+            Expected time between consecutive points of a series,
+            required by -gaps.
+    -checksum
+            Write a digest of the points of each series, and of
+            each database, instead of exporting, to compare the
+            data held by two nodes.  Defaults to "false".
+    -estimate
+            Report the estimated number of series, lines and
+            uncompressed bytes the export would write for each
+            database, without exporting.  Defaults to "false".
+    -reverse
+            Export the points of each series newest first, reading WAL
+            segments before TSM files and the newest file first.
+            Can not be used with -follow.  Defaults to "false".
+`, os.Getenv("HOME"), latestFormatVersion, defaultBufferSize)
 
 	fmt.Fprintf(cmd.Stdout, usage)
 }
+
+// countingWriter counts the bytes written to an io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	*w.n += int64(n)
+	return n, err
+}
@@ -1,3 +1,1202 @@
 package export_test
 
-// #TODO: write some tests
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_inspect/export"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/internal/testutil"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure an interrupted export leaves a readable, line-complete gzip file.
+func TestCommand_Run_Interrupt(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, 3.0)},
+	})
+
+	out := filepath.Join(dir, "export.gz")
+	cmd := NewCommand()
+	cmd.Interrupt <- os.Interrupt
+	if err := cmd.Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-compress"); err != export.ErrInterrupted {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := MustReadGzipLines(t, out)
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "# INFLUXDB EXPORT") {
+		t.Fatalf("unexpected output: %q", lines)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "cpu") {
+			t.Fatalf("unexpected point after interrupt: %q", line)
+		}
+	}
+}
+
+// Ensure compressed exports written at any gzip level read back the same,
+// and that invalid levels are rejected.
+func TestCommand_Run_GzipLevel(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	values := make([]tsm1.Value, 1000)
+	for i := range values {
+		values[i] = tsm1.NewValue(int64(i), float64(i%10))
+	}
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": values,
+	})
+
+	sizes := make(map[string]int64)
+	var exp []string
+	for _, level := range []string{"0", "-1", "9"} {
+		out := filepath.Join(dir, "export"+level+".gz")
+		if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-compress", "-gzip-level", level); err != nil {
+			t.Fatalf("level %s: %s", level, err)
+		}
+		fi, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[level] = fi.Size()
+
+		var points []string
+		for _, line := range MustReadGzipLines(t, out) {
+			if strings.HasPrefix(line, "cpu") {
+				points = append(points, line)
+			}
+		}
+		if len(points) != len(values) {
+			t.Fatalf("level %s: unexpected points: %d", level, len(points))
+		} else if exp != nil && !reflect.DeepEqual(points, exp) {
+			t.Fatalf("level %s: points differ from level 0", level)
+		}
+		exp = points
+	}
+	if sizes["0"] <= sizes["9"] {
+		t.Fatalf("expected level 9 to be smaller than level 0: %v", sizes)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"-compress", "-gzip-level", "10"}, err: "invalid gzip level 10, expected -1 to 9"},
+		{args: []string{"-compress", "-gzip-level", "-2"}, err: "invalid gzip level -2, expected -1 to 9"},
+		{args: []string{"-gzip-level", "9"}, err: "-gzip-level requires -compress"},
+	} {
+		args := append([]string{"-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", filepath.Join(dir, "invalid.gz")}, tt.args...)
+		if err := NewCommand().Run(args...); err == nil || err.Error() != tt.err {
+			t.Errorf("%v: unexpected error: got %v, exp %q", tt.args, err, tt.err)
+		}
+	}
+}
+
+// Ensure only the measurements matching -measurement, the fields listed by
+// -field and the series with the -tag values are exported, from both TSM and
+// WAL files.
+func TestCommand_Run_Filter(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#user":       {tsm1.NewValue(0, 1.0)},
+		"cpu,host=a#!~#system":     {tsm1.NewValue(0, 2.0)},
+		"cpu,host=a#!~#idle":       {tsm1.NewValue(0, 3.0)},
+		"cpu_total,host=a#!~#user": {tsm1.NewValue(0, 4.0)},
+		`disk\ io,host=a#!~#user`:  {tsm1.NewValue(0, 5.0)},
+		"mem,host=a#!~#user":       {tsm1.NewValue(0, 6.0)},
+		`mem,host=a\,b#!~#user`:    {tsm1.NewValue(0, 10.0)},
+	})
+	testutil.MustWriteWAL(filepath.Join(dir, "wal", "db0", "rp0", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=b#!~#user": {tsm1.NewValue(10, 7.0)},
+		"cpu,host=b#!~#idle": {tsm1.NewValue(10, 8.0)},
+		"mem,host=b#!~#user": {tsm1.NewValue(10, 9.0)},
+	})
+
+	for _, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{
+			args: []string{"-measurement", "^cpu$"},
+			exp: []string{
+				"cpu,host=a idle=3 0",
+				"cpu,host=a system=2 0",
+				"cpu,host=a user=1 0",
+				"cpu,host=b idle=8 10",
+				"cpu,host=b user=7 10",
+			},
+		},
+		{
+			args: []string{"-measurement", "^(cpu|disk io)", "-field", "user,system"},
+			exp: []string{
+				"cpu,host=a system=2 0",
+				"cpu,host=a user=1 0",
+				"cpu,host=b user=7 10",
+				"cpu_total,host=a user=4 0",
+				`disk\ io,host=a user=5 0`,
+			},
+		},
+		{
+			args: []string{"-tag", "host=b"},
+			exp: []string{
+				"cpu,host=b idle=8 10",
+				"cpu,host=b user=7 10",
+				"mem,host=b user=9 10",
+			},
+		},
+		{
+			args: []string{"-measurement", "^mem$", "-tag", "host=a,b"},
+			exp: []string{
+				`mem,host=a\,b user=10 0`,
+			},
+		},
+		{
+			args: []string{"-tag", "host=a", "-tag", "region=west"},
+		},
+	} {
+		out := filepath.Join(dir, "export")
+		args := append([]string{"-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out}, tt.args...)
+		if err := NewCommand().Run(args...); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var points []string
+		for _, line := range strings.Split(string(buf), "\n") {
+			if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "CREATE") {
+				points = append(points, line)
+			}
+		}
+		sort.Strings(points)
+		if !reflect.DeepEqual(points, tt.exp) {
+			t.Errorf("%v: unexpected points:\n\ngot=%q\n\nexp=%q", tt.args, points, tt.exp)
+		}
+	}
+
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-measurement", "cpu(", "-out", filepath.Join(dir, "invalid")); err == nil || !strings.HasPrefix(err.Error(), "invalid -measurement: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a compressed export can be read back in full.
+// Ensure -reverse writes the points of a series newest first, reading WAL
+// segments before TSM files and the newest file of each first.
+func TestCommand_Run_Reverse(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(20, 3.0), tsm1.NewValue(30, 4.0)},
+	})
+	testutil.MustWriteWAL(filepath.Join(dir, "wal", "db0", "rp0", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(40, 5.0)},
+	})
+	testutil.MustWriteWAL(filepath.Join(dir, "wal", "db0", "rp0", "1", "_00002.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(50, 6.0)},
+	})
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-reverse"); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "CREATE") {
+			points = append(points, line)
+		}
+	}
+	if exp := []string{
+		"cpu,host=a value=6 50",
+		"cpu,host=a value=5 40",
+		"cpu,host=a value=4 30",
+		"cpu,host=a value=3 20",
+		"cpu,host=a value=2 10",
+		"cpu,host=a value=1 0",
+	}; !reflect.DeepEqual(points, exp) {
+		t.Fatalf("unexpected points: %v", points)
+	}
+
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-out", out, "-reverse", "-follow"); err == nil || err.Error() != "-reverse can not be used with -follow" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure -checksum writes the same digests for the same points however they
+// are laid out in files, and different digests once a point differs.
+func TestCommand_Run_Checksum(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	checksums := func(name string) []string {
+		if err := os.MkdirAll(filepath.Join(dir, name, "wal"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		cmd := NewCommand()
+		cmd.Stdout = &buf
+		if err := cmd.Run("-datadir", filepath.Join(dir, name, "data"), "-waldir", filepath.Join(dir, name, "wal"), "-checksum"); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	// The points of node a are in a single file.
+	testutil.MustWriteTSM(filepath.Join(dir, "a", "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(20, int64(7))},
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.5), tsm1.NewValue(20, 2.5)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, int64(3))},
+	})
+
+	// Node b holds the same points across two files and the WAL, with a
+	// value replaced by a later file.
+	testutil.MustWriteTSM(filepath.Join(dir, "b", "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 9.5)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "b", "data", "db0", "rp0", "1", "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.5)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, int64(3))},
+	})
+	testutil.MustWriteWAL(filepath.Join(dir, "b", "wal", "db0", "rp0", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(20, int64(7))},
+		"cpu,host=a#!~#value": {tsm1.NewValue(20, 2.5)},
+	})
+
+	// Node c differs in the type of a value.
+	testutil.MustWriteTSM(filepath.Join(dir, "c", "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(20, int64(7))},
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.5), tsm1.NewValue(20, 2.5)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, 3.0)},
+	})
+
+	a, b, c := checksums("a"), checksums("b"), checksums("c")
+	if len(a) != 3 || !strings.HasPrefix(a[0], "db0\trp0\tcpu,host=a\t") || !strings.HasPrefix(a[1], "db0\trp0\tcpu,host=b\t") || !strings.HasPrefix(a[2], "database\tdb0\t") {
+		t.Fatalf("unexpected output:\n%s", strings.Join(a, "\n"))
+	} else if !reflect.DeepEqual(a, b) {
+		t.Fatalf("checksums differ:\n%s\n\n%s", strings.Join(a, "\n"), strings.Join(b, "\n"))
+	} else if len(c) != 3 || a[0] != c[0] || a[1] == c[1] || a[2] == c[2] {
+		t.Fatalf("unexpected checksums:\n%s\n\n%s", strings.Join(a, "\n"), strings.Join(c, "\n"))
+	}
+}
+
+// Ensure -estimate reports the lines and bytes of each database's export
+// without writing it.
+func TestCommand_Run_Estimate(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.5), tsm1.NewValue(20, 2.5)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, 3.5)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db1", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"mem,host=a#!~#free": {tsm1.NewValue(100, int64(7))},
+	})
+	testutil.MustWriteWAL(filepath.Join(dir, "wal", "db1", "rp0", "2", "_00001.wal"), map[string][]tsm1.Value{
+		"mem,host=a#!~#free": {tsm1.NewValue(200, int64(8))},
+	})
+
+	var buf bytes.Buffer
+	cmd := NewCommand()
+	cmd.Stdout = &buf
+	out := filepath.Join(dir, "export")
+	if err := cmd.Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-estimate"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("expected no export to be written: %v", err)
+	}
+
+	// Each line is the series, field, value and time, e.g.
+	// "cpu,host=a value=1.5 10\n" and "mem,host=a free=7i 100\n".
+	var rows []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		rows = append(rows, strings.Join(strings.Fields(line), " "))
+	}
+	if exp := []string{
+		"Database Series (est) Lines Est. Bytes",
+		"db0 2 3 72",
+		"db1 1 2 46",
+		"Total 5 118",
+	}; !reflect.DeepEqual(rows, exp) {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestCommand_Run_Compress(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, 3.0)},
+	})
+
+	out := filepath.Join(dir, "export.gz")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-compress"); err != nil {
+		t.Fatal(err)
+	}
+
+	var points []string
+	for _, line := range MustReadGzipLines(t, out) {
+		if strings.HasPrefix(line, "cpu") {
+			points = append(points, line)
+		}
+	}
+
+	exp := []string{
+		"cpu,host=a value=1 0",
+		"cpu,host=a value=2 10",
+		"cpu,host=b value=3 0",
+	}
+	if !reflect.DeepEqual(points, exp) {
+		t.Fatalf("unexpected points:\n\ngot=%q\n\nexp=%q", points, exp)
+	}
+}
+
+// Ensure a failure to flush the buffered output, such as on a full disk,
+// fails the export.
+func TestCommand_Run_BufferFlushError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full not available")
+	}
+
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+
+	for _, compress := range []bool{false, true} {
+		args := []string{"-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", "/dev/full"}
+		if compress {
+			args = append(args, "-compress")
+		}
+		if err := NewCommand().Run(args...); err == nil || !strings.Contains(err.Error(), "no space left on device") {
+			t.Fatalf("compress=%v: unexpected error: %v", compress, err)
+		}
+	}
+
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "export"), "-buffer-size", "0"); err == nil || err.Error() != "buffer size must be positive" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure -precision writes timestamps in its unit and -v2 names the bucket of
+// each database and retention policy instead of writing DDL.
+func TestCommand_Run_PrecisionV2(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(1500000000123456789, 1.0)},
+	})
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-precision", "ms", "-v2"); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+
+	exp := []string{
+		"# DML",
+		"# CONTEXT-BUCKET:db0/rp0",
+		"# writing tsm data",
+		"cpu,host=a value=1 1500000000123",
+	}
+	if len(lines) < len(exp) || !reflect.DeepEqual(lines[len(lines)-len(exp):], exp) {
+		t.Fatalf("unexpected lines:\n\ngot=%q\n\nexp=%q", lines, exp)
+	}
+	for _, line := range lines {
+		if line == "# DDL" || strings.HasPrefix(line, "CREATE DATABASE") {
+			t.Fatalf("unexpected DDL: %q", line)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"-precision", "m"}, err: `invalid precision "m", expected ns, us, ms or s`},
+		{args: []string{"-v2", "-with-ddl"}, err: "-v2 can not be used with -with-ddl"},
+	} {
+		args := append([]string{"-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "invalid")}, tt.args...)
+		if err := NewCommand().Run(args...); err == nil || err.Error() != tt.err {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+	}
+}
+
+// Ensure string values containing newlines are exported on a single line and
+// are restored when the export is read back.
+func TestCommand_Run_EscapeNewlines(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	value := "line one\nline two\r\n\"quoted\" \\n"
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#msg": {tsm1.NewValue(0, value)},
+	})
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-escape-newlines"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var escaped bool
+	var points []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line == "# ESCAPED-NEWLINES" {
+			escaped = true
+		} else if strings.HasPrefix(line, "cpu") {
+			points = append(points, line)
+		}
+	}
+	if !escaped {
+		t.Fatal("expected escaped newlines header")
+	} else if len(points) != 1 {
+		t.Fatalf("unexpected points: %q", points)
+	}
+
+	pts, err := models.ParsePointsString(models.UnescapeStringFieldNewlines(points[0]))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pts) != 1 {
+		t.Fatalf("unexpected point count: %d", len(pts))
+	} else if v := pts[0].Fields()["msg"]; v != value {
+		t.Fatalf("unexpected value: %q", v)
+	}
+}
+
+// Ensure the export records its format version unless an older version
+// without the header is requested.
+func TestCommand_Run_FormatVersion(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+
+	header := func(args ...string) []string {
+		out := filepath.Join(dir, "export")
+		args = append([]string{"-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out}, args...)
+		if err := NewCommand().Run(args...); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(string(buf), "\n")
+		for i, line := range lines {
+			if line == "# DDL" {
+				return lines[:i]
+			}
+		}
+		t.Fatalf("missing DDL header: %q", lines)
+		return nil
+	}
+
+	if lines := header(); len(lines) != 3 || lines[1] != "# FORMAT-VERSION:3" || lines[2] != "# PRECISION:ns" {
+		t.Fatalf("unexpected header: %q", lines)
+	}
+	if lines := header("-precision", "ms"); len(lines) != 3 || lines[2] != "# PRECISION:ms" {
+		t.Fatalf("unexpected header: %q", lines)
+	}
+	if lines := header("-output-format-version", "2"); len(lines) != 2 || lines[1] != "# FORMAT-VERSION:2" {
+		t.Fatalf("unexpected header: %q", lines)
+	}
+	if lines := header("-output-format-version", "1"); len(lines) != 1 || !strings.HasPrefix(lines[0], "# INFLUXDB EXPORT") {
+		t.Fatalf("unexpected header: %q", lines)
+	}
+
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "export"), "-output-format-version", "4"); err == nil || !strings.Contains(err.Error(), "unsupported output format version") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Output older importers would misread is refused for older versions.
+	for _, args := range [][]string{
+		{"-output-format-version", "2", "-precision", "s"},
+		{"-output-format-version", "2", "-escape-newlines"},
+		{"-output-format-version", "1", "-v2"},
+	} {
+		args = append([]string{"-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "export")}, args...)
+		if err := NewCommand().Run(args...); err == nil || !strings.Contains(err.Error(), "require -output-format-version 3") {
+			t.Fatalf("unexpected error for %v: %v", args, err)
+		}
+	}
+}
+
+// Ensure points missing fields of their series are exported according to
+// the null policy.
+func TestCommand_Run_NullPolicy(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#busy": {tsm1.NewValue(0, int64(2)), tsm1.NewValue(10, int64(3))},
+		"cpu,host=a#!~#idle": {tsm1.NewValue(0, 1.0)},
+		"mem#!~#free":        {tsm1.NewValue(0, 5.0)},
+	})
+
+	for _, tt := range []struct {
+		policy string
+		exp    []string
+	}{
+		{
+			policy: "",
+			exp:    []string{"cpu,host=a busy=2i 0", "cpu,host=a busy=3i 10", "cpu,host=a idle=1 0", "mem free=5 0"},
+		},
+		{
+			policy: "empty",
+			exp:    []string{"cpu,host=a busy=2i 0", "cpu,host=a busy=3i 10", "cpu,host=a idle=1 0", "mem free=5 0"},
+		},
+		{
+			policy: "skip",
+			exp:    []string{"cpu,host=a busy=2i 0", "cpu,host=a idle=1 0", "mem free=5 0"},
+		},
+		{
+			policy: "zero",
+			exp:    []string{"cpu,host=a busy=2i 0", "cpu,host=a busy=3i 10", "cpu,host=a idle=1 0", "mem free=5 0", "cpu,host=a idle=0 10"},
+		},
+	} {
+		out := filepath.Join(dir, "export-"+tt.policy)
+		args := []string{"-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out}
+		if tt.policy != "" {
+			args = append(args, "-null-policy", tt.policy)
+		}
+		if err := NewCommand().Run(args...); err != nil {
+			t.Fatalf("%s: %s", tt.policy, err)
+		}
+
+		buf, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var points []string
+		for _, line := range strings.Split(string(buf), "\n") {
+			if strings.HasPrefix(line, "cpu") || strings.HasPrefix(line, "mem") {
+				points = append(points, line)
+			}
+		}
+		if !reflect.DeepEqual(points, tt.exp) {
+			t.Fatalf("%s: unexpected points:\n\ngot=%q\n\nexp=%q", tt.policy, points, tt.exp)
+		}
+	}
+}
+
+// Ensure redacted tag and field values are replaced by a stable hash that
+// keeps distinct values distinct.
+func TestCommand_Run_Redact(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a,region=west#!~#user": {tsm1.NewValue(0, "alice")},
+		"cpu,host=b,region=west#!~#user": {tsm1.NewValue(0, "alice"), tsm1.NewValue(10, "bob")},
+	})
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-redact-tags", "host", "-redact-fields", "user"); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var points []models.Point
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "cpu") {
+			p, err := models.ParsePointsString(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points = append(points, p...)
+		}
+	}
+	if len(points) != 3 {
+		t.Fatalf("unexpected points: %s", buf)
+	}
+
+	hosts, users := make(map[string]struct{}), make(map[string]struct{})
+	for _, p := range points {
+		if region := p.Tags().GetString("region"); region != "west" {
+			t.Fatalf("unexpected region: %s", region)
+		}
+		host := p.Tags().GetString("host")
+		if host == "a" || host == "b" {
+			t.Fatalf("host not redacted: %s", p)
+		}
+		hosts[host] = struct{}{}
+
+		user := p.Fields()["user"].(string)
+		if user == "alice" || user == "bob" {
+			t.Fatalf("user not redacted: %s", p)
+		}
+		users[user] = struct{}{}
+	}
+	if len(hosts) != 2 || len(users) != 2 {
+		t.Fatalf("expected distinct values to stay distinct: %s", buf)
+	}
+}
+
+// Ensure the export stops before the uncompressed output exceeds the
+// maximum size, ending on a complete point.
+func TestCommand_Run_MaxOutputSize(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var values []tsm1.Value
+	for i := 0; i < 10; i++ {
+		values = append(values, tsm1.NewValue(int64(i), float64(i)))
+	}
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": values,
+	})
+
+	full := filepath.Join(dir, "full")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", full, "-compress"); err != nil {
+		t.Fatal(err)
+	}
+	lines := MustReadGzipLines(t, full)
+	var size int
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+
+	// Leave room for all but the last two points.
+	limit := size - len(lines[len(lines)-1]) - 2
+	out := filepath.Join(dir, "export")
+	var stderr bytes.Buffer
+	cmd := NewCommand()
+	cmd.Stderr = &stderr
+	if err := cmd.Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-compress", "-max-output-size", strconv.Itoa(limit)); err != export.ErrTruncated {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, exp := MustReadGzipLines(t, out), lines[:len(lines)-2]; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected lines:\n\ngot=%q\n\nexp=%q", got, exp)
+	}
+	if exp := "last point written: cpu,host=a#!~#value at 7"; !strings.Contains(stderr.String(), exp) {
+		t.Fatalf("expected notice %q, got %q", exp, stderr.String())
+	}
+}
+
+// Ensure a key whose blocks can not be read is reported and skipped, and
+// the rest of the file is still exported.
+func TestCommand_Run_UnreadableKey(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm")
+	testutil.MustWriteTSM(path, map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+		"mem#!~#free":         {tsm1.NewValue(0, 5.0)},
+	})
+
+	// Corrupt the block type of the first block, after the file header and
+	// the block's checksum.
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff}, 9); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out := filepath.Join(dir, "export")
+	var stderr bytes.Buffer
+	cmd := NewCommand()
+	cmd.Stderr = &stderr
+	if err := cmd.Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(buf), "cpu") || !strings.Contains(string(buf), "mem free=5 0\n") {
+		t.Fatalf("unexpected export:\n%s", buf)
+	}
+	if exp := `unable to read key "cpu,host=a#!~#value" in ` + path + `, skipping`; !strings.Contains(stderr.String(), exp) {
+		t.Fatalf("expected %q, got %q", exp, stderr.String())
+	} else if exp := "1 keys of TSM files could not be read and were skipped\n"; !strings.Contains(stderr.String(), exp) {
+		t.Fatalf("expected %q, got %q", exp, stderr.String())
+	}
+}
+
+// Ensure following an export writes only points newer than those already
+// exported, including points from files created after the export started.
+func TestCommand_Run_Follow(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+	})
+
+	out := filepath.Join(dir, "export")
+	cmd := NewCommand()
+	errs := make(chan error)
+	go func() {
+		errs <- cmd.Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out, "-follow", "-follow-interval", "10ms")
+	}()
+	MustWaitForLine(t, out, "cpu,host=a value=2 10")
+
+	// Simulate a compaction replacing the file with one holding new points.
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000002.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0), tsm1.NewValue(20, 3.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(5, 4.0)},
+	})
+	if err := os.Remove(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm")); err != nil {
+		t.Fatal(err)
+	}
+	MustWaitForLine(t, out, "cpu,host=b value=4 5")
+
+	cmd.Interrupt <- os.Interrupt
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var points []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "cpu") {
+			points = append(points, line)
+		}
+	}
+
+	exp := []string{
+		"cpu,host=a value=1 0",
+		"cpu,host=a value=2 10",
+		"cpu,host=a value=3 20",
+		"cpu,host=b value=4 5",
+	}
+	if !reflect.DeepEqual(points, exp) {
+		t.Fatalf("unexpected points:\n\ngot=%q\n\nexp=%q", points, exp)
+	}
+}
+
+// Ensure -with-ddl writes statements creating databases and retention
+// policies using the settings from the metadata.
+func TestCommand_Run_WithDDL(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "autogen", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db1", "rp1", "3", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+
+	// Only db0 is in the metadata.
+	if err := os.Mkdir(filepath.Join(dir, "meta"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	c := meta.NewClient(&meta.Config{Dir: filepath.Join(dir, "meta")})
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	duration, replicaN := 7*24*time.Hour, 2
+	if _, err := c.CreateDatabaseWithRetentionPolicy("db0", &meta.RetentionPolicySpec{Name: "rp0", Duration: &duration, ReplicaN: &replicaN, ShardGroupDuration: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-metadir", filepath.Join(dir, "meta"), "-out", out, "-with-ddl"); err != nil {
+		t.Fatal(err)
+	}
+
+	readDDL := func() []string {
+		buf, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ddl []string
+		for _, line := range strings.Split(string(buf), "\n") {
+			if strings.HasPrefix(line, "CREATE") {
+				ddl = append(ddl, line)
+			}
+		}
+		return ddl
+	}
+
+	exp := []string{
+		"CREATE DATABASE db0",
+		"CREATE RETENTION POLICY autogen ON db0 DURATION 0s REPLICATION 1",
+		"CREATE RETENTION POLICY rp0 ON db0 DURATION 1w REPLICATION 2 SHARD DURATION 1d DEFAULT",
+		"CREATE DATABASE db1",
+		"CREATE RETENTION POLICY rp1 ON db1 DURATION 0s REPLICATION 1",
+	}
+	if ddl := readDDL(); !reflect.DeepEqual(ddl, exp) {
+		t.Fatalf("unexpected ddl:\n\ngot=%q\n\nexp=%q", ddl, exp)
+	}
+
+	// Without -with-ddl the metadata is not read, and every retention policy
+	// is created as the default of its database.
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-metadir", filepath.Join(dir, "meta"), "-out", out); err != nil {
+		t.Fatal(err)
+	}
+	exp = []string{
+		"CREATE DATABASE db0 WITH NAME autogen",
+		"CREATE DATABASE db0 WITH NAME rp0",
+		"CREATE DATABASE db1 WITH NAME rp1",
+	}
+	ddl := readDDL()
+	sort.Strings(ddl)
+	if !reflect.DeepEqual(ddl, exp) {
+		t.Fatalf("unexpected ddl without -with-ddl:\n\ngot=%q\n\nexp=%q", ddl, exp)
+	}
+}
+
+// Ensure gaps between the points of a series longer than the expected
+// interval are reported, with the points merged across fields and shards.
+func TestCommand_Run_Gaps(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10e9, 2.0)},
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(20e9, 3.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(50e9, 2.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(60e9, 4.0), tsm1.NewValue(70e9, 5.0)},
+	})
+
+	var stdout bytes.Buffer
+	cmd := NewCommand()
+	cmd.Stdout = &stdout
+	if err := cmd.Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", filepath.Join(dir, "export"),
+		"-gaps", "-expected-interval", "15s"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected output: %q", lines)
+	}
+	for i, exp := range []string{
+		"db0 rp0 cpu,host=a 1970-01-01T00:00:20Z 1970-01-01T00:01:00Z 40s",
+		"db0 rp0 cpu,host=b 1970-01-01T00:00:00Z 1970-01-01T00:00:50Z 50s",
+	} {
+		if got := strings.Join(strings.Fields(lines[i+1]), " "); got != exp {
+			t.Fatalf("unexpected gap %d: got %q, exp %q", i, got, exp)
+		}
+	}
+	if exp := "2 gaps longer than 15s found."; lines[3] != exp {
+		t.Fatalf("unexpected summary: got %q, exp %q", lines[3], exp)
+	}
+
+	// Points are not exported.
+	if _, err := os.Stat(filepath.Join(dir, "export")); !os.IsNotExist(err) {
+		t.Fatalf("unexpected export: %v", err)
+	}
+
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "export"), "-gaps"); err == nil || !strings.Contains(err.Error(), "-expected-interval") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// NewCommand returns an export command that discards its diagnostics.
+// Ensure the points of each retention policy follow a context line naming
+// the retention policy of the directory they were read from.
+func TestCommand_Run_RetentionPolicyContext(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "one_week", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"mem,host=a#!~#value": {tsm1.NewValue(0, 2.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db1", "raw", "3", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"disk,host=a#!~#value": {tsm1.NewValue(0, 3.0)},
+	})
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var db, rp string
+	got := make(map[string]string)
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "# CONTEXT-DATABASE:") {
+			db = strings.TrimPrefix(line, "# CONTEXT-DATABASE:")
+		} else if strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:") {
+			rp = strings.TrimPrefix(line, "# CONTEXT-RETENTION-POLICY:")
+		} else if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "CREATE") {
+			got[line] = db + "/" + rp
+		}
+	}
+
+	exp := map[string]string{
+		"cpu,host=a value=1 0":  "db0/autogen",
+		"mem,host=a value=2 0":  "db0/one_week",
+		"disk,host=a value=3 0": "db1/raw",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected contexts:\n\ngot=%v\n\nexp=%v", got, exp)
+	}
+}
+
+// Ensure points with special characters in their measurement, tags, field
+// keys and string values are escaped so the export parses back to the same
+// points.
+func TestCommand_Run_Escaping(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Series keys are stored escaped and field keys unescaped, as written
+	// by the engine.
+	series := string(models.MakeKey([]byte("cpu load,avg"), models.NewTags(map[string]string{
+		"host name": "a,b c=d",
+		"region":    `us "west"`,
+	})))
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		tsm1.SeriesFieldKey(series, "load avg=1m"):  {tsm1.NewValue(0, 1.5)},
+		tsm1.SeriesFieldKey(series, "count,total"):  {tsm1.NewValue(0, int64(2))},
+		tsm1.SeriesFieldKey(series, `msg "quoted"`): {tsm1.NewValue(0, `say "hi", a=b \ c`)},
+		tsm1.SeriesFieldKey(series, "ok"):           {tsm1.NewValue(0, true)},
+		tsm1.SeriesFieldKey(series, "tiny"):         {tsm1.NewValue(0, 1e-21)},
+	})
+
+	out := filepath.Join(dir, "export")
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := make(map[string]interface{})
+	for _, line := range strings.Split(string(buf), "\n") {
+		if !strings.HasPrefix(line, "cpu") {
+			continue
+		}
+		points, err := models.ParsePointsString(line)
+		if err != nil {
+			t.Fatalf("unable to parse %q: %v", line, err)
+		} else if len(points) != 1 {
+			t.Fatalf("unexpected points in %q: %d", line, len(points))
+		}
+
+		p := points[0]
+		if p.Name() != "cpu load,avg" {
+			t.Fatalf("unexpected measurement in %q: %q", line, p.Name())
+		} else if exp := map[string]string{"host name": "a,b c=d", "region": `us "west"`}; !reflect.DeepEqual(p.Tags().Map(), exp) {
+			t.Fatalf("unexpected tags in %q: %v", line, p.Tags().Map())
+		}
+		for k, v := range p.Fields() {
+			fields[k] = v
+		}
+	}
+
+	exp := map[string]interface{}{
+		"load avg=1m":  1.5,
+		"count,total":  int64(2),
+		`msg "quoted"`: `say "hi", a=b \ c`,
+		"ok":           true,
+		"tiny":         1e-21,
+	}
+	if !reflect.DeepEqual(fields, exp) {
+		t.Fatalf("unexpected fields:\n\ngot=%#v\n\nexp=%#v", fields, exp)
+	}
+}
+
+// Ensure only points within the time range given by -start and -end are
+// exported, whether given in RFC3339 format or in nanoseconds, and that
+// deleted points stay deleted.
+func TestCommand_Run_TimeRange(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Write the points of cpu in several blocks.
+	path := filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm")
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range [][]tsm1.Value{
+		{tsm1.NewValue(0, 1.0), tsm1.NewValue(10e9, 2.0)},
+		{tsm1.NewValue(20e9, 3.0), tsm1.NewValue(30e9, 4.0)},
+		{tsm1.NewValue(40e9, 5.0), tsm1.NewValue(50e9, 6.0)},
+	} {
+		if err := w.Write("cpu#!~#value", block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	} else if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete a point.
+	if f, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := r.DeleteRange([]string{"cpu#!~#value"}, 30e9, 30e9); err != nil {
+		t.Fatal(err)
+	} else if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{args: nil, exp: []string{"cpu value=1 0", "cpu value=2 10000000000", "cpu value=3 20000000000", "cpu value=5 40000000000", "cpu value=6 50000000000"}},
+		{args: []string{"-start", "10000000000", "-end", "40000000000"}, exp: []string{"cpu value=2 10000000000", "cpu value=3 20000000000", "cpu value=5 40000000000"}},
+		{args: []string{"-start", "1970-01-01T00:00:15Z", "-end", "1970-01-01T00:00:35Z"}, exp: []string{"cpu value=3 20000000000"}},
+		{args: []string{"-start", "45000000000"}, exp: []string{"cpu value=6 50000000000"}},
+		{args: []string{"-end", "0"}, exp: []string{"cpu value=1 0"}},
+	} {
+		out := filepath.Join(dir, "export"+strconv.Itoa(i))
+		args := append([]string{"-datadir", filepath.Join(dir, "data"), "-waldir", filepath.Join(dir, "wal"), "-out", out}, tt.args...)
+		if err := NewCommand().Run(args...); err != nil {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+
+		buf, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var points []string
+		for _, line := range strings.Split(string(buf), "\n") {
+			if strings.HasPrefix(line, "cpu") {
+				points = append(points, line)
+			}
+		}
+		if !reflect.DeepEqual(points, tt.exp) {
+			t.Errorf("%d. unexpected points:\n\ngot=%q\n\nexp=%q", i, points, tt.exp)
+		}
+	}
+
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "export"), "-start", "yesterday"); err == nil || err.Error() != `invalid start time: "yesterday" is neither an RFC3339 time nor a Unix timestamp in nanoseconds` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := NewCommand().Run("-datadir", filepath.Join(dir, "data"), "-out", filepath.Join(dir, "export"), "-start", "10", "-end", "0"); err == nil || err.Error() != "end time before start time" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func NewCommand() *export.Command {
+	cmd := export.NewCommand()
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = ioutil.Discard
+	return cmd
+}
+
+// MustReadGzipLines reads every line from the gzip file at path, failing the
+// test if the stream is truncated or otherwise invalid.
+func MustReadGzipLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("read gzip: %s", err)
+	}
+	return lines
+}
+
+// MustWaitForLine waits for the file at path to contain line, failing the
+// test if it does not appear within a few seconds.
+func MustWaitForLine(t *testing.T, path, line string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		if buf, err := ioutil.ReadFile(path); err == nil && strings.Contains(string(buf), line+"\n") {
+			return
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", line)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package export
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// parseFilters parses the -measurement regular expression and the -field
+// list. An empty flag leaves its filter unset, so nothing is filtered out.
+func (cmd *Command) parseFilters(measurement, fields string) error {
+	if measurement != "" {
+		re, err := regexp.Compile(measurement)
+		if err != nil {
+			return fmt.Errorf("invalid -measurement: %s", err)
+		}
+		cmd.measurementFilter = re
+	}
+
+	if fields != "" {
+		cmd.fieldFilter = make(map[string]struct{})
+		for _, field := range strings.Split(fields, ",") {
+			if field == "" {
+				return fmt.Errorf("invalid -field %q, field keys must not be empty", fields)
+			}
+			cmd.fieldFilter[field] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// selected returns true if the series and field key k passes the
+// -measurement, -field and -tag filters. The regular expression is matched
+// against the unescaped measurement name, field keys are compared exactly,
+// and tag values are compared with the unescaped tags parsed from the
+// series key. Keys filtered out are never decoded.
+func (cmd *Command) selected(k []byte) bool {
+	series, field := tsm1.SeriesAndFieldFromCompositeKey(k)
+	if cmd.fieldFilter != nil {
+		if _, ok := cmd.fieldFilter[field]; !ok {
+			return false
+		}
+	}
+	if cmd.measurementFilter == nil && len(cmd.tagFilter) == 0 {
+		return true
+	}
+
+	name, tags, _ := models.ParseKey(series)
+	if cmd.measurementFilter != nil && !cmd.measurementFilter.MatchString(escape.UnescapeString(name)) {
+		return false
+	}
+	for key, value := range cmd.tagFilter {
+		if tags.GetString(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// tagList is a repeatable flag holding the value required of each tag key.
+type tagList map[string]string
+
+// String returns the tags as key=value pairs, sorted and comma-separated.
+func (l tagList) String() string {
+	a := make([]string, 0, len(l))
+	for k, v := range l {
+		a = append(a, k+"="+v)
+	}
+	sort.Strings(a)
+	return strings.Join(a, ",")
+}
+
+// Set adds a tag given as key=value. A key may only be given once, since a
+// series has a single value for it.
+func (l tagList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid tag %q, expected key=value", s)
+	}
+	key, value := s[:i], s[i+1:]
+	if _, ok := l[key]; ok {
+		return fmt.Errorf("tag key %q given more than once", key)
+	}
+	l[key] = value
+	return nil
+}
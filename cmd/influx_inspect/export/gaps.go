@@ -0,0 +1,127 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// gap is a span between two consecutive points of a series that is longer
+// than the expected interval.
+type gap struct {
+	series     string
+	start, end int64
+}
+
+// writeGaps writes, for each series in each exported database and retention
+// policy, the gaps between consecutive points longer than the expected
+// interval, instead of exporting the points. The points of a series are
+// merged across its fields, shards and WAL segments before gaps are looked
+// for, and deleted points are left out, so a gap shows where data was
+// deleted or never written.
+func (cmd *Command) writeGaps(w io.Writer) error {
+	keys := make([]string, 0, len(cmd.manifest))
+	for key := range cmd.manifest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"DB", "RP", "Series", "Gap Start", "Gap End", "Duration"}, "\t"))
+	var n int
+	for _, key := range keys {
+		gaps, err := cmd.findGaps(key)
+		if err != nil {
+			return err
+		}
+
+		dbrp := strings.Split(key, string(byte(os.PathSeparator)))
+		for _, g := range gaps {
+			fmt.Fprintln(tw, strings.Join([]string{
+				dbrp[0],
+				dbrp[1],
+				g.series,
+				time.Unix(0, g.start).UTC().Format(time.RFC3339Nano),
+				time.Unix(0, g.end).UTC().Format(time.RFC3339Nano),
+				time.Duration(g.end - g.start).String(),
+			}, "\t"))
+		}
+		n += len(gaps)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d gaps longer than %s found.\n", n, cmd.expectedInterval)
+	return nil
+}
+
+// findGaps returns the gaps in the series of the database and retention
+// policy key, ordered by series and time. The timestamps of every series
+// are held in memory until the gaps are found.
+func (cmd *Command) findGaps(key string) ([]gap, error) {
+	times := make(map[string][]int64)
+	add := func(k []byte, values []tsm1.Value) {
+		if !cmd.selected(k) {
+			return
+		}
+		series, _ := tsm1.SeriesAndFieldFromCompositeKey(k)
+		a := times[string(series)]
+		for _, v := range values {
+			if t := v.UnixNano(); t >= cmd.startTime && t <= cmd.endTime {
+				a = append(a, t)
+			}
+		}
+		times[string(series)] = a
+	}
+
+	for _, f := range cmd.tsmFiles[key] {
+		if err := cmd.readTSM(f, func(r *tsm1.TSMReader) error {
+			for i := 0; i < r.KeyCount(); i++ {
+				k, _ := r.KeyAt(i)
+				if !cmd.selected(k) {
+					continue
+				}
+				values, err := cmd.readValues(r, string(k))
+				if err != nil {
+					return err
+				}
+				add(k, values)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range cmd.walFiles[key] {
+		if err := cmd.readWAL(f, func(k string, values []tsm1.Value) {
+			add([]byte(k), values)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	series := make([]string, 0, len(times))
+	for s := range times {
+		series = append(series, s)
+	}
+	sort.Strings(series)
+
+	var gaps []gap
+	interval := int64(cmd.expectedInterval)
+	for _, s := range series {
+		a := int64Slice(times[s])
+		sort.Sort(a)
+		for i := 1; i < len(a); i++ {
+			if a[i]-a[i-1] > interval {
+				gaps = append(gaps, gap{series: string(cmd.redactSeries([]byte(s))), start: a[i-1], end: a[i]})
+			}
+		}
+	}
+	return gaps, nil
+}
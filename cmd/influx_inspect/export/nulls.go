@@ -0,0 +1,347 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Null policies control how a point missing fields that other points in its
+// series have is exported.
+const (
+	// nullPolicyEmpty leaves absent fields out of the point. Line protocol
+	// has no empty value, so this exports the stored fields unchanged.
+	nullPolicyEmpty = "empty"
+
+	// nullPolicySkip skips points missing any field of their series.
+	nullPolicySkip = "skip"
+
+	// nullPolicyZero fills absent fields with the zero value of their type.
+	nullPolicyZero = "zero"
+)
+
+// fieldIndex records the fields of each series in a database and retention
+// policy, and the fields present at each timestamp of series with more than
+// one field. Series are indexed per shard, so a field added to a series
+// later only affects the shards it was written to.
+type fieldIndex struct {
+	series map[string]*seriesFields
+}
+
+// seriesFields holds the fields of a series in a shard.
+type seriesFields struct {
+	shard  string
+	series string
+
+	fields []string
+	zeros  map[string]interface{}
+
+	// points holds, for each timestamp, whether each field in fields is
+	// present. It is only set for series with more than one field.
+	points map[int64][]bool
+}
+
+func newFieldIndex() *fieldIndex {
+	return &fieldIndex{series: make(map[string]*seriesFields)}
+}
+
+// addField adds a field, and the zero value of its type, to a series.
+func (idx *fieldIndex) addField(shard, series, field string, zero interface{}) {
+	k := shard + "\x00" + series
+	s := idx.series[k]
+	if s == nil {
+		s = &seriesFields{shard: shard, series: series, zeros: make(map[string]interface{})}
+		idx.series[k] = s
+	}
+	if _, ok := s.zeros[field]; !ok {
+		s.fields = append(s.fields, field)
+		s.zeros[field] = zero
+	}
+}
+
+// prepare readies the index to record timestamps, once all fields have been
+// added. It returns false if no series has more than one field.
+func (idx *fieldIndex) prepare() bool {
+	var ok bool
+	for _, s := range idx.series {
+		if len(s.fields) > 1 {
+			sort.Strings(s.fields)
+			s.points = make(map[int64][]bool)
+			ok = true
+		}
+	}
+	return ok
+}
+
+// lookup returns the fields of a series with more than one field, or nil.
+func (idx *fieldIndex) lookup(shard string, series []byte) *seriesFields {
+	if s := idx.series[shard+"\x00"+string(series)]; s != nil && s.points != nil {
+		return s
+	}
+	return nil
+}
+
+// mark records the field as present at each timestamp in values.
+func (s *seriesFields) mark(field string, values []tsm1.Value) {
+	i := sort.SearchStrings(s.fields, field)
+	for _, v := range values {
+		present := s.points[v.UnixNano()]
+		if present == nil {
+			present = make([]bool, len(s.fields))
+			s.points[v.UnixNano()] = present
+		}
+		present[i] = true
+	}
+}
+
+// complete returns false if the point at t in the series is missing fields.
+func (idx *fieldIndex) complete(shard string, series []byte, t int64) bool {
+	s := idx.lookup(shard, series)
+	if s == nil {
+		return true
+	}
+	for _, ok := range s.points[t] {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFields builds the field index for the database and retention policy
+// key from its TSM and WAL files. The index holds every timestamp of series
+// with more than one field, so it is only built when -null-policy skip or
+// zero is given.
+func (cmd *Command) indexFields(key string) error {
+	cmd.fields = nil
+	if cmd.nullPolicy == nullPolicyEmpty {
+		return nil
+	}
+
+	// Find the fields of each series, which only needs the TSM index.
+	idx := newFieldIndex()
+	for _, f := range cmd.tsmFiles[key] {
+		if err := cmd.readTSM(f, func(r *tsm1.TSMReader) error {
+			for i := 0; i < r.KeyCount(); i++ {
+				k, typ := r.KeyAt(i)
+				if !cmd.selected(k) {
+					continue
+				}
+				series, field := tsm1.SeriesAndFieldFromCompositeKey(k)
+				idx.addField(shardID(f), string(series), field, zeroBlockValue(typ))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, f := range cmd.walFiles[key] {
+		if err := cmd.readWAL(f, func(k string, values []tsm1.Value) {
+			if !cmd.selected([]byte(k)) {
+				return
+			}
+			series, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+			idx.addField(shardID(f), string(series), field, zeroValue(values[0].Value()))
+		}); err != nil {
+			return err
+		}
+	}
+	cmd.fields = idx
+	if !idx.prepare() {
+		return nil
+	}
+
+	// Record the timestamps of series with more than one field.
+	for _, f := range cmd.tsmFiles[key] {
+		if err := cmd.readTSM(f, func(r *tsm1.TSMReader) error {
+			for i := 0; i < r.KeyCount(); i++ {
+				k, _ := r.KeyAt(i)
+				if !cmd.selected(k) {
+					continue
+				}
+				series, field := tsm1.SeriesAndFieldFromCompositeKey(k)
+				if s := idx.lookup(shardID(f), series); s != nil {
+					values, err := cmd.readValues(r, string(k))
+					if err != nil {
+						return err
+					}
+					s.mark(field, values)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, f := range cmd.walFiles[key] {
+		if err := cmd.readWAL(f, func(k string, values []tsm1.Value) {
+			if !cmd.selected([]byte(k)) {
+				return
+			}
+			series, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+			if s := idx.lookup(shardID(f), series); s != nil {
+				s.mark(field, values)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZeroFields writes a zero value for each field absent from a point,
+// when the null policy is to fill absent fields.
+func (cmd *Command) writeZeroFields(w io.Writer, key string) error {
+	if cmd.nullPolicy != nullPolicyZero || cmd.fields == nil {
+		return nil
+	}
+
+	var series []*seriesFields
+	for _, s := range cmd.fields.series {
+		if s.points != nil {
+			series = append(series, s)
+		}
+	}
+	sort.Sort(seriesFieldsSlice(series))
+
+	var written bool
+	for _, s := range series {
+		times := make(int64Slice, 0, len(s.points))
+		for t := range s.points {
+			times = append(times, t)
+		}
+		sort.Sort(times)
+
+		for _, t := range times {
+			if t < cmd.startTime || t > cmd.endTime {
+				continue
+			}
+			for i, ok := range s.points[t] {
+				if ok {
+					continue
+				}
+
+				field := s.fields[i]
+				seriesField := tsm1.SeriesFieldKey(s.series, field)
+				if !cmd.track(key, seriesField, t) {
+					continue
+				}
+				if !written {
+					fmt.Fprintln(w, "# writing zero values for absent fields")
+					written = true
+				}
+				if err := cmd.writePoint(w, seriesField, []byte(s.series), cmd.formatField(field, s.zeros[field]), t); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readTSM calls fn with a reader for the TSM file at path.
+func (cmd *Command) readTSM(path string, fn func(r *tsm1.TSMReader) error) error {
+	if cmd.interrupted() {
+		return ErrInterrupted
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		// Unreadable files are skipped by the export as well.
+		return nil
+	}
+	defer r.Close()
+
+	return fn(r)
+}
+
+// readWAL calls fn with the values of each key written to the WAL segment
+// at path. Reading stops at the first corrupt entry, as the export does.
+func (cmd *Command) readWAL(path string, fn func(key string, values []tsm1.Value)) error {
+	if cmd.interrupted() {
+		return ErrInterrupted
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := tsm1.NewWALSegmentReader(f)
+	defer r.Close()
+	for r.Next() {
+		entry, err := r.Read()
+		if err != nil {
+			break
+		}
+		if e, ok := entry.(*tsm1.WriteWALEntry); ok {
+			for k, values := range e.Values {
+				if len(values) > 0 {
+					fn(k, values)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shardID returns the ID of the shard holding the TSM or WAL file at path.
+func shardID(path string) string {
+	return filepath.Base(filepath.Dir(path))
+}
+
+// zeroBlockValue returns the zero value for a TSM block type.
+func zeroBlockValue(typ byte) interface{} {
+	switch typ {
+	case tsm1.BlockInteger:
+		return int64(0)
+	case tsm1.BlockBoolean:
+		return false
+	case tsm1.BlockString:
+		return ""
+	default:
+		return float64(0)
+	}
+}
+
+// zeroValue returns the zero value of the type of v.
+func zeroValue(v interface{}) interface{} {
+	switch v.(type) {
+	case int64:
+		return int64(0)
+	case bool:
+		return false
+	case string:
+		return ""
+	default:
+		return float64(0)
+	}
+}
+
+type seriesFieldsSlice []*seriesFields
+
+func (a seriesFieldsSlice) Len() int      { return len(a) }
+func (a seriesFieldsSlice) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a seriesFieldsSlice) Less(i, j int) bool {
+	if a[i].shard != a[j].shard {
+		return a[i].shard < a[j].shard
+	}
+	return a[i].series < a[j].series
+}
+
+type int64Slice []int64
+
+func (a int64Slice) Len() int           { return len(a) }
+func (a int64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64Slice) Less(i, j int) bool { return a[i] < a[j] }
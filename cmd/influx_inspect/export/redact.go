@@ -0,0 +1,91 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// keyList is a repeatable flag holding tag or field keys.
+type keyList map[string]struct{}
+
+// String returns the keys, sorted and comma-separated.
+func (l keyList) String() string {
+	a := make([]string, 0, len(l))
+	for k := range l {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return strings.Join(a, ",")
+}
+
+// Set adds a key to the list.
+func (l keyList) Set(s string) error {
+	if s == "" {
+		return errors.New("key must not be empty")
+	}
+	l[s] = struct{}{}
+	return nil
+}
+
+// redactHash returns the hash standing in for a redacted value. The hash
+// only depends on the value, so a value is redacted the same way wherever it
+// appears and distinct values stay distinct.
+func redactHash(v string) []byte {
+	h := sha256.Sum256([]byte(v))
+	return h[:8]
+}
+
+// redactSeries returns the series key with the values of the tags in
+// -redact-tags replaced by their hash.
+func (cmd *Command) redactSeries(series []byte) []byte {
+	if len(cmd.redactTags) == 0 {
+		return series
+	}
+	if key, ok := cmd.redacted[string(series)]; ok {
+		return key
+	}
+
+	name, tags, _ := models.ParseKey(series)
+	redacted := make(models.Tags, len(tags))
+	for i, t := range tags {
+		redacted[i] = t
+		if _, ok := cmd.redactTags[string(t.Key)]; ok {
+			redacted[i].Value = []byte(hex.EncodeToString(redactHash(string(t.Value))))
+		}
+	}
+	key := models.MakeKey([]byte(name), redacted)
+	cmd.redacted[string(series)] = key
+	return key
+}
+
+// redactField returns v replaced by its hash if field is in -redact-fields.
+// Strings are replaced by the hash in hex, integers by the hash as a
+// non-negative integer and floats by the hash as a float between 0 and 1, so
+// the type of the field is kept. Booleans are not redacted.
+func (cmd *Command) redactField(field string, v interface{}) interface{} {
+	if _, ok := cmd.redactFields[field]; !ok {
+		return v
+	}
+
+	switch v := v.(type) {
+	case string:
+		return hex.EncodeToString(redactHash(v))
+	case int64:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(v))
+		return int64(binary.BigEndian.Uint64(redactHash(string(buf[:]))) & math.MaxInt64)
+	case float64:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+		return float64(binary.BigEndian.Uint64(redactHash(string(buf[:])))>>11) / (1 << 53)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,83 @@
+// Package testutil provides the fixtures shared by the influx_inspect
+// command tests.
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// MustTempDir returns a temporary directory with an empty WAL directory.
+// Panic on error.
+func MustTempDir() string {
+	dir, err := ioutil.TempDir("", "influx-inspect-")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "wal"), 0777); err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// MustWriteTSM writes values to a new TSM file at path. Panic on error.
+func MustWriteTSM(path string, values map[string][]tsm1.Value) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		panic(err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		panic(err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := w.Write(k, values[k]); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// MustWriteWAL writes values to a new WAL segment at path. Panic on error.
+func MustWriteWAL(path string, values map[string][]tsm1.Value) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		panic(err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	entry := &tsm1.WriteWALEntry{Values: values}
+	b, err := entry.Encode(nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := tsm1.NewWALSegmentWriter(f).Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+		panic(err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/influxdata/influxdb/cmd/influx_inspect/export"
 	"github.com/influxdata/influxdb/cmd/influx_inspect/help"
 	"github.com/influxdata/influxdb/cmd/influx_inspect/report"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/summary"
 	"github.com/influxdata/influxdb/cmd/influx_inspect/verify"
 	_ "github.com/influxdata/influxdb/tsdb/engine"
 )
@@ -18,7 +19,11 @@ import (
 func main() {
 
 	m := NewMain()
-	if err := m.Run(os.Args[1:]...); err != nil {
+	if err := m.Run(os.Args[1:]...); err == export.ErrTruncated {
+		// The notice has already been printed; a distinct status lets
+		// scripts tell a truncated export from a failed one.
+		os.Exit(2)
+	} else if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -63,7 +68,9 @@ func (m *Main) Run(args ...string) error {
 		}
 	case "export":
 		name := export.NewCommand()
-		if err := name.Run(args...); err != nil {
+		if err := name.Run(args...); err == export.ErrTruncated {
+			return err
+		} else if err != nil {
 			return fmt.Errorf("export: %s", err)
 		}
 	case "report":
@@ -71,6 +78,11 @@ func (m *Main) Run(args ...string) error {
 		if err := name.Run(args...); err != nil {
 			return fmt.Errorf("report: %s", err)
 		}
+	case "summary":
+		name := summary.NewCommand()
+		if err := name.Run(args...); err != nil {
+			return fmt.Errorf("summary: %s", err)
+		}
 	case "verify":
 		name := verify.NewCommand()
 		if err := name.Run(args...); err != nil {
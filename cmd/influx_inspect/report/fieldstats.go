@@ -0,0 +1,173 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/retailnext/hllpp"
+)
+
+// fieldStats accumulates the values of a field of one type across the
+// series of its measurement.
+type fieldStats struct {
+	measurement, field string
+	typ                byte
+
+	count int
+
+	// The smallest, largest and sum of the values of numeric fields.
+	// Integers are also kept as integers so large values are exact.
+	min, max   float64
+	imin, imax int64
+	sum        float64
+
+	// The distinct values of string and boolean fields, and the number of
+	// empty strings.
+	distinct *hllpp.HLLPP
+	empty    int
+}
+
+// add accumulates v.
+func (s *fieldStats) add(v interface{}) {
+	s.count++
+	switch v := v.(type) {
+	case float64:
+		if s.count == 1 || v < s.min {
+			s.min = v
+		}
+		if s.count == 1 || v > s.max {
+			s.max = v
+		}
+		s.sum += v
+	case int64:
+		if s.count == 1 || v < s.imin {
+			s.imin = v
+		}
+		if s.count == 1 || v > s.imax {
+			s.imax = v
+		}
+		s.sum += float64(v)
+	case string:
+		if v == "" {
+			s.empty++
+		}
+		s.distinct.Add([]byte(v))
+	case bool:
+		s.distinct.Add([]byte(strconv.FormatBool(v)))
+	}
+}
+
+// row returns the columns of the field's row in the -field-stats table.
+func (s *fieldStats) row() []string {
+	min, max, mean, distinct, empty := "-", "-", "-", "-", "-"
+	switch s.typ {
+	case tsm1.BlockFloat64:
+		min, max = formatFloat(s.min), formatFloat(s.max)
+	case tsm1.BlockInteger:
+		min, max = strconv.FormatInt(s.imin, 10), strconv.FormatInt(s.imax, 10)
+	case tsm1.BlockString:
+		empty = fmt.Sprintf("%.1f%%", 100*float64(s.empty)/float64(s.count))
+		fallthrough
+	case tsm1.BlockBoolean:
+		distinct = strconv.FormatUint(s.distinct.Count(), 10)
+	}
+	if s.typ == tsm1.BlockFloat64 || s.typ == tsm1.BlockInteger {
+		mean = formatFloat(s.sum / float64(s.count))
+	}
+	return []string{s.measurement, s.field, blockTypeName(s.typ), strconv.Itoa(s.count), min, max, mean, distinct, empty}
+}
+
+// printFieldStats writes, for each field of each measurement, the number of
+// values across all series and, for numeric fields, their smallest, largest
+// and mean value. For string and boolean fields the estimated number of
+// distinct values is written instead, along with the percentage of empty
+// strings. Every block is decoded. A field written with more than one type
+// has a row for each type.
+func (cmd *Command) printFieldStats(files []string) error {
+	type statsKey struct {
+		measurement, field string
+		typ                byte
+	}
+	stats := make(map[statsKey]*fieldStats)
+
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", f, err)
+			continue
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+			continue
+		}
+
+		for i := 0; i < reader.KeyCount(); i++ {
+			key, typ := reader.KeyAt(i)
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			measurement, _, _ := models.ParseKey(seriesKey)
+
+			k := statsKey{measurement, field, typ}
+			s := stats[k]
+			if s == nil {
+				s = &fieldStats{measurement: measurement, field: field, typ: typ, distinct: hllpp.New()}
+				stats[k] = s
+			}
+
+			values, err := reader.ReadAll(string(key))
+			if err != nil {
+				reader.Close()
+				return err
+			}
+			for _, v := range values {
+				s.add(v.Value())
+			}
+		}
+		reader.Close()
+	}
+
+	a := make(fieldStatsSlice, 0, len(stats))
+	for _, s := range stats {
+		if s.count > 0 {
+			a = append(a, s)
+		}
+	}
+	sort.Sort(a)
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Measurement", "Field", "Type", "Count", "Min", "Max", "Mean", "Distinct (est)", "Empty"}, "\t"))
+	for _, s := range a {
+		fmt.Fprintln(tw, strings.Join(s.row(), "\t"))
+	}
+	return tw.Flush()
+}
+
+// blockTypeName returns the name of the field type stored in blocks of typ.
+func blockTypeName(typ byte) string {
+	if t := tsm1.BlockTypeToInfluxQLDataType(typ); t != influxql.Unknown {
+		return t.String()
+	}
+	return fmt.Sprintf("unknown(%d)", typ)
+}
+
+// fieldStatsSlice sorts fields by measurement, field and type.
+type fieldStatsSlice []*fieldStats
+
+func (a fieldStatsSlice) Len() int      { return len(a) }
+func (a fieldStatsSlice) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a fieldStatsSlice) Less(i, j int) bool {
+	if a[i].measurement != a[j].measurement {
+		return a[i].measurement < a[j].measurement
+	} else if a[i].field != a[j].field {
+		return a[i].field < a[j].field
+	}
+	return a[i].typ < a[j].typ
+}
@@ -0,0 +1,170 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// histogramBarWidth is the width of the bar drawn for the largest bucket.
+const histogramBarWidth = 40
+
+// histogram counts sampled values in buckets of equal width between the
+// smallest and largest value.
+type histogram struct {
+	min, max float64
+	counts   []int
+}
+
+// newHistogram returns a histogram of values with n buckets.
+func newHistogram(values []float64, n int) *histogram {
+	h := &histogram{min: values[0], max: values[0], counts: make([]int, n)}
+	for _, v := range values {
+		if v < h.min {
+			h.min = v
+		}
+		if v > h.max {
+			h.max = v
+		}
+	}
+	for _, v := range values {
+		h.counts[h.bucket(v)]++
+	}
+	return h
+}
+
+// bucket returns the index of the bucket holding v. The largest value is
+// held by the last bucket.
+func (h *histogram) bucket(v float64) int {
+	if h.max == h.min {
+		return 0
+	}
+	i := int(float64(len(h.counts)) * (v - h.min) / (h.max - h.min))
+	if i >= len(h.counts) {
+		i = len(h.counts) - 1
+	}
+	return i
+}
+
+// bounds returns the lower and upper bound of bucket i.
+func (h *histogram) bounds(i int) (lower, upper float64) {
+	width := (h.max - h.min) / float64(len(h.counts))
+	lower, upper = h.min+float64(i)*width, h.min+float64(i+1)*width
+	if i == len(h.counts)-1 {
+		upper = h.max
+	}
+	return lower, upper
+}
+
+// printHistogram writes the distribution of the sampled values of the
+// numeric field named by -histogram, across all series of its measurement.
+// Values are sampled at -sample-rate and counted in -buckets buckets of
+// equal width.
+func (cmd *Command) printHistogram(files []string) error {
+	i := strings.LastIndex(cmd.histogram, ".")
+	if i <= 0 || i == len(cmd.histogram)-1 {
+		return fmt.Errorf("invalid -histogram %q, expected MEASUREMENT.FIELD", cmd.histogram)
+	}
+	measurement, field := cmd.histogram[:i], cmd.histogram[i+1:]
+	if cmd.buckets < 1 {
+		return fmt.Errorf("-buckets must be at least 1")
+	} else if cmd.sampleRate <= 0 || cmd.sampleRate > 1 {
+		return fmt.Errorf("-sample-rate must be greater than 0 and at most 1")
+	}
+
+	// Sample every value whose turn comes up, so sampling is spread evenly
+	// and repeatable.
+	var values []float64
+	var points int
+	var turn float64
+	sample := func(v float64) {
+		points++
+		if turn += cmd.sampleRate; turn >= 1 {
+			turn--
+			values = append(values, v)
+		}
+	}
+
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", f, err)
+			continue
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+			continue
+		}
+
+		for i := 0; i < reader.KeyCount(); i++ {
+			key, typ := reader.KeyAt(i)
+			seriesKey, f := tsm1.SeriesAndFieldFromCompositeKey(key)
+			if f != field {
+				continue
+			} else if m, _, _ := models.ParseKey(seriesKey); m != measurement {
+				continue
+			}
+			if typ != tsm1.BlockFloat64 && typ != tsm1.BlockInteger {
+				reader.Close()
+				return fmt.Errorf("field %s of measurement %s is not numeric", field, measurement)
+			}
+
+			all, err := reader.ReadAll(string(key))
+			if err != nil {
+				reader.Close()
+				return err
+			}
+			for _, v := range all {
+				switch v := v.Value().(type) {
+				case float64:
+					sample(v)
+				case int64:
+					sample(float64(v))
+				}
+			}
+		}
+		reader.Close()
+	}
+
+	if len(values) == 0 {
+		return fmt.Errorf("no values sampled for field %s of measurement %s", field, measurement)
+	}
+	h := newHistogram(values, cmd.buckets)
+
+	fmt.Fprintf(cmd.Stdout, "Sampled:  %d of %d points\n", len(values), points)
+	fmt.Fprintf(cmd.Stdout, "Min:      %s\n", formatFloat(h.min))
+	fmt.Fprintf(cmd.Stdout, "Max:      %s\n\n", formatFloat(h.max))
+
+	var largest int
+	for _, n := range h.counts {
+		if n > largest {
+			largest = n
+		}
+	}
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"From", "To", "Count", "Percent", ""}, "\t"))
+	for i, n := range h.counts {
+		lower, upper := h.bounds(i)
+		fmt.Fprintln(tw, strings.Join([]string{
+			formatFloat(lower),
+			formatFloat(upper),
+			strconv.Itoa(n),
+			fmt.Sprintf("%.1f%%", 100*float64(n)/float64(len(values))),
+			strings.Repeat("#", (n*histogramBarWidth+largest-1)/largest),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// formatFloat formats f with the fewest digits needed to represent it.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -1,11 +1,13 @@
 package report
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
@@ -21,9 +23,22 @@ type Command struct {
 	Stderr io.Writer
 	Stdout io.Writer
 
-	dir      string
-	pattern  string
-	detailed bool
+	dir        string
+	pattern    string
+	detailed   bool
+	fieldTimes bool
+	rankTags   bool
+
+	fieldSparsity bool
+	sampleSeries  int
+
+	encodings bool
+
+	histogram  string
+	buckets    int
+	sampleRate float64
+
+	fieldStats bool
 }
 
 // NewCommand returns a new instance of Command.
@@ -39,6 +54,15 @@ func (cmd *Command) Run(args ...string) error {
 	fs := flag.NewFlagSet("report", flag.ExitOnError)
 	fs.StringVar(&cmd.pattern, "pattern", "", "Include only files matching a pattern")
 	fs.BoolVar(&cmd.detailed, "detailed", false, "Report detailed cardinality estimates")
+	fs.BoolVar(&cmd.fieldTimes, "field-times", false, "Report the first and last time each field has a value")
+	fs.BoolVar(&cmd.rankTags, "rank-tags", false, "Rank the tag keys of each measurement by their contribution to series cardinality")
+	fs.BoolVar(&cmd.fieldSparsity, "field-sparsity", false, "Report the percentage of sampled points carrying each field")
+	fs.IntVar(&cmd.sampleSeries, "sample-series", 100, "Number of series of each measurement sampled by -field-sparsity")
+	fs.BoolVar(&cmd.encodings, "encodings", false, "Report the size of each field's blocks if they were encoded again by the current engine")
+	fs.StringVar(&cmd.histogram, "histogram", "", "Report the distribution of the sampled values of a numeric field, given as MEASUREMENT.FIELD")
+	fs.IntVar(&cmd.buckets, "buckets", 10, "Number of buckets of -histogram")
+	fs.Float64Var(&cmd.sampleRate, "sample-rate", 1, "Fraction of values sampled by -histogram, greater than 0 and at most 1")
+	fs.BoolVar(&cmd.fieldStats, "field-stats", false, "Report the count, range and mean, or distinct and empty values, of each field")
 
 	fs.SetOutput(cmd.Stdout)
 	fs.Usage = cmd.printUsage
@@ -69,6 +93,20 @@ func (cmd *Command) Run(args ...string) error {
 		return fmt.Errorf("no tsm files at %v\n", cmd.dir)
 	}
 
+	if cmd.fieldTimes {
+		return cmd.printFieldTimes(files)
+	} else if cmd.rankTags {
+		return cmd.printTagRanks(files)
+	} else if cmd.fieldSparsity {
+		return cmd.printFieldSparsity(files)
+	} else if cmd.encodings {
+		return cmd.printEncodings(files)
+	} else if cmd.histogram != "" {
+		return cmd.printHistogram(files)
+	} else if cmd.fieldStats {
+		return cmd.printFieldStats(files)
+	}
+
 	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
 	fmt.Fprintln(tw, strings.Join([]string{"File", "Series", "Load Time"}, "\t"))
 
@@ -167,6 +205,405 @@ func (cmd *Command) Run(args ...string) error {
 	return nil
 }
 
+// printFieldTimes writes the first and last time each field of each
+// measurement has a value, ordered by measurement and field. Times are taken
+// from the index entries of the blocks holding the field so no blocks are
+// decoded.
+func (cmd *Command) printFieldTimes(files []string) error {
+	type timeRange struct {
+		min, max int64
+	}
+	ranges := make(map[fieldKey]*timeRange)
+
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", f, err)
+			continue
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+			continue
+		}
+
+		for i := 0; i < reader.KeyCount(); i++ {
+			key, entries := reader.Key(i)
+			if len(entries) == 0 {
+				continue
+			}
+
+			sep := strings.Index(key, "#!~#")
+			if sep == -1 {
+				continue
+			}
+			measurement, _, _ := models.ParseKey([]byte(key[:sep]))
+			k := fieldKey{measurement, key[sep+4:]}
+
+			r := ranges[k]
+			if r == nil {
+				r = &timeRange{min: entries[0].MinTime, max: entries[0].MaxTime}
+				ranges[k] = r
+			}
+			for _, e := range entries {
+				if e.MinTime < r.min {
+					r.min = e.MinTime
+				}
+				if e.MaxTime > r.max {
+					r.max = e.MaxTime
+				}
+			}
+		}
+		reader.Close()
+	}
+
+	keys := make(fieldKeys, 0, len(ranges))
+	for k := range ranges {
+		keys = append(keys, k)
+	}
+	sort.Sort(keys)
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Measurement", "Field", "First Seen", "Last Seen"}, "\t"))
+	for _, k := range keys {
+		r := ranges[k]
+		fmt.Fprintln(tw, strings.Join([]string{
+			k.measurement,
+			k.field,
+			time.Unix(0, r.min).UTC().Format(time.RFC3339Nano),
+			time.Unix(0, r.max).UTC().Format(time.RFC3339Nano),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printTagRanks writes the tag keys of each measurement ranked by their
+// estimated contribution to the measurement's series cardinality. A key's
+// contribution is its distinct value count weighted by the fraction of the
+// measurement's series that have the key, so keys with many values that
+// appear on most series rank highest.
+func (cmd *Command) printTagRanks(files []string) error {
+	measSeries := make(map[string]*hllpp.HLLPP)
+	tagValues := make(map[tagKey]*hllpp.HLLPP)
+	tagSeries := make(map[tagKey]*hllpp.HLLPP)
+
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", f, err)
+			continue
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+			continue
+		}
+
+		for i := 0; i < reader.KeyCount(); i++ {
+			key, _ := reader.KeyAt(i)
+			seriesKey, _ := tsm1.SeriesAndFieldFromCompositeKey(key)
+			measurement, tags, _ := models.ParseKey(seriesKey)
+
+			series, ok := measSeries[measurement]
+			if !ok {
+				series = hllpp.New()
+				measSeries[measurement] = series
+			}
+			series.Add(seriesKey)
+
+			for _, t := range tags {
+				k := tagKey{measurement, string(t.Key)}
+				if tagValues[k] == nil {
+					tagValues[k] = hllpp.New()
+					tagSeries[k] = hllpp.New()
+				}
+				tagValues[k].Add(t.Value)
+				tagSeries[k].Add(seriesKey)
+			}
+		}
+		reader.Close()
+	}
+
+	// Score each tag key and total the scores of each measurement.
+	ranks := make(tagRanks, 0, len(tagValues))
+	totals := make(map[string]float64)
+	for k, values := range tagValues {
+		r := tagRank{key: k, values: values.Count()}
+		if n := measSeries[k.measurement].Count(); n > 0 {
+			r.coverage = float64(tagSeries[k].Count()) / float64(n)
+			if r.coverage > 1 {
+				r.coverage = 1
+			}
+		}
+		r.score = float64(r.values) * r.coverage
+		totals[k.measurement] += r.score
+		ranks = append(ranks, r)
+	}
+	sort.Sort(ranks)
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Measurement", "Tag Key", "Values (est)", "Series With Key", "Contribution"}, "\t"))
+	for _, r := range ranks {
+		var pct float64
+		if total := totals[r.key.measurement]; total > 0 {
+			pct = 100 * r.score / total
+		}
+		fmt.Fprintln(tw, strings.Join([]string{
+			r.key.measurement,
+			r.key.key,
+			strconv.FormatUint(r.values, 10),
+			fmt.Sprintf("%.1f%%", 100*r.coverage),
+			fmt.Sprintf("%.1f%%", pct),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printFieldSparsity writes the percentage of points carrying each field of
+// each measurement, sparsest first. Points are sampled from the first
+// -sample-series series of each measurement, and a point is a distinct
+// timestamp of a series with a value for any field.
+func (cmd *Command) printFieldSparsity(files []string) error {
+	if cmd.sampleSeries < 1 {
+		return fmt.Errorf("-sample-series must be at least 1")
+	}
+
+	var readers []*tsm1.TSMReader
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+
+	// Find the fields of each measurement and the series to sample.
+	fields := make(map[string]map[string]struct{})
+	series := make(map[string]map[string]struct{})
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", f, err)
+			continue
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+			continue
+		}
+		readers = append(readers, reader)
+
+		for i := 0; i < reader.KeyCount(); i++ {
+			key, _ := reader.KeyAt(i)
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			measurement, _, _ := models.ParseKey(seriesKey)
+
+			if fields[measurement] == nil {
+				fields[measurement] = make(map[string]struct{})
+				series[measurement] = make(map[string]struct{})
+			}
+			fields[measurement][field] = struct{}{}
+			series[measurement][string(seriesKey)] = struct{}{}
+		}
+	}
+
+	var stats fieldSparsities
+	for measurement, keys := range series {
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		var points, sampled int
+		present := make(map[string]int)
+		for _, sk := range sorted {
+			if sampled == cmd.sampleSeries {
+				break
+			}
+
+			all := make(map[int64]struct{})
+			seriesPresent := make(map[string]int)
+			for field := range fields[measurement] {
+				times := make(map[int64]struct{})
+				for _, r := range readers {
+					values, err := r.ReadAll(tsm1.SeriesFieldKey(sk, field))
+					if err != nil {
+						return err
+					}
+					for _, v := range values {
+						times[v.UnixNano()] = struct{}{}
+						all[v.UnixNano()] = struct{}{}
+					}
+				}
+				seriesPresent[field] = len(times)
+			}
+
+			// A series still in the index whose points were all deleted
+			// has nothing to sample, so move on to the next series rather
+			// than letting it take the place of a series with data.
+			if len(all) == 0 {
+				continue
+			}
+			sampled++
+			points += len(all)
+			for field, n := range seriesPresent {
+				present[field] += n
+			}
+		}
+
+		for field := range fields[measurement] {
+			stats = append(stats, fieldSparsity{
+				key:     fieldKey{measurement, field},
+				present: present[field],
+				points:  points,
+			})
+		}
+	}
+	sort.Sort(stats)
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Measurement", "Field", "Points With Field", "Sampled Points", "Presence"}, "\t"))
+	for _, s := range stats {
+		fmt.Fprintln(tw, strings.Join([]string{
+			s.key.measurement,
+			s.key.field,
+			strconv.Itoa(s.present),
+			strconv.Itoa(s.points),
+			fmt.Sprintf("%.1f%%", 100*s.ratio()),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printEncodings writes, for each field of each measurement, the encodings
+// of its blocks and the encodings the current engine chooses when encoding
+// the same values again, along with the size of the blocks each way. Rows
+// are ordered by the bytes re-encoding would save, largest first. Blocks
+// are only encoded in memory; the files are not modified.
+func (cmd *Command) printEncodings(files []string) error {
+	stats := make(map[encodingKey]*encodingStats)
+
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", f, err)
+			continue
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+			continue
+		}
+
+		var values []tsm1.Value
+		iter := reader.BlockIterator()
+		for iter.Next() {
+			key, _, _, _, buf, err := iter.Read()
+			if err != nil {
+				fmt.Fprintf(cmd.Stderr, "error: %s: %v. Skipping.\n", file.Name(), err)
+				break
+			}
+
+			current, err := blockEncoding(buf)
+			if err != nil {
+				fmt.Fprintf(cmd.Stderr, "error: %s: %s: %v. Skipping block.\n", file.Name(), key, err)
+				continue
+			}
+			values, err = tsm1.DecodeBlock(buf, values[:0])
+			if err != nil {
+				fmt.Fprintf(cmd.Stderr, "error: %s: %s: %v. Skipping block.\n", file.Name(), key, err)
+				continue
+			} else if len(values) == 0 {
+				continue
+			}
+			encoded, err := tsm1.Values(values).Encode(nil)
+			if err != nil {
+				return err
+			}
+			chosen, err := blockEncoding(encoded)
+			if err != nil {
+				return err
+			}
+
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
+			measurement, _, _ := models.ParseKey(seriesKey)
+			k := encodingKey{fieldKey{measurement, field}, current, chosen}
+			st := stats[k]
+			if st == nil {
+				st = &encodingStats{key: k}
+				stats[k] = st
+			}
+			st.blocks++
+			st.size += len(buf)
+			st.encodedSize += len(encoded)
+		}
+		reader.Close()
+	}
+
+	a := make(encodingStatsSlice, 0, len(stats))
+	for _, st := range stats {
+		a = append(a, st)
+	}
+	sort.Sort(a)
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Measurement", "Field", "Blocks", "Encoding", "Size", "Chosen Encoding", "Chosen Size", "Savings"}, "\t"))
+	for _, st := range a {
+		fmt.Fprintln(tw, strings.Join([]string{
+			st.key.field.measurement,
+			st.key.field.field,
+			strconv.Itoa(st.blocks),
+			st.key.current,
+			strconv.Itoa(st.size),
+			st.key.chosen,
+			strconv.Itoa(st.encodedSize),
+			fmt.Sprintf("%d (%.1f%%)", st.savings(), 100*float64(st.savings())/float64(st.size)),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Names of the timestamp and value encodings of blocks, indexed by block
+// type and then by encoding, as shown by dumptsm.
+var (
+	timeEncodings  = []string{"none", "s8b", "rle"}
+	valueEncodings = map[byte][]string{
+		tsm1.BlockFloat64: {"none", "gor"},
+		tsm1.BlockInteger: {"none", "s8b", "rle"},
+		tsm1.BlockBoolean: {"none", "bp"},
+		tsm1.BlockString:  {"none", "snpy"},
+	}
+)
+
+// blockEncoding returns the timestamp and value encodings of a block, as
+// "timestamps/values".
+func blockEncoding(block []byte) (string, error) {
+	if len(block) < 2 {
+		return "", fmt.Errorf("block too short")
+	}
+	names, ok := valueEncodings[block[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown block type: %d", block[0])
+	}
+
+	tsLen, n := binary.Uvarint(block[1:])
+	if n <= 0 || uint64(len(block)-1-n) <= tsLen || tsLen == 0 {
+		return "", fmt.Errorf("invalid block length")
+	}
+	ts, values := block[1+n:], block[1+n+int(tsLen):]
+
+	name := func(names []string, enc byte) string {
+		if int(enc) < len(names) {
+			return names[enc]
+		}
+		return fmt.Sprintf("unknown(%d)", enc)
+	}
+	return name(timeEncodings, ts[0]>>4) + "/" + name(names, values[0]>>4), nil
+}
+
 // printUsage prints the usage message to STDERR.
 func (cmd *Command) printUsage() {
 	usage := `Displays shard level report.
@@ -178,7 +615,146 @@ Usage: influx_inspect report [flags]
     -detailed
             Report detailed cardinality estimates.
             Defaults to "false".
+    -field-times
+            Report the first and last time each field has a value.
+            Defaults to "false".
+    -rank-tags
+            Rank the tag keys of each measurement by their estimated
+            contribution to series cardinality.
+            Defaults to "false".
+    -field-sparsity
+            Report the percentage of sampled points carrying each field,
+            sparsest first.
+            Defaults to "false".
+    -sample-series <n>
+            Number of series of each measurement sampled by -field-sparsity.
+            Defaults to "100".
+    -encodings
+            Report the encodings of each field's blocks and the encodings
+            and size the current engine would use for the same values,
+            largest savings first.
+            Defaults to "false".
+    -histogram <measurement.field>
+            Report the minimum, maximum and distribution of the sampled
+            values of a numeric field across all series of its
+            measurement.
+    -buckets <n>
+            Number of buckets of -histogram.
+            Defaults to "10".
+    -sample-rate <rate>
+            Fraction of values sampled by -histogram.
+            Defaults to "1".
+    -field-stats
+            Report the number of values of each field and their minimum,
+            maximum and mean, or for strings and booleans the estimated
+            number of distinct values and the percentage of empty strings.
+            Defaults to "false".
 `
 
 	fmt.Fprintf(cmd.Stdout, usage)
 }
+
+// fieldKey identifies a field of a measurement.
+type fieldKey struct {
+	measurement, field string
+}
+
+type fieldKeys []fieldKey
+
+func (a fieldKeys) Len() int      { return len(a) }
+func (a fieldKeys) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a fieldKeys) Less(i, j int) bool {
+	if a[i].measurement != a[j].measurement {
+		return a[i].measurement < a[j].measurement
+	}
+	return a[i].field < a[j].field
+}
+
+// tagKey identifies a tag key of a measurement.
+type tagKey struct {
+	measurement, key string
+}
+
+// tagRank holds the estimated cardinality contribution of a tag key.
+type tagRank struct {
+	key      tagKey
+	values   uint64
+	coverage float64
+	score    float64
+}
+
+// tagRanks sorts by measurement and then by descending score.
+type tagRanks []tagRank
+
+func (a tagRanks) Len() int      { return len(a) }
+func (a tagRanks) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a tagRanks) Less(i, j int) bool {
+	if a[i].key.measurement != a[j].key.measurement {
+		return a[i].key.measurement < a[j].key.measurement
+	} else if a[i].score != a[j].score {
+		return a[i].score > a[j].score
+	}
+	return a[i].key.key < a[j].key.key
+}
+
+// fieldSparsity holds how many sampled points of a measurement carry a field.
+type fieldSparsity struct {
+	key     fieldKey
+	present int
+	points  int
+}
+
+// ratio returns the fraction of sampled points carrying the field.
+func (s fieldSparsity) ratio() float64 {
+	if s.points == 0 {
+		return 0
+	}
+	return float64(s.present) / float64(s.points)
+}
+
+// fieldSparsities sorts by ascending presence, then by measurement and field.
+type fieldSparsities []fieldSparsity
+
+func (a fieldSparsities) Len() int      { return len(a) }
+func (a fieldSparsities) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a fieldSparsities) Less(i, j int) bool {
+	if x, y := a[i].ratio(), a[j].ratio(); x != y {
+		return x < y
+	}
+	return fieldKeys{a[i].key, a[j].key}.Less(0, 1)
+}
+
+// encodingKey identifies a field's blocks by their encodings as stored and
+// as chosen by the current engine.
+type encodingKey struct {
+	field           fieldKey
+	current, chosen string
+}
+
+// encodingStats holds the size of a field's blocks as stored and when
+// encoded again.
+type encodingStats struct {
+	key         encodingKey
+	blocks      int
+	size        int
+	encodedSize int
+}
+
+// savings returns the bytes saved by encoding the blocks again.
+func (s *encodingStats) savings() int { return s.size - s.encodedSize }
+
+// encodingStatsSlice sorts by descending savings, then by field and encodings.
+type encodingStatsSlice []*encodingStats
+
+func (a encodingStatsSlice) Len() int      { return len(a) }
+func (a encodingStatsSlice) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a encodingStatsSlice) Less(i, j int) bool {
+	if x, y := a[i].savings(), a[j].savings(); x != y {
+		return x > y
+	} else if a[i].key.field != a[j].key.field {
+		return fieldKeys{a[i].key.field, a[j].key.field}.Less(0, 1)
+	} else if a[i].key.current != a[j].key.current {
+		return a[i].key.current < a[j].key.current
+	}
+	return a[i].key.chosen < a[j].key.chosen
+}
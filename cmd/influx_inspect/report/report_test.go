@@ -1,3 +1,310 @@
 package report_test
 
-// TODO: write some tests
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influx_inspect/internal/testutil"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/report"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure the first and last time of each field is taken across series and
+// files.
+func TestCommand_Run_FieldTimes(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 2.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(5, 3.0)},
+		"mem,host=a#!~#free":  {tsm1.NewValue(1000000000, int64(1))},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(15, 4.0)},
+		"cpu,host=a#!~#value": {tsm1.NewValue(30, 5.0)},
+	})
+
+	var buf bytes.Buffer
+	cmd := report.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run("-field-times", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"Measurement Field First Seen Last Seen",
+		"cpu idle 1970-01-01T00:00:00.000000015Z 1970-01-01T00:00:00.000000015Z",
+		"cpu value 1970-01-01T00:00:00.000000005Z 1970-01-01T00:00:00.00000003Z",
+		"mem free 1970-01-01T00:00:01Z 1970-01-01T00:00:01Z",
+	}
+	if got := Lines(buf.String()); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected output:\n\ngot=%s\n\nexp=%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+// Ensure tag keys are ranked by their distinct values weighted by the share
+// of series that have them.
+func TestCommand_Run_RankTags(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a,region=r1#!~#value": {tsm1.NewValue(0, 1.0)},
+		"cpu,host=b,region=r2#!~#value": {tsm1.NewValue(0, 1.0)},
+		"cpu,host=c#!~#value":           {tsm1.NewValue(0, 1.0)},
+		"mem,host=a#!~#free":            {tsm1.NewValue(0, int64(1))},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=d#!~#value": {tsm1.NewValue(10, 1.0)},
+	})
+
+	var buf bytes.Buffer
+	cmd := report.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run("-rank-tags", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"Measurement Tag Key Values (est) Series With Key Contribution",
+		"cpu host 4 100.0% 80.0%",
+		"cpu region 2 50.0% 20.0%",
+		"mem host 1 100.0% 100.0%",
+	}
+	if got := Lines(buf.String()); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected output:\n\ngot=%s\n\nexp=%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+// Ensure the presence of each field is the share of sampled points, taken
+// across files, that carry it.
+func TestCommand_Run_FieldSparsity(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(0, 1.0)},
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 1.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 1.0)},
+		"cpu,host=c#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewValue(20, 1.0)},
+		"cpu,host=a#!~#value": {tsm1.NewValue(20, 1.0), tsm1.NewValue(30, 1.0)},
+	})
+
+	for i, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{
+			args: []string{"-field-sparsity"},
+			exp: []string{
+				"Measurement Field Points With Field Sampled Points Presence",
+				"cpu idle 2 7 28.6%",
+				"cpu value 7 7 100.0%",
+			},
+		},
+		// Only host=a and host=b are sampled.
+		{
+			args: []string{"-field-sparsity", "-sample-series", "2"},
+			exp: []string{
+				"Measurement Field Points With Field Sampled Points Presence",
+				"cpu idle 2 6 33.3%",
+				"cpu value 6 6 100.0%",
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		cmd := report.NewCommand()
+		cmd.Stdout = &buf
+		if err := cmd.Run(append(tt.args, dir)...); err != nil {
+			t.Errorf("%d. %v: unexpected error: %v", i, tt.args, err)
+			continue
+		}
+		if got := Lines(buf.String()); strings.Join(got, "\n") != strings.Join(tt.exp, "\n") {
+			t.Errorf("%d. %v: unexpected output:\n\ngot=%s\n\nexp=%s", i, tt.args, strings.Join(got, "\n"), strings.Join(tt.exp, "\n"))
+		}
+	}
+}
+
+// Ensure series whose points were all deleted are passed over when sampling
+// field sparsity, rather than being sampled as series without fields.
+func TestCommand_Run_FieldSparsity_TombstonedSeries(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000000001-000000001.tsm")
+	testutil.MustWriteTSM(path, map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 2.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 2.0)},
+	})
+
+	// Delete every point of host=a, one at a time so that its key is left
+	// in the index.
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteRange([]string{"cpu,host=a#!~#value"}, 0, 15); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteRange([]string{"cpu,host=a#!~#value"}, 16, 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	cmd := report.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run("-field-sparsity", "-sample-series", "1", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := Lines(buf.String())
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output: %s", buf.String())
+	} else if lines[1] != "cpu value 2 2 100.0%" {
+		t.Fatalf("unexpected row: %q", lines[1])
+	}
+}
+
+// Ensure the encodings and sizes of float, integer, boolean and string blocks
+// are reported as stored and as encoded again.
+func TestCommand_Run_Encodings(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	values := map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.5), tsm1.NewValue(10, 2.5), tsm1.NewValue(20, 3.5)},
+		"cpu,host=a#!~#count": {tsm1.NewValue(0, int64(1)), tsm1.NewValue(10, int64(7)), tsm1.NewValue(20, int64(3))},
+		"cpu,host=a#!~#up":    {tsm1.NewValue(0, true), tsm1.NewValue(10, false), tsm1.NewValue(20, true)},
+		"cpu,host=a#!~#os":    {tsm1.NewValue(0, "linux"), tsm1.NewValue(10, "linux"), tsm1.NewValue(20, "darwin")},
+	}
+	testutil.MustWriteTSM(filepath.Join(dir, "000000001-000000001.tsm"), values)
+
+	// Blocks written by the current engine are encoded again the same way.
+	size := func(key string) string {
+		buf, err := tsm1.Values(values[key]).Encode(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strconv.Itoa(len(buf))
+	}
+
+	var buf bytes.Buffer
+	cmd := report.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run("-encodings", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"Measurement Field Blocks Encoding Size Chosen Encoding Chosen Size Savings",
+		"cpu count 1 rle/s8b " + size("cpu,host=a#!~#count") + " rle/s8b " + size("cpu,host=a#!~#count") + " 0 (0.0%)",
+		"cpu os 1 rle/snpy " + size("cpu,host=a#!~#os") + " rle/snpy " + size("cpu,host=a#!~#os") + " 0 (0.0%)",
+		"cpu up 1 rle/bp " + size("cpu,host=a#!~#up") + " rle/bp " + size("cpu,host=a#!~#up") + " 0 (0.0%)",
+		"cpu value 1 rle/gor " + size("cpu,host=a#!~#value") + " rle/gor " + size("cpu,host=a#!~#value") + " 0 (0.0%)",
+	}
+	if got := Lines(buf.String()); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected output:\n\ngot=%s\n\nexp=%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+// Ensure the histogram of a numeric field counts its values across series.
+func TestCommand_Run_Histogram(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 2.0), tsm1.NewValue(30, 3.0), tsm1.NewValue(40, 4.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 100.0)},
+		"cpu,host=a#!~#msg":   {tsm1.NewValue(10, "hello")},
+	})
+
+	run := func(args ...string) (string, error) {
+		var buf bytes.Buffer
+		cmd := report.NewCommand()
+		cmd.Stdout = &buf
+		err := cmd.Run(append(args, dir)...)
+		return buf.String(), err
+	}
+
+	out, err := run("-histogram", "cpu.value", "-buckets", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("unexpected output: %s", out)
+	} else if lines[0] != "Sampled:  6 of 6 points" || lines[1] != "Min:      1" || lines[2] != "Max:      100" {
+		t.Fatalf("unexpected summary: %s", out)
+	} else if row := strings.Join(strings.Fields(lines[5]), " "); row != "1 50.5 5 83.3% ########################################" {
+		t.Fatalf("unexpected first bucket: %q", row)
+	} else if row := strings.Join(strings.Fields(lines[6]), " "); row != "50.5 100 1 16.7% ########" {
+		t.Fatalf("unexpected last bucket: %q", row)
+	}
+
+	if out, err := run("-histogram", "cpu.value", "-sample-rate", "0.5"); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(out, "Sampled:  3 of 6 points\n") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+
+	if _, err := run("-histogram", "cpu.msg"); err == nil || !strings.Contains(err.Error(), "not numeric") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the values of each field are summarized by type across series.
+func TestCommand_Run_FieldStats(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 4.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(10, -2.5)},
+		"cpu,host=a#!~#count": {tsm1.NewValue(10, int64(9007199254740993)), tsm1.NewValue(20, int64(-1))},
+		"cpu,host=a#!~#msg":   {tsm1.NewValue(10, "ok"), tsm1.NewValue(20, ""), tsm1.NewValue(30, "ok"), tsm1.NewValue(40, "err")},
+		"mem,host=a#!~#up":    {tsm1.NewValue(10, true)},
+	})
+
+	var buf bytes.Buffer
+	cmd := report.NewCommand()
+	cmd.Stdout = &buf
+	if err := cmd.Run("-field-stats", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"Measurement Field Type Count Min Max Mean Distinct (est) Empty",
+		"cpu count integer 2 -1 9007199254740993 4.5035996273704955e+15 - -",
+		"cpu msg string 4 - - - 3 25.0%",
+		"cpu value float 3 -2.5 4 0.8333333333333334 - -",
+		"mem up boolean 1 - - - 1 -",
+	}
+	if got := Lines(buf.String()); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unexpected output:\n\ngot=%s\n\nexp=%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+// Lines returns the lines of s with the fields of each line separated by a
+// single space.
+func Lines(s string) []string {
+	var a []string
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		a = append(a, strings.Join(strings.Fields(line), " "))
+	}
+	return a
+}
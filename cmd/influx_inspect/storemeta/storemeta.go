@@ -0,0 +1,192 @@
+// Package storemeta adapts the cluster metadata of services/meta to the
+// tsdb.MetaClient used by a store opened by the tools.
+package storemeta
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// Source is the cluster metadata adapted by a Client, such as a
+// *meta.Client.
+type Source interface {
+	Data() meta.Data
+	SetData(data *meta.Data) error
+}
+
+// Client adapts a Source to a tsdb.MetaClient. It also implements
+// tsdb.MetaSnapshotter and tsdb.ShardGroupMerger.
+type Client struct {
+	source Source
+}
+
+// NewClient returns a new instance of Client reading and updating source.
+func NewClient(source Source) *Client {
+	return &Client{source: source}
+}
+
+// Shards returns the shards of the live shard groups.
+func (c *Client) Shards() []tsdb.ShardMeta {
+	var a []tsdb.ShardMeta
+	data := c.source.Data()
+	for _, dbi := range data.Databases {
+		for _, rpi := range dbi.RetentionPolicies {
+			for _, sgi := range rpi.ShardGroups {
+				if sgi.Deleted() {
+					continue
+				}
+				for _, si := range sgi.Shards {
+					sm := tsdb.ShardMeta{ID: si.ID, Database: dbi.Name, RetentionPolicy: rpi.Name}
+					for _, so := range si.Owners {
+						sm.Owners = append(sm.Owners, so.NodeID)
+					}
+					a = append(a, sm)
+				}
+			}
+		}
+	}
+	return a
+}
+
+// RetentionPolicy returns the settings of a retention policy, or nil if the
+// metadata does not hold it.
+func (c *Client) RetentionPolicy(database, name string) *tsdb.RetentionPolicyMeta {
+	data := c.source.Data()
+	dbi := data.Database(database)
+	if dbi == nil {
+		return nil
+	}
+	rpi := dbi.RetentionPolicy(name)
+	if rpi == nil {
+		return nil
+	}
+	return &tsdb.RetentionPolicyMeta{
+		Duration:           rpi.Duration,
+		ShardGroupDuration: rpi.ShardGroupDuration,
+		ReplicaN:           rpi.ReplicaN,
+		Default:            dbi.DefaultRetentionPolicy == name,
+	}
+}
+
+// MarshalMetadata returns the encoded metadata.
+func (c *Client) MarshalMetadata() ([]byte, error) {
+	data := c.source.Data()
+	return data.MarshalBinary()
+}
+
+// RestoreMetadata replaces the metadata with the encoded metadata in buf.
+// The metadata is unchanged if buf cannot be decoded.
+func (c *Client) RestoreMetadata(buf []byte) error {
+	data := &meta.Data{}
+	if err := data.UnmarshalBinary(buf); err != nil {
+		return err
+	}
+	return c.source.SetData(data)
+}
+
+// MergeShardGroups checks that the shard groups of the shards in ids can be
+// merged into the group of the first, and returns a function updating the
+// metadata so that group covers the time ranges of all of them and the
+// others are deleted. The groups must hold no other shards, and the merged
+// group must not overlap another group.
+func (c *Client) MergeShardGroups(database, policy string, ids []uint64) (func() error, error) {
+	data := c.source.Data()
+	other := data.Clone()
+	if err := mergeShardGroups(other, database, policy, ids); err != nil {
+		return nil, err
+	}
+	return func() error { return c.source.SetData(other) }, nil
+}
+
+// mergeShardGroups updates data so the shard group of the first shard in ids
+// covers the time ranges of the groups of all the shards, and deletes the
+// other groups.
+func mergeShardGroups(data *meta.Data, database, policy string, ids []uint64) error {
+	if len(ids) == 0 {
+		return errors.New("no shards to merge")
+	}
+
+	rpi, err := data.RetentionPolicy(database, policy)
+	if err != nil {
+		return err
+	} else if rpi == nil {
+		return fmt.Errorf("retention policy not found in metadata: %s/%s", database, policy)
+	}
+
+	merging := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		merging[id] = true
+	}
+
+	var target *meta.ShardGroupInfo
+	var groups []*meta.ShardGroupInfo
+	var start, end time.Time
+	var found int
+	for i := range rpi.ShardGroups {
+		sgi := &rpi.ShardGroups[i]
+		if sgi.Deleted() {
+			continue
+		}
+
+		var n int
+		for _, si := range sgi.Shards {
+			if merging[si.ID] {
+				n++
+			}
+			if si.ID == ids[0] {
+				target = sgi
+			}
+		}
+		if n == 0 {
+			continue
+		} else if n != len(sgi.Shards) {
+			return fmt.Errorf("shard group %d holds shards that are not being merged", sgi.ID)
+		}
+		found += n
+
+		if start.IsZero() || sgi.StartTime.Before(start) {
+			start = sgi.StartTime
+		}
+		if sgi.EndTime.After(end) {
+			end = sgi.EndTime
+		}
+		groups = append(groups, sgi)
+	}
+	if found != len(ids) {
+		return errors.New("not all shards were found in the metadata")
+	}
+
+	for i := range rpi.ShardGroups {
+		sgi := &rpi.ShardGroups[i]
+		if sgi.Deleted() {
+			continue
+		}
+		var merged bool
+		for _, g := range groups {
+			merged = merged || g == sgi
+		}
+		if !merged && sgi.StartTime.Before(end) && start.Before(sgi.EndTime) {
+			return fmt.Errorf("merged shard group would overlap shard group %d", sgi.ID)
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, sgi := range groups {
+		if sgi == target {
+			continue
+		}
+		sgi.DeletedAt = now
+	}
+	for _, si := range target.Shards {
+		if si.ID == ids[0] {
+			target.Shards = []meta.ShardInfo{si}
+			break
+		}
+	}
+	target.StartTime, target.EndTime = start, end
+	return nil
+}
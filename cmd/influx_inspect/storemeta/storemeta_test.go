@@ -0,0 +1,144 @@
+package storemeta_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_inspect/storemeta"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// Ensure the shards of live shard groups are returned with their owners.
+func TestClient_Shards(t *testing.T) {
+	c := storemeta.NewClient(&Source{Metadata: meta.Data{Databases: []meta.DatabaseInfo{{
+		Name: "db0",
+		RetentionPolicies: []meta.RetentionPolicyInfo{{
+			Name: "rp0",
+			ShardGroups: []meta.ShardGroupInfo{
+				{ID: 1, Shards: []meta.ShardInfo{
+					{ID: 1, Owners: []meta.ShardOwner{{NodeID: 2}, {NodeID: 3}}},
+					{ID: 2},
+				}},
+				{ID: 2, DeletedAt: time.Unix(1, 0), Shards: []meta.ShardInfo{{ID: 3}}},
+			},
+		}},
+	}}}})
+
+	exp := []tsdb.ShardMeta{
+		{ID: 1, Database: "db0", RetentionPolicy: "rp0", Owners: []uint64{2, 3}},
+		{ID: 2, Database: "db0", RetentionPolicy: "rp0"},
+	}
+	if got := c.Shards(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected shards:\n\ngot=%+v\n\nexp=%+v", got, exp)
+	}
+}
+
+// Ensure retention policy settings are returned, marking the default.
+func TestClient_RetentionPolicy(t *testing.T) {
+	c := storemeta.NewClient(&Source{Metadata: meta.Data{Databases: []meta.DatabaseInfo{{
+		Name:                   "db0",
+		DefaultRetentionPolicy: "rp0",
+		RetentionPolicies: []meta.RetentionPolicyInfo{
+			{Name: "rp0", ReplicaN: 2, Duration: time.Hour, ShardGroupDuration: time.Minute},
+			{Name: "rp1", ReplicaN: 1},
+		},
+	}}}})
+
+	if rpi := c.RetentionPolicy("db0", "rp0"); !reflect.DeepEqual(rpi, &tsdb.RetentionPolicyMeta{Duration: time.Hour, ShardGroupDuration: time.Minute, ReplicaN: 2, Default: true}) {
+		t.Fatalf("unexpected retention policy: %+v", rpi)
+	} else if rpi := c.RetentionPolicy("db0", "rp1"); rpi == nil || rpi.Default {
+		t.Fatalf("unexpected retention policy: %+v", rpi)
+	} else if rpi := c.RetentionPolicy("db0", "rp2"); rpi != nil {
+		t.Fatalf("unexpected retention policy: %+v", rpi)
+	} else if rpi := c.RetentionPolicy("db1", "rp0"); rpi != nil {
+		t.Fatalf("unexpected retention policy: %+v", rpi)
+	}
+}
+
+// Ensure encoded metadata is restored, and left alone if it is invalid.
+func TestClient_RestoreMetadata(t *testing.T) {
+	s := &Source{Metadata: meta.Data{Index: 3, Databases: []meta.DatabaseInfo{{Name: "db0"}}}}
+	c := storemeta.NewClient(s)
+	buf, err := c.MarshalMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RestoreMetadata([]byte("not metadata")); err == nil {
+		t.Fatal("expected error")
+	} else if s.Updated != nil {
+		t.Fatal("unexpected update")
+	}
+
+	if err := c.RestoreMetadata(buf); err != nil {
+		t.Fatal(err)
+	} else if s.Updated == nil || s.Updated.Index != 3 || s.Updated.Database("db0") == nil {
+		t.Fatalf("unexpected metadata: %+v", s.Updated)
+	}
+}
+
+// Ensure shard groups are merged into the group of the first shard, and
+// groups that cannot be merged are rejected without updating the metadata.
+func TestClient_MergeShardGroups(t *testing.T) {
+	group := func(id uint64, start int64, shards ...uint64) meta.ShardGroupInfo {
+		sgi := meta.ShardGroupInfo{ID: id, StartTime: time.Unix(start, 0), EndTime: time.Unix(start+10, 0)}
+		for _, id := range shards {
+			sgi.Shards = append(sgi.Shards, meta.ShardInfo{ID: id})
+		}
+		return sgi
+	}
+	s := &Source{Metadata: meta.Data{Databases: []meta.DatabaseInfo{{
+		Name: "db0",
+		RetentionPolicies: []meta.RetentionPolicyInfo{{
+			Name:        "rp0",
+			ShardGroups: []meta.ShardGroupInfo{group(1, 0, 1), group(2, 10, 2), group(3, 20, 3), group(4, 30, 4, 5)},
+		}},
+	}}}}
+	c := storemeta.NewClient(s)
+
+	for _, tt := range []struct {
+		policy string
+		ids    []uint64
+		err    string
+	}{
+		{"rp0", []uint64{1, 3}, "overlap shard group 2"},
+		{"rp0", []uint64{3, 4}, "shard group 4 holds shards that are not being merged"},
+		{"rp0", []uint64{1, 6}, "not all shards were found"},
+		{"rp1", []uint64{1, 2}, "retention policy not found"},
+	} {
+		if _, err := c.MergeShardGroups("db0", tt.policy, tt.ids); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: unexpected error: %v", tt.ids, err)
+		}
+	}
+
+	update, err := c.MergeShardGroups("db0", "rp0", []uint64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	} else if s.Updated != nil {
+		t.Fatal("unexpected update before the merge is applied")
+	} else if err := update(); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := s.Updated.Databases[0].RetentionPolicies[0].ShardGroups
+	if g := groups[0]; !g.StartTime.Equal(time.Unix(0, 0)) || !g.EndTime.Equal(time.Unix(30, 0)) || g.Deleted() {
+		t.Fatalf("unexpected merged group: %+v", g)
+	} else if !groups[1].Deleted() || !groups[2].Deleted() || groups[3].Deleted() {
+		t.Fatalf("unexpected groups: %+v", groups)
+	} else if s.Metadata.Databases[0].RetentionPolicies[0].ShardGroups[1].Deleted() {
+		t.Fatal("expected original metadata to be unchanged")
+	}
+}
+
+// Source is a storemeta.Source holding metadata in memory and recording
+// the metadata it is updated with.
+type Source struct {
+	Metadata meta.Data
+	Updated  *meta.Data
+}
+
+func (s *Source) Data() meta.Data               { return s.Metadata }
+func (s *Source) SetData(data *meta.Data) error { s.Updated = data; return nil }
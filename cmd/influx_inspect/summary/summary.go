@@ -0,0 +1,1521 @@
+package summary
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/storemeta"
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// errLimitReached is returned while reading points to stop once -limit points
+// have been written.
+var errLimitReached = errors.New("limit reached")
+
+// Command represents the program execution for "influx_inspect summary".
+type Command struct {
+	// Standard input/output, overridden for testing.
+	Stderr io.Writer
+	Stdout io.Writer
+
+	dir              string
+	databases        map[string]struct{}
+	shard            string
+	shardID          uint64
+	measurement      string
+	tagCardinality   string
+	points           bool
+	listShards       bool
+	checkMeta        bool
+	checkDuplicates  bool
+	compactionStatus bool
+	measurementSizes bool
+	compression      bool
+	top              int
+	tags             tagList
+	fieldTypeSummary bool
+	jsonSummary      bool
+	schema           bool
+	force            bool
+	workers          int
+
+	series  string
+	since   int64
+	until   int64
+	reverse bool
+	limit   int
+}
+
+// NewCommand returns a new instance of Command.
+func NewCommand() *Command {
+	return &Command{
+		Stderr: os.Stderr,
+		Stdout: os.Stdout,
+		tags:   make(tagList),
+	}
+}
+
+// Run executes the command.
+func (cmd *Command) Run(args ...string) error {
+	var dbs, since, until string
+	fs := flag.NewFlagSet("summary", flag.ExitOnError)
+	fs.StringVar(&cmd.dir, "dir", os.Getenv("HOME")+"/.influxdb", "Root storage path. [$HOME/.influxdb]")
+	fs.StringVar(&dbs, "db", "", "Comma-delimited list of databases to summarize. Default is all databases.")
+	fs.StringVar(&cmd.shard, "shard", "", "Only open and summarize this shard, given as a shard ID or the path of its directory")
+	fs.BoolVar(&cmd.points, "points", false, "Add a Points column to the shard table, counted by reading the series of measurements with several fields")
+	fs.BoolVar(&cmd.listShards, "list-shards", false, "List shard sizes, formats and time ranges and exit")
+	fs.BoolVar(&cmd.checkMeta, "check-meta", false, "Check shards on disk against the metadata and exit")
+	fs.BoolVar(&cmd.checkDuplicates, "check-duplicate-series", false, "Check the index for series registered more than once and exit")
+	fs.BoolVar(&cmd.compactionStatus, "compaction-status", false, "Show the compaction state of each shard and exit")
+	fs.BoolVar(&cmd.measurementSizes, "measurement-sizes", false, "List measurements by estimated size on disk, largest first, and exit")
+	fs.BoolVar(&cmd.compression, "series-compression", false, "List series by the ratio of their raw value bytes to their bytes on disk, worst first, and exit")
+	fs.IntVar(&cmd.top, "top", 20, "Limit -series-compression to the first N series, or 0 for all")
+	fs.Var(cmd.tags, "tag", "Limit -series-compression, -measurement and -schema to the series with this tag value, given as key=value (may be repeated, all must match)")
+	fs.StringVar(&cmd.measurement, "measurement", "", "Show the time range of a measurement in each database and exit")
+	fs.StringVar(&cmd.tagCardinality, "tag-cardinality", "", "Compare the tag key cardinality of a measurement across shards and exit")
+	fs.BoolVar(&cmd.fieldTypeSummary, "field-type-summary", false, "Summarize field counts and sizes by type and exit")
+	fs.BoolVar(&cmd.jsonSummary, "json-summary", false, "Write the store's totals and measurements as a single JSON document and exit")
+	fs.BoolVar(&cmd.schema, "schema", false, "List the tag keys and fields, with their types, of each measurement and exit")
+	fs.IntVar(&cmd.workers, "workers", runtime.GOMAXPROCS(0), "Number of shards scanned concurrently by the shard summary, -list-shards and -field-type-summary")
+	fs.IntVar(&cmd.workers, "concurrency", runtime.GOMAXPROCS(0), "Same as -workers")
+	fs.BoolVar(&cmd.force, "force", false, "Read the data directory even if another process appears to hold its lock")
+	fs.StringVar(&cmd.series, "series", "", "Dump the points of a single series key across all shards and exit")
+	fs.StringVar(&since, "since", "", "Only dump points of -series at or after this RFC3339 time")
+	fs.StringVar(&until, "until", "", "Only dump points of -series at or before this RFC3339 time")
+	fs.BoolVar(&cmd.reverse, "reverse", false, "Dump the points of -series newest first")
+	fs.IntVar(&cmd.limit, "limit", 0, "Stop after dumping this many points of -series, or 0 for all")
+
+	fs.SetOutput(cmd.Stdout)
+	fs.Usage = cmd.printUsage
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cmd.workers < 1 {
+		return fmt.Errorf("-workers must be at least 1")
+	}
+
+	cmd.since, cmd.until = math.MinInt64, math.MaxInt64
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return err
+		}
+		cmd.since = t.UnixNano()
+	}
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return err
+		}
+		cmd.until = t.UnixNano()
+	}
+	if cmd.limit < 0 {
+		return fmt.Errorf("-limit must not be negative")
+	}
+	if len(cmd.tags) > 0 && !cmd.compression && cmd.measurement == "" && !cmd.schema {
+		return fmt.Errorf("-tag can only be used with -series-compression, -measurement or -schema")
+	}
+
+	// Each mode writes its own report and exits, so at most one is given.
+	var modes []string
+	for _, m := range []struct {
+		name string
+		set  bool
+	}{
+		{"-list-shards", cmd.listShards},
+		{"-check-meta", cmd.checkMeta},
+		{"-check-duplicate-series", cmd.checkDuplicates},
+		{"-compaction-status", cmd.compactionStatus},
+		{"-measurement-sizes", cmd.measurementSizes},
+		{"-series-compression", cmd.compression},
+		{"-field-type-summary", cmd.fieldTypeSummary},
+		{"-json-summary", cmd.jsonSummary},
+		{"-schema", cmd.schema},
+		{"-measurement", cmd.measurement != ""},
+		{"-tag-cardinality", cmd.tagCardinality != ""},
+		{"-series", cmd.series != ""},
+	} {
+		if m.set {
+			modes = append(modes, m.name)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("%s can not be used together", strings.Join(modes, " and "))
+	} else if cmd.points && len(modes) > 0 {
+		return fmt.Errorf("-points can not be used with %s", modes[0])
+	}
+
+	if dbs != "" {
+		cmd.databases = make(map[string]struct{})
+		for _, db := range strings.Split(dbs, ",") {
+			if db == "" {
+				return fmt.Errorf("invalid -db %q, database names must not be empty", dbs)
+			}
+			cmd.databases[db] = struct{}{}
+		}
+	}
+
+	if cmd.shard != "" {
+		if cmd.checkMeta {
+			return fmt.Errorf("-check-meta can not be used with -shard")
+		}
+		var dirSet bool
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "dir" {
+				dirSet = true
+			}
+		})
+		if err := cmd.parseShard(dirSet); err != nil {
+			return err
+		}
+	}
+
+	// Parse the series key before touching the store, so a typo fails fast.
+	if cmd.series != "" {
+		key, err := parseSeriesKey(cmd.series)
+		if err != nil {
+			return err
+		}
+		cmd.series = key
+	}
+
+	// Stay off data that another tool, such as influx_tsm, is working on.
+	// The store is only read, so the lock is checked rather than taken.
+	if !cmd.force {
+		if err := tsdb.CheckLock(filepath.Join(cmd.dir, "data")); err != nil {
+			if _, ok := err.(*tsdb.LockedError); ok {
+				return fmt.Errorf("%s, or use -force", err)
+			}
+			return err
+		}
+	}
+
+	store, err := cmd.openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if cmd.shard != "" {
+		if err := cmd.selectShard(store); err != nil {
+			return err
+		}
+	}
+	if err := cmd.checkDatabases(store); err != nil {
+		return err
+	}
+
+	if cmd.listShards {
+		return cmd.printShardList(store)
+	} else if cmd.checkMeta {
+		return cmd.checkMetadata(store)
+	} else if cmd.checkDuplicates {
+		return cmd.checkDuplicateSeries(store)
+	} else if cmd.compactionStatus {
+		return cmd.printCompactionStatus(store)
+	} else if cmd.measurementSizes {
+		return cmd.printMeasurementSizes(store)
+	} else if cmd.compression {
+		return cmd.printSeriesCompression(store)
+	} else if cmd.fieldTypeSummary {
+		return cmd.printFieldTypeSummary(store)
+	} else if cmd.jsonSummary {
+		return cmd.printJSONSummary(store)
+	} else if cmd.schema {
+		return cmd.printSchema(store)
+	} else if cmd.measurement != "" {
+		return cmd.printMeasurementTimeBounds(store)
+	} else if cmd.tagCardinality != "" {
+		return cmd.printTagCardinality(store)
+	} else if cmd.series != "" {
+		return cmd.printSeries(store)
+	}
+	return cmd.printShards(store)
+}
+
+// openStore opens the store under the root storage path, along with any
+// metadata and node information found next to it.
+func (cmd *Command) openStore() (*tsdb.Store, error) {
+	metaDir := filepath.Join(cmd.dir, "meta")
+	var metaClient *meta.Client
+	if _, err := os.Stat(filepath.Join(metaDir, "meta.db")); err == nil {
+		metaClient = meta.NewClient(&meta.Config{Dir: metaDir})
+		if err := metaClient.Load(); err != nil {
+			return nil, fmt.Errorf("load meta: %s", err)
+		}
+	}
+
+	store := tsdb.NewStoreForTooling(cmd.dir)
+	if cmd.shard != "" {
+		id := cmd.shardID
+		store.ShardFilter = func(database, retentionPolicy string, shardID uint64) bool {
+			return shardID == id
+		}
+	}
+	if err := store.OpenReadOnly(); err != nil {
+		return nil, err
+	}
+	if metaClient != nil {
+		store.MetaClient = storemeta.NewClient(metaClient)
+	}
+	if node, err := influxdb.LoadNode(metaDir); err == nil {
+		store.NodeID = node.ID
+	}
+	return store, nil
+}
+
+// parseShard resolves -shard, given as a shard ID or as the path of a shard
+// directory. The root storage path of a shard directory is used as -dir,
+// unless -dir was given, in which case the shard must be in it.
+func (cmd *Command) parseShard(dirSet bool) error {
+	if id, err := strconv.ParseUint(cmd.shard, 10, 64); err == nil {
+		cmd.shardID = id
+		return nil
+	}
+
+	path, err := filepath.Abs(cmd.shard)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(filepath.Base(path), 10, 64)
+	if !fi.IsDir() || err != nil {
+		return fmt.Errorf("invalid -shard %q, expected a shard ID or a shard directory", cmd.shard)
+	}
+
+	// Shards are stored at <root>/data/<db>/<rp>/<id>.
+	data := filepath.Dir(filepath.Dir(filepath.Dir(path)))
+	if filepath.Base(data) != "data" {
+		return fmt.Errorf("shard directory %s is not in a data directory", path)
+	}
+	root := filepath.Dir(data)
+	if dirSet {
+		dir, err := filepath.Abs(cmd.dir)
+		if err != nil {
+			return err
+		} else if dir != root {
+			return fmt.Errorf("shard directory %s is not in the store at %s", path, cmd.dir)
+		}
+	}
+	cmd.dir, cmd.shardID = root, id
+	return nil
+}
+
+// selectShard limits the summary to the database of the -shard shard, the
+// only shard opened.
+func (cmd *Command) selectShard(store *tsdb.Store) error {
+	sh := store.Shard(cmd.shardID)
+	if sh == nil {
+		return fmt.Errorf("shard not found: %d", cmd.shardID)
+	} else if !cmd.includes(sh.Database()) {
+		return fmt.Errorf("shard %d is in database %s, which is not selected by -db", cmd.shardID, sh.Database())
+	}
+	cmd.databases = map[string]struct{}{sh.Database(): struct{}{}}
+	return nil
+}
+
+// printShards writes a row for each shard in the store, ordered by ID. The
+// Points column is only written with -points, since counting points can
+// mean reading every series. A value that can not be read is shown as
+// "error", and the errors are written to stderr after the table.
+func (cmd *Command) printShards(store *tsdb.Store) error {
+	ids := store.ShardIDs()
+	sort.Sort(uint64Slice(ids))
+
+	// Rows are built concurrently and written once all are done, in order.
+	shards := cmd.filterShards(store.Shards(ids))
+	rows := make([][]string, len(shards))
+	errs := make([][]string, len(shards))
+	if err := cmd.forEachShard(shards, func(i int, sh *tsdb.Shard) error {
+		cell := func(name, v string, err error) string {
+			if err != nil {
+				errs[i] = append(errs[i], fmt.Sprintf("shard %d: %s: %s", sh.ID(), name, err))
+				return "error"
+			}
+			return v
+		}
+
+		o, err := store.ShardOwners(sh.ID())
+		ownership := cell("ownership", o.String(), err)
+
+		n, err := sh.SeriesKeyCount()
+		series := cell("series", strconv.Itoa(n), err)
+
+		info, err := store.ShardInfo(sh.ID())
+		format := cell("format", info.Format.String(), err)
+		size := cell("size", strconv.FormatInt(info.Size, 10), err)
+
+		row := []string{
+			strconv.FormatUint(sh.ID(), 10),
+			sh.Database(),
+			sh.RetentionPolicy(),
+			sh.Path(),
+			format,
+			size,
+			series,
+		}
+		if cmd.points {
+			n, err := sh.PointCount()
+			row = append(row, cell("points", strconv.FormatInt(n, 10), err))
+		}
+		values, err := sh.ValueCount()
+		rows[i] = append(row, cell("values", strconv.FormatInt(values, 10), err), ownership)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	header := []string{"Shard", "DB", "RP", "Path", "Format", "Size", "Series"}
+	if cmd.points {
+		header = append(header, "Points")
+	}
+	header = append(header, "Values", "Ownership")
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, a := range errs {
+		for _, msg := range a {
+			fmt.Fprintln(cmd.Stderr, msg)
+		}
+	}
+	return nil
+}
+
+// printShardList writes the size, format and time range of each shard in the
+// store, ordered by database and then by ID. Only file and cache metadata is
+// consulted so no shard data is decoded.
+func (cmd *Command) printShardList(store *tsdb.Store) error {
+	shards := cmd.filterShards(store.Shards(store.ShardIDs()))
+	sort.Sort(shardsByDatabase(shards))
+
+	rows := make([][]string, len(shards))
+	if err := cmd.forEachShard(shards, func(i int, sh *tsdb.Shard) error {
+		info, err := store.ShardInfo(sh.ID())
+		if err != nil {
+			return err
+		}
+
+		min, max, err := store.ShardTimeRange(sh.ID())
+		if err != nil {
+			return err
+		}
+		timeRange := "-"
+		if min <= max {
+			timeRange = time.Unix(0, min).UTC().Format(time.RFC3339Nano) + " - " + time.Unix(0, max).UTC().Format(time.RFC3339Nano)
+		}
+
+		rows[i] = []string{
+			strconv.FormatUint(info.ID, 10),
+			info.Database,
+			info.RetentionPolicy,
+			info.Path,
+			strconv.FormatInt(info.Size, 10),
+			info.Format.String(),
+			timeRange,
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Shard", "DB", "RP", "Path", "Size", "Format", "Time Range"}, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// forEachShard calls fn with each shard and its index, from up to -workers
+// goroutines at once. fn must not write to the output, which is not safe
+// for concurrent use. The first error returned by fn is returned once every
+// call is done.
+func (cmd *Command) forEachShard(shards []*tsdb.Shard, fn func(i int, sh *tsdb.Shard) error) error {
+	var mu sync.Mutex
+	var err error
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if e := fn(i, shards[i]); e != nil {
+					mu.Lock()
+					if err == nil {
+						err = e
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range shards {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return err
+}
+
+// checkMetadata writes any inconsistencies between the shards on disk and the
+// metadata, returning an error if any were found.
+func (cmd *Command) checkMetadata(store *tsdb.Store) error {
+	all, err := store.ValidateMetadataConsistency()
+	if err != nil {
+		return err
+	}
+	var a []tsdb.Inconsistency
+	for _, i := range all {
+		if cmd.includes(i.Database) {
+			a = append(a, i)
+		}
+	}
+	if len(a) == 0 {
+		fmt.Fprintln(cmd.Stdout, "No inconsistencies found.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Shard", "DB", "RP", "Path", "Problem", "Suggested Fix"}, "\t"))
+	for _, i := range a {
+		fmt.Fprintln(tw, strings.Join([]string{
+			strconv.FormatUint(i.ShardID, 10),
+			i.Database,
+			i.RetentionPolicy,
+			i.Path,
+			i.Type.String(),
+			i.Fix,
+		}, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d inconsistencies found", len(a))
+}
+
+// checkDuplicateSeries writes each series registered more than once in the
+// index of each database, along with the shards holding each registration,
+// returning an error if any were found.
+func (cmd *Command) checkDuplicateSeries(store *tsdb.Store) error {
+	databases := cmd.filterDatabases(store.Databases())
+	sort.Strings(databases)
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	var n int
+	for _, db := range databases {
+		idx := store.DatabaseIndex(db)
+		if idx == nil {
+			continue
+		}
+
+		for _, key := range idx.FindDuplicateSeries() {
+			if n == 0 {
+				fmt.Fprintln(tw, strings.Join([]string{"DB", "Series", "ID", "Registered Key", "Shards"}, "\t"))
+			}
+			n++
+
+			for _, s := range idx.DuplicateSeries(key) {
+				ids := s.ShardIDs()
+				shards := make([]string, len(ids))
+				for i, id := range ids {
+					shards[i] = strconv.FormatUint(id, 10)
+				}
+
+				fmt.Fprintln(tw, strings.Join([]string{
+					db,
+					key,
+					strconv.FormatUint(s.ID, 10),
+					s.Key,
+					strings.Join(shards, ","),
+				}, "\t"))
+			}
+		}
+	}
+	if n == 0 {
+		fmt.Fprintln(cmd.Stdout, "No duplicate series found.")
+		return nil
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d duplicate series found", n)
+}
+
+// printCompactionStatus writes the compaction state of each shard, ordered by
+// database and then by ID, so shards that will be compacted once the node
+// starts can be compacted offline beforehand.
+func (cmd *Command) printCompactionStatus(store *tsdb.Store) error {
+	states := store.CompactionStatus()
+	shards := cmd.filterShards(store.Shards(store.ShardIDs()))
+	sort.Sort(shardsByDatabase(shards))
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Shard", "DB", "RP", "Status", "Files", "Generations", "Tombstoned", "Planned", "Temp Files", "WAL Segments", "WAL Points"}, "\t"))
+	for _, sh := range shards {
+		st, ok := states[sh.ID()]
+		if !ok {
+			continue
+		}
+		fmt.Fprintln(tw, strings.Join([]string{
+			strconv.FormatUint(sh.ID(), 10),
+			sh.Database(),
+			sh.RetentionPolicy(),
+			st.Status.String(),
+			strconv.Itoa(st.Files),
+			strconv.Itoa(st.Generations),
+			strconv.Itoa(st.Tombstones),
+			strconv.Itoa(st.PlannedFiles),
+			strconv.Itoa(st.TempFiles),
+			strconv.Itoa(st.WALSegments),
+			strconv.FormatInt(st.WALPoints, 10),
+		}, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printWALNote writes the number of points of the summarized shards held
+// only in the WAL, if any, for the summaries read from the TSM files that
+// leave them out.
+func (cmd *Command) printWALNote(store *tsdb.Store) {
+	states := store.CompactionStatus()
+	var segments int
+	var points int64
+	for _, sh := range cmd.filterShards(store.Shards(store.ShardIDs())) {
+		segments += states[sh.ID()].WALSegments
+		points += states[sh.ID()].WALPoints
+	}
+	if points > 0 {
+		fmt.Fprintf(cmd.Stdout, "%d points in %d WAL segments are not included.\n", points, segments)
+	}
+}
+
+// measurementSize is the size, series count, point count and value count of
+// a measurement.
+type measurementSize struct {
+	database    string
+	measurement string
+	series      int
+	points      int64
+	values      int64
+	size        int64
+}
+
+// printMeasurementSizes writes the estimated bytes on disk of each measurement
+// in each database, largest first, along with its series, point and value
+// counts and the cumulative percentage of all measurements' bytes. Sizes are
+// summed from the TSM indexes, so data only held in the WAL is not included.
+// Values are counted from the block headers of the TSM files and the WAL, and
+// points by reading every series.
+func (cmd *Command) printMeasurementSizes(store *tsdb.Store) error {
+	var sizes []measurementSize
+	var total int64
+	for _, db := range cmd.filterDatabases(store.Databases()) {
+		idx := store.DatabaseIndex(db)
+		if idx == nil {
+			continue
+		}
+		points, err := store.MeasurementPointCounts(db)
+		if err != nil {
+			return err
+		}
+		values, err := store.MeasurementValueCounts(db)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range idx.Measurements() {
+			n, err := store.MeasurementSize(db, m.Name)
+			if err != nil {
+				return err
+			}
+			sizes = append(sizes, measurementSize{
+				database:    db,
+				measurement: m.Name,
+				series:      m.SeriesN(),
+				points:      points[m.Name],
+				values:      values[m.Name],
+				size:        n,
+			})
+			total += n
+		}
+	}
+	sort.Sort(measurementSizesBySize(sizes))
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"DB", "Measurement", "Series", "Points", "Values", "Est. Bytes", "Bytes/Series", "Percent", "Cumulative"}, "\t"))
+	var cumulative int64
+	for _, s := range sizes {
+		cumulative += s.size
+
+		var perSeries int64
+		if s.series > 0 {
+			perSeries = s.size / int64(s.series)
+		}
+		var pct, cumulativePct float64
+		if total > 0 {
+			pct = 100 * float64(s.size) / float64(total)
+			cumulativePct = 100 * float64(cumulative) / float64(total)
+		}
+
+		fmt.Fprintln(tw, strings.Join([]string{
+			s.database,
+			s.measurement,
+			strconv.Itoa(s.series),
+			strconv.FormatInt(s.points, 10),
+			strconv.FormatInt(s.values, 10),
+			strconv.FormatInt(s.size, 10),
+			strconv.FormatInt(perSeries, 10),
+			fmt.Sprintf("%.1f%%", pct),
+			fmt.Sprintf("%.1f%%", cumulativePct),
+		}, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	cmd.printWALNote(store)
+	return nil
+}
+
+// seriesCompression is the raw and on-disk size of a series' values.
+type seriesCompression struct {
+	database string
+	key      string
+	tsdb.SeriesBlockStats
+}
+
+// ratio returns the raw bytes of the series' values per byte on disk.
+func (s *seriesCompression) ratio() float64 {
+	if s.DiskBytes == 0 {
+		return 0
+	}
+	return float64(s.RawBytes) / float64(s.DiskBytes)
+}
+
+// printSeriesCompression writes the compression ratio of each series in each
+// database, summed across its shards, worst first. Every block of every
+// series is decoded, so this can take a long time on a large store. Series
+// are streamed from the index and only the -top worst are held in memory.
+func (cmd *Command) printSeriesCompression(store *tsdb.Store) error {
+	worst := &seriesCompressionHeap{n: cmd.top}
+	for _, db := range cmd.filterDatabases(store.Databases()) {
+		idx := store.DatabaseIndex(db)
+		if idx == nil {
+			continue
+		}
+
+		if err := cmd.forEachSeries(idx, func(s *tsdb.Series) error {
+			c := &seriesCompression{database: db, key: s.Key}
+			for _, id := range s.ShardIDs() {
+				sh := store.Shard(id)
+				if sh == nil {
+					continue
+				}
+				stats, err := sh.SeriesBlockStats(s.Key)
+				if err != nil {
+					return err
+				}
+				c.Values += stats.Values
+				c.RawBytes += stats.RawBytes
+				c.DiskBytes += stats.DiskBytes
+			}
+			if c.DiskBytes > 0 {
+				worst.add(c)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	series := worst.sorted()
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"DB", "Series", "Values", "Raw Bytes", "Disk Bytes", "Ratio"}, "\t"))
+	for _, s := range series {
+		fmt.Fprintln(tw, strings.Join([]string{
+			s.database,
+			s.key,
+			strconv.FormatInt(s.Values, 10),
+			strconv.FormatInt(s.RawBytes, 10),
+			strconv.FormatInt(s.DiskBytes, 10),
+			fmt.Sprintf("%.2f", s.ratio()),
+		}, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	cmd.printWALNote(store)
+	return nil
+}
+
+// forEachSeries calls fn with each series of idx that has every -tag value.
+// The series of each measurement are looked up in its tag index, so series
+// without the tag values are never visited.
+func (cmd *Command) forEachSeries(idx *tsdb.DatabaseIndex, fn func(s *tsdb.Series) error) error {
+	if len(cmd.tags) == 0 {
+		return idx.ForEachSeries(fn)
+	}
+
+	expr := cmd.tags.expr()
+	for _, m := range idx.Measurements() {
+		ids, err := m.SeriesIDsAllOrByExpr(expr)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			s := m.SeriesByID(id)
+			if s == nil {
+				continue
+			}
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printFieldTypeSummary writes the number of fields of each type across the
+// store along with the bytes of TSM blocks holding them. Sizes are taken
+// from the TSM indexes so no blocks are decoded. Data only held in the WAL
+// is not included.
+func (cmd *Command) printFieldTypeSummary(store *tsdb.Store) error {
+	// Shards are scanned concurrently and their fields and sizes merged.
+	stats := newFieldTypeStats()
+	var mu sync.Mutex
+	if err := cmd.forEachShard(cmd.filterShards(store.Shards(store.ShardIDs())), func(i int, sh *tsdb.Shard) error {
+		s, err := scanFieldTypes(sh)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		stats.merge(s)
+		mu.Unlock()
+		return nil
+	}); err != nil {
+		return err
+	}
+	fields, sizes, total := stats.fields, stats.sizes, stats.total
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Type", "Fields", "Est. Bytes", "Percent"}, "\t"))
+	for _, typ := range []byte{tsm1.BlockFloat64, tsm1.BlockInteger, tsm1.BlockString, tsm1.BlockBoolean} {
+		var pct float64
+		if total > 0 {
+			pct = 100 * float64(sizes[typ]) / float64(total)
+		}
+		fmt.Fprintln(tw, strings.Join([]string{
+			tsm1.BlockTypeToInfluxQLDataType(typ).String(),
+			strconv.Itoa(len(fields[typ])),
+			strconv.FormatInt(sizes[typ], 10),
+			fmt.Sprintf("%.1f%%", pct),
+		}, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	cmd.printWALNote(store)
+	return nil
+}
+
+// fieldTypeStats holds the fields of each type, keyed by database,
+// measurement and field, and the bytes of the blocks holding them.
+type fieldTypeStats struct {
+	fields map[byte]map[string]struct{}
+	sizes  map[byte]int64
+	total  int64
+}
+
+func newFieldTypeStats() *fieldTypeStats {
+	return &fieldTypeStats{
+		fields: make(map[byte]map[string]struct{}),
+		sizes:  make(map[byte]int64),
+	}
+}
+
+// add records a field of the given type held in blocks of size bytes.
+func (s *fieldTypeStats) add(typ byte, key string, size int64) {
+	if s.fields[typ] == nil {
+		s.fields[typ] = make(map[string]struct{})
+	}
+	s.fields[typ][key] = struct{}{}
+	s.sizes[typ] += size
+	s.total += size
+}
+
+// merge adds the fields and sizes of other to s.
+func (s *fieldTypeStats) merge(other *fieldTypeStats) {
+	for typ, keys := range other.fields {
+		for k := range keys {
+			s.add(typ, k, 0)
+		}
+		s.sizes[typ] += other.sizes[typ]
+	}
+	s.total += other.total
+}
+
+// scanFieldTypes returns the fields of each type in the shard's TSM files and
+// the bytes of the blocks holding them.
+func scanFieldTypes(sh *tsdb.Shard) (*fieldTypeStats, error) {
+	files, err := filepath.Glob(filepath.Join(sh.Path(), "*."+tsm1.TSMFileExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newFieldTypeStats()
+	for _, path := range files {
+		if err := scanFileFieldTypes(sh.Database(), path, stats); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// scanFileFieldTypes adds the fields of the TSM file at path, in database
+// db, to stats. The file is closed on return, even if scanning panics.
+func scanFileFieldTypes(db, path string, stats *fieldTypeStats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	defer r.Close()
+
+	for i := 0; i < r.KeyCount(); i++ {
+		key, typ := r.KeyAt(i)
+		_, entries := r.Key(i)
+
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		measurement := tsdb.MeasurementFromSeriesKey(string(seriesKey))
+
+		var size int64
+		for _, e := range entries {
+			size += int64(e.Size)
+		}
+		// Fields are counted once per type in each measurement of each
+		// database.
+		stats.add(typ, db+"\x00"+measurement+"\x00"+field, size)
+	}
+	return nil
+}
+
+// jsonSummary is the document written by -json-summary.
+type jsonSummary struct {
+	Shards       int                  `json:"shards"`
+	Databases    int                  `json:"databases"`
+	Series       int                  `json:"series"`
+	DiskBytes    int64                `json:"diskBytes"`
+	Measurements []measurementSummary `json:"measurements"`
+}
+
+// measurementSummary is a row of the measurements in a jsonSummary.
+type measurementSummary struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	Series      int    `json:"series"`
+	Fields      int    `json:"fields"`
+	TagKeys     int    `json:"tagKeys"`
+}
+
+// printJSONSummary writes the number of shards, databases and series in the
+// store, its size on disk and a row for each measurement, ordered by
+// database and measurement, as a single JSON document. Counts are taken from
+// the in-memory index so no point data is read.
+func (cmd *Command) printJSONSummary(store *tsdb.Store) error {
+	sizes, err := store.DiskSizeByShard()
+	if err != nil {
+		return err
+	}
+	shards := cmd.filterShards(store.Shards(store.ShardIDs()))
+	var size int64
+	for _, sh := range shards {
+		size += sizes[sh.ID()]
+	}
+
+	databases := cmd.filterDatabases(store.Databases())
+	sort.Strings(databases)
+
+	s := jsonSummary{
+		Shards:       len(shards),
+		Databases:    len(databases),
+		DiskBytes:    size,
+		Measurements: []measurementSummary{},
+	}
+	for _, db := range databases {
+		idx := store.DatabaseIndex(db)
+		if idx == nil {
+			continue
+		}
+		s.Series += idx.SeriesN()
+
+		measurements := idx.Measurements()
+		sort.Sort(measurements)
+		for _, m := range measurements {
+			s.Measurements = append(s.Measurements, measurementSummary{
+				Database:    db,
+				Measurement: m.Name,
+				Series:      m.SeriesN(),
+				Fields:      len(m.FieldNames()),
+				TagKeys:     len(m.TagKeys()),
+			})
+		}
+	}
+
+	return json.NewEncoder(cmd.Stdout).Encode(s)
+}
+
+// printSchema writes a row for each tag key and field of each measurement,
+// ordered by database, measurement and key, without reading any points.
+// Tag keys and field types are taken from the index. A field written with
+// different types in different shards is listed with each of its types,
+// looked up in the shards. With -tag, only the measurements holding a series
+// with every tag value are listed.
+func (cmd *Command) printSchema(store *tsdb.Store) error {
+	databases := cmd.filterDatabases(store.Databases())
+	sort.Strings(databases)
+	filters := cmd.tags.filters()
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"DB", "Measurement", "Key", "Kind", "Type"}, "\t"))
+	for _, db := range databases {
+		idx := store.DatabaseIndex(db)
+		if idx == nil {
+			continue
+		}
+		measurements := idx.Measurements()
+		sort.Sort(measurements)
+		for _, m := range measurements {
+			if len(filters) > 0 && len(m.SeriesKeysMatching(filters)) == 0 {
+				continue
+			}
+
+			tagKeys := m.TagKeys()
+			sort.Strings(tagKeys)
+			for _, k := range tagKeys {
+				fmt.Fprintln(tw, strings.Join([]string{db, m.Name, k, "tag", ""}, "\t"))
+			}
+
+			names := m.FieldNames()
+			sort.Strings(names)
+			for _, name := range names {
+				types := []string{m.FieldType(name).String()}
+				if m.FieldType(name) == influxql.Unknown {
+					var err error
+					if types, err = shardFieldTypes(store, db, m.Name, name); err != nil {
+						return err
+					}
+				}
+				fmt.Fprintln(tw, strings.Join([]string{db, m.Name, name, "field", strings.Join(types, ",")}, "\t"))
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// shardFieldTypes returns the sorted types of the field of the measurement
+// across the shards of the database.
+func shardFieldTypes(store *tsdb.Store, db, measurement, field string) ([]string, error) {
+	var types []string
+	for _, sh := range store.Shards(store.ShardIDs()) {
+		if sh.Database() != db {
+			continue
+		}
+		set, err := sh.FieldTypes(measurement)
+		if err != nil {
+			return nil, err
+		}
+		if typ, ok := set[field]; ok && !contains(types, typ.String()) {
+			types = append(types, typ.String())
+		}
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+// printMeasurementTimeBounds writes the time range of the measurement in
+// each database that holds data for it.
+func (cmd *Command) printMeasurementTimeBounds(store *tsdb.Store) error {
+	databases := cmd.filterDatabases(store.Databases())
+	sort.Strings(databases)
+
+	var found bool
+	for _, db := range databases {
+		first, last, err := store.MeasurementTimeBounds(db, cmd.measurement, cmd.tags.filters())
+		if err != nil {
+			return err
+		} else if first > last {
+			continue
+		}
+
+		found = true
+		fmt.Fprintf(cmd.Stdout, "measurement %s in %s: data from %s to %s\n", cmd.measurement, db,
+			time.Unix(0, first).UTC().Format(time.RFC3339Nano),
+			time.Unix(0, last).UTC().Format(time.RFC3339Nano))
+	}
+	if !found {
+		fmt.Fprintf(cmd.Stdout, "measurement %s: no data\n", cmd.measurement)
+	}
+	return nil
+}
+
+// printTagCardinality writes the number of distinct values of each tag key
+// of a measurement in each shard, ordered by database, shard ID and tag key,
+// so that shards where a tag's cardinality grew stand out.
+func (cmd *Command) printTagCardinality(store *tsdb.Store) error {
+	shards := cmd.filterShards(store.Shards(store.ShardIDs()))
+	sort.Sort(shardsByDatabase(shards))
+
+	tw := tabwriter.NewWriter(cmd.Stdout, 8, 8, 1, '\t', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Shard", "DB", "RP", "Tag Key", "Values"}, "\t"))
+	for _, sh := range shards {
+		n, err := sh.TagKeyCardinality(cmd.tagCardinality)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Fprintln(tw, strings.Join([]string{
+				strconv.FormatUint(sh.ID(), 10),
+				sh.Database(),
+				sh.RetentionPolicy(),
+				k,
+				strconv.Itoa(n[k]),
+			}, "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+// checkDatabases returns an error naming any database given by -db that is
+// not in the store, along with the databases that are.
+func (cmd *Command) checkDatabases(store *tsdb.Store) error {
+	databases := store.Databases()
+	sort.Strings(databases)
+
+	var missing []string
+	for db := range cmd.databases {
+		if !contains(databases, db) {
+			missing = append(missing, db)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+
+	available := "none"
+	if len(databases) > 0 {
+		available = strings.Join(databases, ", ")
+	}
+	return fmt.Errorf("database not found: %s (databases in store: %s)", strings.Join(missing, ", "), available)
+}
+
+// includes returns whether the database db is summarized.
+func (cmd *Command) includes(db string) bool {
+	if cmd.databases == nil {
+		return true
+	}
+	_, ok := cmd.databases[db]
+	return ok
+}
+
+// filterDatabases returns the databases in a that are summarized.
+func (cmd *Command) filterDatabases(a []string) []string {
+	if cmd.databases == nil {
+		return a
+	}
+	var other []string
+	for _, db := range a {
+		if cmd.includes(db) {
+			other = append(other, db)
+		}
+	}
+	return other
+}
+
+// filterShards returns the shards in a whose database is summarized.
+func (cmd *Command) filterShards(a []*tsdb.Shard) []*tsdb.Shard {
+	if cmd.databases == nil {
+		return a
+	}
+	var other []*tsdb.Shard
+	for _, sh := range a {
+		if cmd.includes(sh.Database()) {
+			other = append(other, sh)
+		}
+	}
+	return other
+}
+
+// contains returns whether a contains s.
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSeriesKey returns the series key s in the form it is stored in the
+// index, with its tags sorted, or an error if s is not a valid series key.
+func parseSeriesKey(s string) (string, error) {
+	points, err := models.ParsePointsString(s + " value=0")
+	if err != nil || len(points) != 1 {
+		return "", fmt.Errorf("invalid series key %q", s)
+	}
+	return string(points[0].Key()), nil
+}
+
+// printSeries writes the points of the series given by -series in each shard
+// holding it, as line protocol. The shards are found from the series' entry
+// in each database index, and only that series is read from them.
+func (cmd *Command) printSeries(store *tsdb.Store) error {
+	var ids []uint64
+	for _, db := range cmd.filterDatabases(store.Databases()) {
+		idx := store.DatabaseIndex(db)
+		if idx == nil {
+			continue
+		}
+		if s := idx.Series(cmd.series); s != nil {
+			ids = append(ids, s.ShardIDs()...)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("series %q not found", cmd.series)
+	}
+
+	// Shards are created in time order, so ordering them by ID orders the
+	// points of the series by time.
+	sort.Sort(uint64Slice(ids))
+	if cmd.reverse {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+	}
+
+	// Points are written as they are read, and reading stops once -limit
+	// points have been written.
+	var n int
+	for _, sh := range store.Shards(ids) {
+		if cmd.limit > 0 && n >= cmd.limit {
+			break
+		}
+
+		var header bool
+		if err := sh.ReadSeries(cmd.series, cmd.since, cmd.until, !cmd.reverse, func(points []models.Point) error {
+			if !header {
+				fmt.Fprintf(cmd.Stdout, "# shard %d (%s/%s)\n", sh.ID(), sh.Database(), sh.RetentionPolicy())
+				header = true
+			}
+			for _, p := range points {
+				if cmd.limit > 0 && n >= cmd.limit {
+					return errLimitReached
+				}
+				fmt.Fprintln(cmd.Stdout, p.String())
+				n++
+			}
+			return nil
+		}); err == tsdb.ErrFieldsNotFound {
+			// The points are on disk but can not be read, which is reported
+			// rather than leaving the shard out silently.
+			fmt.Fprintf(cmd.Stdout, "# shard %d (%s/%s): WARNING: skipped, %s %s\n", sh.ID(), sh.Database(), sh.RetentionPolicy(), err, tsdb.MeasurementFromSeriesKey(cmd.series))
+		} else if err != nil && err != errLimitReached {
+			return err
+		}
+	}
+	if n == 0 {
+		fmt.Fprintf(cmd.Stdout, "series %s: no points\n", cmd.series)
+	}
+	return nil
+}
+
+// printUsage prints the usage message to STDERR.
+func (cmd *Command) printUsage() {
+	usage := fmt.Sprintf(`Displays a summary of the shards in a store.
+
+Usage: influx_inspect summary [flags]
+
+    -dir <path>
+            Root storage path
+            Defaults to "%[1]s/.influxdb".
+    -db <names>
+            Comma-delimited list of databases to summarize. Defaults
+            to all databases.
+    -shard <id or path>
+            Only open and summarize this shard, given as a shard ID or
+            the path of its directory. A path also sets -dir to the
+            store holding the shard. Startup is much faster on stores
+            with many shards, since no other shard is opened.
+    -points
+            Add a Points column to the shard table. Points of
+            measurements with several fields are counted by reading
+            their series, which can take as long as reading the
+            shard.
+    -list-shards
+            List the size, format and time range of each shard and exit.
+    -check-meta
+            Check shards on disk against the metadata and exit.
+    -check-duplicate-series
+            Check the index for series registered more than once and
+            exit.
+    -compaction-status
+            Show the compaction state of each shard, including the
+            WAL segments and points not yet written to TSM files, and
+            exit.
+    -measurement-sizes
+            List measurements by estimated size on disk, largest
+            first, and exit.
+    -series-compression
+            List series by the ratio of their raw value bytes to
+            their bytes on disk, worst first, and exit.
+    -top <n>
+            Limit -series-compression to the first n series, or 0
+            for all. Defaults to 20.
+    -tag <key=value>
+            Limit -series-compression, -measurement and -schema to
+            the series with this tag value. May be repeated, in
+            which case a series must match every tag.
+    -measurement <name>
+            Show the time range of a measurement in each database and exit.
+    -tag-cardinality <measurement>
+            Compare the tag key cardinality of a measurement across
+            shards and exit.
+    -field-type-summary
+            Summarize field counts and sizes by type and exit.
+    -workers <n>
+            Number of shards scanned concurrently by the shard
+            summary, -list-shards and -field-type-summary. Output is
+            written once every shard is scanned, in the usual order.
+            Defaults to the number of CPUs.
+    -concurrency <n>
+            Same as -workers.
+    -json-summary
+            Write the number of shards, databases and series, the
+            size on disk and a row for each measurement as a single
+            JSON document and exit.
+    -schema
+            List the tag keys and fields of each measurement, with
+            the types of the fields, and exit. No points are read.
+    -series <key>
+            Dump the points of a single series, such as
+            'cpu,host=web01', from each shard holding it as line
+            protocol and exit.
+    -since <time>
+            Only dump points of -series at or after this RFC3339
+            time.
+    -until <time>
+            Only dump points of -series at or before this RFC3339
+            time.
+    -reverse
+            Dump the points of -series newest first.
+    -limit <n>
+            Stop after dumping n points of -series, or 0 for all.
+            Defaults to 0.
+    -force
+            Read the data directory even if another process appears
+            to hold its lock.
+`, os.Getenv("HOME"))
+
+	fmt.Fprintf(cmd.Stdout, usage)
+}
+
+type uint64Slice []uint64
+
+func (a uint64Slice) Len() int           { return len(a) }
+func (a uint64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a uint64Slice) Less(i, j int) bool { return a[i] < a[j] }
+
+// tagList is a repeatable flag holding the value required of each tag key.
+type tagList map[string]string
+
+// String returns the tags as key=value pairs, sorted and comma-separated.
+func (l tagList) String() string {
+	a := make([]string, 0, len(l))
+	for k, v := range l {
+		a = append(a, k+"="+v)
+	}
+	sort.Strings(a)
+	return strings.Join(a, ",")
+}
+
+// Set adds a tag given as key=value. A key may only be given once, since a
+// series has a single value for it.
+func (l tagList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid tag %q, expected key=value", s)
+	}
+	key, value := s[:i], s[i+1:]
+	if _, ok := l[key]; ok {
+		return fmt.Errorf("tag key %q given more than once", key)
+	}
+	l[key] = value
+	return nil
+}
+
+// expr returns an expression matching the series with every tag value, in
+// the form the tag index of a measurement evaluates.
+func (l tagList) expr() influxql.Expr {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var expr influxql.Expr
+	for _, k := range keys {
+		e := &influxql.BinaryExpr{
+			Op:  influxql.EQ,
+			LHS: &influxql.VarRef{Val: k, Type: influxql.Tag},
+			RHS: &influxql.StringLiteral{Val: l[k]},
+		}
+		if expr == nil {
+			expr = e
+		} else {
+			expr = &influxql.BinaryExpr{Op: influxql.AND, LHS: expr, RHS: e}
+		}
+	}
+	return expr
+}
+
+// filters returns the tag filters matching the series with every tag value,
+// sorted by tag key, or nil if no tags are given.
+func (l tagList) filters() []*tsdb.TagFilter {
+	if len(l) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	filters := make([]*tsdb.TagFilter, len(keys))
+	for i, k := range keys {
+		filters[i] = &tsdb.TagFilter{Op: influxql.EQ, Key: k, Value: l[k]}
+	}
+	return filters
+}
+
+// seriesCompressionHeap holds the n series compressing worst of those added,
+// or every series if n is 0. The series compressing best is at the root, so
+// it is the one replaced by a worse series.
+type seriesCompressionHeap struct {
+	n int
+	a seriesCompressionsByRatio
+}
+
+func (h *seriesCompressionHeap) Len() int           { return len(h.a) }
+func (h *seriesCompressionHeap) Swap(i, j int)      { h.a.Swap(i, j) }
+func (h *seriesCompressionHeap) Less(i, j int) bool { return h.a.Less(j, i) }
+
+func (h *seriesCompressionHeap) Push(x interface{}) {
+	h.a = append(h.a, x.(*seriesCompression))
+}
+
+func (h *seriesCompressionHeap) Pop() interface{} {
+	s := h.a[len(h.a)-1]
+	h.a = h.a[:len(h.a)-1]
+	return s
+}
+
+// add adds s, dropping the series compressing best if more than n are held.
+func (h *seriesCompressionHeap) add(s *seriesCompression) {
+	if h.n == 0 {
+		h.a = append(h.a, s)
+	} else if len(h.a) < h.n {
+		heap.Push(h, s)
+	} else if (seriesCompressionsByRatio{s, h.a[0]}).Less(0, 1) {
+		h.a[0] = s
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the series held, worst first.
+func (h *seriesCompressionHeap) sorted() []*seriesCompression {
+	sort.Sort(h.a)
+	return h.a
+}
+
+type measurementSizesBySize []measurementSize
+
+func (a measurementSizesBySize) Len() int      { return len(a) }
+func (a measurementSizesBySize) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a measurementSizesBySize) Less(i, j int) bool {
+	if a[i].size != a[j].size {
+		return a[i].size > a[j].size
+	}
+	if a[i].database != a[j].database {
+		return a[i].database < a[j].database
+	}
+	return a[i].measurement < a[j].measurement
+}
+
+type shardsByDatabase []*tsdb.Shard
+
+func (a shardsByDatabase) Len() int      { return len(a) }
+func (a shardsByDatabase) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a shardsByDatabase) Less(i, j int) bool {
+	if a[i].Database() != a[j].Database() {
+		return a[i].Database() < a[j].Database()
+	}
+	return a[i].ID() < a[j].ID()
+}
+
+type seriesCompressionsByRatio []*seriesCompression
+
+func (a seriesCompressionsByRatio) Len() int      { return len(a) }
+func (a seriesCompressionsByRatio) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a seriesCompressionsByRatio) Less(i, j int) bool {
+	if ri, rj := a[i].ratio(), a[j].ratio(); ri != rj {
+		return ri < rj
+	} else if a[i].database != a[j].database {
+		return a[i].database < a[j].database
+	}
+	return a[i].key < a[j].key
+}
@@ -0,0 +1,930 @@
+package summary_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/internal/testutil"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/summary"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure each report is written from a store on disk.
+func TestCommand_Run(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, 3.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(20, 4.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db1", "rp0", "3", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"disk,host=a#!~#used": {tsm1.NewValue(30, 5.0)},
+		"disk,host=b#!~#used": {tsm1.NewValue(30, 6.0)},
+	})
+
+	for i, tt := range []struct {
+		args []string
+		exp  []string
+		nexp []string
+		out  string
+		err  string
+	}{
+		// The shard table is written when no report is asked for.
+		{
+			exp: []string{
+				"Shard DB RP Path Format Size Series Values Ownership",
+				"1 db0 rp0 $DIR/data/db0/rp0/1 tsm1 * 2 3 standalone",
+				"2 db0 rp0 $DIR/data/db0/rp0/2 tsm1 * 1 1 standalone",
+				"3 db1 rp0 $DIR/data/db1/rp0/3 tsm1 * 2 2 standalone",
+			},
+		},
+		{
+			args: []string{"-points"},
+			exp: []string{
+				"Shard DB RP Path Format Size Series Points Values Ownership",
+				"1 db0 rp0 $DIR/data/db0/rp0/1 tsm1 * 2 3 3 standalone",
+				"2 db0 rp0 $DIR/data/db0/rp0/2 tsm1 * 1 1 1 standalone",
+				"3 db1 rp0 $DIR/data/db1/rp0/3 tsm1 * 2 2 2 standalone",
+			},
+		},
+		{
+			args: []string{"-points", "-list-shards"},
+			err:  "-points can not be used with -list-shards",
+		},
+		{
+			args: []string{"-list-shards"},
+			exp: []string{
+				"Shard DB RP Path Size Format Time Range",
+				"1 db0 rp0 $DIR/data/db0/rp0/1 * tsm1 1970-01-01T00:00:00Z - 1970-01-01T00:00:00.00000001Z",
+				"2 db0 rp0 $DIR/data/db0/rp0/2 * tsm1 1970-01-01T00:00:00.00000002Z - 1970-01-01T00:00:00.00000002Z",
+			},
+		},
+		// Metadata can only be checked when it is found next to the store.
+		{
+			args: []string{"-check-meta"},
+			err:  "metadata not found",
+		},
+		{
+			args: []string{"-field-type-summary"},
+			exp: []string{
+				"Type Fields Est. Bytes Percent",
+				"float 2 * 100.0%",
+				"integer 0 0 0.0%",
+				"string 0 0 0.0%",
+				"boolean 0 0 0.0%",
+			},
+		},
+		{
+			args: []string{"-measurement", "cpu"},
+			out:  "measurement cpu in db0: data from 1970-01-01T00:00:00Z to 1970-01-01T00:00:00.00000002Z\n",
+		},
+		{
+			args: []string{"-measurement", "mem"},
+			out:  "measurement mem: no data\n",
+		},
+		{
+			args: []string{"-tag-cardinality", "cpu"},
+			exp: []string{
+				"Shard DB RP Tag Key Values",
+				"1 db0 rp0 host 2",
+				"2 db0 rp0 host 1",
+			},
+		},
+		{
+			args: []string{"-check-duplicate-series"},
+			out:  "No duplicate series found.\n",
+		},
+		{
+			args: []string{"-field-type-summary", "-workers", "0"},
+			err:  "-workers must be at least 1",
+		},
+		{
+			args: []string{"-series", "cpu,host=a"},
+			out:  "# shard 1 (db0/rp0)\ncpu,host=a value=1 0\ncpu,host=a value=2 10\n# shard 2 (db0/rp0)\ncpu,host=a value=4 20\n",
+		},
+		{
+			args: []string{"-series", "cpu,host=a", "-reverse", "-limit", "2"},
+			out:  "# shard 2 (db0/rp0)\ncpu,host=a value=4 20\n# shard 1 (db0/rp0)\ncpu,host=a value=2 10\n",
+		},
+		{
+			args: []string{"-series", "cpu,host=a", "-limit", "1"},
+			out:  "# shard 1 (db0/rp0)\ncpu,host=a value=1 0\n",
+		},
+		{
+			args: []string{"-series", "cpu,host=a", "-since", "1970-01-01T00:00:00.000000005Z", "-until", "1970-01-01T00:00:00.000000015Z"},
+			out:  "# shard 1 (db0/rp0)\ncpu,host=a value=2 10\n",
+		},
+		{
+			args: []string{"-series", "cpu,host=a", "-since", "1970-01-01T00:00:01Z"},
+			out:  "series cpu,host=a: no points\n",
+		},
+		{
+			args: []string{"-series", "cpu,host=c"},
+			err:  `series "cpu,host=c" not found`,
+		},
+		{
+			args: []string{"-series", "cpu,host="},
+			err:  `invalid series key "cpu,host="`,
+		},
+		// Reports are restricted to the databases given by -db.
+		{
+			args: []string{"-db", "db1"},
+			exp: []string{
+				"Shard DB RP Path Format Size Series Values Ownership",
+				"3 db1 rp0 $DIR/data/db1/rp0/3 tsm1 * 2 2 standalone",
+			},
+			nexp: []string{
+				"1 db0 rp0 $DIR/data/db0/rp0/1 tsm1 * * * standalone",
+				"2 db0 rp0 $DIR/data/db0/rp0/2 tsm1 * * * standalone",
+			},
+		},
+		{
+			args: []string{"-db", "db1", "-field-type-summary"},
+			exp:  []string{"float 1 * 100.0%"},
+		},
+		{
+			args: []string{"-db", "db1", "-measurement", "cpu"},
+			out:  "measurement cpu: no data\n",
+		},
+		{
+			args: []string{"-db", "db1,db0", "-measurement", "cpu"},
+			out:  "measurement cpu in db0: data from 1970-01-01T00:00:00Z to 1970-01-01T00:00:00.00000002Z\n",
+		},
+		{
+			args: []string{"-db", "db1", "-series", "cpu,host=a"},
+			err:  `series "cpu,host=a" not found`,
+		},
+		{
+			args: []string{"-db", "db9,db1,db8"},
+			err:  "database not found: db8, db9 (databases in store: db0, db1)",
+		},
+		{
+			args: []string{"-db", "db0,"},
+			err:  `invalid -db "db0,", database names must not be empty`,
+		},
+		// Only the shard given by -shard is opened and summarized.
+		{
+			args: []string{"-shard", "2"},
+			exp: []string{
+				"Shard DB RP Path Format Size Series Values Ownership",
+				"2 db0 rp0 $DIR/data/db0/rp0/2 tsm1 * 1 1 standalone",
+			},
+			nexp: []string{
+				"1 db0 rp0 $DIR/data/db0/rp0/1 tsm1 * * * standalone",
+				"3 db1 rp0 $DIR/data/db1/rp0/3 tsm1 * * * standalone",
+			},
+		},
+		{
+			args: []string{"-shard", filepath.Join(dir, "data", "db0", "rp0", "2"), "-series", "cpu,host=a"},
+			out:  "# shard 2 (db0/rp0)\ncpu,host=a value=4 20\n",
+		},
+		{
+			args: []string{"-shard", "9"},
+			err:  "shard not found: 9",
+		},
+		{
+			args: []string{"-shard", "1", "-db", "db1"},
+			err:  "shard 1 is in database db0, which is not selected by -db",
+		},
+		{
+			args: []string{"-shard", "1", "-check-meta"},
+			err:  "-check-meta can not be used with -shard",
+		},
+		// The schema lists tag keys before the fields of each measurement.
+		{
+			args: []string{"-schema"},
+			exp: []string{
+				"DB Measurement Key Kind Type",
+				"db0 cpu host tag",
+				"db0 cpu value field float",
+				"db1 disk host tag",
+				"db1 disk used field float",
+			},
+		},
+		{
+			args: []string{"-schema", "-db", "db1"},
+			exp: []string{
+				"DB Measurement Key Kind Type",
+				"db1 disk host tag",
+				"db1 disk used field float",
+			},
+			nexp: []string{"db0 cpu host tag"},
+		},
+		{
+			args: []string{"-tag", "host=a"},
+			err:  "-tag can only be used with -series-compression, -measurement or -schema",
+		},
+		{
+			args: []string{"-series", "cpu,host=a", "-tag", "host=a"},
+			err:  "-tag can only be used with -series-compression, -measurement or -schema",
+		},
+		{
+			args: []string{"-measurement-sizes", "-tag", "host=a"},
+			err:  "-tag can only be used with -series-compression, -measurement or -schema",
+		},
+		// -tag limits the time range and schema to the matching series.
+		{
+			args: []string{"-measurement", "cpu", "-tag", "host=b"},
+			out:  "measurement cpu in db0: data from 1970-01-01T00:00:00Z to 1970-01-01T00:00:00Z\n",
+		},
+		{
+			args: []string{"-measurement", "cpu", "-tag", "host=c"},
+			out:  "measurement cpu: no data\n",
+		},
+		{
+			args: []string{"-schema", "-tag", "host=c"},
+			exp:  []string{"DB Measurement Key Kind Type"},
+			nexp: []string{"db0 cpu host tag", "db1 disk host tag"},
+		},
+		// Only one report can be written at a time.
+		{
+			args: []string{"-list-shards", "-schema"},
+			err:  "-list-shards and -schema can not be used together",
+		},
+		{
+			args: []string{"-measurement", "cpu", "-tag-cardinality", "cpu", "-series", "cpu,host=a"},
+			err:  "-measurement and -tag-cardinality and -series can not be used together",
+		},
+	} {
+		var buf bytes.Buffer
+		cmd := NewCommand(&buf)
+		if err := cmd.Run(append([]string{"-dir", dir}, tt.args...)...); tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%d. %v: unexpected error: %v", i, tt.args, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d. %v: unexpected error: %v", i, tt.args, err)
+			continue
+		}
+
+		got := strings.Replace(buf.String(), dir, "$DIR", -1)
+		if tt.out != "" && got != tt.out {
+			t.Errorf("%d. %v: unexpected output:\n\ngot=%q\n\nexp=%q", i, tt.args, got, tt.out)
+		}
+		for _, line := range tt.exp {
+			if !ContainsLine(got, line) {
+				t.Errorf("%d. %v: line not found: %q\n\n%s", i, tt.args, line, got)
+			}
+		}
+		for _, line := range tt.nexp {
+			if ContainsLine(got, line) {
+				t.Errorf("%d. %v: unexpected line: %q\n\n%s", i, tt.args, line, got)
+			}
+		}
+	}
+}
+
+// Ensure the store overview is written as a single JSON document.
+func TestCommand_Run_JSONSummary(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a,region=r1#!~#value": {tsm1.NewValue(0, 1.0)},
+		"cpu,host=b,region=r1#!~#idle":  {tsm1.NewValue(0, 2.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a,region=r1#!~#value": {tsm1.NewValue(10, 3.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db1", "rp0", "3", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"mem,host=a#!~#free": {tsm1.NewValue(0, int64(1))},
+	})
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-json-summary"); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Shards       int   `json:"shards"`
+		Databases    int   `json:"databases"`
+		Series       int   `json:"series"`
+		DiskBytes    int64 `json:"diskBytes"`
+		Measurements []struct {
+			Database    string `json:"database"`
+			Measurement string `json:"measurement"`
+			Series      int    `json:"series"`
+			Fields      int    `json:"fields"`
+			TagKeys     int    `json:"tagKeys"`
+		} `json:"measurements"`
+	}
+	dec := json.NewDecoder(&buf)
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	} else if dec.More() {
+		t.Fatalf("unexpected data after document")
+	}
+
+	if doc.Shards != 3 || doc.Databases != 2 || doc.Series != 3 || doc.DiskBytes <= 0 {
+		t.Fatalf("unexpected totals: %+v", doc)
+	} else if len(doc.Measurements) != 2 {
+		t.Fatalf("unexpected measurements: %+v", doc.Measurements)
+	}
+	if m := doc.Measurements[0]; m.Database != "db0" || m.Measurement != "cpu" || m.Series != 2 || m.Fields != 2 || m.TagKeys != 2 {
+		t.Errorf("unexpected measurement: %+v", m)
+	}
+	if m := doc.Measurements[1]; m.Database != "db1" || m.Measurement != "mem" || m.Series != 1 || m.Fields != 1 || m.TagKeys != 1 {
+		t.Errorf("unexpected measurement: %+v", m)
+	}
+}
+
+// Ensure series written with their tags in a different order are reported
+// as registered more than once.
+func TestCommand_Run_CheckDuplicateSeries(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a,region=west#!~#value": {tsm1.NewValue(0, 1.0)},
+		"cpu,host=b,region=west#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,region=west,host=a#!~#value": {tsm1.NewValue(10, 2.0)},
+	})
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-check-duplicate-series"); err == nil || err.Error() != "1 duplicate series found" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exp := []string{
+		"DB Series ID Registered Key Shards",
+		"db0 cpu,host=a,region=west * cpu,host=a,region=west 1",
+		"db0 cpu,host=a,region=west * cpu,region=west,host=a 2",
+	}
+	for _, line := range exp {
+		if !ContainsLine(buf.String(), line) {
+			t.Errorf("line not found: %q\n\n%s", line, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "\n"); n != len(exp) {
+		t.Errorf("unexpected line count: %d\n\n%s", n, buf.String())
+	}
+}
+
+// Ensure the compaction state of each shard is reported.
+func TestCommand_Run_CompactionStatus(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	values := map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	}
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), values)
+
+	// Shard 2 has two generations at different levels so that they are not
+	// compacted as soon as the shard is opened.
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000002.tsm"), values)
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000002-000000001.tsm"), values)
+
+	// Shard 3 has a temporary file left by an interrupted compaction.
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "3", "000000001-000000001.tsm"), values)
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "db0", "rp0", "3", "000000002-000000002.tsm.tmp"), []byte("partial"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shard 4 holds its points only in the WAL.
+	if err := os.MkdirAll(filepath.Join(dir, "data", "db0", "rp0", "4"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	testutil.MustWriteWAL(filepath.Join(dir, "wal", "db0", "rp0", "4", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+	})
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-compaction-status"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Shard DB RP Status Files Generations Tombstoned Planned Temp Files WAL Segments WAL Points",
+		"1 db0 rp0 optimal 1 1 0 0 0 0 0",
+		"2 db0 rp0 needs-compaction 2 2 0 * 0 0 0",
+		"3 db0 rp0 partial-temp-files 1 1 0 0 1 0 0",
+		"4 db0 rp0 * 0 0 0 0 0 1 2",
+	} {
+		if !ContainsLine(buf.String(), line) {
+			t.Errorf("line not found: %q\n\n%s", line, buf.String())
+		}
+	}
+
+	// Summaries read from the TSM files note the points left out.
+	buf.Reset()
+	if err := NewCommand(&buf).Run("-dir", dir, "-measurement-sizes"); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasSuffix(buf.String(), "\n2 points in 1 WAL segments are not included.\n") {
+		t.Errorf("WAL note not found:\n\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := NewCommand(&buf).Run("-dir", dir, "-measurement-sizes", "-shard", "1"); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(buf.String(), "WAL") {
+		t.Errorf("unexpected WAL note:\n\n%s", buf.String())
+	}
+}
+
+// Ensure measurements are listed largest first with their share of the
+// store.
+func TestCommand_Run_MeasurementSizes(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var values []tsm1.Value
+	for i := 0; i < 100; i++ {
+		values = append(values, tsm1.NewValue(int64(i), float64(i)*1.5))
+	}
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": values,
+		"cpu,host=b#!~#value": values,
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db1", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"mem,host=a#!~#free": {tsm1.NewValue(0, int64(1))},
+		"mem,host=a#!~#used": {tsm1.NewValue(0, int64(2))},
+	})
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-measurement-sizes"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output:\n\n%s", buf.String())
+	}
+	for i, line := range []string{
+		"DB Measurement Series Points Values Est. Bytes Bytes/Series Percent Cumulative",
+		"db0 cpu 2 200 200 * * * *",
+		"db1 mem 1 1 2 * * * 100.0%",
+	} {
+		if !ContainsLine(lines[i], line) {
+			t.Errorf("%d. unexpected line: %q\n\n%s", i, line, buf.String())
+		}
+	}
+
+	// The bytes per series of cpu is half of its bytes.
+	fields := strings.Fields(lines[1])
+	size, err := strconv.Atoi(fields[5])
+	if err != nil {
+		t.Fatal(err)
+	} else if size == 0 || fields[6] != strconv.Itoa(size/2) {
+		t.Errorf("unexpected sizes: %q", lines[1])
+	} else if fields[7] != fields[8] {
+		t.Errorf("unexpected percentages: %q", lines[1])
+	}
+}
+
+// Ensure field types are named as InfluxQL names them, and the fields of a
+// point are counted as values of a single point.
+func TestCommand_Run_FieldTypes(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#f": {tsm1.NewValue(0, 1.5)},
+		"cpu,host=a#!~#i": {tsm1.NewValue(0, int64(1))},
+		"cpu,host=a#!~#s": {tsm1.NewValue(0, "x")},
+		"cpu,host=a#!~#b": {tsm1.NewValue(0, true)},
+	})
+
+	for i, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		// The fields of a point are counted as one point and several values.
+		{
+			args: []string{"-points"},
+			exp: []string{
+				"Shard DB RP Path Format Size Series Points Values Ownership",
+				"1 db0 rp0 * tsm1 * 1 1 4 standalone",
+			},
+		},
+		{
+			args: []string{"-field-type-summary"},
+			exp: []string{
+				"Type Fields Est. Bytes Percent",
+				"float 1 * *",
+				"integer 1 * *",
+				"string 1 * *",
+				"boolean 1 * *",
+			},
+		},
+		{
+			args: []string{"-schema"},
+			exp: []string{
+				"DB Measurement Key Kind Type",
+				"db0 cpu host tag",
+				"db0 cpu b field boolean",
+				"db0 cpu f field float",
+				"db0 cpu i field integer",
+				"db0 cpu s field string",
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run(append([]string{"-dir", dir}, tt.args...)...); err != nil {
+			t.Errorf("%d. %v: unexpected error: %v", i, tt.args, err)
+			continue
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != len(tt.exp) {
+			t.Errorf("%d. %v: unexpected output:\n\n%s", i, tt.args, buf.String())
+			continue
+		}
+		for j := range tt.exp {
+			if !ContainsLine(lines[j], tt.exp[j]) {
+				t.Errorf("%d. %v: unexpected line %d: %q\n\n%s", i, tt.args, j, tt.exp[j], buf.String())
+			}
+		}
+	}
+}
+
+// Ensure -schema lists each type of a field written with different types in
+// different shards.
+func TestCommand_Run_Schema_MixedTypes(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,region=west#!~#value": {tsm1.NewValue(10, int64(2))},
+		"cpu,region=west#!~#up":    {tsm1.NewValue(10, true)},
+	})
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-schema"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	exp := []string{
+		"DB Measurement Key Kind Type",
+		"db0 cpu host tag",
+		"db0 cpu region tag",
+		"db0 cpu up field boolean",
+		"db0 cpu value field float,integer",
+	}
+	if len(lines) != len(exp) {
+		t.Fatalf("unexpected output:\n\n%s", buf.String())
+	}
+	for i, line := range exp {
+		if !ContainsLine(lines[i], line) {
+			t.Errorf("%d. unexpected line: %q\n\n%s", i, line, buf.String())
+		}
+	}
+}
+
+// Ensure series are listed by compression ratio, worst first.
+func TestCommand_Run_SeriesCompression(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// host=a holds a constant that compresses well and host=b random strings
+	// that hardly compress at all.
+	var constant, random []tsm1.Value
+	for i := 0; i < 1000; i++ {
+		constant = append(constant, tsm1.NewValue(int64(i)*10, 1.0))
+	}
+	rnd := rand.New(rand.NewSource(0))
+	for i := 0; i < 10; i++ {
+		b := make([]byte, 32)
+		for j := range b {
+			b[j] = byte('a' + rnd.Intn(26))
+		}
+		random = append(random, tsm1.NewValue(int64(i)*10, string(b)))
+	}
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": constant[:500],
+		"cpu,host=b#!~#msg":   random,
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": constant[500:],
+	})
+
+	for i, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{
+			args: []string{"-series-compression"},
+			exp: []string{
+				"DB Series Values Raw Bytes Disk Bytes Ratio",
+				"db0 cpu,host=b 10 400 * *",
+				"db0 cpu,host=a 1000 16000 * *",
+			},
+		},
+		{
+			args: []string{"-series-compression", "-top", "1"},
+			exp: []string{
+				"DB Series Values Raw Bytes Disk Bytes Ratio",
+				"db0 cpu,host=b 10 400 * *",
+			},
+		},
+		{
+			args: []string{"-series-compression", "-tag", "host=a"},
+			exp: []string{
+				"DB Series Values Raw Bytes Disk Bytes Ratio",
+				"db0 cpu,host=a 1000 16000 * *",
+			},
+		},
+		{
+			args: []string{"-series-compression", "-tag", "host=c"},
+			exp: []string{
+				"DB Series Values Raw Bytes Disk Bytes Ratio",
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run(append([]string{"-dir", dir}, tt.args...)...); err != nil {
+			t.Errorf("%d. %v: unexpected error: %v", i, tt.args, err)
+			continue
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != len(tt.exp) {
+			t.Errorf("%d. %v: unexpected output:\n\n%s", i, tt.args, buf.String())
+			continue
+		}
+		for j := range tt.exp {
+			if !ContainsLine(lines[j], tt.exp[j]) {
+				t.Errorf("%d. %v: unexpected line %d: %q\n\n%s", i, tt.args, j, tt.exp[j], buf.String())
+			}
+		}
+	}
+}
+
+// Ensure fields scanned concurrently are summarized the same as when they
+// are scanned one shard at a time.
+func TestCommand_Run_FieldTypeSummary_Workers(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for id := 1; id <= 8; id++ {
+		testutil.MustWriteTSM(filepath.Join(dir, "data", "db"+strconv.Itoa(id%2), "rp0", strconv.Itoa(id), "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {tsm1.NewValue(int64(id), 1.0)},
+			"cpu,host=a#!~#count": {tsm1.NewValue(int64(id), int64(id))},
+			"cpu,host=a#!~#os":    {tsm1.NewValue(int64(id), "linux")},
+			"mem,host=a#!~#up":    {tsm1.NewValue(int64(id), true)},
+		})
+	}
+
+	var exp string
+	for _, workers := range []string{"1", "3", "8", "16"} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run("-dir", dir, "-field-type-summary", "-workers", workers); err != nil {
+			t.Fatal(err)
+		}
+
+		if workers == "1" {
+			exp = buf.String()
+			for _, line := range []string{
+				"float 2 * *",
+				"integer 2 * *",
+				"string 2 * *",
+				"boolean 2 * *",
+			} {
+				if !ContainsLine(exp, line) {
+					t.Fatalf("line not found: %q\n\n%s", line, exp)
+				}
+			}
+		} else if got := buf.String(); got != exp {
+			t.Errorf("-workers %s: unexpected output:\n\ngot=%s\n\nexp=%s", workers, got, exp)
+		}
+	}
+}
+
+// Ensure shards scanned concurrently are written in the same order as when
+// they are scanned one at a time.
+func TestCommand_Run_Workers(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for id := 1; id <= 12; id++ {
+		testutil.MustWriteTSM(filepath.Join(dir, "data", "db"+strconv.Itoa(id%3), "rp0", strconv.Itoa(id), "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {tsm1.NewValue(int64(id), 1.0)},
+		})
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-list-shards"},
+		{"-field-type-summary"},
+	} {
+		var exp string
+		for _, workers := range [][]string{
+			{"-workers", "1"},
+			{"-workers", "4"},
+			{"-workers", "32"},
+			{"-concurrency", "4"},
+		} {
+			var buf bytes.Buffer
+			if err := NewCommand(&buf).Run(append(append([]string{"-dir", dir}, args...), workers...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			if exp == "" {
+				exp = buf.String()
+			} else if got := buf.String(); got != exp {
+				t.Errorf("%v %v: unexpected output:\n\ngot=%s\n\nexp=%s", args, workers, got, exp)
+			}
+		}
+	}
+
+	// The shard summary is ordered by ID, and -list-shards by database and
+	// then by ID.
+	for _, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{
+			args: []string{"-workers", "4"},
+			exp:  []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"},
+		},
+		{
+			args: []string{"-workers", "4", "-list-shards"},
+			exp:  []string{"3", "6", "9", "12", "1", "4", "7", "10", "2", "5", "8", "11"},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run(append([]string{"-dir", dir}, tt.args...)...); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+			ids = append(ids, strings.Fields(line)[0])
+		}
+		if strings.Join(ids, " ") != strings.Join(tt.exp, " ") {
+			t.Errorf("%v: unexpected order: %v", tt.args, ids)
+		}
+	}
+}
+
+// Ensure shards are marked as owned, replicated or remote from the cluster
+// metadata found next to the store, and shards it lacks are reported.
+func TestCommand_Run_Ownership(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", id, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+	}
+	MustWriteMeta(filepath.Join(dir, "meta"), 1, &meta.Data{
+		Databases: []meta.DatabaseInfo{{
+			Name: "db0",
+			RetentionPolicies: []meta.RetentionPolicyInfo{{
+				Name: "rp0",
+				ShardGroups: []meta.ShardGroupInfo{{
+					ID: 1,
+					Shards: []meta.ShardInfo{
+						{ID: 1, Owners: []meta.ShardOwner{{NodeID: 1}, {NodeID: 2}}},
+						{ID: 2, Owners: []meta.ShardOwner{{NodeID: 2}, {NodeID: 1}}},
+						{ID: 3, Owners: []meta.ShardOwner{{NodeID: 2}}},
+					},
+				}},
+			}},
+		}},
+	})
+
+	var buf, stderr bytes.Buffer
+	cmd := NewCommand(&buf)
+	cmd.Stderr = &stderr
+	if err := cmd.Run("-dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Replace(buf.String(), dir, "$DIR", -1)
+	for _, line := range []string{
+		"1 db0 rp0 $DIR/data/db0/rp0/1 tsm1 * 1 1 owner",
+		"2 db0 rp0 $DIR/data/db0/rp0/2 tsm1 * 1 1 replica",
+		"3 db0 rp0 $DIR/data/db0/rp0/3 tsm1 * 1 1 remote",
+		"4 db0 rp0 $DIR/data/db0/rp0/4 tsm1 * 1 1 error",
+	} {
+		if !ContainsLine(got, line) {
+			t.Errorf("line not found: %q\n\n%s", line, got)
+		}
+	}
+
+	// Shard 4 is not in the metadata, which is reported rather than hidden.
+	if exp := "shard 4: ownership: shard not found\n"; stderr.String() != exp {
+		t.Errorf("unexpected stderr: %q", stderr.String())
+	}
+}
+
+// Ensure shards that are orphaned, missing or misplaced are reported against
+// the metadata found next to the store.
+func TestCommand_Run_CheckMeta(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{"db0/rp0/1", "db0/rp0/2", "db1/rp0/4"} {
+		testutil.MustWriteTSM(filepath.Join(dir, "data", path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+	}
+	MustWriteMeta(filepath.Join(dir, "meta"), 1, &meta.Data{
+		Databases: []meta.DatabaseInfo{{
+			Name: "db0",
+			RetentionPolicies: []meta.RetentionPolicyInfo{{
+				Name: "rp0",
+				ShardGroups: []meta.ShardGroupInfo{{
+					ID: 1,
+					Shards: []meta.ShardInfo{
+						{ID: 1, Owners: []meta.ShardOwner{{NodeID: 1}}},
+						{ID: 3, Owners: []meta.ShardOwner{{NodeID: 1}}},
+						{ID: 4, Owners: []meta.ShardOwner{{NodeID: 1}}},
+						{ID: 5, Owners: []meta.ShardOwner{{NodeID: 2}}},
+					},
+				}},
+			}},
+		}},
+	})
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-check-meta"); err == nil || err.Error() != "3 inconsistencies found" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := strings.Replace(buf.String(), dir, "$DIR", -1)
+	for _, line := range []string{
+		"Shard DB RP Path Problem Suggested Fix",
+		"2 db0 rp0 $DIR/data/db0/rp0/2 orphaned archive and remove $DIR/data/db0/rp0/2, or restore the metadata for shard 2",
+		"3 db0 rp0 $DIR/data/db0/rp0/3 missing restore shard 3 from a backup, or run DROP SHARD 3",
+		"4 db0 rp0 $DIR/data/db1/rp0/4 misplaced move $DIR/data/db1/rp0/4 to $DIR/data/db0/rp0/4",
+	} {
+		if !ContainsLine(got, line) {
+			t.Errorf("line not found: %q\n\n%s", line, got)
+		}
+	}
+	if n := strings.Count(got, "\n"); n != 4 {
+		t.Errorf("unexpected line count: %d\n\n%s", n, got)
+	}
+}
+
+// NewCommand returns a command writing its reports to w.
+func NewCommand(w *bytes.Buffer) *summary.Command {
+	cmd := summary.NewCommand()
+	cmd.Stdout = w
+	cmd.Stderr = ioutil.Discard
+	return cmd
+}
+
+// ContainsLine returns true if s has a line with the whitespace separated
+// fields of line, where a field of "*" matches any field.
+func ContainsLine(s, line string) bool {
+	exp := strings.Fields(line)
+	for _, l := range strings.Split(s, "\n") {
+		got := strings.Fields(l)
+		if len(got) != len(exp) {
+			continue
+		}
+		match := true
+		for i := range exp {
+			if exp[i] != "*" && exp[i] != got[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// MustWriteMeta writes data as the metadata in the meta directory dir, with
+// nodeID as the ID of the node. Panic on error.
+func MustWriteMeta(dir string, nodeID uint64, data *meta.Data) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		panic(err)
+	}
+
+	buf, err := data.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "meta.db"), buf, 0666); err != nil {
+		panic(err)
+	}
+
+	node := influxdb.NewNode(dir)
+	node.ID = nodeID
+	if err := node.Save(); err != nil {
+		panic(err)
+	}
+}
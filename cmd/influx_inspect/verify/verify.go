@@ -7,9 +7,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"text/tabwriter"
 	"time"
 
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/tsdb"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 )
 
@@ -17,6 +22,10 @@ import (
 type Command struct {
 	Stderr io.Writer
 	Stdout io.Writer
+
+	onlyCorrupt bool
+	check       bool
+	workers     int
 }
 
 // NewCommand returns a new instance of Command.
@@ -32,6 +41,9 @@ func (cmd *Command) Run(args ...string) error {
 	var path string
 	fs := flag.NewFlagSet("verify", flag.ExitOnError)
 	fs.StringVar(&path, "dir", os.Getenv("HOME")+"/.influxdb", "Root storage path. [$HOME/.influxdb]")
+	fs.BoolVar(&cmd.onlyCorrupt, "only-corrupt", false, "Only report corrupt blocks and a final tally")
+	fs.BoolVar(&cmd.check, "check", false, "Open and decode every block of every shard, reporting a PASS or FAIL row for each shard")
+	fs.IntVar(&cmd.workers, "workers", runtime.GOMAXPROCS(0), "Number of shards to verify concurrently")
 
 	fs.SetOutput(cmd.Stdout)
 	fs.Usage = cmd.printUsage
@@ -40,70 +52,268 @@ func (cmd *Command) Run(args ...string) error {
 		return err
 	}
 
+	if cmd.workers < 1 {
+		return fmt.Errorf("-workers must be at least 1")
+	}
+
 	start := time.Now()
 	dataPath := filepath.Join(path, "data")
 
-	brokenBlocks := 0
-	totalBlocks := 0
-
 	// No need to do this in a loop
 	ext := fmt.Sprintf(".%s", tsm1.TSMFileExtension)
 
-	// Get all TSM files by walking through the data dir
-	files := []string{}
+	// Get all TSM files by walking through the data dir, grouping them by
+	// the shard directory holding them.
+	files := make(map[string][]string)
 	err := filepath.Walk(dataPath, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if filepath.Ext(path) == ext {
-			files = append(files, path)
+			files[filepath.Dir(path)] = append(files[filepath.Dir(path)], path)
 		}
 		return nil
 	})
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	shards := make(shardDirs, 0, len(files))
+	for dir := range files {
+		shards = append(shards, dir)
+	}
+	sort.Sort(shards)
+
+	verify := cmd.verifyShard
+	if cmd.check {
+		verify = cmd.checkShard
 	}
 
+	// Shards are verified concurrently but reported in shard ID order. Only
+	// the lines to be reported are kept for each shard so healthy shards
+	// cost little while they wait their turn.
+	done := make(chan struct{})
+	defer close(done)
+	jobs := make(chan int)
+	results := make(chan *shardResult)
+	for i := 0; i < cmd.workers; i++ {
+		go func() {
+			for j := range jobs {
+				select {
+				case results <- verify(j, shards[j], files[shards[j]]):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range shards {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
 	tw := tabwriter.NewWriter(cmd.Stdout, 16, 8, 0, '\t', 0)
+	if cmd.check {
+		fmt.Fprintln(tw, "Shard\tPath\tStatus\tError")
+	}
+
+	brokenBlocks := 0
+	totalBlocks := 0
+	brokenShards := 0
+	failedShards := 0
+
+	pending := make(map[int]*shardResult)
+	for next := 0; next < len(shards); {
+		r := <-results
+		pending[r.index] = r
+
+		for r := pending[next]; r != nil; r = pending[next] {
+			delete(pending, next)
+			next++
+
+			for _, line := range r.lines {
+				fmt.Fprintln(tw, line)
+			}
+			if r.err != nil {
+				tw.Flush()
+				return r.err
+			}
+
+			totalBlocks += r.blocks
+			brokenBlocks += r.brokenBlocks
+			if r.brokenBlocks > 0 {
+				brokenShards++
+			}
+			if r.failed {
+				failedShards++
+			}
+		}
+	}
+
+	if cmd.check {
+		fmt.Fprintf(tw, "Failed Shards: %d / %d, in %vs\n", failedShards, len(shards), time.Since(start).Seconds())
+		tw.Flush()
+		if failedShards > 0 {
+			return fmt.Errorf("%d of %d shards failed", failedShards, len(shards))
+		}
+		return nil
+	}
+
+	if !cmd.onlyCorrupt {
+		fmt.Fprintf(tw, "Broken Blocks: %d / %d, in %vs\n", brokenBlocks, totalBlocks, time.Since(start).Seconds())
+		tw.Flush()
+		return nil
+	}
+
+	if brokenBlocks == 0 {
+		fmt.Fprintf(tw, "all %d shards healthy\n", len(shards))
+		tw.Flush()
+		return nil
+	}
+
+	fmt.Fprintf(tw, "Broken Blocks: %d / %d, Broken Shards: %d / %d, in %vs\n", brokenBlocks, totalBlocks, brokenShards, len(shards), time.Since(start).Seconds())
+	tw.Flush()
+	return fmt.Errorf("%d broken blocks found", brokenBlocks)
+}
+
+// shardResult holds the outcome of verifying the TSM files of one shard.
+type shardResult struct {
+	index        int
+	lines        []string
+	blocks       int
+	brokenBlocks int
+	failed       bool
+	err          error
+}
+
+// verifyShard verifies the checksums of every block in the TSM files of a
+// shard, stopping at the first file that cannot be read.
+func (cmd *Command) verifyShard(index int, dir string, files []string) *shardResult {
+	r := &shardResult{index: index}
+	sort.Strings(files)
 
-	// Verify the checksums of every block in every file
 	for _, f := range files {
 		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
 		if err != nil {
-			return err
+			r.err = err
+			return r
 		}
 
 		reader, err := tsm1.NewTSMReader(file)
 		if err != nil {
-			return err
+			file.Close()
+			r.err = err
+			return r
 		}
 
 		blockItr := reader.BlockIterator()
 		brokenFileBlocks := 0
 		count := 0
 		for blockItr.Next() {
-			totalBlocks++
+			r.blocks++
 			key, _, _, checksum, buf, err := blockItr.Read()
 			if err != nil {
-				brokenBlocks++
-				fmt.Fprintf(tw, "%s: could not get checksum for key %v block %d due to error: %q\n", f, key, count, err)
+				brokenFileBlocks++
+				r.lines = append(r.lines, fmt.Sprintf("%s: could not get checksum for key %v block %d due to error: %q", f, key, count, err))
 			} else if expected := crc32.ChecksumIEEE(buf); checksum != expected {
-				brokenBlocks++
-				fmt.Fprintf(tw, "%s: got %d but expected %d for key %v, block %d\n", f, checksum, expected, key, count)
+				brokenFileBlocks++
+				r.lines = append(r.lines, fmt.Sprintf("%s: got %d but expected %d for key %v, block %d", f, checksum, expected, key, count))
 			}
 			count++
 		}
-		if brokenFileBlocks == 0 {
-			fmt.Fprintf(tw, "%s: healthy\n", f)
+		if brokenFileBlocks == 0 && !cmd.onlyCorrupt {
+			r.lines = append(r.lines, fmt.Sprintf("%s: healthy", f))
 		}
+		r.brokenBlocks += brokenFileBlocks
 		reader.Close()
 	}
+	return r
+}
 
-	fmt.Fprintf(tw, "Broken Blocks: %d / %d, in %vs\n", brokenBlocks, totalBlocks, time.Since(start).Seconds())
-	tw.Flush()
+// checkShard opens every TSM file of the shard in dir and decodes each of
+// its blocks, stopping at the first failure. Unlike verifyShard, a file that
+// cannot be opened fails the shard rather than the whole run. The result
+// holds a single row reporting whether the shard passed, and why not.
+func (cmd *Command) checkShard(index int, dir string, files []string) *shardResult {
+	r := &shardResult{index: index}
+	sort.Strings(files)
+
+	if err := checkFiles(files, &r.blocks); err != nil {
+		r.failed = true
+		r.lines = append(r.lines, fmt.Sprintf("%s\t%s\tFAIL\t%v", filepath.Base(dir), dir, err))
+	} else if !cmd.onlyCorrupt {
+		r.lines = append(r.lines, fmt.Sprintf("%s\t%s\tPASS\t-", filepath.Base(dir), dir))
+	}
+	return r
+}
+
+// checkFiles decodes every block of the TSM files, counting them in blocks,
+// and returns an error describing the first one that cannot be read. The
+// files must be those of a single shard. A field stored with different types
+// in the shard also fails the check, as the shard can then not record the
+// fields of its measurement, and the measurement can not be read.
+func checkFiles(files []string, blocks *int) error {
+	// The type of each field, keyed by measurement and field.
+	types := make(map[[2]string]byte)
+	for _, f := range files {
+		file, err := os.OpenFile(f, os.O_RDONLY, 0600)
+		if err != nil {
+			return err
+		}
+
+		reader, err := tsm1.NewTSMReader(file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: read index: %v", f, err)
+		}
+
+		var values []tsm1.Value
+		blockItr := reader.BlockIterator()
+		for n := 0; blockItr.Next(); n++ {
+			*blocks++
+			key, _, _, checksum, buf, err := blockItr.Read()
+			if err != nil {
+				reader.Close()
+				return fmt.Errorf("%s: read block %d of key %s: %v", f, n, key, err)
+			} else if expected := crc32.ChecksumIEEE(buf); checksum != expected {
+				reader.Close()
+				return fmt.Errorf("%s: checksum of block %d of key %s is %d, expected %d", f, n, key, checksum, expected)
+			}
+			if values, err = tsm1.DecodeBlock(buf, values[:0]); err != nil {
+				reader.Close()
+				return fmt.Errorf("%s: decode block %d of key %s: %v", f, n, key, err)
+			}
+
+			series, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
+			measurement := tsdb.MeasurementFromSeriesKey(string(series))
+			k := [2]string{measurement, string(field)}
+			typ, _ := tsm1.BlockType(buf)
+			if prev, ok := types[k]; !ok {
+				types[k] = typ
+			} else if prev != typ {
+				reader.Close()
+				return fmt.Errorf("%s: field %s of measurement %s is stored as both %s and %s, so the measurement's fields can not be recorded", f, field, measurement, blockTypeName(prev), blockTypeName(typ))
+			}
+		}
+		reader.Close()
+	}
 	return nil
 }
 
+// blockTypeName returns the name of the field type stored by blocks of typ.
+func blockTypeName(typ byte) string {
+	if t := tsm1.BlockTypeToInfluxQLDataType(typ); t != influxql.Unknown {
+		return t.String()
+	}
+	return fmt.Sprintf("unknown(%d)", typ)
+}
+
 // printUsage prints the usage message to STDERR.
 func (cmd *Command) printUsage() {
 	usage := fmt.Sprintf(`Verifies the the checksum of shards.
@@ -113,7 +323,39 @@ Usage: influx_inspect verify [flags]
     -dir <path>
             Root storage path
             Defaults to "%[1]s/.influxdb".
+    -only-corrupt
+            Only report corrupt blocks followed by a tally, exiting
+            with an error if any are found.
+    -check
+            Open every TSM file and decode every block, instead of
+            only comparing checksums. A row is printed for each shard
+            with PASS, or FAIL and the first error found, such as an
+            unreadable index, a block that cannot be decoded, or a
+            field stored with more than one type, which leaves its
+            measurement without recorded fields. Exits
+            with an error if any shard fails. With -only-corrupt,
+            only failed shards are listed.
+    -workers <n>
+            Number of shards to verify concurrently.
+            Defaults to the number of available CPUs.
  `, os.Getenv("HOME"))
 
 	fmt.Fprintf(cmd.Stdout, usage)
 }
+
+// shardDirs sorts shard directories by shard ID, falling back to the path for
+// directories that are not named by ID.
+type shardDirs []string
+
+func (a shardDirs) Len() int      { return len(a) }
+func (a shardDirs) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a shardDirs) Less(i, j int) bool {
+	x, errx := strconv.ParseUint(filepath.Base(a[i]), 10, 64)
+	y, erry := strconv.ParseUint(filepath.Base(a[j]), 10, 64)
+	if errx == nil && erry == nil && x != y {
+		return x < y
+	} else if (errx == nil) != (erry == nil) {
+		return errx == nil
+	}
+	return a[i] < a[j]
+}
@@ -1,3 +1,220 @@
 package verify_test
 
-// TODO: write some tests
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influx_inspect/internal/testutil"
+	"github.com/influxdata/influxdb/cmd/influx_inspect/verify"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure only corrupt blocks and a tally are reported with -only-corrupt.
+func TestCommand_Run_OnlyCorrupt(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+	})
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(10, 2.0)},
+	})
+
+	// A healthy store is summarized in a single line.
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-only-corrupt"); err != nil {
+		t.Fatal(err)
+	} else if got := buf.String(); got != "all 2 shards healthy\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+
+	// Corrupt the block of the second shard.
+	path := filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm")
+	MustCorruptBlock(path)
+
+	buf.Reset()
+	if err := NewCommand(&buf).Run("-dir", dir, "-only-corrupt"); err == nil || err.Error() != "1 broken blocks found" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output: %s", buf.String())
+	} else if !strings.HasPrefix(lines[0], path+": got ") || !strings.HasSuffix(lines[0], "for key cpu,host=a#!~#value, block 0") {
+		t.Fatalf("unexpected corrupt block line: %q", lines[0])
+	} else if !strings.HasPrefix(lines[1], "Broken Blocks: 1 / 2, Broken Shards: 1 / 2, in ") {
+		t.Fatalf("unexpected tally: %q", lines[1])
+	}
+}
+
+// Ensure a data directory that can not be read is returned as an error.
+func TestCommand_Run_MissingDir(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, args := range [][]string{{}, {"-check"}} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run(append([]string{"-dir", dir}, args...)...); err == nil || !os.IsNotExist(err) {
+			t.Fatalf("%v: unexpected error: %v", args, err)
+		}
+	}
+}
+
+// Ensure shards verified concurrently are reported in shard ID order.
+func TestCommand_Run_Workers(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var exp []string
+	for id := 1; id <= 12; id++ {
+		path := filepath.Join(dir, "data", "db"+strconv.Itoa(id%3), "rp0", strconv.Itoa(id), "000000001-000000001.tsm")
+		testutil.MustWriteTSM(path, map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+		exp = append(exp, path+": healthy")
+	}
+	exp = append(exp, "Broken Blocks: 0 / 12, in")
+
+	for _, workers := range []string{"1", "4", "12", "32"} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run("-dir", dir, "-workers", workers); err != nil {
+			t.Fatal(err)
+		}
+
+		// The elapsed time is dropped from the tally.
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if n := len(lines); n > 0 {
+			lines[n-1] = lines[n-1][:strings.LastIndex(lines[n-1], " ")]
+		}
+		if got := strings.Join(lines, "\n"); got != strings.Join(exp, "\n") {
+			t.Errorf("-workers %s: unexpected output:\n\ngot=%s\n\nexp=%s", workers, got, strings.Join(exp, "\n"))
+		}
+	}
+
+	if err := NewCommand(&bytes.Buffer{}).Run("-dir", dir, "-workers", "0"); err == nil || err.Error() != "-workers must be at least 1" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure -check reports a PASS or FAIL row for each shard.
+func TestCommand_Run_Check(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"1", "2", "3"} {
+		testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", id, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		})
+	}
+	path := filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm")
+	MustCorruptBlock(path)
+
+	for i, tt := range []struct {
+		args []string
+		exp  []string
+	}{
+		{
+			args: []string{"-check"},
+			exp: []string{
+				"Shard Path Status Error",
+				"1 " + filepath.Join(dir, "data", "db0", "rp0", "1") + " PASS -",
+				"2 " + filepath.Join(dir, "data", "db0", "rp0", "2") + " FAIL " + path + ": checksum of block 0 of key cpu,host=a#!~#value is",
+				"3 " + filepath.Join(dir, "data", "db0", "rp0", "3") + " PASS -",
+				"Failed Shards: 1 / 3, in",
+			},
+		},
+		// Only failed shards are listed with -only-corrupt.
+		{
+			args: []string{"-check", "-only-corrupt"},
+			exp: []string{
+				"Shard Path Status Error",
+				"2 " + filepath.Join(dir, "data", "db0", "rp0", "2") + " FAIL " + path + ": checksum of block 0 of key cpu,host=a#!~#value is",
+				"Failed Shards: 1 / 3, in",
+			},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := NewCommand(&buf).Run(append([]string{"-dir", dir}, tt.args...)...); err == nil || err.Error() != "1 of 3 shards failed" {
+			t.Errorf("%d. %v: unexpected error: %v", i, tt.args, err)
+			continue
+		}
+
+		// Each line must start with the expected fields.
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != len(tt.exp) {
+			t.Errorf("%d. %v: unexpected output:\n\n%s", i, tt.args, buf.String())
+			continue
+		}
+		for j, line := range lines {
+			if got := strings.Join(strings.Fields(line), " "); !strings.HasPrefix(got, tt.exp[j]) {
+				t.Errorf("%d. %v: unexpected line %d:\n\ngot=%s\n\nexp=%s", i, tt.args, j, got, tt.exp[j])
+			}
+		}
+	}
+}
+
+// Ensure -check fails a shard storing a field with more than one type.
+func TestCommand_Run_Check_MixedTypes(t *testing.T) {
+	dir := testutil.MustTempDir()
+	defer os.RemoveAll(dir)
+
+	testutil.MustWriteTSM(filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value": {tsm1.NewValue(0, 1.0)},
+		"cpu,host=b#!~#value": {tsm1.NewValue(0, int64(2))},
+	})
+	path := filepath.Join(dir, "data", "db0", "rp0", "1", "000000001-000000001.tsm")
+
+	var buf bytes.Buffer
+	if err := NewCommand(&buf).Run("-dir", dir, "-check"); err == nil || err.Error() != "1 of 1 shards failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := []string{
+		"Shard Path Status Error",
+		"1 " + filepath.Join(dir, "data", "db0", "rp0", "1") + " FAIL " + path + ": field value of measurement cpu is stored as both float and integer, so the measurement's fields can not be recorded",
+		"Failed Shards: 1 / 1, in",
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(exp) {
+		t.Fatalf("unexpected output:\n\n%s", buf.String())
+	}
+	for i, line := range lines {
+		if got := strings.Join(strings.Fields(line), " "); !strings.HasPrefix(got, exp[i]) {
+			t.Errorf("unexpected line %d:\n\ngot=%s\n\nexp=%s", i, got, exp[i])
+		}
+	}
+}
+
+// NewCommand returns a command writing its reports to w.
+func NewCommand(w *bytes.Buffer) *verify.Command {
+	cmd := verify.NewCommand()
+	cmd.Stdout = w
+	cmd.Stderr = ioutil.Discard
+	return cmd
+}
+
+// MustCorruptBlock flips a byte in the data of the first block of the TSM
+// file at path so that it no longer matches its checksum. Panic on error.
+func MustCorruptBlock(path string) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	// The first block follows the 5 byte file header and its own 4 byte
+	// checksum.
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, 10); err != nil {
+		panic(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, 10); err != nil {
+		panic(err)
+	}
+}
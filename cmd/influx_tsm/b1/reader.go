@@ -38,16 +38,24 @@ type Reader struct {
 	fields map[string]*tsdb.MeasurementFields
 	codecs map[string]*tsdb.FieldCodec
 
+	// Only points within [minTime, maxTime] are emitted.
+	minTime, maxTime int64
+
+	// If set, only series for which filter returns true are read.
+	filter func(series string) bool
+
 	stats *stats.Stats
 }
 
 // NewReader returns a reader for the b1 shard at path.
 func NewReader(path string, stats *stats.Stats, chunkSize int) *Reader {
 	r := &Reader{
-		path:   path,
-		fields: make(map[string]*tsdb.MeasurementFields),
-		codecs: make(map[string]*tsdb.FieldCodec),
-		stats:  stats,
+		path:    path,
+		fields:  make(map[string]*tsdb.MeasurementFields),
+		codecs:  make(map[string]*tsdb.FieldCodec),
+		minTime: math.MinInt64,
+		maxTime: math.MaxInt64,
+		stats:   stats,
 	}
 
 	if chunkSize <= 0 {
@@ -59,6 +67,19 @@ func NewReader(path string, stats *stats.Stats, chunkSize int) *Reader {
 	return r
 }
 
+// SetTimeRange restricts the reader to points with timestamps between min
+// and max, inclusive. It must be called before Open.
+func (r *Reader) SetTimeRange(min, max int64) {
+	r.minTime, r.maxTime = min, max
+}
+
+// SetSeriesFilter restricts the reader to the series for which fn returns
+// true. fn is called once for each series in the shard. It must be called
+// before Open.
+func (r *Reader) SetSeriesFilter(fn func(series string) bool) {
+	r.filter = fn
+}
+
 // Open opens the reader.
 func (r *Reader) Open() error {
 	// Open underlying storage.
@@ -109,6 +130,9 @@ func (r *Reader) Open() error {
 
 	// Create cursor for each field of each series.
 	for s := range seriesSet {
+		if r.filter != nil && !r.filter(s) {
+			continue
+		}
 		measurement := tsdb.MeasurementFromSeriesKey(s)
 		fields := r.fields[measurement]
 		if fields == nil {
@@ -154,6 +178,12 @@ OUTER:
 				return true
 			}
 
+			if k < r.minTime || k > r.maxTime {
+				r.stats.AddPointsRead(1)
+				r.stats.IncrRangeFiltered()
+				continue
+			}
+
 			if f, ok := v.(float64); ok {
 				if math.IsInf(f, 0) {
 					r.stats.AddPointsRead(1)
@@ -185,6 +215,33 @@ func (r *Reader) Read() (string, []tsm1.Value, error) {
 	return r.keyBuf, r.values[:r.valuePos], nil
 }
 
+// MaxTime returns the latest timestamp of any point in the b1 shard at path,
+// read from the last key of each series, so no points are decoded. ok is
+// false if the shard holds no points.
+func MaxTime(path string) (max int64, ok bool, err error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, false, err
+	}
+	defer db.Close()
+
+	max = math.MinInt64
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if excludedBuckets[string(name)] {
+				return nil
+			}
+			if k, _ := b.Cursor().Last(); len(k) == 8 {
+				if t := int64(binary.BigEndian.Uint64(k)); t > max {
+					max, ok = t, true
+				}
+			}
+			return nil
+		})
+	})
+	return max, ok, err
+}
+
 // Close closes the reader.
 func (r *Reader) Close() error {
 	r.tx.Rollback()
@@ -19,6 +19,13 @@ import (
 // DefaultChunkSize is the size of chunks read from the bz1 shard
 const DefaultChunkSize = 1000
 
+// Warnf logs a problem that does not stop a shard being read, such as a
+// block that cannot be decoded. It may be replaced to route the messages to
+// another log.
+var Warnf = func(format string, v ...interface{}) {
+	fmt.Printf(format+"\n", v...)
+}
+
 // Reader is used to read all data from a bz1 shard.
 type Reader struct {
 	path string
@@ -35,16 +42,24 @@ type Reader struct {
 	fields map[string]*tsdb.MeasurementFields
 	codecs map[string]*tsdb.FieldCodec
 
+	// Only points within [minTime, maxTime] are emitted.
+	minTime, maxTime int64
+
+	// If set, only series for which filter returns true are read.
+	filter func(series string) bool
+
 	stats *stats.Stats
 }
 
 // NewReader returns a reader for the bz1 shard at path.
 func NewReader(path string, stats *stats.Stats, chunkSize int) *Reader {
 	r := &Reader{
-		path:   path,
-		fields: make(map[string]*tsdb.MeasurementFields),
-		codecs: make(map[string]*tsdb.FieldCodec),
-		stats:  stats,
+		path:    path,
+		fields:  make(map[string]*tsdb.MeasurementFields),
+		codecs:  make(map[string]*tsdb.FieldCodec),
+		minTime: math.MinInt64,
+		maxTime: math.MaxInt64,
+		stats:   stats,
 	}
 
 	if chunkSize <= 0 {
@@ -56,6 +71,19 @@ func NewReader(path string, stats *stats.Stats, chunkSize int) *Reader {
 	return r
 }
 
+// SetTimeRange restricts the reader to points with timestamps between min
+// and max, inclusive. It must be called before Open.
+func (r *Reader) SetTimeRange(min, max int64) {
+	r.minTime, r.maxTime = min, max
+}
+
+// SetSeriesFilter restricts the reader to the series for which fn returns
+// true. fn is called once for each series in the shard. It must be called
+// before Open.
+func (r *Reader) SetSeriesFilter(fn func(series string) bool) {
+	r.filter = fn
+}
+
 // Open opens the reader.
 func (r *Reader) Open() error {
 	// Open underlying storage.
@@ -118,6 +146,9 @@ func (r *Reader) Open() error {
 
 	// Create cursor for each field of each series.
 	for s := range seriesSet {
+		if r.filter != nil && !r.filter(s) {
+			continue
+		}
 		measurement := tsdb.MeasurementFromSeriesKey(s)
 		fields := r.fields[measurement]
 		if fields == nil {
@@ -129,6 +160,7 @@ func (r *Reader) Open() error {
 			if c == nil {
 				continue
 			}
+			c.minTime, c.maxTime, c.stats = r.minTime, r.maxTime, r.stats
 			c.SeekTo(0)
 			r.cursors = append(r.cursors, c)
 		}
@@ -165,6 +197,12 @@ OUTER:
 				return true
 			}
 
+			if k < r.minTime || k > r.maxTime {
+				r.stats.AddPointsRead(1)
+				r.stats.IncrRangeFiltered()
+				continue
+			}
+
 			if f, ok := v.(float64); ok {
 				if math.IsInf(f, 0) {
 					r.stats.AddPointsRead(1)
@@ -196,6 +234,40 @@ func (r *Reader) Read() (string, []tsm1.Value, error) {
 	return r.keyBuf, r.values[:r.valuePos], nil
 }
 
+// MaxTime returns the latest timestamp of any point in the bz1 shard at path,
+// read from the header of the last block of each series, so no blocks are
+// decoded. ok is false if the shard holds no points.
+func MaxTime(path string) (max int64, ok bool, err error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, false, err
+	}
+	defer db.Close()
+
+	max = math.MinInt64
+	err = db.View(func(tx *bolt.Tx) error {
+		points := tx.Bucket([]byte("points"))
+		if points == nil {
+			return nil
+		}
+		return points.ForEach(func(key, _ []byte) error {
+			b := points.Bucket(key)
+			if b == nil {
+				return nil
+			}
+
+			// The first 8 bytes of a block hold its max timestamp.
+			if _, v := b.Cursor().Last(); len(v) >= 8 {
+				if t := int64(binary.BigEndian.Uint64(v[0:8])); t > max {
+					max, ok = t, true
+				}
+			}
+			return nil
+		})
+	})
+	return max, ok, err
+}
+
 // Close closes the reader.
 func (r *Reader) Close() error {
 	r.tx.Rollback()
@@ -216,6 +288,10 @@ type cursor struct {
 
 	keyBuf int64
 	valBuf interface{}
+
+	// Blocks entirely outside [minTime, maxTime] are skipped undecoded.
+	minTime, maxTime int64
+	stats            *stats.Stats
 }
 
 // newCursor returns an instance of a bz1 cursor.
@@ -232,6 +308,9 @@ func newCursor(tx *bolt.Tx, series string, field string, dec *tsdb.FieldCodec) *
 		field:  field,
 		dec:    dec,
 		keyBuf: -2,
+
+		minTime: math.MinInt64,
+		maxTime: math.MaxInt64,
 	}
 }
 
@@ -301,8 +380,7 @@ func (c *cursor) Next() (int64, interface{}) {
 
 			// If no items left then read first item from next block.
 			if c.off >= len(c.buf) {
-				_, v := c.cursor.Next()
-				c.setBuf(v)
+				c.setBuf(c.nextBlock())
 			}
 
 			return c.read()
@@ -317,6 +395,28 @@ func (c *cursor) Next() (int64, interface{}) {
 	}
 }
 
+// nextBlock advances to the next block which may hold points within the
+// cursor's time range, using the block's key and header to skip any block
+// that lies entirely outside it. Returns nil if no blocks remain.
+func (c *cursor) nextBlock() []byte {
+	for k, v := c.cursor.Next(); k != nil; k, v = c.cursor.Next() {
+		if len(v) < 8 {
+			return v
+		}
+
+		// The key holds the block's min timestamp and the first 8 bytes of
+		// the value its max timestamp.
+		min, max := int64(binary.BigEndian.Uint64(k)), int64(binary.BigEndian.Uint64(v[0:8]))
+		if max >= c.minTime && min <= c.maxTime {
+			return v
+		}
+		if c.stats != nil {
+			c.stats.IncrBlocksSkipped()
+		}
+	}
+	return nil
+}
+
 // setBuf saves a compressed block to the buffer.
 func (c *cursor) setBuf(block []byte) {
 	// Clear if the block is empty.
@@ -330,7 +430,7 @@ func (c *cursor) setBuf(block []byte) {
 	buf, err := snappy.Decode(nil, block[8:])
 	if err != nil {
 		c.buf = c.buf[0:0]
-		fmt.Printf("block decode error: %s\n", err)
+		Warnf("block decode error: %s", err)
 	}
 
 	c.buf, c.off = buf, 0
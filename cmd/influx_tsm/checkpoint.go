@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	tsmreader "github.com/influxdata/influxdb/cmd/influx_tsm/tsm1"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// checkpointName is the name of the checkpoint file written to the converted
+// output of a shard once it is complete.
+const checkpointName = "influx_tsm.checkpoint"
+
+// checkpoint records the source shard a conversion was made from and the
+// TSM files it produced, so a retried conversion can tell complete output
+// from the partial output of a conversion that was interrupted.
+type checkpoint struct {
+	Format  string
+	Size    int64
+	MinTime int64
+	MaxTime int64
+	Files   []checkpointFile
+}
+
+// checkpointFile is the size and checksum of a converted TSM file.
+type checkpointFile struct {
+	Name     string
+	Size     int64
+	Checksum uint32
+}
+
+// newCheckpoint returns a checkpoint for the conversion of si, over the time
+// range min to max, whose output is the TSM files in dir.
+func newCheckpoint(si *tsdb.ShardInfo, min, max int64, dir string) (*checkpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*."+tsm1.TSMFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	c := &checkpoint{Format: si.FormatAsString(), Size: si.Size, MinTime: min, MaxTime: max}
+	for _, path := range paths {
+		f, err := checksumFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c.Files = append(c.Files, f)
+	}
+	return c, nil
+}
+
+// checksumFile returns the size and checksum of the file at path.
+func checksumFile(path string) (checkpointFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return checkpointFile{}, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return checkpointFile{}, err
+	}
+	return checkpointFile{Name: filepath.Base(path), Size: n, Checksum: h.Sum32()}, nil
+}
+
+// WriteFile writes the checkpoint to the checkpoint file in dir.
+func (c *checkpoint) WriteFile(dir string) error {
+	f, err := os.Create(filepath.Join(dir, checkpointName))
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "source %s %d %d %d\n", c.Format, c.Size, c.MinTime, c.MaxTime)
+	for _, cf := range c.Files {
+		fmt.Fprintf(w, "file %s %d %08x\n", cf.Name, cf.Size, cf.Checksum)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readCheckpoint reads the checkpoint file in dir.
+func readCheckpoint(dir string) (*checkpoint, error) {
+	f, err := os.Open(filepath.Join(dir, checkpointName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &checkpoint{}
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty checkpoint")
+	}
+	if _, err := fmt.Sscanf(scanner.Text(), "source %s %d %d %d", &c.Format, &c.Size, &c.MinTime, &c.MaxTime); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint source %q: %v", scanner.Text(), err)
+	}
+	for scanner.Scan() {
+		var cf checkpointFile
+		if _, err := fmt.Sscanf(scanner.Text(), "file %s %d %x", &cf.Name, &cf.Size, &cf.Checksum); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint file %q: %v", scanner.Text(), err)
+		}
+		c.Files = append(c.Files, cf)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkConverted decides whether the output at dst of an earlier conversion
+// of si can be used instead of converting the shard again. The output is
+// only used if its checkpoint was written, the source shard and time range
+// are unchanged, and every TSM file still matches the size and checksum
+// recorded in the checkpoint. Otherwise the reason the shard must be
+// converted again is returned. If there is no earlier output, both return
+// values are empty.
+func checkConverted(si *tsdb.ShardInfo, dst string, min, max int64) (skip bool, reason string) {
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		return false, ""
+	}
+
+	prev, err := readCheckpoint(dst)
+	if os.IsNotExist(err) {
+		return false, "no checkpoint, the earlier conversion did not complete"
+	} else if err != nil {
+		return false, fmt.Sprintf("unreadable checkpoint: %v", err)
+	}
+
+	if prev.Format != si.FormatAsString() || prev.Size != si.Size {
+		return false, "the source shard changed since the earlier conversion"
+	} else if prev.MinTime != min || prev.MaxTime != max {
+		return false, "the time range changed since the earlier conversion"
+	}
+
+	curr, err := newCheckpoint(si, min, max, dst)
+	if err != nil {
+		return false, fmt.Sprintf("unreadable output: %v", err)
+	} else if !reflect.DeepEqual(prev.Files, curr.Files) {
+		return false, "the output does not match its checkpoint"
+	}
+	return true, ""
+}
+
+// digestShard returns the digest of the points in the converted tsm1 shard
+// in dir.
+func digestShard(dir string) (Digest, error) {
+	var d Digest
+	r := tsmreader.NewReader(dir, new(stats.Stats), 0)
+	if err := r.Open(); err != nil {
+		return d, err
+	}
+	defer r.Close()
+
+	for r.Next() {
+		k, v, err := r.Read()
+		if err != nil {
+			return d, err
+		}
+		d.Add(k, v)
+	}
+	return d, r.Close()
+}
+
+// resumeConversions prepares the data directory, listed in shards, for
+// converting again after an interrupted run. The output of an earlier
+// conversion whose source shard is still present is removed, unless it can
+// be used instead of converting the shard again, so that the shard is
+// converted from scratch. The output of a conversion that was interrupted
+// after its source shard was deleted is moved into place, if it still
+// matches its checkpoint. Otherwise it is left alone, since it holds the
+// only copy of the shard.
+func resumeConversions(shards tsdb.ShardInfos) error {
+	min, max := opts.timeRange()
+
+	sources := make(map[string]*tsdb.ShardInfo)
+	for _, si := range shards {
+		sources[si.FullPath(opts.DataPath)] = si
+	}
+
+	for _, out := range shards {
+		dst := out.FullPath(opts.DataPath)
+		if !strings.HasSuffix(dst, "."+tsmExt) {
+			continue
+		}
+		src := strings.TrimSuffix(dst, "."+tsmExt)
+
+		if si, ok := sources[src]; ok {
+			if skip, reason := checkConverted(si, dst, min, max); !skip {
+				logger.Warn(shardFields(si).with(Fields{"output": dst, "reason": reason}), "Removing output of an earlier conversion of %v at %v: %v", src, dst, reason)
+				if err := os.RemoveAll(dst); err != nil {
+					return fmt.Errorf("Removal of %v failed: %v", dst, err)
+				}
+			}
+			continue
+		}
+
+		si := &tsdb.ShardInfo{
+			Database:        out.Database,
+			RetentionPolicy: out.RetentionPolicy,
+			Path:            strings.TrimSuffix(out.Path, "."+tsmExt),
+			Format:          out.Format,
+		}
+		fields := shardFields(si).with(Fields{"output": dst})
+		if reason := checkOrphaned(dst); reason != "" {
+			logger.Warn(fields.with(Fields{"reason": reason}), "Leaving output of an earlier conversion at %v in place, its source shard %v was deleted but it cannot be completed: %v", dst, src, reason)
+			continue
+		}
+
+		target := opts.targetPath(si)
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return fmt.Errorf("Creation of %v failed: %v", filepath.Dir(target), err)
+		}
+		if err := moveDir(osFileSystem{}, dst, target); err != nil {
+			return fmt.Errorf("Rename of %v to %v failed, converted shard remains at %v: %v", dst, target, dst, err)
+		}
+		if err := os.Remove(filepath.Join(target, checkpointName)); err != nil && !os.IsNotExist(err) {
+			logger.Warn(fields.with(Fields{"error": err}), "Removal of checkpoint in %v failed: %v", target, err)
+		}
+		logger.Info(fields.with(Fields{"event": "shard_resumed"}), "Completed conversion of %v from the output of an earlier conversion at %v", src, dst)
+	}
+	return nil
+}
+
+// checkOrphaned returns why the output at dst of a conversion whose source
+// shard was deleted cannot be moved into place, or an empty string if it
+// can. Its checkpoint must be present, and every TSM file must still match
+// it.
+func checkOrphaned(dst string) string {
+	prev, err := readCheckpoint(dst)
+	if os.IsNotExist(err) {
+		return "no checkpoint"
+	} else if err != nil {
+		return fmt.Sprintf("unreadable checkpoint: %v", err)
+	}
+
+	curr, err := newCheckpoint(&tsdb.ShardInfo{}, prev.MinTime, prev.MaxTime, dst)
+	if err != nil {
+		return fmt.Sprintf("unreadable output: %v", err)
+	} else if !reflect.DeepEqual(prev.Files, curr.Files) {
+		return "the output does not match its checkpoint"
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure the output of an earlier conversion is only used if its checkpoint
+// matches the source shard, time range and TSM files.
+func TestCheckConverted(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	si := &tsdb.ShardInfo{Format: tsdb.BZ1, Size: 100}
+	dst := filepath.Join(dir, "1.tsm")
+
+	// No earlier output.
+	if skip, reason := checkConverted(si, dst, 0, 100); skip || reason != "" {
+		t.Fatalf("unexpected result without output: %v %q", skip, reason)
+	}
+
+	var st stats.Stats
+	c := NewConverter(dst, 1, &st)
+	c.digest = new(Digest)
+	if err := c.Process(&sliceIterator{keys: []string{"cpu#!~#value", "mem#!~#value"}, values: [][]tsm1.Value{
+		{tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		{tsm1.NewValue(0, int64(3))},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output without a checkpoint is from an interrupted conversion.
+	if skip, reason := checkConverted(si, dst, 0, 100); skip || reason == "" {
+		t.Fatalf("unexpected result without checkpoint: %v %q", skip, reason)
+	}
+
+	cp, err := newCheckpoint(si, 0, 100, dst)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(cp.Files) < 2 {
+		t.Fatalf("expected multiple TSM files, got %d", len(cp.Files))
+	}
+	if err := cp.WriteFile(dst); err != nil {
+		t.Fatal(err)
+	}
+	if skip, reason := checkConverted(si, dst, 0, 100); !skip || reason != "" {
+		t.Fatalf("unexpected result with checkpoint: %v %q", skip, reason)
+	}
+
+	// The digest read from the output matches the digest of the conversion.
+	if d, err := digestShard(dst); err != nil {
+		t.Fatal(err)
+	} else if d != *c.digest {
+		t.Fatalf("digest mismatch: %s != %s", d, *c.digest)
+	}
+
+	// A changed source shard or time range is converted again.
+	if skip, reason := checkConverted(&tsdb.ShardInfo{Format: tsdb.BZ1, Size: 200}, dst, 0, 100); skip || reason == "" {
+		t.Fatalf("unexpected result with changed source: %v %q", skip, reason)
+	}
+	if skip, reason := checkConverted(si, dst, 0, 50); skip || reason == "" {
+		t.Fatalf("unexpected result with changed time range: %v %q", skip, reason)
+	}
+
+	// Output that no longer matches its checkpoint is converted again.
+	MustWriteFile(filepath.Join(dst, cp.Files[0].Name), "corrupt")
+	if skip, reason := checkConverted(si, dst, 0, 100); skip || reason == "" {
+		t.Fatalf("unexpected result with corrupt output: %v %q", skip, reason)
+	}
+}
+
+// Ensure resuming removes output that cannot be used, keeps output that can,
+// and moves complete output whose source shard was deleted into place.
+func TestResumeConversions(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir}
+	min, max := opts.timeRange()
+
+	rp := filepath.Join(dir, "db0", "rp0")
+	values := map[string][]tsm1.Value{"cpu#!~#value": {tsm1.NewValue(0, 1.0)}}
+	for _, path := range []string{"1", "1.tsm", "2", "2.tsm", "3.tsm", "4.tsm"} {
+		MustWriteTSMFile(filepath.Join(rp, path, "000000001-000000001.tsm"), values)
+	}
+	dbs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, si := range listShards(dbs) {
+		switch si.Path {
+		case "2":
+			cp, err := newCheckpoint(si, min, max, filepath.Join(rp, "2.tsm"))
+			if err != nil {
+				t.Fatal(err)
+			} else if err := cp.WriteFile(filepath.Join(rp, "2.tsm")); err != nil {
+				t.Fatal(err)
+			}
+		case "3":
+			t.Fatal("unexpected shard 3")
+		}
+	}
+	cp, err := newCheckpoint(&tsdb.ShardInfo{Format: tsdb.BZ1, Size: 100}, min, max, filepath.Join(rp, "3.tsm"))
+	if err != nil {
+		t.Fatal(err)
+	} else if err := cp.WriteFile(filepath.Join(rp, "3.tsm")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumeConversions(listShards(dbs)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output without a checkpoint is removed while its source is present.
+	MustNotExist(t, filepath.Join(rp, "1.tsm"))
+
+	// Output of a shard whose source was deleted is moved into place.
+	MustNotExist(t, filepath.Join(rp, "3.tsm"))
+	MustNotExist(t, filepath.Join(rp, "3", checkpointName))
+
+	for _, path := range []string{
+		filepath.Join(rp, "1"),
+		filepath.Join(rp, "2", "000000001-000000001.tsm"),
+		filepath.Join(rp, "2.tsm", checkpointName),
+		filepath.Join(rp, "3", "000000001-000000001.tsm"),
+		filepath.Join(rp, "4.tsm", "000000001-000000001.tsm"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept: %v", path, err)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
@@ -11,6 +13,18 @@ import (
 
 const (
 	maxBlocksPerKey = 65535
+
+	// maxKeyLength is the longest key a TSM file can hold.
+	maxKeyLength = 65535
+
+	// The bytes a block adds to a TSM file besides its data: its checksum
+	// and its index entry. The first block of a key in a file also adds the
+	// key, its length, its type and its entry count to the index.
+	blockOverhead = 4 + 28
+	keyOverhead   = 2 + 1 + 2
+
+	// footerSize is the size of the footer ending a TSM file.
+	footerSize = 8
 )
 
 // KeyIterator is used to iterate over b* keys for conversion to tsm keys
@@ -24,7 +38,20 @@ type Converter struct {
 	path           string
 	maxTSMFileSize uint32
 	sequence       int
+	points         uint64
 	stats          *stats.Stats
+
+	// digest, if set, is updated with every point written.
+	digest *Digest
+
+	// progress, if set, is called at most once every interval with the
+	// points and bytes written so far.
+	progress func(points, bytes uint64)
+	interval time.Duration
+
+	// workers is the number of blocks encoded at once. Blocks are written
+	// in the order they are read whatever the number of workers.
+	workers int
 }
 
 // NewConverter returns a new instance of the Converter.
@@ -36,65 +63,194 @@ func NewConverter(path string, sz uint32, stats *stats.Stats) *Converter {
 	}
 }
 
-// Process writes the data provided by iter to a tsm1 shard.
+// Process writes the data provided by iter to a tsm1 shard. Blocks are read
+// from iter on one goroutine, encoded by up to workers goroutines at once, and
+// written in the order they were read.
+//
+// The blocks are written to a sequence of TSM files named by generation 1
+// and a sequence number counting from 1, such as 000000001-000000001.tsm and
+// 000000001-000000002.tsm, the same names the tsm1 engine gives the files of
+// a compaction. A new file is started whenever the next block would take the
+// current file over the maximum size, or once a key has as many blocks as a
+// file can hold, so every file stays within the maximum size unless a
+// single block exceeds it. The same input always produces the same files.
 func (c *Converter) Process(iter KeyIterator) error {
 	// Ensure the tsm1 directory exists.
 	if err := os.MkdirAll(c.path, 0777); err != nil {
 		return err
 	}
 
+	blocks, stop := c.encodeBlocks(iter)
+	defer stop()
+
 	// Iterate until no more data remains.
 	var w tsm1.TSMWriter
 	var keyCount map[string]int
 
-	for iter.Next() {
-		k, v, err := iter.Read()
-		if err != nil {
-			return err
+	// The points and bytes written to the shard, of the TSM files closed.
+	var points, written uint64
+	lastProgress := time.Now()
+
+	for b := range blocks {
+		<-b.ready
+		if b.err != nil {
+			return b.err
+		}
+		k, v := b.key, b.values
+		if len(v) == 0 {
+			continue
+		} else if len(k) > maxKeyLength {
+			return tsm1.ErrMaxKeyLengthExceeded
+		}
+
+		// Rather than let the block take the file over the maximum size,
+		// start a new file, unless the file is empty. The size of the file
+		// does not count the type of each key in the index, so it is added.
+		if w != nil {
+			size := uint64(w.Size()) + uint64(len(keyCount)) + uint64(len(b.block)) + blockOverhead + footerSize
+			if keyCount[k] == 0 {
+				size += uint64(len(k)) + keyOverhead
+			}
+			if size > uint64(c.maxTSMFileSize) {
+				if err := c.closeTSMWriter(w); err != nil {
+					return err
+				}
+				written += uint64(w.Size())
+				w = nil
+			}
 		}
 
 		if w == nil {
-			w, err = c.nextTSMWriter()
-			if err != nil {
+			var err error
+			if w, err = c.nextTSMWriter(); err != nil {
 				return err
 			}
 			keyCount = map[string]int{}
 		}
-		if err := w.Write(k, v); err != nil {
+		if err := w.WriteBlock(k, v[0].UnixNano(), v[len(v)-1].UnixNano(), b.block); err != nil {
 			return err
 		}
 		keyCount[k]++
+		if c.digest != nil {
+			c.digest.Add(k, v)
+		}
 
 		c.stats.AddPointsRead(len(v))
 		c.stats.AddPointsWritten(len(v))
+		points += uint64(len(v))
+		c.points = points
 
-		// If we have a max file size configured and we're over it, start a new TSM file.
-		if w.Size() > c.maxTSMFileSize || keyCount[k] == maxBlocksPerKey {
-			if err := w.WriteIndex(); err != nil && err != tsm1.ErrNoValues {
+		// A file's index can only hold so many blocks of a key.
+		if keyCount[k] == maxBlocksPerKey {
+			if err := c.closeTSMWriter(w); err != nil {
 				return err
 			}
+			written += uint64(w.Size())
+			w = nil
+		}
 
-			c.stats.AddTSMBytes(w.Size())
-
-			if err := w.Close(); err != nil {
-				return err
+		if c.progress != nil && time.Since(lastProgress) >= c.interval {
+			bytes := written
+			if w != nil {
+				bytes += uint64(w.Size())
 			}
-			w = nil
+			c.progress(points, bytes)
+			lastProgress = time.Now()
 		}
 	}
 
 	if w != nil {
-		if err := w.WriteIndex(); err != nil && err != tsm1.ErrNoValues {
-			return err
-		}
-		c.stats.AddTSMBytes(w.Size())
+		return c.closeTSMWriter(w)
+	}
+	return nil
+}
 
-		if err := w.Close(); err != nil {
-			return err
-		}
+// TSMFiles returns the number of TSM files written by Process.
+func (c *Converter) TSMFiles() int {
+	return c.sequence
+}
+
+// PointsWritten returns the number of points written by Process.
+func (c *Converter) PointsWritten() uint64 {
+	return c.points
+}
+
+// encodedBlock is a block of values read for a key, and its encoding.
+type encodedBlock struct {
+	key    string
+	values []tsm1.Value
+	block  []byte
+	err    error
+
+	// ready is closed once the block is encoded, or failed to be.
+	ready chan struct{}
+}
+
+// encodeBlocks reads the blocks of iter and encodes them on a pool of
+// workers. The blocks are returned in the order they were read, and each may
+// only be used once its ready channel is closed. The returned function stops
+// reading and waits for every goroutine to exit, and must be called before
+// iter is closed.
+func (c *Converter) encodeBlocks(iter KeyIterator) (<-chan *encodedBlock, func()) {
+	workers := c.workers
+	if workers < 1 {
+		workers = 1
 	}
 
-	return nil
+	blocks := make(chan *encodedBlock, 2*workers)
+	jobs := make(chan *encodedBlock, 2*workers)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				if len(b.values) > 0 {
+					b.block, b.err = tsm1.Values(b.values).Encode(nil)
+				}
+				close(b.ready)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(blocks)
+		defer close(jobs)
+
+		for iter.Next() {
+			k, v, err := iter.Read()
+			// Readers reuse the values slice on the next call to Next, so it
+			// is copied before being handed to another goroutine.
+			b := &encodedBlock{key: k, values: append([]tsm1.Value(nil), v...), err: err, ready: make(chan struct{})}
+			if err != nil {
+				close(b.ready)
+			} else {
+				select {
+				case jobs <- b:
+				case <-done:
+					return
+				}
+			}
+
+			select {
+			case blocks <- b:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return blocks, func() {
+		close(done)
+		wg.Wait()
+	}
 }
 
 // nextTSMWriter returns the next TSMWriter for the Converter.
@@ -116,3 +272,13 @@ func (c *Converter) nextTSMWriter() (tsm1.TSMWriter, error) {
 	c.stats.IncrTSMFileCount()
 	return w, nil
 }
+
+// closeTSMWriter writes the index of the TSM file being written by w and
+// closes it.
+func (c *Converter) closeTSMWriter(w tsm1.TSMWriter) error {
+	if err := w.WriteIndex(); err != nil && err != tsm1.ErrNoValues {
+		return err
+	}
+	c.stats.AddTSMBytes(w.Size())
+	return w.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Digest is an order-independent digest of the points in a shard. Each point
+// is hashed on its own and the hashes are summed, so the digest does not
+// depend on the order the points were written in or how they were split
+// across blocks and TSM files.
+type Digest [4]uint64
+
+// Add adds the values of the series key to the digest.
+func (d *Digest) Add(key string, values []tsm1.Value) {
+	var buf [9]byte
+	for _, v := range values {
+		h := sha256.New()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+
+		binary.BigEndian.PutUint64(buf[:8], uint64(v.UnixNano()))
+		h.Write(buf[:8])
+
+		switch v := v.Value().(type) {
+		case float64:
+			buf[0] = tsm1.BlockFloat64
+			binary.BigEndian.PutUint64(buf[1:], math.Float64bits(v))
+			h.Write(buf[:])
+		case int64:
+			buf[0] = tsm1.BlockInteger
+			binary.BigEndian.PutUint64(buf[1:], uint64(v))
+			h.Write(buf[:])
+		case bool:
+			buf[0], buf[1] = tsm1.BlockBoolean, 0
+			if v {
+				buf[1] = 1
+			}
+			h.Write(buf[:2])
+		case string:
+			buf[0] = tsm1.BlockString
+			h.Write(buf[:1])
+			h.Write([]byte(v))
+		}
+
+		sum := h.Sum(nil)
+		for i := range d {
+			d[i] += binary.BigEndian.Uint64(sum[i*8:])
+		}
+	}
+}
+
+// String returns the digest as a hex string.
+func (d Digest) String() string {
+	return fmt.Sprintf("%016x%016x%016x%016x", d[0], d[1], d[2], d[3])
+}
+
+// manifest records the digest of each converted shard.
+type manifest struct {
+	mu      sync.Mutex
+	digests map[string]Digest
+}
+
+// newManifest returns a new, empty manifest.
+func newManifest() *manifest {
+	return &manifest{digests: make(map[string]Digest)}
+}
+
+// Set records the digest of the shard at path, relative to the data directory.
+func (m *manifest) Set(path string, d Digest) {
+	m.mu.Lock()
+	m.digests[path] = d
+	m.mu.Unlock()
+}
+
+// WriteFile writes a line for each shard, ordered by path, to the named file.
+// Manifests of replicas holding the same data are identical.
+func (m *manifest) WriteFile(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paths := make([]string, 0, len(m.digests))
+	for path := range m.digests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, err := fmt.Fprintf(f, "%s %s\n", filepath.ToSlash(path), m.digests[path]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure the digest does not depend on the order values are added in.
+func TestDigest_OrderIndependent(t *testing.T) {
+	values := []tsm1.Value{
+		tsm1.NewValue(0, 1.5),
+		tsm1.NewValue(1, int64(2)),
+		tsm1.NewValue(2, true),
+		tsm1.NewValue(3, "foo"),
+	}
+
+	var a, b Digest
+	a.Add("cpu,host=a#!~#value", values)
+	a.Add("cpu,host=b#!~#value", values[:1])
+
+	b.Add("cpu,host=b#!~#value", values[:1])
+	b.Add("cpu,host=a#!~#value", values[2:])
+	b.Add("cpu,host=a#!~#value", values[:2])
+
+	if a != b {
+		t.Fatalf("digest mismatch: %s != %s", a, b)
+	}
+
+	var c Digest
+	c.Add("cpu,host=a#!~#value", values)
+	c.Add("cpu,host=b#!~#value", []tsm1.Value{tsm1.NewValue(0, 1.25)})
+	if a == c {
+		t.Fatalf("expected digests to differ: %s", a)
+	}
+}
+
+// Ensure the digest of a converted shard does not depend on the TSM file size.
+func TestConverter_Digest(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	process := func(path string, sz uint32) Digest {
+		var st stats.Stats
+		c := NewConverter(path, sz, &st)
+		c.digest = new(Digest)
+		if err := c.Process(&sliceIterator{keys: []string{"cpu#!~#value", "mem#!~#value"}, values: [][]tsm1.Value{
+			{tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+			{tsm1.NewValue(0, int64(3))},
+		}}); err != nil {
+			t.Fatal(err)
+		}
+		return *c.digest
+	}
+
+	small, large := process(filepath.Join(dir, "small"), 1), process(filepath.Join(dir, "large"), 1<<20)
+	if small != large {
+		t.Fatalf("digest mismatch: %s != %s", small, large)
+	}
+
+	if files, err := filepath.Glob(filepath.Join(dir, "small", "*.tsm")); err != nil {
+		t.Fatal(err)
+	} else if len(files) < 2 {
+		t.Fatalf("expected multiple TSM files, got %d", len(files))
+	}
+}
+
+// sliceIterator is a KeyIterator over fixed keys and values.
+type sliceIterator struct {
+	keys   []string
+	values [][]tsm1.Value
+	i      int
+}
+
+func (itr *sliceIterator) Next() bool {
+	itr.i++
+	return itr.i <= len(itr.keys)
+}
+
+func (itr *sliceIterator) Read() (string, []tsm1.Value, error) {
+	return itr.keys[itr.i-1], itr.values[itr.i-1], nil
+}
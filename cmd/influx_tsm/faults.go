@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+)
+
+// errInjectedFailure is returned by the conversion of the shard selected by
+// injectFailAt.
+var errInjectedFailure = errors.New("injected failure")
+
+// injectFailAt is the index, in conversion order, of a shard whose conversion
+// fails deliberately once its output is written but before it is
+// checkpointed, as if the conversion had been interrupted. It lets tests check
+// that a conversion run again after a failure resumes without losing or
+// converting any data twice. It is -1 unless set by a test, or by the
+// -inject-fail-at flag of a build with the inject tag.
+var injectFailAt = -1
+
+// injectFault returns errInjectedFailure if si is the shard selected by
+// injectFailAt.
+func (t *tracker) injectFault(si *tsdb.ShardInfo) error {
+	if injectFailAt < 0 || injectFailAt >= len(t.shards) || t.shards[injectFailAt] != si {
+		return nil
+	}
+	return errInjectedFailure
+}
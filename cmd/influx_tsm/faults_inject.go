@@ -0,0 +1,11 @@
+// +build inject
+
+package main
+
+import "flag"
+
+// registerFaultFlags registers the flags injecting failures, which are only
+// available in builds with the inject tag.
+func registerFaultFlags(fs *flag.FlagSet) {
+	fs.IntVar(&injectFailAt, "inject-fail-at", -1, "Fail the conversion of the shard with this index, for testing resumed conversions.")
+}
@@ -0,0 +1,9 @@
+// +build !inject
+
+package main
+
+import "flag"
+
+// registerFaultFlags does nothing, failures can only be injected in builds
+// with the inject tag.
+func registerFaultFlags(fs *flag.FlagSet) {}
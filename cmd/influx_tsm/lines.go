@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	"github.com/influxdata/influxdb/influxql"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// writeLines writes the points of shards to w as line protocol, in the
+// format read by influx -import, instead of converting them. A DDL section
+// creates each database and retention policy, renamed by -rp-rename, and
+// the points of each retention policy follow context lines naming it. The
+// shards are only read, one at a time, and are left as they are. It returns
+// the number of points written.
+func writeLines(w io.Writer, shards tsdb.ShardInfos, st *stats.Stats) (uint64, error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# ESCAPED-NEWLINES")
+	fmt.Fprintln(bw, "# DDL")
+	for i, si := range shards {
+		if i == 0 || !sameRetentionPolicy(si, shards[i-1]) {
+			fmt.Fprintf(bw, "CREATE DATABASE %s WITH NAME %s\n", influxql.QuoteIdent(si.Database), influxql.QuoteIdent(opts.retentionPolicy(si)))
+		}
+	}
+
+	var n uint64
+	fmt.Fprintln(bw, "# DML")
+	for i, si := range shards {
+		if i == 0 || !sameRetentionPolicy(si, shards[i-1]) {
+			fmt.Fprintf(bw, "# CONTEXT-DATABASE:%s\n", si.Database)
+			fmt.Fprintf(bw, "# CONTEXT-RETENTION-POLICY:%s\n", opts.retentionPolicy(si))
+		}
+
+		start := time.Now()
+		logger.Info(shardFields(si).with(Fields{"event": "shard_started", "size": si.Size}), "Writing shard %v as line protocol", si.FullPath(opts.DataPath))
+		m, err := writeShardLines(bw, si, st)
+		if err != nil {
+			logger.Error(shardFields(si).with(Fields{"event": "shard_failed", "error": err}), "Failed to write %v as line protocol: %v", si.FullPath(opts.DataPath), err)
+			return n, fmt.Errorf("Failed to write %v as line protocol: %v", si.FullPath(opts.DataPath), err)
+		}
+		n += m
+		d := time.Since(start)
+		logger.Info(shardFields(si).with(Fields{"event": "shard_completed", "points_written": m, "duration": d}), "Wrote %d points of %v as line protocol (%v)", m, si.FullPath(opts.DataPath), d)
+	}
+	return n, bw.Flush()
+}
+
+// writeShardLines writes the points of the shard si within the converted
+// time range to w as line protocol, one field per line.
+func writeShardLines(w io.Writer, si *tsdb.ShardInfo, st *stats.Stats) (uint64, error) {
+	reader, err := newShardReader(si, si.FullPath(opts.DataPath), st)
+	if err != nil {
+		return 0, err
+	}
+	reader.SetTimeRange(opts.timeRange())
+	if err := reader.Open(); err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	var n uint64
+	for reader.Next() {
+		k, values, err := reader.Read()
+		if err != nil {
+			return n, err
+		}
+		series, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+		prefix := string(series) + " " + escape.String(field) + "="
+		for _, v := range values {
+			if _, err := fmt.Fprintf(w, "%s%s %d\n", prefix, formatLineValue(v.Value()), v.UnixNano()); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// formatLineValue returns the line protocol representation of a field value.
+// Newlines in strings are escaped so each point stays on one line.
+func formatLineValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + models.EscapeStringFieldNewlines(models.EscapeStringField(v)) + `"`
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// sameRetentionPolicy returns whether a and b are in the same database and
+// retention policy.
+func sameRetentionPolicy(a, b *tsdb.ShardInfo) bool {
+	return a.Database == b.Database && a.RetentionPolicy == b.RetentionPolicy
+}
+
+// runLines writes shards as line protocol to the file named by -to-line, or
+// to stdout if it is -.
+func runLines(shards tsdb.ShardInfos, stdout io.Writer) error {
+	w := stdout
+	if opts.LinePath != "-" {
+		f, err := os.Create(opts.LinePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	logger.Info(Fields{"event": "conversion_started", "shards": len(shards)}, "Writing %d shards as line protocol....", len(shards))
+	start := time.Now()
+	n, err := writeLines(w, shards, new(stats.Stats))
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != stdout {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	d := time.Since(start)
+	logger.Info(Fields{"event": "conversion_completed", "points_written": n, "duration": d}, "Wrote %d points as line protocol (%v)", n, d)
+	return nil
+}
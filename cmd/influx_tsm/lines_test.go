@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure shards are written as line protocol that influx -import reads,
+// grouped by database and renamed retention policy.
+func TestWriteLines(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir, RPRenames: map[string]string{"default": "raw"}}
+
+	MustWriteTSMFile(filepath.Join(dir, "db0", "default", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#value":      {tsm1.NewValue(0, 1.5), tsm1.NewValue(10, 2.0)},
+		"cpu,host=a#!~#busy count": {tsm1.NewValue(0, int64(3))},
+	})
+	MustWriteTSMFile(filepath.Join(dir, "db0", "rp0", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"log#!~#msg": {tsm1.NewValue(5, "a \"b\"\nc")},
+		"up#!~#ok":   {tsm1.NewValue(5, true)},
+	})
+	shards := tsdb.ShardInfos{
+		{Database: "db0", RetentionPolicy: "default", Path: "1", Format: tsdb.TSM1},
+		{Database: "db0", RetentionPolicy: "rp0", Path: "2", Format: tsdb.TSM1},
+	}
+
+	var buf bytes.Buffer
+	n, err := writeLines(&buf, shards, new(stats.Stats))
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 5 {
+		t.Fatalf("unexpected points written: %d", n)
+	}
+
+	if exp := `# ESCAPED-NEWLINES
+# DDL
+CREATE DATABASE db0 WITH NAME raw
+CREATE DATABASE db0 WITH NAME rp0
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:raw
+cpu,host=a busy\ count=3i 0
+cpu,host=a value=1.5 0
+cpu,host=a value=2 10
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+log msg="a \"b\"\nc" 5
+up ok=true 5
+`; buf.String() != exp {
+		t.Fatalf("unexpected output:\ngot:\n%s\nexp:\n%s", buf.String(), exp)
+	}
+}
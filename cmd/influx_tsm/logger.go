@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+)
+
+// Log formats accepted by -log-format.
+const (
+	textLogFormat = "text"
+	jsonLogFormat = "json"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+// Log levels, from least to most severe.
+const (
+	InfoLevel Level = iota
+	WarnLevel
+	ErrorLevel
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// Fields are named values attached to a log message, such as the shard it
+// is about. Errors are logged as their message and durations in seconds.
+type Fields map[string]interface{}
+
+// Logger writes leveled log messages. In the text format each message is
+// written as a human-readable line, without its fields. In the JSON format
+// each message is written as an object on a line of its own, holding the
+// time, level and message along with the fields.
+type Logger struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+
+	now  func() time.Time
+	exit func(int)
+}
+
+// NewLogger returns a logger writing to w in format, which must be "text"
+// or "json".
+func NewLogger(w io.Writer, format string) (*Logger, error) {
+	l := &Logger{w: w, now: time.Now, exit: os.Exit}
+	switch format {
+	case textLogFormat:
+	case jsonLogFormat:
+		l.json = true
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected %s or %s", format, textLogFormat, jsonLogFormat)
+	}
+	return l, nil
+}
+
+// Info logs an informational message.
+func (l *Logger) Info(fields Fields, format string, v ...interface{}) {
+	l.log(InfoLevel, fields, format, v...)
+}
+
+// Warn logs a problem that does not stop the conversion.
+func (l *Logger) Warn(fields Fields, format string, v ...interface{}) {
+	l.log(WarnLevel, fields, format, v...)
+}
+
+// Error logs a problem that stops the conversion of a shard or database.
+func (l *Logger) Error(fields Fields, format string, v ...interface{}) {
+	l.log(ErrorLevel, fields, format, v...)
+}
+
+// Fatal logs an error and exits.
+func (l *Logger) Fatal(fields Fields, format string, v ...interface{}) {
+	l.log(ErrorLevel, fields, format, v...)
+	l.exit(1)
+}
+
+func (l *Logger) log(level Level, fields Fields, format string, v ...interface{}) {
+	now := l.now()
+	msg := fmt.Sprintf(format, v...)
+
+	var buf bytes.Buffer
+	if l.json {
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, now.UTC().Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, level.String())
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf.WriteByte(',')
+			writeJSON(&buf, k)
+			buf.WriteByte(':')
+			writeJSON(&buf, fieldValue(fields[k]))
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s [%s] %s\n", now.Format("2006/01/02 15:04:05.000000"), level, msg)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+// writeJSON writes v to buf as JSON, or as a string describing the failure
+// if v cannot be encoded.
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("!(%v)", err))
+	}
+	buf.Write(b)
+}
+
+// fieldValue returns the value of a field as it is logged.
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.Seconds()
+	default:
+		return v
+	}
+}
+
+// shardFields returns the fields identifying the shard si.
+func shardFields(si *tsdb.ShardInfo) Fields {
+	return Fields{
+		"database":         si.Database,
+		"retention_policy": si.RetentionPolicy,
+		"shard":            si.Path,
+		"path":             si.FullPath(opts.DataPath),
+		"engine":           si.FormatAsString(),
+	}
+}
+
+// with returns a copy of f with the fields in other added.
+func (f Fields) with(other Fields) Fields {
+	a := make(Fields, len(f)+len(other))
+	for k, v := range f {
+		a[k] = v
+	}
+	for k, v := range other {
+		a[k] = v
+	}
+	return a
+}
+
+// logger is the log of the conversion, configured by -log-format.
+var logger, _ = NewLogger(os.Stderr, textLogFormat)
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Ensure messages are written as text lines without their fields.
+func TestLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	l := MustNewLogger(&buf, textLogFormat)
+
+	l.Info(Fields{"database": "db0"}, "Backup of database '%v' started", "db0")
+	l.Warn(nil, "Database backup disabled.")
+	if exp := "2016/01/28 12:23:43.699266 [info] Backup of database 'db0' started\n" +
+		"2016/01/28 12:23:43.699266 [warn] Database backup disabled.\n"; buf.String() != exp {
+		t.Fatalf("unexpected output:\ngot %q\nexp %q", buf.String(), exp)
+	}
+}
+
+// Ensure messages are written as JSON objects holding their fields, and that
+// errors and durations are logged as strings and seconds.
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := MustNewLogger(&buf, jsonLogFormat)
+
+	l.Error(Fields{
+		"event":    "shard_failed",
+		"shard":    "1",
+		"duration": 1500 * time.Millisecond,
+		"error":    errors.New("corrupt block"),
+	}, "Failed to convert %v: %v", "db0/rp0/1", "corrupt block")
+	l.Info(nil, "Conversion starting....")
+	if exp := `{"time":"2016-01-28T12:23:43.699266Z","level":"error","msg":"Failed to convert db0/rp0/1: corrupt block","duration":1.5,"error":"corrupt block","event":"shard_failed","shard":"1"}` + "\n" +
+		`{"time":"2016-01-28T12:23:43.699266Z","level":"info","msg":"Conversion starting...."}` + "\n"; buf.String() != exp {
+		t.Fatalf("unexpected output:\ngot %s\nexp %s", buf.String(), exp)
+	}
+}
+
+// Ensure a fatal message is logged as an error before exiting.
+func TestLogger_Fatal(t *testing.T) {
+	var buf bytes.Buffer
+	l := MustNewLogger(&buf, textLogFormat)
+	var code int
+	l.exit = func(c int) { code = c }
+
+	l.Fatal(nil, "Conversion aborted.")
+	if code != 1 {
+		t.Fatalf("unexpected exit code: %d", code)
+	} else if exp := "2016/01/28 12:23:43.699266 [error] Conversion aborted.\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+// Ensure an unknown log format is rejected.
+func TestNewLogger_InvalidFormat(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "xml"); err == nil || err.Error() != `invalid log format "xml", expected text or json` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// MustNewLogger returns a logger writing to w in format, with a fixed time.
+func MustNewLogger(w *bytes.Buffer, format string) *Logger {
+	l, err := NewLogger(w, format)
+	if err != nil {
+		panic(err)
+	}
+	l.now = func() time.Time { return time.Date(2016, 1, 28, 12, 23, 43, 699266000, time.UTC) }
+	return l
+}
@@ -7,13 +7,17 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -22,12 +26,17 @@ import (
 
 	"github.com/influxdata/influxdb/cmd/influx_tsm/b1"
 	"github.com/influxdata/influxdb/cmd/influx_tsm/bz1"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
 	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsm1"
+	influxtsdb "github.com/influxdata/influxdb/tsdb"
 )
 
-// ShardReader reads b* shards and converts to tsm shards
+// ShardReader reads b* or tsm1 shards and converts to tsm shards
 type ShardReader interface {
 	KeyIterator
+	SetTimeRange(min, max int64)
+	SetSeriesFilter(fn func(series string) bool)
 	Open() error
 	Close() error
 }
@@ -39,13 +48,19 @@ const (
 var description = `
 Convert a database from b1 or bz1 format to tsm1 format.
 
+With -recompact, fragmented tsm1 shards are also read and written out again,
+re-encoding and re-compacting them with the current tsm1 format.
+
 This tool will backup the directories before conversion (if not disabled).
-The backed-up files must be removed manually, generally after starting up the
-node again to make sure all of data has been converted correctly.
+Each database is copied to a directory of the same name in the -backup
+directory, which must be outside the data directory and is best placed on
+another volume. The backed-up files must be removed manually, generally after
+starting up the node again to make sure all of data has been converted
+correctly.
 
 To restore a backup:
-  Shut down the node, remove the converted directory, and
-  copy the backed-up directory to the original location.`
+  Shut down the node, remove the converted database directory, and
+  copy <backup>/<database> to <data-path>/<database>.`
 
 type options struct {
 	DataPath       string
@@ -54,26 +69,77 @@ type options struct {
 	DebugAddr      string
 	TSMSize        uint64
 	Parallel       bool
+	Workers        int
+	SeriesWorkers  int
+	ParallelDBs    int
+	GroupByRP      bool
 	SkipBackup     bool
+	Resume         bool
 	UpdateInterval time.Duration
 	Yes            bool
+	Quiet          bool
 	CPUFile        string
+	Since          time.Time
+	Until          time.Time
+	RPRenames      map[string]string
+	ManifestPath   string
+	ReportPath     string
+	Recompact      bool
+	RecompactAll   bool
+	Force          bool
+	SkipSpaceCheck bool
+	Verify         bool
+	VerifyRate     float64
+	VerifySeed     int64
+	KeepBackupDays int
+	LogFormat      string
+	LinePath       string
+	RetryAttempts  int
+	RetryBackoff   time.Duration
+	ShardsFrom     string
 }
 
 func (o *options) Parse() error {
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
-	var dbs string
+	var dbs, sz, since, until, rpRenames string
 
 	fs.StringVar(&dbs, "dbs", "", "Comma-delimited list of databases to convert. Default is to convert all databases.")
-	fs.Uint64Var(&opts.TSMSize, "sz", maxTSMSz, "Maximum size of individual TSM files.")
-	fs.BoolVar(&opts.Parallel, "parallel", false, "Perform parallel conversion. (up to GOMAXPROCS shards at once)")
-	fs.BoolVar(&opts.SkipBackup, "nobackup", false, "Disable database backups. Not recommended.")
+	fs.StringVar(&opts.ShardsFrom, "from-file", "", "Convert only the shards whose paths are listed in this file, or on stdin if -, one per line, instead of every shard of the data directory. Cannot be used with -dbs.")
+	fs.StringVar(&sz, "sz", formatSize(maxTSMSz), "Maximum size of individual TSM files, in bytes or with a k, m or g suffix, such as 512m.")
+	fs.BoolVar(&opts.Parallel, "parallel", false, "Perform parallel conversion. (up to -workers shards at once)")
+	fs.IntVar(&opts.Workers, "workers", 0, "Number of shards converted at once with -parallel. Default is GOMAXPROCS.")
+	fs.BoolVar(&opts.GroupByRP, "group-by-rp", false, "Convert the shards one retention policy at a time, logging a header before and a summary after each. Cannot be used with -parallel-databases.")
+	fs.IntVar(&opts.SeriesWorkers, "series-workers", 0, "Number of blocks of a shard's series encoded at once. Default is GOMAXPROCS divided by the number of shards converted at once.")
+	fs.IntVar(&opts.ParallelDBs, "parallel-databases", 0, "Convert up to this many databases at once, backing up and converting each database's shards one at a time. Cannot be used with -parallel.")
+	fs.BoolVar(&opts.SkipBackup, "no-backup", false, "Disable database backups, for nodes already backed up by other means. Not recommended.")
+	fs.BoolVar(&opts.SkipBackup, "nobackup", false, "Same as -no-backup.")
+	fs.BoolVar(&opts.Resume, "resume", false, "Before converting, finish or clean up the output of an interrupted conversion.")
 	fs.StringVar(&opts.BackupPath, "backup", "", "The location to backup up the current databases. Must not be within the data directory.")
+	fs.StringVar(&opts.BackupPath, "backup-dir", "", "Same as -backup.")
 	fs.StringVar(&opts.DebugAddr, "debug", "", "If set, http debugging endpoints will be enabled on the given address")
 	fs.DurationVar(&opts.UpdateInterval, "interval", 5*time.Second, "How often status updates are printed.")
 	fs.BoolVar(&opts.Yes, "y", false, "Don't ask, just convert")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "Don't log progress while converting, for scripted runs.")
 	fs.StringVar(&opts.CPUFile, "profile", "", "CPU Profile location")
+	fs.StringVar(&since, "since", "", "Only convert points at or after this RFC3339 time, or this long ago, such as 720h. b1 and bz1 shards with no points since then are not converted. Default is no lower bound.")
+	fs.StringVar(&until, "until", "", "Only convert points at or before this RFC3339 time. Default is no upper bound.")
+	fs.StringVar(&opts.ManifestPath, "manifest", "", "If set, a digest of each converted shard is written to this file.")
+	fs.StringVar(&opts.ReportPath, "report", "", "If set, a JSON report of the conversion of each shard, with its sizes, TSM files, points and duration, is written to this file.")
+	fs.BoolVar(&opts.Recompact, "recompact", false, "Also convert tsm1 shards with more than one TSM file or with tombstones, re-compacting them.")
+	fs.BoolVar(&opts.RecompactAll, "recompact-all", false, "Also convert every tsm1 shard, even those already fully compacted. Implies -recompact.")
+	fs.BoolVar(&opts.Force, "force", false, "Convert even if another process holds the lock on the data directory.")
+	fs.BoolVar(&opts.SkipSpaceCheck, "skip-space-check", false, "Back up databases even if the backup directory appears to lack the space for them.")
+	fs.BoolVar(&opts.Verify, "verify", false, "Read each shard again after conversion and compare it point by point with the converted shard.")
+	fs.Float64Var(&opts.VerifyRate, "verify-sample-rate", 1, "Fraction of series compared by -verify, from 0 to 1. Series are selected deterministically from -verify-seed.")
+	fs.Int64Var(&opts.VerifySeed, "verify-seed", 0, "Seed selecting the series compared when -verify-sample-rate is less than 1.")
+	fs.IntVar(&opts.KeepBackupDays, "keep-backup-days", 0, "After a successful run, remove backups of conversions verified more than this many days ago. Requires -verify. Default is to keep backups indefinitely.")
+	fs.StringVar(&opts.LogFormat, "log-format", textLogFormat, "Format of log messages, text or json.")
+	fs.StringVar(&opts.LinePath, "to-line", "", "Write the shards as line protocol to this file, or to stdout if -, instead of converting them. The shards are left unchanged.")
+	fs.StringVar(&rpRenames, "rp-rename", "", "Comma-delimited list of FROM=TO retention policy renames. Converted shards are written under the TO retention policy.")
+	fs.IntVar(&opts.RetryAttempts, "retry-attempts", defaultRetryAttempts, "Number of times a file operation on a shard is attempted when it fails with a transient error, such as EAGAIN or EBUSY on networked storage.")
+	fs.DurationVar(&opts.RetryBackoff, "retry-backoff", defaultRetryBackoff, "Wait before retrying a file operation that failed with a transient error. The wait doubles with each retry.")
+	registerFaultFlags(fs)
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %v [options] <data-path> \n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "%v\n\nOptions:\n", description)
@@ -85,10 +151,16 @@ func (o *options) Parse() error {
 		return err
 	}
 
+	l, err := NewLogger(os.Stderr, o.LogFormat)
+	if err != nil {
+		return err
+	}
+	logger = l
+	bz1.Warnf = func(format string, v ...interface{}) { logger.Warn(nil, format, v...) }
+
 	if len(fs.Args()) < 1 {
 		return errors.New("no data directory specified")
 	}
-	var err error
 	if o.DataPath, err = filepath.Abs(fs.Args()[0]); err != nil {
 		return err
 	}
@@ -96,8 +168,83 @@ func (o *options) Parse() error {
 		return err
 	}
 
-	if o.TSMSize > maxTSMSz {
-		return fmt.Errorf("bad TSM file size, maximum TSM file size is %d", maxTSMSz)
+	if o.Workers < 0 {
+		return errors.New("-workers must not be negative")
+	} else if o.Workers > 0 && !o.Parallel {
+		return errors.New("-workers requires -parallel")
+	}
+
+	if o.SeriesWorkers < 0 {
+		return errors.New("-series-workers must not be negative")
+	}
+
+	if o.RetryAttempts < 1 {
+		return errors.New("-retry-attempts must be at least 1")
+	} else if o.RetryBackoff < 0 {
+		return errors.New("-retry-backoff must not be negative")
+	}
+
+	if o.ParallelDBs < 0 {
+		return errors.New("-parallel-databases must not be negative")
+	} else if o.ParallelDBs > 0 && o.Parallel {
+		return errors.New("-parallel and -parallel-databases cannot be used together")
+	} else if o.ParallelDBs > 0 && o.GroupByRP {
+		return errors.New("-group-by-rp and -parallel-databases cannot be used together")
+	}
+
+	if o.TSMSize, err = parseSize(sz); err != nil {
+		return err
+	} else if o.TSMSize > maxTSMSz {
+		return fmt.Errorf("bad TSM file size, maximum TSM file size is %s", formatSize(maxTSMSz))
+	}
+
+	if since != "" {
+		if o.Since, err = parseSince(since, time.Now()); err != nil {
+			return fmt.Errorf("invalid -since time: %v", err)
+		}
+	}
+	if until != "" {
+		if o.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return fmt.Errorf("invalid -until time: %v", err)
+		}
+	}
+	if !o.Since.IsZero() && !o.Until.IsZero() && o.Until.Before(o.Since) {
+		return errors.New("-until must not be before -since")
+	}
+
+	if o.RecompactAll {
+		o.Recompact = true
+	}
+
+	if o.VerifyRate <= 0 || o.VerifyRate > 1 {
+		return errors.New("-verify-sample-rate must be greater than 0 and at most 1")
+	} else if o.VerifyRate < 1 && !o.Verify {
+		return errors.New("-verify-sample-rate requires -verify")
+	}
+
+	if o.KeepBackupDays < 0 {
+		return errors.New("-keep-backup-days must not be negative")
+	} else if o.KeepBackupDays > 0 && !o.Verify {
+		return errors.New("-keep-backup-days requires -verify")
+	} else if o.KeepBackupDays > 0 && o.SkipBackup {
+		return errors.New("-keep-backup-days cannot be used with -no-backup")
+	}
+
+	if o.RPRenames, err = parseRPRenames(rpRenames); err != nil {
+		return err
+	}
+
+	// Writing line protocol leaves the shards alone, so there is nothing to
+	// back up, run in parallel or verify.
+	if o.LinePath != "" {
+		switch {
+		case o.BackupPath != "" || o.SkipBackup:
+			return errors.New("-to-line cannot be used with -backup or -no-backup, the shards are not changed")
+		case o.Parallel || o.ParallelDBs > 0:
+			return errors.New("-to-line cannot be used with -parallel or -parallel-databases")
+		case o.Resume || o.Verify || o.ManifestPath != "" || o.ReportPath != "" || o.Recompact || o.GroupByRP:
+			return errors.New("-to-line cannot be used with -resume, -verify, -manifest, -report, -recompact or -group-by-rp")
+		}
 	}
 
 	// Check if specific databases were requested.
@@ -106,9 +253,17 @@ func (o *options) Parse() error {
 		o.DBs = nil
 	}
 
-	if !o.SkipBackup {
+	if o.ShardsFrom != "" {
+		if o.DBs != nil {
+			return errors.New("-from-file cannot be used with -dbs, list only the shards to convert")
+		} else if o.ShardsFrom == "-" && !o.Yes {
+			return errors.New("-from-file - requires -y, stdin cannot be read for both the shards and confirmation")
+		}
+	}
+
+	if !o.SkipBackup && o.LinePath == "" {
 		if o.BackupPath == "" {
-			return errors.New("either -nobackup or -backup DIR must be set")
+			return errors.New("either -no-backup or -backup DIR must be set")
 		}
 		if o.BackupPath, err = filepath.Abs(o.BackupPath); err != nil {
 			return err
@@ -120,40 +275,184 @@ func (o *options) Parse() error {
 			return err
 		}
 
-		if strings.HasPrefix(o.BackupPath, o.DataPath) {
-			fmt.Println(o.BackupPath, o.DataPath)
-			return errors.New("backup directory cannot be contained within data directory")
+		if within(o.BackupPath, o.DataPath) {
+			return fmt.Errorf("backup directory %v cannot be contained within data directory %v", o.BackupPath, o.DataPath)
 		}
 	}
 
 	if o.DebugAddr != "" {
-		log.Printf("Starting debugging server on http://%v", o.DebugAddr)
+		logger.Info(nil, "Starting debugging server on http://%v", o.DebugAddr)
 		go func() {
-			log.Fatal(http.ListenAndServe(o.DebugAddr, nil))
+			logger.Fatal(nil, "Debugging server failed: %v", http.ListenAndServe(o.DebugAddr, nil))
 		}()
 	}
 
 	return nil
 }
 
-var opts options
+// parseRPRenames parses a comma-delimited list of FROM=TO retention policy renames.
+func parseRPRenames(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
 
-const maxTSMSz uint64 = 2 * 1024 * 1024 * 1024
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		a := strings.SplitN(pair, "=", 2)
+		if len(a) != 2 || a[0] == "" || a[1] == "" {
+			return nil, fmt.Errorf("invalid -rp-rename %q, expected FROM=TO", pair)
+		} else if a[0] == a[1] {
+			return nil, fmt.Errorf("invalid -rp-rename %q, retention policies must differ", pair)
+		} else if _, ok := m[a[0]]; ok {
+			return nil, fmt.Errorf("invalid -rp-rename, %q renamed more than once", a[0])
+		}
+		m[a[0]] = a[1]
+	}
+	return m, nil
+}
+
+// parseSince parses a time given to -since, either in RFC3339 format or as a
+// duration before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC3339 time nor a duration", s)
+	} else if d <= 0 {
+		return time.Time{}, fmt.Errorf("duration %v must be positive", d)
+	}
+	return now.Add(-d), nil
+}
 
-func init() {
-	log.SetOutput(os.Stderr)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+// parseSize parses a size given to -sz, in bytes or with a k, m or g suffix
+// for kibibytes, mebibytes or gibibytes. Suffixes are case-insensitive.
+func parseSize(s string) (uint64, error) {
+	num, mult := strings.ToLower(s), uint64(1)
+	switch {
+	case strings.HasSuffix(num, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(num, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(num, "g"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = num[:len(num)-1]
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n > math.MaxUint64/mult {
+		return 0, fmt.Errorf("invalid -sz %q, expected a number of bytes optionally followed by k, m or g, such as 512m", s)
+	}
+	return n * mult, nil
+}
+
+// formatSize returns n in the largest unit of parseSize that it is a whole
+// multiple of.
+func formatSize(n uint64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n%(1<<30) == 0:
+		return strconv.FormatUint(n>>30, 10) + "g"
+	case n%(1<<20) == 0:
+		return strconv.FormatUint(n>>20, 10) + "m"
+	case n%(1<<10) == 0:
+		return strconv.FormatUint(n>>10, 10) + "k"
+	default:
+		return strconv.FormatUint(n, 10)
+	}
 }
 
+// targetPath returns the path the converted shard will be written to, taking
+// any retention policy rename into account.
+func (o *options) targetPath(si *tsdb.ShardInfo) string {
+	return filepath.Join(o.DataPath, si.Database, o.retentionPolicy(si), si.Path)
+}
+
+// retentionPolicy returns the retention policy the shard si is converted
+// into, taking any retention policy rename into account.
+func (o *options) retentionPolicy(si *tsdb.ShardInfo) string {
+	if to, ok := o.RPRenames[si.RetentionPolicy]; ok {
+		return to
+	}
+	return si.RetentionPolicy
+}
+
+// workers returns the number of shards converted, or databases backed up, at
+// once. Without -parallel, shards are converted one at a time.
+func (o *options) workers() int {
+	switch {
+	case !o.Parallel:
+		return 1
+	case o.Workers > 0:
+		return o.Workers
+	default:
+		return runtime.GOMAXPROCS(0)
+	}
+}
+
+// seriesWorkers returns the number of blocks of a shard encoded at once. By
+// default the CPUs are shared between the shards converted at once.
+func (o *options) seriesWorkers() int {
+	if o.SeriesWorkers > 0 {
+		return o.SeriesWorkers
+	}
+	shards := o.workers()
+	if o.ParallelDBs > 0 {
+		shards = o.ParallelDBs
+	}
+	if n := runtime.GOMAXPROCS(0) / shards; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// timeRange returns the requested time range in nanoseconds, defaulting to
+// the widest possible range for any unset bound.
+func (o *options) timeRange() (min, max int64) {
+	min, max = math.MinInt64, math.MaxInt64
+	if !o.Since.IsZero() {
+		min = o.Since.UnixNano()
+	}
+	if !o.Until.IsZero() {
+		max = o.Until.UnixNano()
+	}
+	return min, max
+}
+
+var opts options
+
+const maxTSMSz uint64 = 2 * 1024 * 1024 * 1024
+
 func main() {
 	if err := opts.Parse(); err != nil {
-		log.Fatal(err)
+		logger.Fatal(nil, "%v", err)
+	}
+
+	// With -to-line -, stdout carries the line protocol, so everything else
+	// is printed to stderr.
+	stdout := os.Stdout
+	if opts.LinePath == "-" {
+		os.Stdout = os.Stderr
+	}
+
+	// Keep other tools off the data while it is converted. The lock is
+	// released by the system if the run fails, so it is never left behind.
+	lock, err := influxtsdb.LockDir(opts.DataPath, opts.Force)
+	if _, ok := err.(*influxtsdb.LockedError); ok {
+		logger.Fatal(nil, "%v, or use -force", err)
+	} else if err != nil {
+		logger.Fatal(nil, "failed to lock data directory at %v: %v", opts.DataPath, err)
 	}
+	defer lock.Unlock()
 
 	// Determine the list of databases
 	dbs, err := ioutil.ReadDir(opts.DataPath)
 	if err != nil {
-		log.Fatalf("failed to access data directory at %v: %v\n", opts.DataPath, err)
+		logger.Fatal(nil, "failed to access data directory at %v: %v", opts.DataPath, err)
 	}
 	fmt.Println() // Cleanly separate output from start of program.
 
@@ -175,14 +474,34 @@ func main() {
 	fmt.Println("b1 and bz1 shard conversion.")
 	fmt.Println("-----------------------------------")
 	fmt.Println("Data directory is:                 ", opts.DataPath)
-	if !opts.SkipBackup {
+	if !opts.SkipBackup && opts.LinePath == "" {
 		fmt.Println("Backup directory is:               ", opts.BackupPath)
 	}
 	fmt.Println("Databases specified:               ", allDBs(opts.DBs))
-	fmt.Println("Database backups enabled:          ", yesno(!opts.SkipBackup), badUser)
-	fmt.Printf("Parallel mode enabled (GOMAXPROCS): %s (%d)\n", yesno(opts.Parallel), runtime.GOMAXPROCS(0))
+	fmt.Println("Shards listed in:                  ", shardsFrom(opts.ShardsFrom))
+	fmt.Println("Database backups enabled:          ", yesno(!opts.SkipBackup && opts.LinePath == ""), badUser)
+	fmt.Println("Line protocol output:              ", linePath(opts.LinePath))
+	fmt.Printf("Parallel mode enabled (workers):    %s (%d)\n", yesno(opts.Parallel), opts.workers())
+	fmt.Println("Parallel databases:                ", parallelDBs(opts.ParallelDBs))
+	fmt.Println("Series workers per shard:          ", opts.seriesWorkers())
+	fmt.Println("Group by retention policy:         ", yesno(opts.GroupByRP))
+	fmt.Println("Time range:                        ", timeRange(opts.Since, opts.Until))
+	fmt.Println("Retention policy renames:          ", rpRenames(opts.RPRenames))
+	fmt.Println("Digest manifest:                   ", manifestPath(opts.ManifestPath))
+	fmt.Println("Conversion report:                 ", manifestPath(opts.ReportPath))
+	fmt.Println("Re-compact tsm1 shards:            ", recompact(opts.Recompact, opts.RecompactAll))
+	fmt.Println("Resume interrupted conversions:    ", yesno(opts.Resume))
+	fmt.Println("Verify conversions:                ", verify(opts.Verify, opts.VerifyRate, opts.VerifySeed))
+	fmt.Println("Remove verified backups after:     ", keepBackupDays(opts.KeepBackupDays))
 	fmt.Println()
 
+	if opts.SkipBackup {
+		fmt.Println(noBackupWarning)
+		if !opts.Yes && !confirm("Convert without a backup?") {
+			logger.Fatal(nil, "Conversion aborted.")
+		}
+	}
+
 	shards := collectShards(dbs)
 
 	// Anything to convert?
@@ -192,6 +511,19 @@ func main() {
 		return
 	}
 
+	if opts.LinePath == "" {
+		if err := checkTargetPaths(shards); err != nil {
+			logger.Fatal(nil, "%v", err)
+		}
+
+		// Fail before copying anything rather than partway through a backup.
+		if !opts.SkipBackup && !opts.SkipSpaceCheck {
+			if err := checkBackupSpace(shards); err != nil {
+				logger.Fatal(nil, "%v", err)
+			}
+		}
+	}
+
 	// Display list of convertible shards.
 	fmt.Println()
 	w := new(tabwriter.Writer)
@@ -202,65 +534,317 @@ func main() {
 	}
 	w.Flush()
 
-	if !opts.Yes {
-		// Get confirmation from user.
-		fmt.Printf("\nThese shards will be converted. Proceed? y/N: ")
-		liner := bufio.NewReader(os.Stdin)
-		yn, err := liner.ReadString('\n')
-		if err != nil {
-			log.Fatalf("failed to read response: %v", err)
+	prompt := "\nThese shards will be converted. Proceed?"
+	if opts.LinePath != "" {
+		prompt = "\nThese shards will be written as line protocol. Proceed?"
+	}
+	if !opts.Yes && !confirm(prompt) {
+		logger.Fatal(nil, "Conversion aborted.")
+	}
+
+	if opts.LinePath != "" {
+		if err := runLines(shards, stdout); err != nil {
+			logger.Fatal(nil, "Error occurred preventing completion: %v", err)
 		}
-		yn = strings.TrimRight(strings.ToLower(yn), "\n")
-		if yn != "y" {
-			log.Fatal("Conversion aborted.")
+		return
+	}
+	logger.Info(Fields{"event": "conversion_started", "shards": len(shards)}, "Conversion starting....")
+
+	if opts.Resume {
+		if err := resumeConversions(listShards(dbs)); err != nil {
+			logger.Fatal(nil, "Failed to resume conversion: %v", err)
 		}
 	}
-	fmt.Println("Conversion starting....")
 
 	if opts.CPUFile != "" {
 		f, err := os.Create(opts.CPUFile)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal(nil, "%v", err)
 		}
 		if err = pprof.StartCPUProfile(f); err != nil {
-			log.Fatal(err)
+			logger.Fatal(nil, "%v", err)
 		}
 		defer pprof.StopCPUProfile()
 	}
 
 	tr := newTracker(shards, opts)
+	go stopOnSignal(tr)
 
 	if err := tr.Run(); err != nil {
-		log.Fatalf("Error occurred preventing completion: %v\n", err)
+		logger.Fatal(nil, "Error occurred preventing completion: %v", err)
 	}
 
 	tr.PrintStats()
+	if !tr.stopped() {
+		logger.Info(tr.statsFields().with(Fields{"event": "conversion_completed"}), "Conversion completed (%v)", tr.Stats.TotalTime)
+	}
+
+	if opts.ManifestPath != "" {
+		if err := tr.manifest.WriteFile(opts.ManifestPath); err != nil {
+			logger.Fatal(nil, "Failed to write manifest %v: %v", opts.ManifestPath, err)
+		}
+		logger.Info(nil, "Digest manifest written to %v", opts.ManifestPath)
+	}
+	if opts.ReportPath != "" {
+		if err := tr.report.WriteFile(opts.ReportPath, tr.Stats.TotalTime); err != nil {
+			logger.Fatal(nil, "Failed to write report %v: %v", opts.ReportPath, err)
+		}
+		logger.Info(nil, "Conversion report written to %v", opts.ReportPath)
+	}
+
+	if tr.stopped() {
+		n := atomic.LoadUint64(&tr.converted)
+		logger.Fatal(tr.statsFields().with(Fields{"event": "conversion_interrupted", "converted_shards": n}), "Conversion interrupted: %d of %d shards converted, run again to convert the rest", n, len(shards))
+	}
+
+	if len(tr.failed) > 0 {
+		logger.Fatal(Fields{"event": "conversion_failed", "databases": tr.failed}, "Conversion failed for databases: %v", strings.Join(tr.failed, ", "))
+	}
+
+	// Every shard converted and verified, so older backups can go.
+	if opts.KeepBackupDays > 0 {
+		cleanupBackups()
+	}
+}
+
+// stopOnSignal stops the conversion of tr once the process is interrupted,
+// letting the shards being converted finish. A second interrupt exits
+// immediately, leaving partial output for -resume to clean up.
+func stopOnSignal(tr *tracker) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	<-c
+	running := tr.Running()
+	logger.Warn(Fields{"event": "conversion_stopping", "shards": running}, "Interrupted, finishing the shards being converted: %v. Interrupt again to exit immediately.", strings.Join(running, ", "))
+	tr.Stop()
+
+	<-c
+	running = tr.Running()
+	logger.Fatal(Fields{"event": "conversion_aborted", "shards": running}, "Conversion aborted while converting: %v. Run again with -resume to clean up their output.", strings.Join(running, ", "))
 }
 
 func collectShards(dbs []os.FileInfo) tsdb.ShardInfos {
 	// Get the list of shards for conversion.
+	var shards tsdb.ShardInfos
+	if opts.ShardsFrom != "" {
+		shards = listedShards(opts.ShardsFrom)
+	} else {
+		shards = listShards(dbs)
+	}
+	if opts.Recompact {
+		shards = filterCompacted(shards)
+	} else {
+		shards = shards.FilterFormat(tsdb.TSM1)
+	}
+	if !opts.Since.IsZero() {
+		shards = filterSince(shards)
+	}
+	return shards
+}
+
+// listShards returns every shard of the databases dbs selected by -dbs, in
+// any format, including the output of earlier conversions.
+func listShards(dbs []os.FileInfo) tsdb.ShardInfos {
 	var shards tsdb.ShardInfos
 	for _, db := range dbs {
+		if !db.IsDir() {
+			continue
+		}
 		d := tsdb.NewDatabase(filepath.Join(opts.DataPath, db.Name()))
 		shs, err := d.Shards()
 		if err != nil {
-			log.Fatalf("Failed to access shards for database %v: %v\n", d.Name(), err)
+			logger.Fatal(Fields{"database": d.Name()}, "Failed to access shards for database %v: %v", d.Name(), err)
 		}
 		shards = append(shards, shs...)
 	}
 
 	sort.Sort(shards)
-	shards = shards.FilterFormat(tsdb.TSM1)
-	if len(dbs) > 0 {
-		shards = shards.ExclusiveDatabases(opts.DBs)
+	return shards.ExclusiveDatabases(opts.DBs)
+}
+
+// listedShards returns the shards listed in the file at path by -from-file,
+// or on stdin if path is -.
+func listedShards(path string) tsdb.ShardInfos {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			logger.Fatal(nil, "Failed to open shard list: %v", err)
+		}
+		defer f.Close()
+		r = f
 	}
 
+	shards, err := readShardList(r)
+	if err != nil {
+		logger.Fatal(nil, "Failed to read shard list %v: %v", path, err)
+	}
 	return shards
 }
 
-// backupDatabase backs up the database named db
-func backupDatabase(db string) error {
+// readShardList returns the shards whose paths are listed in r, one per line.
+// A path is absolute or relative to the data directory, and must be that of
+// a shard in it, such as /var/lib/influxdb/data/db0/default/1. Blank lines
+// and lines starting with # are ignored, as are repeated shards.
+func readShardList(r io.Reader) (tsdb.ShardInfos, error) {
+	var shards tsdb.ShardInfos
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		path := line
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(opts.DataPath, path)
+		}
+		// The data path has its symlinks resolved, so the shard's must be too.
+		if p, err := filepath.EvalSymlinks(path); err == nil {
+			path = p
+		}
+
+		rel, err := filepath.Rel(opts.DataPath, path)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		if len(parts) != 3 || parts[0] == ".." {
+			return nil, fmt.Errorf("line %d: %v is not a shard in %v", n, line, opts.DataPath)
+		} else if seen[rel] {
+			continue
+		}
+		seen[rel] = true
+
+		si, err := tsdb.NewDatabase(filepath.Join(opts.DataPath, parts[0])).Shard(parts[1], parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		shards = append(shards, si)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(shards)
+	return shards, nil
+}
+
+// filterSince returns a copy of shards without the b1 and bz1 shards whose
+// newest point is before -since, which would convert to empty shards. Their
+// newest point is read from the last block of each series, so no points are
+// decoded. tsm1 shards are kept.
+func filterSince(shards tsdb.ShardInfos) tsdb.ShardInfos {
+	since := opts.Since.UnixNano()
+	var a tsdb.ShardInfos
+	for _, si := range shards {
+		path := si.FullPath(opts.DataPath)
+
+		var max int64
+		var ok bool
+		var err error
+		switch si.Format {
+		case tsdb.B1:
+			max, ok, err = b1.MaxTime(path)
+		case tsdb.BZ1:
+			max, ok, err = bz1.MaxTime(path)
+		default:
+			a = append(a, si)
+			continue
+		}
+		if err != nil {
+			logger.Fatal(shardFields(si), "Failed to inspect shard %v: %v", path, err)
+		}
+
+		if ok && max < since {
+			logger.Info(shardFields(si).with(Fields{"event": "shard_skipped", "max_time": time.Unix(0, max).UTC()}), "Shard %v skipped, its newest point at %v is before -since", path, time.Unix(0, max).UTC().Format(time.RFC3339))
+			continue
+		}
+		a = append(a, si)
+	}
+	return a
+}
+
+// filterCompacted returns a copy of shards without the tsm1 shards that are
+// already fully compacted, unless all tsm1 shards are to be re-compacted.
+func filterCompacted(shards tsdb.ShardInfos) tsdb.ShardInfos {
+	var a tsdb.ShardInfos
+	for _, si := range shards {
+		if si.Format != tsdb.TSM1 {
+			a = append(a, si)
+			continue
+		}
+
+		// Skip the output of an earlier, interrupted conversion.
+		if strings.HasSuffix(si.Path, "."+tsmExt) {
+			continue
+		}
+
+		if !opts.RecompactAll {
+			ok, reason, err := tsm1.NeedsCompaction(si.FullPath(opts.DataPath))
+			if err != nil {
+				logger.Fatal(shardFields(si), "Failed to inspect shard %v: %v", si.FullPath(opts.DataPath), err)
+			} else if !ok {
+				continue
+			}
+			logger.Info(shardFields(si).with(Fields{"reason": reason}), "Shard %v will be re-compacted: %v", si.FullPath(opts.DataPath), reason)
+		}
+		a = append(a, si)
+	}
+	return a
+}
+
+// checkTargetPaths ensures no converted shard will be written over an
+// existing shard, or over another converted shard, due to a retention policy
+// rename.
+func checkTargetPaths(shards tsdb.ShardInfos) error {
+	seen := make(map[string]string)
+	for _, si := range shards {
+		src, dst := si.FullPath(opts.DataPath), opts.targetPath(si)
+		if src == dst {
+			continue
+		}
+
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("cannot move %v to %v: shard %v already exists in retention policy %v", src, dst, si.Path, filepath.Base(filepath.Dir(dst)))
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if other, ok := seen[dst]; ok {
+			return fmt.Errorf("cannot move both %v and %v to %v", other, src, dst)
+		}
+		seen[dst] = src
+	}
+	return nil
+}
+
+// within returns whether path is dir or is contained in it. Both must be
+// clean absolute paths.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// backupDatabase backs up the shards of the database named db that are to be
+// converted by copying their files. Shards an earlier run already converted
+// are left out, so rerunning an interrupted conversion only copies what is
+// left to convert. The files are copied as they are on disk, so influxd must
+// be stopped before influx_tsm is run: influxd does not take the lock on the
+// data directory held by influx_tsm. The b1 and bz1 shards being converted
+// can not be opened by a tsdb.Store, so they can not be copied with
+// Store.CopyShard.
+func backupDatabase(db string, shards tsdb.ShardInfos) error {
 	copyFile := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
 		// Strip the DataPath from the path and replace with BackupPath.
 		toPath := strings.Replace(path, opts.DataPath, opts.BackupPath, 1)
 
@@ -291,12 +875,12 @@ func backupDatabase(db string) error {
 		}
 
 		if dstInfo.Size() == srcInfo.Size() {
-			log.Printf("Backup file already found for %v with correct size, skipping.", path)
+			logger.Info(Fields{"path": path}, "Backup file already found for %v with correct size, skipping.", path)
 			return nil
 		}
 
 		if dstInfo.Size() > srcInfo.Size() {
-			log.Printf("Invalid backup file found for %v, replacing with good copy.", path)
+			logger.Warn(Fields{"path": path}, "Invalid backup file found for %v, replacing with good copy.", path)
 			if err := out.Truncate(0); err != nil {
 				return err
 			}
@@ -306,7 +890,7 @@ func backupDatabase(db string) error {
 		}
 
 		if dstInfo.Size() > 0 {
-			log.Printf("Resuming backup of file %v, starting at %v bytes", path, dstInfo.Size())
+			logger.Info(Fields{"path": path, "offset": dstInfo.Size()}, "Resuming backup of file %v, starting at %v bytes", path, dstInfo.Size())
 		}
 
 		off, err := out.Seek(0, os.SEEK_END)
@@ -317,30 +901,64 @@ func backupDatabase(db string) error {
 			return err
 		}
 
-		log.Printf("Backing up file %v", path)
+		logger.Info(Fields{"path": path}, "Backing up file %v", path)
 
 		_, err = io.Copy(out, in)
 
 		return err
 	}
 
-	return filepath.Walk(filepath.Join(opts.DataPath, db), copyFile)
+	for _, si := range shards {
+		if si.Database != db {
+			continue
+		}
+
+		// A shard whose source was deleted once its output was complete
+		// has nothing left to back up.
+		src := si.FullPath(opts.DataPath)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+
+		rp, err := os.Stat(filepath.Dir(src))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(backupPath(si)), rp.Mode()); err != nil {
+			return err
+		}
+		if err := filepath.Walk(src, copyFile); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// convertShard converts the shard in-place.
-func convertShard(si *tsdb.ShardInfo, tr *tracker) error {
+// convertShard converts the shard in-place. If an earlier conversion of the
+// shard left complete output behind, the output is moved into place rather
+// than converting the shard again.
+func convertShard(si *tsdb.ShardInfo, tr *tracker, r *shardReport) error {
 	src := si.FullPath(opts.DataPath)
 	dst := fmt.Sprintf("%v.%v", src, tsmExt)
+	min, max := opts.timeRange()
+
+	skip, reason := checkConverted(si, dst, min, max)
+	if skip {
+		logger.Info(shardFields(si).with(Fields{"event": "shard_resumed", "output": dst}), "Skipping conversion of %v, output of an earlier conversion at %v is complete", src, dst)
+		r.Status = shardResumed
+		return finishShard(si, dst, tr, nil)
+	} else if reason != "" {
+		logger.Warn(shardFields(si).with(Fields{"output": dst, "reason": reason}), "Re-converting %v, discarding output of an earlier conversion at %v: %v", src, dst, reason)
+		if err := retryFS("Removal", dst, func() error { return os.RemoveAll(dst) }); err != nil {
+			return fmt.Errorf("Removal of %v failed: %v", dst, err)
+		}
+	}
 
-	var reader ShardReader
-	switch si.Format {
-	case tsdb.BZ1:
-		reader = bz1.NewReader(src, &tr.Stats, 0)
-	case tsdb.B1:
-		reader = b1.NewReader(src, &tr.Stats, 0)
-	default:
-		return fmt.Errorf("Unsupported shard format: %v", si.FormatAsString())
+	reader, err := newShardReader(si, src, &tr.Stats)
+	if err != nil {
+		return err
 	}
+	reader.SetTimeRange(min, max)
 
 	// Open the shard, and create a converter.
 	if err := reader.Open(); err != nil {
@@ -348,27 +966,133 @@ func convertShard(si *tsdb.ShardInfo, tr *tracker) error {
 	}
 	defer reader.Close()
 	converter := NewConverter(dst, uint32(opts.TSMSize), &tr.Stats)
+	converter.workers = opts.seriesWorkers()
+	if tr.manifest != nil {
+		converter.digest = new(Digest)
+	}
+	if !opts.Quiet {
+		converter.progress = func(points, bytes uint64) {
+			logger.Info(shardFields(si).with(Fields{"event": "shard_progress", "points_written": points, "bytes_written": bytes}), "Converting %v: %d points, %d bytes written", src, points, bytes)
+		}
+		converter.interval = opts.UpdateInterval
+	}
 
 	// Perform the conversion.
 	if err := converter.Process(reader); err != nil {
 		return fmt.Errorf("Conversion of %v failed: %v", src, err)
 	}
+	logger.Info(shardFields(si).with(Fields{"event": "shard_files", "tsm_files": converter.TSMFiles()}), "Shard %v produced %d TSM files", src, converter.TSMFiles())
+	r.PointsWritten = converter.PointsWritten()
+	if err := tr.injectFault(si); err != nil {
+		return fmt.Errorf("Conversion of %v failed: %v", src, err)
+	}
+
+	// Record that the output is complete, so a retry need not convert the
+	// shard again.
+	cp, err := newCheckpoint(si, min, max, dst)
+	if err != nil {
+		return fmt.Errorf("Checkpoint of %v failed: %v", dst, err)
+	}
+	if err := retryFS("Checkpoint", dst, func() error { return cp.WriteFile(dst) }); err != nil {
+		return fmt.Errorf("Checkpoint of %v failed: %v", dst, err)
+	}
 
-	// Delete source shard, and rename new tsm1 shard.
 	if err := reader.Close(); err != nil {
 		return fmt.Errorf("Conversion of %v failed due to close: %v", src, err)
 	}
+	return finishShard(si, dst, tr, converter.digest)
+}
+
+// newShardReader returns a reader for the shard si at path.
+func newShardReader(si *tsdb.ShardInfo, path string, stats *stats.Stats) (ShardReader, error) {
+	switch si.Format {
+	case tsdb.BZ1:
+		return bz1.NewReader(path, stats, 0), nil
+	case tsdb.B1:
+		return b1.NewReader(path, stats, 0), nil
+	case tsdb.TSM1:
+		return tsm1.NewReader(path, stats, 0), nil
+	default:
+		return nil, fmt.Errorf("Unsupported shard format: %v", si.FormatAsString())
+	}
+}
+
+// finishShard deletes the source shard and moves its converted output at dst
+// into place, once the output is verified if requested. The digest of the
+// output is recorded in the manifest, read from the output if d is nil.
+func finishShard(si *tsdb.ShardInfo, dst string, tr *tracker, d *Digest) error {
+	src := si.FullPath(opts.DataPath)
+	if opts.Verify {
+		min, max := opts.timeRange()
+		if err := verifyShard(si, dst, min, max, tr.sampler); err != nil {
+			return fmt.Errorf("Verification of %v failed, converted shard remains at %v: %v", src, dst, err)
+		}
+		if !opts.SkipBackup {
+			if err := markVerified(backupPath(si)); err != nil {
+				return fmt.Errorf("Marking backup of %v as verified failed: %v", src, err)
+			}
+		}
+		logger.Info(shardFields(si).with(Fields{"event": "shard_verified"}), "Conversion of %v verified", src)
+	}
+
+	if tr.manifest != nil && d == nil {
+		digest, err := digestShard(dst)
+		if err != nil {
+			return fmt.Errorf("Digest of %v failed: %v", dst, err)
+		}
+		d = &digest
+	}
 
-	if err := os.RemoveAll(si.FullPath(opts.DataPath)); err != nil {
+	if err := retryFS("Deletion", src, func() error { return os.RemoveAll(src) }); err != nil {
 		return fmt.Errorf("Deletion of %v failed: %v", src, err)
 	}
-	if err := os.Rename(dst, src); err != nil {
-		return fmt.Errorf("Rename of %v to %v failed: %v", dst, src, err)
+	target := opts.targetPath(si)
+	if err := retryFS("Creation", filepath.Dir(target), func() error { return os.MkdirAll(filepath.Dir(target), 0777) }); err != nil {
+		return fmt.Errorf("Creation of %v failed: %v", filepath.Dir(target), err)
+	}
+	if err := moveDir(osFileSystem{}, dst, target); err != nil {
+		return fmt.Errorf("Rename of %v to %v failed, converted shard remains at %v: %v", dst, target, dst, err)
 	}
 
+	if tr.manifest != nil {
+		rel, err := filepath.Rel(opts.DataPath, target)
+		if err != nil {
+			return err
+		}
+		tr.manifest.Set(rel, *d)
+	}
+
+	// The checkpoint is no longer needed once the shard is in place.
+	if err := os.Remove(filepath.Join(target, checkpointName)); err != nil && !os.IsNotExist(err) {
+		logger.Warn(shardFields(si).with(Fields{"error": err}), "Removal of checkpoint in %v failed: %v", target, err)
+	}
 	return nil
 }
 
+// noBackupWarning is printed before converting with -no-backup.
+const noBackupWarning = `**********************************************************************
+WARNING: Database backups are disabled.
+
+Each shard is deleted as soon as it is converted. If a conversion fails
+or is incorrect, the original shard cannot be restored by this tool.
+Only continue if the data directory is already backed up by other
+means, such as a snapshot of its volume.
+**********************************************************************`
+
+// stdin reads the answers to confirmation prompts.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks the user prompt, returning whether they answered y. It exits
+// if no answer can be read.
+func confirm(prompt string) bool {
+	fmt.Printf("%s y/N: ", prompt)
+	yn, err := stdin.ReadString('\n')
+	if err != nil {
+		logger.Fatal(nil, "failed to read response: %v", err)
+	}
+	return strings.TrimRight(strings.ToLower(yn), "\n") == "y"
+}
+
 // ParallelGroup allows the maximum parrallelism of a set of operations to be controlled.
 type ParallelGroup chan struct{}
 
@@ -402,6 +1126,107 @@ func allDBs(dbs []string) string {
 	return fmt.Sprintf("%v", dbs)
 }
 
+// timeRange returns a description of the time range to be converted.
+func timeRange(since, until time.Time) string {
+	if since.IsZero() && until.IsZero() {
+		return "all"
+	}
+
+	from, to := "-", "-"
+	if !since.IsZero() {
+		from = since.Format(time.RFC3339)
+	}
+	if !until.IsZero() {
+		to = until.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s to %s", from, to)
+}
+
+// rpRenames returns a description of the retention policy renames.
+func rpRenames(m map[string]string) string {
+	if len(m) == 0 {
+		return "none"
+	}
+
+	a := make([]string, 0, len(m))
+	for from, to := range m {
+		a = append(a, from+" -> "+to)
+	}
+	sort.Strings(a)
+	return strings.Join(a, ", ")
+}
+
+// parallelDBs returns a description of how many databases are converted at once.
+func parallelDBs(n int) string {
+	if n == 0 {
+		return "no"
+	}
+	return strconv.Itoa(n)
+}
+
+// shardsFrom returns a description of where the shards to convert are listed.
+func shardsFrom(path string) string {
+	switch path {
+	case "":
+		return "none, all shards of the selected databases"
+	case "-":
+		return "stdin"
+	}
+	return path
+}
+
+// manifestPath returns a description of where the digest manifest is written.
+func manifestPath(path string) string {
+	if path == "" {
+		return "disabled"
+	}
+	return path
+}
+
+// verify returns a description of how conversions are verified.
+func verify(enabled bool, rate float64, seed int64) string {
+	switch {
+	case !enabled:
+		return "no"
+	case rate >= 1:
+		return "yes"
+	default:
+		return fmt.Sprintf("%g%% of series (seed %d)", rate*100, seed)
+	}
+}
+
+// linePath returns the destination of -to-line output.
+func linePath(path string) string {
+	switch path {
+	case "":
+		return "none, converting to tsm1"
+	case "-":
+		return "stdout"
+	default:
+		return path
+	}
+}
+
+// keepBackupDays returns a description of when verified backups are removed.
+func keepBackupDays(n int) string {
+	if n == 0 {
+		return "never"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+// recompact returns a description of which tsm1 shards are re-compacted.
+func recompact(enabled, all bool) string {
+	switch {
+	case all:
+		return "all"
+	case enabled:
+		return "fragmented"
+	default:
+		return "no"
+	}
+}
+
 // isEnvSet checks to see if a variable was set in the environment
 func isEnvSet(name string) bool {
 	for _, s := range os.Environ() {
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	tsmreader "github.com/influxdata/influxdb/cmd/influx_tsm/tsm1"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure a backup directory is only rejected if it is within the data
+// directory, not if it merely shares a prefix with it.
+func TestWithin(t *testing.T) {
+	for i, tt := range []struct {
+		path, dir string
+		exp       bool
+	}{
+		{path: "/var/lib/influxdb/data", dir: "/var/lib/influxdb/data", exp: true},
+		{path: "/var/lib/influxdb/data/backup", dir: "/var/lib/influxdb/data", exp: true},
+		{path: "/var/lib/influxdb/data-backup", dir: "/var/lib/influxdb/data", exp: false},
+		{path: "/var/lib/influxdb", dir: "/var/lib/influxdb/data", exp: false},
+		{path: "/mnt/backup", dir: "/var/lib/influxdb/data", exp: false},
+		{path: "/..data", dir: "/", exp: true},
+	} {
+		if got := within(tt.path, tt.dir); got != tt.exp {
+			t.Errorf("%d. within(%q, %q) = %v, exp %v", i, tt.path, tt.dir, got, tt.exp)
+		}
+	}
+}
+
+// Ensure retention policy renames are parsed and validated.
+func TestParseRPRenames(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		exp map[string]string
+		err bool
+	}{
+		{s: "", exp: nil},
+		{s: "default=raw", exp: map[string]string{"default": "raw"}},
+		{s: "default=raw,old=new", exp: map[string]string{"default": "raw", "old": "new"}},
+		{s: "default", err: true},
+		{s: "default=", err: true},
+		{s: "=raw", err: true},
+		{s: "raw=raw", err: true},
+		{s: "default=raw,default=other", err: true},
+	} {
+		m, err := parseRPRenames(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d. %q: expected error", i, tt.s)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d. %q: unexpected error: %s", i, tt.s, err)
+		} else if !reflect.DeepEqual(m, tt.exp) {
+			t.Errorf("%d. %q: got %v, exp %v", i, tt.s, m, tt.exp)
+		}
+	}
+}
+
+// Ensure -sz sizes are parsed with and without suffixes, and formatted back.
+func TestParseSize(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		exp uint64
+		err bool
+	}{
+		{s: "2147483648", exp: 2147483648},
+		{s: "512m", exp: 512 << 20},
+		{s: "512M", exp: 512 << 20},
+		{s: "1g", exp: 1 << 30},
+		{s: "64k", exp: 64 << 10},
+		{s: "", err: true},
+		{s: "g", err: true},
+		{s: "1.5g", err: true},
+		{s: "-1m", err: true},
+		{s: "1t", err: true},
+		{s: "17179869184g", err: true},
+	} {
+		n, err := parseSize(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d. %q: expected error", i, tt.s)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d. %q: unexpected error: %s", i, tt.s, err)
+		} else if n != tt.exp {
+			t.Errorf("%d. %q: got %d, exp %d", i, tt.s, n, tt.exp)
+		}
+	}
+
+	for n, exp := range map[uint64]string{0: "0", 2 << 30: "2g", 512 << 20: "512m", 1536 << 20: "1536m", 1000: "1000"} {
+		if s := formatSize(n); s != exp {
+			t.Errorf("formatSize(%d): got %q, exp %q", n, s, exp)
+		}
+	}
+}
+
+// Ensure -since is parsed as an RFC3339 time or a positive duration before
+// now.
+func TestParseSince(t *testing.T) {
+	now := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, tt := range []struct {
+		s   string
+		exp time.Time
+		err bool
+	}{
+		{s: "2016-01-01T00:00:00Z", exp: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{s: "720h", exp: now.Add(-720 * time.Hour)},
+		{s: "0s", err: true},
+		{s: "-1h", err: true},
+		{s: "yesterday", err: true},
+	} {
+		since, err := parseSince(tt.s, now)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d. %q: expected error", i, tt.s)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d. %q: unexpected error: %s", i, tt.s, err)
+		} else if !since.Equal(tt.exp) {
+			t.Errorf("%d. %q: got %v, exp %v", i, tt.s, since, tt.exp)
+		}
+	}
+}
+
+// Ensure -since skips the bz1 shards whose newest point is before it, and
+// keeps empty and tsm1 shards.
+func TestFilterSince(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir, Since: time.Unix(0, 50)}
+
+	MustWriteBZ1(filepath.Join(dir, "db0", "rp0", "1"), map[string]int64{"cpu,host=a": 10, "cpu,host=b": 40})
+	MustWriteBZ1(filepath.Join(dir, "db0", "rp0", "2"), map[string]int64{"cpu,host=a": 10, "cpu,host=b": 60})
+	MustWriteBZ1(filepath.Join(dir, "db0", "rp0", "3"), nil)
+
+	shards := tsdb.ShardInfos{
+		{Database: "db0", RetentionPolicy: "rp0", Path: "1", Format: tsdb.BZ1},
+		{Database: "db0", RetentionPolicy: "rp0", Path: "2", Format: tsdb.BZ1},
+		{Database: "db0", RetentionPolicy: "rp0", Path: "3", Format: tsdb.BZ1},
+		{Database: "db0", RetentionPolicy: "rp0", Path: "4", Format: tsdb.TSM1},
+	}
+	if got, exp := filterSince(shards), shards[1:]; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected shards: got %v, exp %v", got, exp)
+	}
+}
+
+// Ensure shards are read from a list of absolute and relative paths, and that
+// paths outside the data directory are rejected.
+func TestReadShardList(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir}
+
+	MustWriteBZ1(filepath.Join(dir, "db0", "rp0", "1"), nil)
+	MustWriteBZ1(filepath.Join(dir, "db0", "rp0", "2"), nil)
+	MustWriteBZ1(filepath.Join(dir, "db1", "rp0", "3"), nil)
+
+	list := strings.Join([]string{
+		"# batch 1",
+		filepath.Join(dir, "db1", "rp0", "3"),
+		"",
+		"db0/rp0/1",
+		"db0/rp0/1/",
+	}, "\n")
+	shards, err := readShardList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, si := range shards {
+		paths = append(paths, filepath.Join(si.Database, si.RetentionPolicy, si.Path))
+	}
+	if exp := []string{filepath.Join("db0", "rp0", "1"), filepath.Join("db1", "rp0", "3")}; !reflect.DeepEqual(paths, exp) {
+		t.Fatalf("unexpected shards: got %v, exp %v", paths, exp)
+	} else if shards[0].Format == tsdb.TSM1 || shards[0].Size == 0 {
+		t.Fatalf("unexpected shard: %#v", shards[0])
+	}
+
+	for _, line := range []string{"db0/rp0", filepath.Dir(dir), "db0/rp0/9"} {
+		if _, err := readShardList(strings.NewReader(line)); err == nil {
+			t.Fatalf("expected error for %q", line)
+		}
+	}
+}
+
+// Ensure a fragmented tsm1 shard is re-compacted into a single TSM file, with
+// values from later files replacing those from earlier files.
+func TestConverter_Recompact(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "1")
+	MustWriteTSMFile(filepath.Join(src, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		"mem#!~#value": {tsm1.NewValue(0, int64(3))},
+	})
+	MustWriteTSMFile(filepath.Join(src, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(10, 4.0), tsm1.NewValue(20, 5.0)},
+	})
+
+	if ok, _, err := tsmreader.NeedsCompaction(src); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected fragmented shard to need compaction")
+	}
+
+	var st stats.Stats
+	r := tsmreader.NewReader(src, &st, 0)
+	if err := r.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	dst := filepath.Join(dir, "1.tsm")
+	if err := NewConverter(dst, uint32(maxTSMSz), &st).Process(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _, err := tsmreader.NeedsCompaction(dst); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected re-compacted shard to be fully compacted")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dst, "*.tsm"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 {
+		t.Fatalf("unexpected TSM files: %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	for key, exp := range map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 4.0), tsm1.NewValue(20, 5.0)},
+		"mem#!~#value": {tsm1.NewValue(0, int64(3))},
+	} {
+		values, err := tr.ReadAll(key)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(values, exp) {
+			t.Fatalf("%s: unexpected values: %v", key, values)
+		}
+	}
+}
+
+// MustWriteTSMFile writes a TSM file holding values to path, creating any
+// parent directories. Panic on error.
+func MustWriteTSMFile(path string, values map[string][]tsm1.Value) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		panic(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		panic(err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := w.Write(k, values[k]); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// Ensure the converter reports the points and bytes written to the shard as
+// it converts it.
+func TestConverter_Progress(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var st stats.Stats
+	c := NewConverter(filepath.Join(dir, "1.tsm"), 1, &st)
+	var points, bytes []uint64
+	c.progress = func(p, b uint64) {
+		points, bytes = append(points, p), append(bytes, b)
+	}
+	if err := c.Process(&sliceIterator{keys: []string{"cpu#!~#value", "mem#!~#value"}, values: [][]tsm1.Value{
+		{tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		{tsm1.NewValue(0, int64(3))},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(points, []uint64{2, 3}) {
+		t.Fatalf("unexpected points reported: %v", points)
+	} else if bytes[0] == 0 || bytes[1] <= bytes[0] || bytes[1] != st.TsmBytesWritten {
+		t.Fatalf("unexpected bytes reported: %v, %d written", bytes, st.TsmBytesWritten)
+	}
+}
+
+// Ensure blocks encoded by several workers are written in the order they
+// were read, producing the same TSM files as a single worker, even when the
+// iterator reuses its values between reads.
+func TestConverter_Workers(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	var keys []string
+	var values [][]tsm1.Value
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 3; j++ {
+			keys = append(keys, fmt.Sprintf("cpu,host=server%02d#!~#value", i))
+			var a []tsm1.Value
+			for k := 0; k < 100; k++ {
+				a = append(a, tsm1.NewValue(int64(j*100+k), float64(i*k)))
+			}
+			values = append(values, a)
+		}
+	}
+
+	var digests []Digest
+	var contents [][]byte
+	for _, workers := range []int{1, 4} {
+		var st stats.Stats
+		c := NewConverter(filepath.Join(dir, strconv.Itoa(workers)), 4096, &st)
+		c.workers = workers
+		c.digest = new(Digest)
+		if err := c.Process(&reusingIterator{keys: keys, values: values}); err != nil {
+			t.Fatal(err)
+		} else if st.PointsWritten != 15000 {
+			t.Fatalf("unexpected points written: %d", st.PointsWritten)
+		}
+		digests = append(digests, *c.digest)
+
+		files, err := filepath.Glob(filepath.Join(dir, strconv.Itoa(workers), "*.tsm"))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(files) < 2 {
+			t.Fatalf("expected multiple TSM files, got %d", len(files))
+		}
+		var b []byte
+		for _, f := range files {
+			buf, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = append(b, buf...)
+		}
+		contents = append(contents, b)
+	}
+
+	if digests[0] != digests[1] {
+		t.Fatalf("digests differ: %s, %s", digests[0], digests[1])
+	} else if !bytes.Equal(contents[0], contents[1]) {
+		t.Fatal("TSM files differ")
+	}
+}
+
+// reusingIterator is a KeyIterator over fixed keys and values that, like the
+// shard readers, overwrites the values it returned on the next call to Next.
+type reusingIterator struct {
+	keys   []string
+	values [][]tsm1.Value
+	buf    []tsm1.Value
+	i      int
+}
+
+func (itr *reusingIterator) Next() bool {
+	itr.i++
+	if itr.i > len(itr.keys) {
+		return false
+	}
+	itr.buf = append(itr.buf[:0], itr.values[itr.i-1]...)
+	return true
+}
+
+func (itr *reusingIterator) Read() (string, []tsm1.Value, error) {
+	return itr.keys[itr.i-1], itr.buf, nil
+}
+
+// Ensure a shard larger than the maximum TSM file size is split into a
+// numbered sequence of files, each within the maximum size.
+func TestConverter_TSMFiles(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Every key has the same length and a block of the same values, so each
+	// block takes the same space in a file.
+	var keys []string
+	var values [][]tsm1.Value
+	var block []tsm1.Value
+	for i := 0; i < 100; i++ {
+		block = append(block, tsm1.NewValue(int64(i), float64(i)*1.5))
+	}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("cpu,host=server%02d#!~#value", i))
+		values = append(values, block)
+	}
+	buf, err := tsm1.Values(block).Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file holds a 5 byte header, an 8 byte footer, and for each block its
+	// checksum, data and index entry, with the key's length, type and count.
+	const maxSize = 4096
+	perBlock := 4 + len(buf) + 28 + 2 + len(keys[0]) + 1 + 2
+	perFile := (maxSize - 5 - 8) / perBlock
+	exp := (len(keys) + perFile - 1) / perFile
+
+	var st stats.Stats
+	c := NewConverter(filepath.Join(dir, "1.tsm"), maxSize, &st)
+	if err := c.Process(&sliceIterator{keys: keys, values: values}); err != nil {
+		t.Fatal(err)
+	} else if c.TSMFiles() != exp || exp < 2 {
+		t.Fatalf("unexpected TSM files: got %d, exp %d", c.TSMFiles(), exp)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "1.tsm", "*.tsm"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(files) != exp {
+		t.Fatalf("unexpected TSM files: %v", files)
+	}
+	var points int
+	for i, f := range files {
+		if name := fmt.Sprintf("000000001-%09d.tsm", i+1); filepath.Base(f) != name {
+			t.Fatalf("unexpected file name: got %s, exp %s", filepath.Base(f), name)
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		} else if fi.Size() > maxSize {
+			t.Fatalf("%s: size %d exceeds %d", f, fi.Size(), maxSize)
+		}
+
+		fd, err := os.Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := tsm1.NewTSMReader(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < r.KeyCount(); j++ {
+			k, _ := r.KeyAt(j)
+			a, err := r.ReadAll(string(k))
+			if err != nil {
+				t.Fatal(err)
+			}
+			points += len(a)
+		}
+		r.Close()
+	}
+	if points != 2000 {
+		t.Fatalf("unexpected points: %d", points)
+	}
+}
+
+// Ensure only an answer of y confirms a prompt.
+func TestConfirm(t *testing.T) {
+	defer func(r *bufio.Reader) { stdin = r }(stdin)
+	stdin = bufio.NewReader(strings.NewReader("y\nY\nn\n\nyes\n"))
+
+	for i, exp := range []bool{true, true, false, false, false} {
+		if got := confirm("Proceed?"); got != exp {
+			t.Errorf("%d. unexpected confirmation: got %v, exp %v", i, got, exp)
+		}
+	}
+}
+
+// Ensure only the shards to be converted are backed up, leaving out shards
+// an earlier run already converted.
+func TestBackupDatabase(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: filepath.Join(dir, "data"), BackupPath: filepath.Join(dir, "backup")}
+
+	MustWriteFile(filepath.Join(dir, "data", "db0", "rp0", "1"), "bz1 shard")
+	MustWriteFile(filepath.Join(dir, "data", "db0", "rp0", "2", "000000001-000000001.tsm"), "tsm1 shard")
+	MustWriteFile(filepath.Join(dir, "data", "db1", "rp0", "3"), "b1 shard")
+
+	shards := tsdb.ShardInfos{
+		{Database: "db0", RetentionPolicy: "rp0", Path: "1", Format: tsdb.BZ1},
+		{Database: "db0", RetentionPolicy: "rp0", Path: "4", Format: tsdb.BZ1},
+		{Database: "db1", RetentionPolicy: "rp0", Path: "3", Format: tsdb.B1},
+	}
+	if err := backupDatabase("db0", shards); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "backup", "db0", "rp0", "1")); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "bz1 shard" {
+		t.Fatalf("unexpected backup: %q", b)
+	}
+	for _, path := range []string{
+		filepath.Join(dir, "backup", "db0", "rp0", "2"),
+		filepath.Join(dir, "backup", "db0", "rp0", "4"),
+		filepath.Join(dir, "backup", "db1"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("unexpected backup of %s: %v", path, err)
+		}
+	}
+}
+
+// MustWriteBZ1 writes a bz1 shard to path holding a block for each series,
+// whose header gives the series' newest point. Blocks hold no points.
+func MustWriteBZ1(path string, maxTimes map[string]int64) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		panic(err)
+	}
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		points, err := tx.CreateBucket([]byte("points"))
+		if err != nil {
+			return err
+		}
+		for series, max := range maxTimes {
+			b, err := points.CreateBucket([]byte(series))
+			if err != nil {
+				return err
+			}
+			var k, v [8]byte
+			binary.BigEndian.PutUint64(v[:], uint64(max))
+			if err := b.Put(k[:], v[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic(err)
+	}
+}
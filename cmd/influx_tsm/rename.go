@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// renameRetries is the number of times a rename is attempted before
+	// giving up.
+	renameRetries = 5
+
+	// renameRetryInterval is how long to wait between rename attempts.
+	renameRetryInterval = 100 * time.Millisecond
+)
+
+// fileSystem is the set of filesystem operations used to move a converted
+// shard into place. It allows failures to be simulated in tests.
+type fileSystem interface {
+	Rename(oldpath, newpath string) error
+	RemoveAll(path string) error
+}
+
+// osFileSystem implements fileSystem using the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+func (osFileSystem) RemoveAll(path string) error          { return os.RemoveAll(path) }
+
+// moveDir moves the directory at src to dst. Some filesystems refuse to
+// rename over an existing target or fail renames transiently, so dst is
+// removed and the rename retried. If src and dst are on different devices
+// the directory is copied to dst and src removed once the copy is complete.
+// src is never removed unless dst holds a complete copy of it. Transient
+// errors of each operation are first retried as by retryFS.
+func moveDir(fs fileSystem, src, dst string) error {
+	var err error
+	for i := 0; i < renameRetries; i++ {
+		if i > 0 {
+			time.Sleep(renameRetryInterval)
+		}
+
+		if err = retryFS("Rename", src, func() error { return fs.Rename(src, dst) }); err == nil {
+			return nil
+		} else if isCrossDevice(err) {
+			if err := copyDir(src, dst); err != nil {
+				return fmt.Errorf("copy of %v to %v failed: %v", src, dst, err)
+			}
+			return retryFS("Deletion", src, func() error { return fs.RemoveAll(src) })
+		}
+
+		if err := fs.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// isCrossDevice returns true if err is from a rename across devices.
+func isCrossDevice(err error) bool {
+	if le, ok := err.(*os.LinkError); ok {
+		err = le.Err
+	}
+	return err == syscall.EXDEV
+}
+
+// copyDir recursively copies the directory at src to dst, syncing each file.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		toPath := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return retryFS("Creation", toPath, func() error { return os.MkdirAll(toPath, info.Mode()) })
+		}
+		return retryFS("Copy", path, func() error { return copyFile(path, toPath, info.Mode()) })
+	})
+}
+
+// copyFile copies the file at src to dst and syncs it to disk.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
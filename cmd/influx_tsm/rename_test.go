@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Ensure a directory can be moved with a plain rename.
+func TestMoveDir(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "1.tsm"), filepath.Join(dir, "1")
+	MustWriteFile(filepath.Join(src, "000000001-000000001.tsm"), "data")
+
+	if err := moveDir(osFileSystem{}, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	MustMatchFile(t, filepath.Join(dst, "000000001-000000001.tsm"), "data")
+	MustNotExist(t, src)
+}
+
+// Ensure a directory is copied when renaming across devices.
+func TestMoveDir_CrossDevice(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "1.tsm"), filepath.Join(dir, "1")
+	MustWriteFile(filepath.Join(src, "000000001-000000001.tsm"), "data")
+	MustWriteFile(filepath.Join(src, "sub", "000000002-000000001.tsm"), "more")
+
+	fs := &FileSystem{
+		RenameFn: func(oldpath, newpath string) error {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		},
+	}
+	if err := moveDir(fs, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	MustMatchFile(t, filepath.Join(dst, "000000001-000000001.tsm"), "data")
+	MustMatchFile(t, filepath.Join(dst, "sub", "000000002-000000001.tsm"), "more")
+	MustNotExist(t, src)
+}
+
+// Ensure the source is kept if the cross device copy fails.
+func TestMoveDir_CrossDevice_CopyError(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "1.tsm"), filepath.Join(dir, "1")
+	MustWriteFile(filepath.Join(src, "000000001-000000001.tsm"), "data")
+
+	// A file at the destination prevents the directory being created.
+	MustWriteFile(dst, "")
+
+	fs := &FileSystem{
+		RenameFn: func(oldpath, newpath string) error {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		},
+	}
+	if err := moveDir(fs, src, dst); err == nil {
+		t.Fatal("expected error")
+	}
+	MustMatchFile(t, filepath.Join(src, "000000001-000000001.tsm"), "data")
+}
+
+// Ensure the rename is retried after removing an existing target.
+func TestMoveDir_Retry(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "1.tsm"), filepath.Join(dir, "1")
+	MustWriteFile(filepath.Join(src, "000000001-000000001.tsm"), "data")
+	MustWriteFile(filepath.Join(dst, "stale"), "")
+
+	var n int
+	fs := &FileSystem{
+		RenameFn: func(oldpath, newpath string) error {
+			n++
+			if _, err := os.Stat(newpath); err == nil {
+				return errors.New("target exists")
+			}
+			return os.Rename(oldpath, newpath)
+		},
+	}
+	if err := moveDir(fs, src, dst); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected rename attempts: %d", n)
+	}
+	MustMatchFile(t, filepath.Join(dst, "000000001-000000001.tsm"), "data")
+	MustNotExist(t, filepath.Join(dst, "stale"))
+}
+
+// Ensure the last error is returned once retries are exhausted.
+func TestMoveDir_RetriesExhausted(t *testing.T) {
+	errRename := errors.New("rename failed")
+	var n int
+	fs := &FileSystem{
+		RenameFn: func(oldpath, newpath string) error {
+			n++
+			return errRename
+		},
+	}
+	if err := moveDir(fs, "src", "dst"); err != errRename {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != renameRetries {
+		t.Fatalf("unexpected rename attempts: %d", n)
+	}
+}
+
+// Ensure a rename failing transiently is retried without removing the target.
+func TestMoveDir_Transient(t *testing.T) {
+	defer func(o options) { opts = o }(opts)
+	opts = options{RetryAttempts: 3, RetryBackoff: time.Millisecond}
+
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "1.tsm"), filepath.Join(dir, "1")
+	MustWriteFile(filepath.Join(src, "000000001-000000001.tsm"), "data")
+
+	var n int
+	fs := &FileSystem{
+		RenameFn: func(oldpath, newpath string) error {
+			if n++; n == 1 {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EBUSY}
+			}
+			return os.Rename(oldpath, newpath)
+		},
+		RemoveAllFn: func(path string) error {
+			t.Fatalf("unexpected removal of %v", path)
+			return nil
+		},
+	}
+	if err := moveDir(fs, src, dst); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected rename attempts: %d", n)
+	}
+	MustMatchFile(t, filepath.Join(dst, "000000001-000000001.tsm"), "data")
+}
+
+// FileSystem is a mockable implementation of fileSystem. Operations without a
+// mock function fall through to the os package.
+type FileSystem struct {
+	RenameFn    func(oldpath, newpath string) error
+	RemoveAllFn func(path string) error
+}
+
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if fs.RenameFn != nil {
+		return fs.RenameFn(oldpath, newpath)
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+func (fs *FileSystem) RemoveAll(path string) error {
+	if fs.RemoveAllFn != nil {
+		return fs.RemoveAllFn(path)
+	}
+	return os.RemoveAll(path)
+}
+
+// MustTempDir returns a temporary directory. Panic on error.
+func MustTempDir() string {
+	dir, err := ioutil.TempDir("", "influx-tsm-")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// MustWriteFile writes data to path, creating any parent directories.
+// Panic on error.
+func MustWriteFile(path, data string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+		panic(err)
+	}
+}
+
+// MustMatchFile fails the test if the file at path does not contain data.
+func MustMatchFile(t *testing.T, path, data string) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(buf) != data {
+		t.Fatalf("unexpected data in %s: %q", path, buf)
+	}
+}
+
+// MustNotExist fails the test if path exists.
+func MustNotExist(t *testing.T, path string) {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to not exist: %v", path, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// The status of a shard in the conversion report.
+const (
+	shardConverted = "converted"
+	shardResumed   = "resumed"
+	shardFailed    = "failed"
+)
+
+// shardReport records the conversion of a shard. Shards whose output was
+// completed by an earlier, interrupted run are resumed rather than
+// converted, and report no points written.
+type shardReport struct {
+	Database        string  `json:"database"`
+	RetentionPolicy string  `json:"retention_policy"`
+	Path            string  `json:"path"`
+	SourceFormat    string  `json:"source_format"`
+	SourceSize      int64   `json:"source_size"`
+	TSMFiles        int     `json:"tsm_files"`
+	TSMSize         int64   `json:"tsm_size"`
+	PointsWritten   uint64  `json:"points_written"`
+	Duration        float64 `json:"duration"`
+	Status          string  `json:"status"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// newShardReport returns a report of the conversion of si, before it starts.
+func newShardReport(si *tsdb.ShardInfo) *shardReport {
+	return &shardReport{
+		Database:        si.Database,
+		RetentionPolicy: si.RetentionPolicy,
+		Path:            si.FullPath(opts.DataPath),
+		SourceFormat:    si.FormatAsString(),
+		SourceSize:      si.Size,
+		Status:          shardConverted,
+	}
+}
+
+// finish records the outcome of the conversion, which took d. The TSM files
+// of a converted shard are counted at path, where it was moved into place.
+func (r *shardReport) finish(path string, d time.Duration, err error) {
+	r.Duration = d.Seconds()
+	if err != nil {
+		r.Status, r.Error = shardFailed, err.Error()
+		return
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(path, "*."+tsm1.TSMFileExtension))
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			r.TSMFiles++
+			r.TSMSize += fi.Size()
+		}
+	}
+}
+
+// conversionReport records the conversion of each shard of a run, for the
+// -report file.
+type conversionReport struct {
+	mu sync.Mutex
+
+	Started  time.Time      `json:"started"`
+	Duration float64        `json:"duration"`
+	Shards   []*shardReport `json:"shards"`
+}
+
+// newConversionReport returns an empty report of a run started now.
+func newConversionReport() *conversionReport {
+	return &conversionReport{Started: time.Now().UTC(), Shards: []*shardReport{}}
+}
+
+// Add records the conversion of a shard.
+func (r *conversionReport) Add(s *shardReport) {
+	r.mu.Lock()
+	r.Shards = append(r.Shards, s)
+	r.mu.Unlock()
+}
+
+// WriteFile writes the report of a run that took d as a JSON document to the
+// named file, with the shards ordered by path.
+func (r *conversionReport) WriteFile(name string, d time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Duration = d.Seconds()
+	sort.Sort(shardReportsByPath(r.Shards))
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	if err := enc.Encode(r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type shardReportsByPath []*shardReport
+
+func (a shardReportsByPath) Len() int           { return len(a) }
+func (a shardReportsByPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a shardReportsByPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultRetryAttempts is the default number of times a filesystem
+	// operation failing with a transient error is attempted.
+	defaultRetryAttempts = 3
+
+	// defaultRetryBackoff is the default wait before the first retry of a
+	// filesystem operation. The wait doubles with each retry.
+	defaultRetryBackoff = 500 * time.Millisecond
+)
+
+// transientErrnos are the errors of filesystem operations that may succeed
+// if attempted again, as seen from networked storage. Errors such as ENOSPC
+// or EACCES will not go away by waiting, so they are not retried.
+var transientErrnos = map[syscall.Errno]bool{
+	syscall.EAGAIN:    true,
+	syscall.EBUSY:     true,
+	syscall.EINTR:     true,
+	syscall.ETIMEDOUT: true,
+}
+
+// isTransient returns true if err is from a filesystem operation that may
+// succeed if attempted again.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return ok && transientErrnos[errno]
+}
+
+// retryFS calls fn, the filesystem operation op on path, until it succeeds,
+// fails with an error that is not transient, or has been attempted
+// opts.RetryAttempts times. The wait between attempts starts at
+// opts.RetryBackoff and doubles after each retry. fn must be safe to call
+// again after failing part way through. The last error is returned.
+func retryFS(op, path string, fn func() error) error {
+	backoff := opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt >= opts.RetryAttempts {
+			return err
+		}
+
+		logger.Warn(Fields{"event": "fs_retry", "op": op, "path": path, "attempt": attempt, "backoff": backoff, "error": err}, "%s of %v failed, retrying in %v: %v", op, path, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Ensure transient errors are retried until the operation succeeds.
+func TestRetryFS_Transient(t *testing.T) {
+	defer func(o options) { opts = o }(opts)
+	opts = options{RetryAttempts: 3, RetryBackoff: time.Millisecond}
+
+	var n int
+	err := retryFS("Copy", "src", func() error {
+		n++
+		if n < 3 {
+			return &os.PathError{Op: "open", Path: "src", Err: syscall.EAGAIN}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatalf("unexpected attempts: %d", n)
+	}
+}
+
+// Ensure the last transient error is returned once attempts are exhausted.
+func TestRetryFS_Exhausted(t *testing.T) {
+	defer func(o options) { opts = o }(opts)
+	opts = options{RetryAttempts: 2, RetryBackoff: time.Millisecond}
+
+	var n int
+	err := retryFS("Rename", "src", func() error {
+		n++
+		return &os.LinkError{Op: "rename", Old: "src", New: "dst", Err: syscall.EBUSY}
+	})
+	if !isTransient(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 2 {
+		t.Fatalf("unexpected attempts: %d", n)
+	}
+}
+
+// Ensure permanent errors fail without being retried.
+func TestRetryFS_Permanent(t *testing.T) {
+	defer func(o options) { opts = o }(opts)
+	opts = options{RetryAttempts: 3, RetryBackoff: time.Millisecond}
+
+	var n int
+	err := retryFS("Copy", "src", func() error {
+		n++
+		return &os.PathError{Op: "write", Path: "dst", Err: syscall.ENOSPC}
+	})
+	if err == nil || isTransient(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 1 {
+		t.Fatalf("unexpected attempts: %d", n)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+)
+
+// freeSpace returns the bytes available to an unprivileged user on the
+// filesystem holding path. It is a variable so tests can replace it.
+var freeSpace = availableBytes
+
+// backupSpace returns the bytes still to be copied by backing up shards,
+// less what earlier runs already copied to the backup directory. Only the
+// shards to be converted are backed up, so shards an earlier run already
+// converted are not counted.
+func backupSpace(shards tsdb.ShardInfos) (int64, error) {
+	var need int64
+	for _, si := range shards {
+		done, err := dirSize(backupPath(si))
+		if err != nil {
+			return 0, err
+		}
+		if si.Size > done {
+			need += si.Size - done
+		}
+	}
+	return need, nil
+}
+
+// checkBackupSpace ensures the backup directory has room for the backups of
+// shards. If the free space cannot be determined, a warning is logged and
+// the check is skipped.
+func checkBackupSpace(shards tsdb.ShardInfos) error {
+	need, err := backupSpace(shards)
+	if err != nil {
+		return err
+	}
+
+	avail, err := freeSpace(opts.BackupPath)
+	if err != nil {
+		logger.Warn(Fields{"error": err}, "Free space in backup directory %v unknown, not checked: %v", opts.BackupPath, err)
+		return nil
+	}
+
+	if uint64(need) > avail {
+		return fmt.Errorf("not enough space in backup directory %v: backups need %d bytes but %d are available, %d bytes short. Free up space, choose another -backup directory, or use -skip-space-check",
+			opts.BackupPath, need, avail, uint64(need)-avail)
+	}
+	return nil
+}
+
+// dirSize returns the total size of the files under path, or 0 if path
+// does not exist.
+func dirSize(path string) (int64, error) {
+	var sz int64
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			sz += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return sz, err
+}
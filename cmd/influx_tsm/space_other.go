@@ -0,0 +1,15 @@
+// +build !linux,!darwin,!freebsd,!dragonfly
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// availableBytes is not implemented where syscall has no Statfs, such as on
+// Windows, Solaris and NetBSD, so the free space of the backup directory is
+// never checked there.
+func availableBytes(path string) (uint64, error) {
+	return 0, errors.New("free space cannot be determined on " + runtime.GOOS)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+)
+
+// Ensure backups are refused when the backup directory lacks the space for
+// the shards to be converted, less what is already backed up. Other shards
+// of their databases are not backed up, so they are not counted.
+func TestCheckBackupSpace(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{BackupPath: dir}
+	defer func(fn func(string) (uint64, error)) { freeSpace = fn }(freeSpace)
+
+	all := tsdb.ShardInfos{
+		{Database: "db0", RetentionPolicy: "rp0", Path: "1", Format: tsdb.BZ1, Size: 100},
+		{Database: "db0", RetentionPolicy: "rp0", Path: "2", Format: tsdb.TSM1, Size: 50},
+		{Database: "db1", RetentionPolicy: "rp0", Path: "3", Format: tsdb.B1, Size: 1000},
+	}
+	shards := tsdb.ShardInfos{all[0], all[2]}
+	MustWriteFile(filepath.Join(dir, "db0", "rp0", "1"), "0123456789")
+
+	for i, tt := range []struct {
+		avail uint64
+		err   error
+		exp   string
+	}{
+		{avail: 1090},
+		{avail: 1089, exp: fmt.Sprintf("not enough space in backup directory %v: backups need 1090 bytes but 1089 are available, 1 bytes short. Free up space, choose another -backup directory, or use -skip-space-check", dir)},
+		{err: errors.New("unsupported")},
+	} {
+		freeSpace = func(path string) (uint64, error) {
+			if path != dir {
+				t.Fatalf("%d. unexpected path: %s", i, path)
+			}
+			return tt.avail, tt.err
+		}
+
+		err := checkBackupSpace(shards)
+		if tt.exp == "" && err != nil {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		} else if tt.exp != "" && (err == nil || err.Error() != tt.exp) {
+			t.Errorf("%d. unexpected error: got %v, exp %q", i, err, tt.exp)
+		}
+	}
+}
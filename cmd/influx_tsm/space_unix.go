@@ -0,0 +1,15 @@
+// +build linux darwin freebsd dragonfly
+
+package main
+
+import "syscall"
+
+// availableBytes returns the bytes available to an unprivileged user on the
+// filesystem holding path.
+func availableBytes(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -10,6 +10,8 @@ type Stats struct {
 	NanFiltered     uint64
 	InfFiltered     uint64
 	FieldsFiltered  uint64
+	RangeFiltered   uint64
+	BlocksSkipped   uint64
 	PointsWritten   uint64
 	PointsRead      uint64
 	TsmFilesCreated uint64
@@ -52,3 +54,13 @@ func (s *Stats) IncrInf() {
 func (s *Stats) IncrFiltered() {
 	atomic.AddUint64(&s.FieldsFiltered, 1)
 }
+
+// IncrRangeFiltered increments the number of points filtered by time range.
+func (s *Stats) IncrRangeFiltered() {
+	atomic.AddUint64(&s.RangeFiltered, 1)
+}
+
+// IncrBlocksSkipped increments the number of blocks skipped by time range.
+func (s *Stats) IncrBlocksSkipped() {
+	atomic.AddUint64(&s.BlocksSkipped, 1)
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"runtime"
+	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
@@ -16,19 +17,59 @@ import (
 type tracker struct {
 	Stats stats.Stats
 
-	shards tsdb.ShardInfos
-	opts   options
+	shards   tsdb.ShardInfos
+	opts     options
+	manifest *manifest
+
+	// report records the conversion of each shard, if a report is written.
+	report *conversionReport
+
+	// sampler selects the series verified, if conversions are verified.
+	sampler *seriesSampler
+
+	// start is when shards started being converted, and convertedBytes the
+	// total source size of the shards converted since, for estimating the
+	// time remaining.
+	start          time.Time
+	convertedBytes uint64
+
+	// converted is the number of shards converted successfully.
+	converted uint64
+
+	// stop is closed once the conversion is stopped, after which no further
+	// shards are started.
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// failed holds the databases whose conversion failed, when converting
+	// by database, and running the paths of the shards being converted.
+	mu      sync.Mutex
+	failed  []string
+	running map[string]struct{}
+
+	// rps holds the shards and points converted in each retention policy,
+	// when converting by retention policy.
+	rps []rpStats
 
-	pg ParallelGroup
 	wg sync.WaitGroup
 }
 
 // newTracker will setup and return a clean tracker instance
 func newTracker(shards tsdb.ShardInfos, opts options) *tracker {
 	t := &tracker{
-		shards: shards,
-		opts:   opts,
-		pg:     NewParallelGroup(runtime.GOMAXPROCS(0)),
+		shards:  shards,
+		opts:    opts,
+		stop:    make(chan struct{}),
+		running: make(map[string]struct{}),
+	}
+	if opts.ManifestPath != "" {
+		t.manifest = newManifest()
+	}
+	if opts.ReportPath != "" {
+		t.report = newConversionReport()
+	}
+	if opts.Verify {
+		t.sampler = newSeriesSampler(opts.VerifyRate, opts.VerifySeed)
 	}
 
 	return t
@@ -36,76 +77,362 @@ func newTracker(shards tsdb.ShardInfos, opts options) *tracker {
 
 func (t *tracker) Run() error {
 	conversionStart := time.Now()
+	t.start = conversionStart
+
+	if t.opts.ParallelDBs > 0 {
+		t.runDatabases()
+		t.wait()
+		t.Stats.TotalTime = time.Since(conversionStart)
+		sort.Strings(t.failed)
+		return nil
+	}
 
 	// Backup each directory.
 	if !opts.SkipBackup {
 		databases := t.shards.Databases()
-		fmt.Printf("Backing up %d databases...\n", len(databases))
-		t.wg.Add(len(databases))
-		for i := range databases {
+		logger.Info(Fields{"databases": len(databases)}, "Backing up %d databases...", len(databases))
+		if err := t.forEach(len(databases), func(i int) error {
 			db := databases[i]
-			go t.pg.Do(func() {
-				defer t.wg.Done()
-
-				start := time.Now()
-				log.Printf("Backup of database '%v' started", db)
-				err := backupDatabase(db)
-				if err != nil {
-					log.Fatalf("Backup of database %v failed: %v\n", db, err)
-				}
-				log.Printf("Database %v backed up (%v)\n", db, time.Now().Sub(start))
-			})
+			if err := backupDatabaseLogged(db, t.shards); err != nil {
+				logger.Error(Fields{"event": "backup_failed", "database": db, "error": err}, "Backup of database %v failed: %v", db, err)
+				return fmt.Errorf("Backup of database %v failed: %v", db, err)
+			}
+			return nil
+		}); err != nil {
+			return err
 		}
-		t.wg.Wait()
 	} else {
-		fmt.Println("Database backup disabled.")
-	}
-
-	t.wg.Add(len(t.shards))
-	for i := range t.shards {
-		si := t.shards[i]
-		go t.pg.Do(func() {
-			defer func() {
-				atomic.AddUint64(&t.Stats.CompletedShards, 1)
-				t.wg.Done()
-			}()
-
-			start := time.Now()
-			log.Printf("Starting conversion of shard: %v", si.FullPath(opts.DataPath))
-			if err := convertShard(si, t); err != nil {
-				log.Fatalf("Failed to convert %v: %v\n", si.FullPath(opts.DataPath), err)
+		logger.Warn(nil, "Database backup disabled.")
+	}
+
+	t.start = time.Now()
+	var err error
+	if t.opts.GroupByRP {
+		err = t.convertRetentionPolicies()
+	} else {
+		_, err = t.convertShards(t.shards)
+	}
+
+	t.Stats.TotalTime = time.Since(conversionStart)
+
+	return err
+}
+
+// Stop stops the conversion once the shards being converted are done, so that
+// each of them is either converted or left as it was. No further shards are
+// started. It is safe to call more than once.
+func (t *tracker) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// stopped returns whether the conversion was stopped.
+func (t *tracker) stopped() bool {
+	select {
+	case <-t.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Running returns the paths of the shards being converted, in order.
+func (t *tracker) Running() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := make([]string, 0, len(t.running))
+	for path := range t.running {
+		a = append(a, path)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// convertShards converts shards, from up to as many workers at once as the
+// options allow, and returns the number converted. Once a shard fails no
+// further shards are started.
+func (t *tracker) convertShards(shards tsdb.ShardInfos) (int, error) {
+	var n uint64
+	err := t.forEach(len(shards), func(i int) error {
+		si := shards[i]
+		err := convertShardLogged(si, t)
+		atomic.AddUint64(&t.Stats.CompletedShards, 1)
+		if err != nil {
+			return fmt.Errorf("Failed to convert %v: %v", si.FullPath(opts.DataPath), err)
+		}
+		atomic.AddUint64(&n, 1)
+		return nil
+	})
+	return int(n), err
+}
+
+// rpStats holds the number of shards of a retention policy, the number of
+// them converted, and the points written while converting it, including
+// those written for a shard that then failed.
+type rpStats struct {
+	database        string
+	retentionPolicy string
+	shards          int
+	converted       int
+	points          uint64
+}
+
+// convertRetentionPolicies converts the shards one database and retention
+// policy at a time, logging a header before each retention policy and a
+// summary of the shards and points converted after it. The shards of a
+// retention policy are all finished before the next one is started, so the
+// retention policies of an interrupted run are either done, not started or
+// the one named by the last header.
+func (t *tracker) convertRetentionPolicies() error {
+	for _, shards := range t.shards.ByRetentionPolicy() {
+		if t.stopped() {
+			return nil
+		}
+		db, rp := shards[0].Database, shards[0].RetentionPolicy
+		fields := Fields{"database": db, "retention_policy": rp}
+		logger.Info(fields.with(Fields{"event": "retention_policy_started", "shards": len(shards)}), "Converting retention policy %v/%v: %d shards", db, rp, len(shards))
+
+		points := atomic.LoadUint64(&t.Stats.PointsWritten)
+		n, err := t.convertShards(shards)
+		s := rpStats{
+			database:        db,
+			retentionPolicy: rp,
+			shards:          len(shards),
+			converted:       n,
+			points:          atomic.LoadUint64(&t.Stats.PointsWritten) - points,
+		}
+		t.rps = append(t.rps, s)
+
+		logger.Info(fields.with(Fields{"event": "retention_policy_completed", "shards": s.shards, "converted_shards": s.converted, "points_written": s.points}), "Retention policy %v/%v: %d of %d shards converted, %d points written", db, rp, s.converted, s.shards, s.points)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEach calls fn with each index up to n, in order, from up to as many
+// workers at once as the options allow. Once a call fails, or the conversion
+// is stopped, no further calls are started, and the error of the first
+// failure is returned once the running calls return.
+func (t *tracker) forEach(n int, fn func(i int) error) error {
+	var (
+		mu  sync.Mutex
+		err error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return err != nil
+	}
+
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := 0; i < n && !failed() && !t.stopped(); i++ {
+			indexes <- i
+		}
+	}()
+
+	workers := t.opts.workers()
+	t.wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer t.wg.Done()
+			for i := range indexes {
+				if failed() || t.stopped() {
+					continue
+				}
+				if e := fn(i); e != nil {
+					mu.Lock()
+					if err == nil {
+						err = e
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	t.wait()
+
+	return err
+}
+
+// runDatabases starts converting up to ParallelDBs databases at once. Each
+// database is backed up and then has its shards converted one at a time, so
+// a failure stops the conversion of that database only.
+func (t *tracker) runDatabases() {
+	if t.opts.SkipBackup {
+		logger.Warn(nil, "Database backup disabled.")
+	}
+
+	pg := NewParallelGroup(t.opts.ParallelDBs)
+	for _, db := range t.shards.Databases() {
+		db := db
+		t.wg.Add(1)
+		go pg.Do(func() {
+			defer t.wg.Done()
+
+			if err := t.convertDatabase(db); err != nil {
+				logger.Error(Fields{"event": "database_failed", "database": db, "error": err}, "Conversion of database %v failed, remaining shards not converted: %v", db, err)
+				t.mu.Lock()
+				t.failed = append(t.failed, db)
+				t.mu.Unlock()
 			}
-			log.Printf("Conversion of %v successful (%v)\n", si.FullPath(opts.DataPath), time.Since(start))
 		})
 	}
+}
 
+// convertDatabase backs up the database named db and converts its shards.
+func (t *tracker) convertDatabase(db string) error {
+	if !t.opts.SkipBackup {
+		if err := backupDatabaseLogged(db, t.shards); err != nil {
+			logger.Error(Fields{"event": "backup_failed", "database": db, "error": err}, "Backup of database %v failed: %v", db, err)
+			return fmt.Errorf("backup failed: %v", err)
+		}
+	}
+
+	for _, si := range t.shards {
+		if si.Database != db {
+			continue
+		} else if t.stopped() {
+			return nil
+		}
+
+		err := convertShardLogged(si, t)
+		atomic.AddUint64(&t.Stats.CompletedShards, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupDatabaseLogged backs up the shards of the database named db, logging
+// when the backup starts and completes.
+func backupDatabaseLogged(db string, shards tsdb.ShardInfos) error {
+	start := time.Now()
+	logger.Info(Fields{"event": "backup_started", "database": db}, "Backup of database '%v' started", db)
+	if err := backupDatabase(db, shards); err != nil {
+		return err
+	}
+	d := time.Since(start)
+	logger.Info(Fields{"event": "backup_completed", "database": db, "duration": d}, "Database %v backed up (%v)", db, d)
+	return nil
+}
+
+// convertShardLogged converts the shard si, logging when the conversion
+// starts, completes or fails.
+func convertShardLogged(si *tsdb.ShardInfo, t *tracker) error {
+	fields := shardFields(si)
+	path := si.FullPath(opts.DataPath)
+	t.mu.Lock()
+	t.running[path] = struct{}{}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.running, path)
+		t.mu.Unlock()
+	}()
+
+	start := time.Now()
+	r := newShardReport(si)
+	logger.Info(fields.with(Fields{"event": "shard_started", "size": si.Size}), "Starting conversion of shard: %v", path)
+	err := convertShard(si, t, r)
+	if t.report != nil {
+		r.finish(opts.targetPath(si), time.Since(start), err)
+		t.report.Add(r)
+	}
+	if err != nil {
+		logger.Error(fields.with(Fields{"event": "shard_failed", "error": err}), "Failed to convert %v: %v", path, err)
+		return err
+	}
+	atomic.AddUint64(&t.converted, 1)
+	atomic.AddUint64(&t.convertedBytes, uint64(si.Size))
+	d := time.Since(start)
+	logger.Info(fields.with(Fields{"event": "shard_completed", "duration": d}), "Conversion of %v successful (%v)", path, d)
+	return nil
+}
+
+// wait waits for all conversions to finish, printing status updates.
+func (t *tracker) wait() {
 	done := make(chan struct{})
 	go func() {
 		t.wg.Wait()
 		close(done)
 	}()
 
-WAIT_LOOP:
 	for {
 		select {
 		case <-done:
-			break WAIT_LOOP
+			return
 		case <-time.After(opts.UpdateInterval):
-			t.StatusUpdate()
+			if !opts.Quiet {
+				t.StatusUpdate()
+			}
 		}
 	}
-
-	t.Stats.TotalTime = time.Since(conversionStart)
-
-	return nil
 }
 
 func (t *tracker) StatusUpdate() {
 	shardCount := atomic.LoadUint64(&t.Stats.CompletedShards)
 	pointCount := atomic.LoadUint64(&t.Stats.PointsRead)
 	pointWritten := atomic.LoadUint64(&t.Stats.PointsWritten)
+	percent, eta, ok := estimateProgress(atomic.LoadUint64(&t.convertedBytes), uint64(t.shards.Size()), time.Since(t.start))
+
+	fields := Fields{
+		"event":            "progress",
+		"completed_shards": shardCount,
+		"shards":           len(t.shards),
+		"points_read":      pointCount,
+		"points_written":   pointWritten,
+		"percent":          percent,
+	}
+	remaining := "unknown"
+	if ok {
+		fields["eta"] = eta
+		remaining = eta.String()
+	}
+	logger.Info(fields, "Still Working: Completed Shards: %d/%d (%.1f%%, %s remaining) Points read/written: %d/%d", shardCount, len(t.shards), percent, remaining, pointCount, pointWritten)
+}
 
-	log.Printf("Still Working: Completed Shards: %d/%d Points read/written: %d/%d", shardCount, len(t.shards), pointCount, pointWritten)
+// estimateProgress returns the percentage of the total source size of the
+// shards that was converted, given that done bytes were converted in
+// elapsed. It also estimates the time remaining, rounded to the second, from
+// the rate so far, or returns false if nothing was converted yet.
+func estimateProgress(done, total uint64, elapsed time.Duration) (percent float64, eta time.Duration, ok bool) {
+	if total == 0 {
+		return 100, 0, true
+	}
+	percent = 100 * float64(done) / float64(total)
+	if done == 0 {
+		return percent, 0, false
+	}
+	eta = time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+	return percent, eta - eta%time.Second, true
+}
+
+// statsFields returns the summary statistics of the conversion as log fields.
+func (t *tracker) statsFields() Fields {
+	f := Fields{
+		"databases":         len(t.shards.Databases()),
+		"shards":            len(t.shards),
+		"tsm_files_created": t.Stats.TsmFilesCreated,
+		"points_read":       t.Stats.PointsRead,
+		"points_written":    t.Stats.PointsWritten,
+		"nan_filtered":      t.Stats.NanFiltered,
+		"inf_filtered":      t.Stats.InfFiltered,
+		"fields_filtered":   t.Stats.FieldsFiltered,
+		"range_filtered":    t.Stats.RangeFiltered,
+		"blocks_skipped":    t.Stats.BlocksSkipped,
+		"bytes_pre":         t.shards.Size(),
+		"bytes_post":        t.Stats.TsmBytesWritten,
+		"duration":          t.Stats.TotalTime,
+	}
+	if t.sampler != nil {
+		n, total := t.sampler.Verified()
+		f["series_verified"], f["series_total"] = n, total
+	}
+	return f
 }
 
 func (t *tracker) PrintStats() {
@@ -121,10 +448,35 @@ func (t *tracker) PrintStats() {
 	fmt.Printf("NaN filtered:                        %d\n", t.Stats.NanFiltered)
 	fmt.Printf("Inf filtered:                        %d\n", t.Stats.InfFiltered)
 	fmt.Printf("Points without fields filtered:      %d\n", t.Stats.FieldsFiltered)
+	fmt.Printf("Points outside time range filtered:  %d\n", t.Stats.RangeFiltered)
+	fmt.Printf("Blocks outside time range skipped:   %d\n", t.Stats.BlocksSkipped)
 	fmt.Printf("Disk usage pre-conversion (bytes):   %d\n", preSize)
 	fmt.Printf("Disk usage post-conversion (bytes):  %d\n", postSize)
 	fmt.Printf("Reduction factor:                    %d%%\n", 100*(preSize-postSize)/preSize)
 	fmt.Printf("Bytes per TSM point:                 %.2f\n", float64(postSize)/float64(t.Stats.PointsWritten))
 	fmt.Printf("Total conversion time:               %v\n", t.Stats.TotalTime)
+	if t.sampler != nil {
+		n, total := t.sampler.Verified()
+		fmt.Printf("Series verified:                     %d of %d (%.2f%%)\n", n, total, percent(n, total))
+		fmt.Printf("Verification confidence:             %s\n", t.sampler.confidence())
+	}
 	fmt.Println()
+
+	if len(t.rps) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+		fmt.Fprintln(w, "Database\tRetention\tShards\tPoints written")
+		for _, s := range t.rps {
+			fmt.Fprintf(w, "%v\t%v\t%d/%d\t%d\n", s.database, s.retentionPolicy, s.converted, s.shards, s.points)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+}
+
+// percent returns n as a percentage of total.
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(total)
 }
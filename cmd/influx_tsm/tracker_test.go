@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tsmreader "github.com/influxdata/influxdb/cmd/influx_tsm/tsm1"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure a conversion that failed part way through resumes when it is run
+// again, without losing any points or converting any shard twice.
+func TestTracker_Resume(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	// Fragmented tsm1 shards, so that each is re-compacted.
+	ids := []string{"1", "2", "3"}
+	exp := make(map[string]Digest)
+	for _, id := range ids {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		MustWriteTSMFile(filepath.Join(path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		})
+		MustWriteTSMFile(filepath.Join(path, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(10, 3.0), tsm1.NewValue(20, 4.0)},
+		})
+
+		d, err := digestShard(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp[id] = d
+	}
+
+	defer func(o options) {
+		opts = o
+		injectFailAt = -1
+	}(opts)
+	opts = options{
+		DataPath:       dir,
+		TSMSize:        maxTSMSz,
+		ParallelDBs:    1,
+		SkipBackup:     true,
+		UpdateInterval: time.Hour,
+		Recompact:      true,
+	}
+	convert := func() *tracker {
+		dbs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := newTracker(collectShards(dbs), opts)
+		if err := tr.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+
+	// Fail the conversion of the second shard once its output is written.
+	injectFailAt = 1
+	if tr := convert(); !reflect.DeepEqual(tr.failed, []string{"db0"}) {
+		t.Fatalf("unexpected failed databases: %v", tr.failed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "db0", "rp0", "2."+tsmExt)); err != nil {
+		t.Fatalf("expected output of failed conversion: %v", err)
+	}
+	MustNotExist(t, filepath.Join(dir, "db0", "rp0", "2."+tsmExt, checkpointName))
+
+	// Only the shards not yet converted are converted when run again.
+	injectFailAt = -1
+	tr := convert()
+	if len(tr.failed) != 0 {
+		t.Fatalf("unexpected failed databases: %v", tr.failed)
+	} else if len(tr.shards) != 2 {
+		t.Fatalf("unexpected shards converted: %v", tr.shards)
+	} else if tr.Stats.PointsWritten != 6 {
+		t.Fatalf("unexpected points written: %d", tr.Stats.PointsWritten)
+	}
+
+	for _, id := range ids {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		if d, err := digestShard(path); err != nil {
+			t.Fatal(err)
+		} else if d != exp[id] {
+			t.Fatalf("shard %s: digest mismatch: %s != %s", id, d, exp[id])
+		}
+		if ok, reason, err := tsmreader.NeedsCompaction(path); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatalf("shard %s: expected shard to be compacted: %s", id, reason)
+		}
+		MustNotExist(t, path+"."+tsmExt)
+	}
+}
+
+// Ensure shards are converted by a pool of workers with -parallel, and that
+// a failed conversion stops the run with an error naming the shard.
+func TestTracker_Run_Parallel(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	ids := []string{"1", "2", "3", "4", "5", "6"}
+	for _, id := range ids {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		MustWriteTSMFile(filepath.Join(path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+		MustWriteTSMFile(filepath.Join(path, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(10, 2.0)},
+		})
+	}
+
+	defer func(o options) {
+		opts = o
+		injectFailAt = -1
+	}(opts)
+	opts = options{
+		DataPath:       dir,
+		TSMSize:        maxTSMSz,
+		Parallel:       true,
+		Workers:        3,
+		SkipBackup:     true,
+		UpdateInterval: time.Hour,
+		Recompact:      true,
+	}
+	convert := func() (*tracker, error) {
+		dbs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := newTracker(collectShards(dbs), opts)
+		return tr, tr.Run()
+	}
+
+	injectFailAt = 1
+	if tr, err := convert(); err == nil || !strings.Contains(err.Error(), "Failed to convert "+tr.shards[1].FullPath(dir)+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	injectFailAt = -1
+	if _, err := convert(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		if ok, reason, err := tsmreader.NeedsCompaction(path); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatalf("shard %s: expected shard to be compacted: %s", id, reason)
+		}
+	}
+}
+
+// Ensure no further shards are converted once a conversion fails without
+// -parallel.
+func TestTracker_Run_Failure(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"1", "2", "3"} {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		MustWriteTSMFile(filepath.Join(path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+		MustWriteTSMFile(filepath.Join(path, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(10, 2.0)},
+		})
+	}
+
+	defer func(o options) {
+		opts = o
+		injectFailAt = -1
+	}(opts)
+	opts = options{
+		DataPath:       dir,
+		TSMSize:        maxTSMSz,
+		SkipBackup:     true,
+		UpdateInterval: time.Hour,
+		Recompact:      true,
+	}
+	dbs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injectFailAt = 1
+	tr := newTracker(collectShards(dbs), opts)
+	if err := tr.Run(); err == nil || !strings.Contains(err.Error(), "Failed to convert "+tr.shards[1].FullPath(dir)+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if tr.Stats.CompletedShards != 2 {
+		t.Fatalf("unexpected completed shards: %d", tr.Stats.CompletedShards)
+	}
+	if ok, _, err := tsmreader.NeedsCompaction(tr.shards[2].FullPath(dir)); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected the last shard to be left unconverted")
+	}
+}
+
+// Ensure the report of a run records the TSM files and points of each
+// converted shard, and the error of a failed one.
+func TestTracker_Run_Report(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"1", "2"} {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		MustWriteTSMFile(filepath.Join(path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+		MustWriteTSMFile(filepath.Join(path, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(10, 2.0)},
+		})
+	}
+
+	defer func(o options) {
+		opts = o
+		injectFailAt = -1
+	}(opts)
+	opts = options{
+		DataPath:       dir,
+		TSMSize:        maxTSMSz,
+		SkipBackup:     true,
+		UpdateInterval: time.Hour,
+		Recompact:      true,
+		ReportPath:     filepath.Join(dir, "report.json"),
+	}
+	dbs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injectFailAt = 1
+	tr := newTracker(collectShards(dbs), opts)
+	if err := tr.Run(); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := tr.report.WriteFile(opts.ReportPath, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(opts.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var report struct {
+		Duration float64
+		Shards   []shardReport
+	}
+	if err := json.NewDecoder(f).Decode(&report); err != nil {
+		t.Fatal(err)
+	} else if report.Duration != 1 {
+		t.Fatalf("unexpected duration: %v", report.Duration)
+	} else if len(report.Shards) != 2 {
+		t.Fatalf("unexpected shards: %d", len(report.Shards))
+	}
+
+	if r := report.Shards[0]; r.Status != shardConverted || r.Path != filepath.Join(dir, "db0", "rp0", "1") {
+		t.Fatalf("unexpected shard: %+v", r)
+	} else if r.SourceFormat != "tsm1" || r.TSMFiles != 1 || r.TSMSize == 0 || r.PointsWritten != 2 {
+		t.Fatalf("unexpected shard: %+v", r)
+	}
+	if r := report.Shards[1]; r.Status != shardFailed || r.Error == "" || r.TSMFiles != 0 {
+		t.Fatalf("unexpected shard: %+v", r)
+	}
+}
+
+// Ensure a stopped conversion finishes the shard being converted but starts
+// no further shards.
+func TestTracker_Stop(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, id := range []string{"1", "2", "3"} {
+		path := filepath.Join(dir, "db0", "rp0", id)
+		MustWriteTSMFile(filepath.Join(path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+		MustWriteTSMFile(filepath.Join(path, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(10, 2.0)},
+		})
+	}
+
+	defer func(o options, l *Logger) {
+		opts = o
+		logger = l
+	}(opts, logger)
+	opts = options{
+		DataPath:       dir,
+		TSMSize:        maxTSMSz,
+		SkipBackup:     true,
+		UpdateInterval: time.Hour,
+		Recompact:      true,
+	}
+	dbs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stop the conversion, as an interrupt would, once the first shard starts.
+	tr := newTracker(collectShards(dbs), opts)
+	logger, _ = NewLogger(stopWriter{tr}, textLogFormat)
+
+	if err := tr.Run(); err != nil {
+		t.Fatal(err)
+	} else if !tr.stopped() || tr.converted != 1 {
+		t.Fatalf("unexpected converted shards: %d", tr.converted)
+	} else if running := tr.Running(); len(running) != 0 {
+		t.Fatalf("unexpected running shards: %v", running)
+	}
+	if ok, _, err := tsmreader.NeedsCompaction(tr.shards[0].FullPath(dir)); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected the first shard to be converted")
+	}
+	for _, si := range tr.shards[1:] {
+		if ok, _, err := tsmreader.NeedsCompaction(si.FullPath(dir)); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatalf("expected %s to be left unconverted", si.FullPath(dir))
+		}
+	}
+}
+
+// stopWriter stops a tracker once a shard conversion is logged as started.
+type stopWriter struct {
+	tr *tracker
+}
+
+func (w stopWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("Starting conversion of shard")) {
+		w.tr.Stop()
+	}
+	return len(p), nil
+}
+
+// Ensure -group-by-rp converts the shards one retention policy at a time,
+// recording the shards and points converted in each, and stops at the
+// retention policy of a failed shard.
+func TestTracker_Run_GroupByRP(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{
+		filepath.Join("db0", "rp0", "1"),
+		filepath.Join("db0", "rp0", "2"),
+		filepath.Join("db0", "rp1", "3"),
+		filepath.Join("db0", "rp1", "4"),
+		filepath.Join("db1", "rp0", "5"),
+	} {
+		MustWriteTSMFile(filepath.Join(dir, path, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(0, 1.0)},
+		})
+		MustWriteTSMFile(filepath.Join(dir, path, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu#!~#value": {tsm1.NewValue(10, 2.0)},
+		})
+	}
+
+	defer func(o options) {
+		opts = o
+		injectFailAt = -1
+	}(opts)
+	opts = options{
+		DataPath:       dir,
+		TSMSize:        maxTSMSz,
+		Parallel:       true,
+		Workers:        2,
+		GroupByRP:      true,
+		SkipBackup:     true,
+		UpdateInterval: time.Hour,
+		Recompact:      true,
+	}
+	dbs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injectFailAt = 3
+	tr := newTracker(collectShards(dbs), opts)
+	if err := tr.Run(); err == nil || !strings.Contains(err.Error(), "Failed to convert "+tr.shards[3].FullPath(dir)+": ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := []rpStats{
+		{database: "db0", retentionPolicy: "rp0", shards: 2, converted: 2, points: 4},
+		{database: "db0", retentionPolicy: "rp1", shards: 2, converted: 1, points: 4},
+	}; !reflect.DeepEqual(tr.rps, exp) {
+		t.Fatalf("unexpected retention policy stats: %+v", tr.rps)
+	}
+	if ok, _, err := tsmreader.NeedsCompaction(tr.shards[4].FullPath(dir)); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected the shard of the next retention policy to be left unconverted")
+	}
+}
+
+// Ensure the percentage converted and the time remaining are estimated from
+// the source size of the shards converted so far.
+func TestEstimateProgress(t *testing.T) {
+	for i, tt := range []struct {
+		done, total uint64
+		elapsed     time.Duration
+		percent     float64
+		eta         time.Duration
+		ok          bool
+	}{
+		{done: 0, total: 100, elapsed: time.Minute, percent: 0},
+		{done: 25, total: 100, elapsed: time.Minute, percent: 25, eta: 3 * time.Minute, ok: true},
+		{done: 30, total: 100, elapsed: 10 * time.Second, percent: 30, eta: 23 * time.Second, ok: true},
+		{done: 100, total: 100, elapsed: time.Minute, percent: 100, ok: true},
+		{done: 0, total: 0, percent: 100, ok: true},
+	} {
+		percent, eta, ok := estimateProgress(tt.done, tt.total, tt.elapsed)
+		if percent != tt.percent || eta != tt.eta || ok != tt.ok {
+			t.Errorf("%d. unexpected estimate: got %v %v %v, exp %v %v %v", i, percent, eta, ok, tt.percent, tt.eta, tt.ok)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package tsdb // import "github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -63,7 +64,7 @@ func (s ShardInfos) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s ShardInfos) Less(i, j int) bool {
 	if s[i].Database == s[j].Database {
 		if s[i].RetentionPolicy == s[j].RetentionPolicy {
-			return s[i].Path < s[i].Path
+			return s[i].Path < s[j].Path
 		}
 
 		return s[i].RetentionPolicy < s[j].RetentionPolicy
@@ -87,6 +88,19 @@ func (s ShardInfos) Databases() []string {
 	return dbs
 }
 
+// ByRetentionPolicy splits the shards, which must be sorted, into groups
+// holding the shards of each database and retention policy, in order.
+func (s ShardInfos) ByRetentionPolicy() []ShardInfos {
+	var groups []ShardInfos
+	for i, si := range s {
+		if i == 0 || si.Database != s[i-1].Database || si.RetentionPolicy != s[i-1].RetentionPolicy {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], si)
+	}
+	return groups
+}
+
 // FilterFormat returns a copy of the ShardInfos, with shards of the given
 // format removed.
 func (s ShardInfos) FilterFormat(fmt EngineFormat) ShardInfos {
@@ -173,18 +187,10 @@ func (d *Database) Shards() ([]*ShardInfo, error) {
 		// Process each shard
 		shards, err := rpfd.Readdirnames(-1)
 		for _, sh := range shards {
-			fmt, sz, err := shardFormat(filepath.Join(d.path, rp, sh))
+			si, err := d.Shard(rp, sh)
 			if err != nil {
 				return nil, err
 			}
-
-			si := &ShardInfo{
-				Database:        d.Name(),
-				RetentionPolicy: path.Base(rp),
-				Path:            sh,
-				Format:          fmt,
-				Size:            sz,
-			}
 			shardInfos = append(shardInfos, si)
 		}
 	}
@@ -193,6 +199,23 @@ func (d *Database) Shards() ([]*ShardInfo, error) {
 	return shardInfos, nil
 }
 
+// Shard returns the description of the shard named sh in the retention
+// policy rp of the database.
+func (d *Database) Shard(rp, sh string) (*ShardInfo, error) {
+	fmt, sz, err := shardFormat(filepath.Join(d.path, rp, sh))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShardInfo{
+		Database:        d.Name(),
+		RetentionPolicy: path.Base(rp),
+		Path:            sh,
+		Format:          fmt,
+		Size:            sz,
+	}, nil
+}
+
 // shardFormat returns the format and size on disk of the shard at path.
 func shardFormat(path string) (EngineFormat, int64, error) {
 	// If it's a directory then it's a tsm1 engine
@@ -201,7 +224,16 @@ func shardFormat(path string) (EngineFormat, int64, error) {
 		return 0, 0, err
 	}
 	if fi.Mode().IsDir() {
-		return TSM1, fi.Size(), nil
+		// The size of a tsm1 shard is the size of the files within it.
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		var sz int64
+		for _, fi := range fis {
+			sz += fi.Size()
+		}
+		return TSM1, sz, nil
 	}
 
 	// It must be a BoltDB-based engine.
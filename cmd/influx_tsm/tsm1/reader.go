@@ -0,0 +1,268 @@
+package tsm1 // import "github.com/influxdata/influxdb/cmd/influx_tsm/tsm1"
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// DefaultChunkSize is the size of chunks read from the tsm1 shard. It matches
+// the number of points in a full tsm1 block.
+const DefaultChunkSize = tsdb.DefaultMaxPointsPerBlock
+
+// Reader is used to read all data from a tsm1 shard, so that it can be
+// re-encoded and re-compacted by the converter. Values for each key are
+// merged across all TSM files in the shard, with the values from later
+// files replacing those from earlier files, and deleted values are dropped.
+type Reader struct {
+	path  string
+	files []*tsm1.TSMReader
+
+	keys    []string
+	currKey int
+
+	keyBuf    string
+	values    []tsm1.Value
+	chunk     []tsm1.Value
+	chunkSize int
+	err       error
+
+	// Only points within [minTime, maxTime] are emitted.
+	minTime, maxTime int64
+
+	// If set, only series for which filter returns true are read.
+	filter func(series string) bool
+
+	stats *stats.Stats
+}
+
+// NewReader returns a reader for the tsm1 shard at path.
+func NewReader(path string, stats *stats.Stats, chunkSize int) *Reader {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	return &Reader{
+		path:      path,
+		chunkSize: chunkSize,
+		minTime:   math.MinInt64,
+		maxTime:   math.MaxInt64,
+		stats:     stats,
+	}
+}
+
+// SetTimeRange restricts the reader to points with timestamps between min
+// and max, inclusive. It must be called before Open.
+func (r *Reader) SetTimeRange(min, max int64) {
+	r.minTime, r.maxTime = min, max
+}
+
+// SetSeriesFilter restricts the reader to the series for which fn returns
+// true. fn is called once for each series in the shard. It must be called
+// before Open.
+func (r *Reader) SetSeriesFilter(fn func(series string) bool) {
+	r.filter = fn
+}
+
+// Open opens the TSM files in the shard.
+func (r *Reader) Open() error {
+	paths, err := tsmFiles(r.path)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]struct{})
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		tr, err := tsm1.NewTSMReader(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		r.files = append(r.files, tr)
+
+		for i := 0; i < tr.KeyCount(); i++ {
+			k, _ := tr.KeyAt(i)
+			keys[string(k)] = struct{}{}
+		}
+	}
+
+	for k := range keys {
+		r.keys = append(r.keys, k)
+	}
+	sort.Strings(r.keys)
+
+	if r.filter != nil {
+		r.filterKeys()
+	}
+	return nil
+}
+
+// filterKeys drops the keys of series rejected by the series filter. The
+// keys of a series are adjacent once sorted, so the filter is called once
+// for each series.
+func (r *Reader) filterKeys() {
+	var (
+		keys []string
+		prev string
+		ok   bool
+	)
+	for i, k := range r.keys {
+		series, _ := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+		if i == 0 || string(series) != prev {
+			prev = string(series)
+			ok = r.filter(prev)
+		}
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	r.keys = keys
+}
+
+// Next returns whether there is any more data to be read.
+func (r *Reader) Next() bool {
+	for len(r.values) == 0 {
+		if r.currKey >= len(r.keys) {
+			// All keys drained. No more data remains.
+			return false
+		}
+
+		r.keyBuf = r.keys[r.currKey]
+		r.currKey++
+		if err := r.readKey(r.keyBuf); err != nil {
+			// Return true so the error is returned by Read.
+			r.err = err
+			return true
+		}
+	}
+
+	n := r.chunkSize
+	if n > len(r.values) {
+		n = len(r.values)
+	}
+	r.chunk, r.values = r.values[:n], r.values[n:]
+	return true
+}
+
+// readKey reads and merges the values of key from every TSM file, dropping
+// values outside the time range and values that cannot be stored.
+func (r *Reader) readKey(key string) error {
+	var values tsm1.Values
+	for _, f := range r.files {
+		if !f.Contains(key) {
+			continue
+		}
+		v, err := f.ReadAll(key)
+		if err != nil {
+			return err
+		}
+		values = append(values, v...)
+	}
+	n := len(values)
+	values = values.Deduplicate()
+
+	// Values replaced by a later file were read but are not written again.
+	r.stats.AddPointsRead(n - len(values))
+
+	r.values = r.values[:0]
+	for _, v := range values {
+		if v.UnixNano() < r.minTime || v.UnixNano() > r.maxTime {
+			r.stats.AddPointsRead(1)
+			r.stats.IncrRangeFiltered()
+			continue
+		}
+
+		if f, ok := v.Value().(float64); ok {
+			if math.IsInf(f, 0) {
+				r.stats.AddPointsRead(1)
+				r.stats.IncrInf()
+				continue
+			}
+
+			if math.IsNaN(f) {
+				r.stats.AddPointsRead(1)
+				r.stats.IncrNaN()
+				continue
+			}
+		}
+
+		r.values = append(r.values, v)
+	}
+	return nil
+}
+
+// Read returns the next chunk of data in the shard. Data is emitted
+// completely for every key, in key order, before the next key is processed.
+func (r *Reader) Read() (string, []tsm1.Value, error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return r.keyBuf, r.chunk, nil
+}
+
+// Close closes the reader.
+func (r *Reader) Close() error {
+	var err error
+	for _, f := range r.files {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	r.files = nil
+	return err
+}
+
+// NeedsCompaction returns whether the tsm1 shard at path would benefit from
+// being re-compacted, along with the reason. A shard whose data is held in a
+// single TSM file without tombstones is already fully compacted.
+func NeedsCompaction(path string) (bool, string, error) {
+	paths, err := tsmFiles(path)
+	if err != nil {
+		return false, "", err
+	}
+
+	if len(paths) > 1 {
+		return true, fmt.Sprintf("%d TSM files", len(paths)), nil
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return false, "", err
+		}
+		tr, err := tsm1.NewTSMReader(f)
+		if err != nil {
+			f.Close()
+			return false, "", fmt.Errorf("%s: %v", path, err)
+		}
+		tombstones := tr.HasTombstones()
+		if err := tr.Close(); err != nil {
+			return false, "", err
+		}
+		if tombstones {
+			return true, "tombstones", nil
+		}
+	}
+	return false, "", nil
+}
+
+// tsmFiles returns the paths of the TSM files in the shard at path, in the
+// order they were written.
+func tsmFiles(path string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(path, "*."+tsm1.TSMFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/stats"
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	tsmreader "github.com/influxdata/influxdb/cmd/influx_tsm/tsm1"
+	influxtsdb "github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// verifiedSuffix is the suffix of the marker written next to the backup of a
+// shard once its conversion has been verified. Only backups with a marker are
+// removed by -keep-backup-days.
+const verifiedSuffix = ".verified"
+
+// seriesSampler selects the series whose conversion is verified. A series
+// is selected by hashing its key with the seed, so the same series are
+// selected by every run with the same seed and rate.
+type seriesSampler struct {
+	rate float64
+	seed int64
+
+	// The number of series selected and skipped, counted by sample.
+	verified uint64
+	skipped  uint64
+}
+
+// newSeriesSampler returns a sampler selecting the fraction rate of series.
+func newSeriesSampler(rate float64, seed int64) *seriesSampler {
+	return &seriesSampler{rate: rate, seed: seed}
+}
+
+// selected returns whether series is verified.
+func (s *seriesSampler) selected(series string) bool {
+	if s.rate >= 1 {
+		return true
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, s.seed)
+	h.Write([]byte(series))
+	return float64(binary.BigEndian.Uint64(h.Sum(nil))>>11)/(1<<53) < s.rate
+}
+
+// sample returns whether series is verified, counting the series.
+func (s *seriesSampler) sample(series string) bool {
+	if !s.selected(series) {
+		atomic.AddUint64(&s.skipped, 1)
+		return false
+	}
+	atomic.AddUint64(&s.verified, 1)
+	return true
+}
+
+// Verified returns the number of series verified and the total number of
+// series seen.
+func (s *seriesSampler) Verified() (n, total uint64) {
+	n = atomic.LoadUint64(&s.verified)
+	return n, n + atomic.LoadUint64(&s.skipped)
+}
+
+// confidence describes what a verification that found no differences says
+// about the series that were not verified. If k series were converted
+// incorrectly, the chance that none of them was selected is (1-rate)^k, so
+// with 95% confidence fewer than the smallest k for which that chance is at
+// most 5% were.
+func (s *seriesSampler) confidence() string {
+	if s.rate >= 1 {
+		return "every series compared"
+	}
+	k := math.Ceil(math.Log(0.05) / math.Log(1-s.rate))
+	return fmt.Sprintf("95%% that fewer than %d series differ", int64(k))
+}
+
+// verifyShard reads the source shard si again, over the time range min to
+// max, and compares it point by point with its converted output at dst. If
+// sampler is set, only the series it selects are compared. Once every series
+// has been compared, the output is also opened as a shard, and the number of
+// values recorded in its block headers must match the number compared. It
+// returns an error describing the first difference found.
+func verifyShard(si *tsdb.ShardInfo, dst string, min, max int64, sampler *seriesSampler) error {
+	src, err := newShardReader(si, si.FullPath(opts.DataPath), new(stats.Stats))
+	if err != nil {
+		return err
+	}
+	src.SetTimeRange(min, max)
+	out := tsmreader.NewReader(dst, new(stats.Stats), 0)
+	if sampler != nil {
+		src.SetSeriesFilter(sampler.sample)
+		out.SetSeriesFilter(sampler.selected)
+	}
+
+	if err := src.Open(); err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := out.Open(); err != nil {
+		return err
+	}
+	defer out.Close()
+
+	exp, got := newPointIterator(src), newPointIterator(out)
+	var n int64
+	for {
+		ek, ev, eok, err := exp.next()
+		if err != nil {
+			return err
+		}
+		gk, gv, gok, err := got.next()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !eok && !gok:
+			if sampler != nil && sampler.rate < 1 {
+				return nil
+			}
+			return checkValueCount(dst, n)
+		case !gok:
+			return fmt.Errorf("point of %s at %d is missing", ek, ev.UnixNano())
+		case !eok:
+			return fmt.Errorf("unexpected point of %s at %d", gk, gv.UnixNano())
+		case ek != gk:
+			return fmt.Errorf("expected point of %s, got point of %s", ek, gk)
+		case ev.UnixNano() != gv.UnixNano():
+			return fmt.Errorf("expected point of %s at %d, got point at %d", ek, ev.UnixNano(), gv.UnixNano())
+		case reflect.TypeOf(ev.Value()) != reflect.TypeOf(gv.Value()):
+			return fmt.Errorf("point of %s at %d is %v of type %T, expected %v of type %T", ek, ev.UnixNano(), gv.Value(), gv.Value(), ev.Value(), ev.Value())
+		case ev.Value() != gv.Value():
+			return fmt.Errorf("point of %s at %d is %v, expected %v", ek, ev.UnixNano(), gv.Value(), ev.Value())
+		}
+		n++
+	}
+}
+
+// checkValueCount opens the converted shard at dst read-only and returns an
+// error unless the number of values counted from its block headers is n.
+// Values found in more than one of its files are counted once for each.
+func checkValueCount(dst string, n int64) error {
+	options := influxtsdb.NewEngineOptions()
+	options.ReadOnly = true
+	sh := influxtsdb.NewShard(0, influxtsdb.NewDatabaseIndex(""), dst, filepath.Join(dst, "wal"), options)
+	sh.SetLogOutput(ioutil.Discard)
+	if err := sh.Open(); err != nil {
+		return err
+	}
+	defer sh.Close()
+
+	stats, err := sh.Stats()
+	if err != nil {
+		return err
+	} else if stats.Values != n {
+		return fmt.Errorf("converted shard holds %d values, expected %d", stats.Values, n)
+	}
+	return nil
+}
+
+// pointIterator iterates over the points of a KeyIterator one at a time.
+type pointIterator struct {
+	iter   KeyIterator
+	key    string
+	values []tsm1.Value
+}
+
+func newPointIterator(iter KeyIterator) *pointIterator {
+	return &pointIterator{iter: iter}
+}
+
+// next returns the key and value of the next point, or false once there are
+// no more points.
+func (itr *pointIterator) next() (string, tsm1.Value, bool, error) {
+	for len(itr.values) == 0 {
+		if !itr.iter.Next() {
+			return "", nil, false, nil
+		}
+		k, v, err := itr.iter.Read()
+		if err != nil {
+			return "", nil, false, err
+		}
+		itr.key, itr.values = k, v
+	}
+
+	v := itr.values[0]
+	itr.values = itr.values[1:]
+	return itr.key, v, true, nil
+}
+
+// backupPath returns the path of the backup of si.
+func backupPath(si *tsdb.ShardInfo) string {
+	return filepath.Join(opts.BackupPath, si.Database, si.RetentionPolicy, si.Path)
+}
+
+// markVerified records that the conversion of the shard backed up at path
+// was verified. The modification time of the marker is the time the
+// conversion was verified.
+func markVerified(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path + verifiedSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "verified %s\n", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeExpiredBackups removes the backups of shards under dir whose
+// conversion was verified longer than maxAge before now, along with their
+// markers. Backups without a marker are kept. It returns the paths of the
+// removed backups.
+func removeExpiredBackups(dir string, maxAge time.Duration, now time.Time) ([]string, error) {
+	var markers []string
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, verifiedSuffix) && now.Sub(info.ModTime()) > maxAge {
+			markers = append(markers, path)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, marker := range markers {
+		path := strings.TrimSuffix(marker, verifiedSuffix)
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		if err := os.Remove(marker); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// cleanupBackups removes the backups of conversions verified more than
+// -keep-backup-days ago.
+func cleanupBackups() {
+	maxAge := time.Duration(opts.KeepBackupDays) * 24 * time.Hour
+	removed, err := removeExpiredBackups(opts.BackupPath, maxAge, time.Now())
+	for _, path := range removed {
+		logger.Info(Fields{"event": "backup_removed", "path": path}, "Removed backup %v, verified more than %d days ago", path, opts.KeepBackupDays)
+	}
+	if err != nil {
+		logger.Warn(Fields{"error": err}, "Removal of old backups failed: %v", err)
+	}
+	fmt.Printf("Old backups removed:                 %d\n", len(removed))
+}
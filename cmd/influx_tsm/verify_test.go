@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influx_tsm/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Ensure a converted shard is verified against its source, and that missing,
+// extra and changed points are reported.
+func TestVerifyShard(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir}
+
+	// A fragmented tsm1 shard, whose second file replaces a value.
+	si := &tsdb.ShardInfo{Database: "db0", RetentionPolicy: "rp0", Path: "1", Format: tsdb.TSM1}
+	MustWriteTSMFile(filepath.Join(dir, "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+		"mem#!~#value": {tsm1.NewValue(0, int64(3))},
+	})
+	MustWriteTSMFile(filepath.Join(dir, "db0", "rp0", "1", "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(10, 4.0)},
+	})
+
+	for i, tt := range []struct {
+		values map[string][]tsm1.Value
+		err    string
+	}{
+		{
+			values: map[string][]tsm1.Value{
+				"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 4.0)},
+				"mem#!~#value": {tsm1.NewValue(0, int64(3))},
+			},
+		},
+		{
+			values: map[string][]tsm1.Value{
+				"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+				"mem#!~#value": {tsm1.NewValue(0, int64(3))},
+			},
+			err: "point of cpu#!~#value at 10 is 2, expected 4",
+		},
+		{
+			values: map[string][]tsm1.Value{
+				"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 4.0)},
+			},
+			err: "point of mem#!~#value at 0 is missing",
+		},
+		{
+			values: map[string][]tsm1.Value{
+				"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 4.0), tsm1.NewValue(20, 5.0)},
+				"mem#!~#value": {tsm1.NewValue(0, int64(3))},
+			},
+			err: "expected point of mem#!~#value, got point of cpu#!~#value",
+		},
+		{
+			values: map[string][]tsm1.Value{
+				"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 4.0)},
+				"mem#!~#value": {tsm1.NewValue(0, 3.0)},
+			},
+			err: "point of mem#!~#value at 0 is 3 of type float64, expected 3 of type int64",
+		},
+	} {
+		dst := filepath.Join(dir, "out", strings.Repeat("x", i+1))
+		MustWriteTSMFile(filepath.Join(dst, "000000001-000000001.tsm"), tt.values)
+
+		err := verifyShard(si, dst, math.MinInt64, math.MaxInt64, nil)
+		if tt.err == "" && err != nil {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%d. unexpected error: got %v, exp %q", i, err, tt.err)
+		}
+	}
+}
+
+// Ensure a converted shard whose block headers count more values than were
+// compared fails verification.
+func TestVerifyShard_ValueCount(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir}
+
+	si := &tsdb.ShardInfo{Database: "db0", RetentionPolicy: "rp0", Path: "1", Format: tsdb.TSM1}
+	MustWriteTSMFile(filepath.Join(dir, "db0", "rp0", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+	})
+
+	// The output holds the same points, but the second is in two files.
+	dst := filepath.Join(dir, "out")
+	MustWriteTSMFile(filepath.Join(dst, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 2.0)},
+	})
+	MustWriteTSMFile(filepath.Join(dst, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(10, 2.0)},
+	})
+
+	err := verifyShard(si, dst, math.MinInt64, math.MaxInt64, nil)
+	if exp := "converted shard holds 3 values, expected 2"; err == nil || err.Error() != exp {
+		t.Fatalf("unexpected error: got %v, exp %q", err, exp)
+	}
+
+	// Only the points of the sampled series are compared, so the values of
+	// the shard are not counted.
+	if err := verifyShard(si, dst, math.MinInt64, math.MaxInt64, newSeriesSampler(0.99, 0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure only the series selected by the sampler are compared and counted.
+func TestVerifyShard_Sampled(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(o options) { opts = o }(opts)
+	opts = options{DataPath: dir}
+
+	src, dst := make(map[string][]tsm1.Value), make(map[string][]tsm1.Value)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("cpu,host=server%02d#!~#value", i)
+		src[key] = []tsm1.Value{tsm1.NewValue(0, float64(i))}
+		dst[key] = src[key]
+	}
+	si := &tsdb.ShardInfo{Database: "db0", RetentionPolicy: "rp0", Path: "1", Format: tsdb.TSM1}
+	MustWriteTSMFile(filepath.Join(dir, "db0", "rp0", "1", "000000001-000000001.tsm"), src)
+
+	// Change a point of a series selected by one seed but not the other.
+	var seed int64
+	for ; ; seed++ {
+		if newSeriesSampler(0.5, seed).selected("cpu,host=server00") && !newSeriesSampler(0.5, seed+1).selected("cpu,host=server00") {
+			break
+		}
+	}
+	dst["cpu,host=server00#!~#value"] = []tsm1.Value{tsm1.NewValue(0, 1.0)}
+	MustWriteTSMFile(filepath.Join(dir, "out", "000000001-000000001.tsm"), dst)
+
+	sampler := newSeriesSampler(0.5, seed+1)
+	if err := verifyShard(si, filepath.Join(dir, "out"), math.MinInt64, math.MaxInt64, sampler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, total := sampler.Verified(); total != 100 || n == 0 || n == 100 {
+		t.Fatalf("unexpected series verified: %d of %d", n, total)
+	}
+
+	err := verifyShard(si, filepath.Join(dir, "out"), math.MinInt64, math.MaxInt64, newSeriesSampler(0.5, seed))
+	if exp := "point of cpu,host=server00#!~#value at 0 is 1, expected 0"; err == nil || err.Error() != exp {
+		t.Fatalf("unexpected error: got %v, exp %q", err, exp)
+	}
+}
+
+// Ensure series are sampled deterministically, at roughly the sample rate.
+func TestSeriesSampler(t *testing.T) {
+	a, b, c := newSeriesSampler(0.1, 1), newSeriesSampler(0.1, 1), newSeriesSampler(0.1, 2)
+	var differ bool
+	for i := 0; i < 10000; i++ {
+		series := fmt.Sprintf("cpu,host=server%d", i)
+		if a.sample(series) != b.selected(series) {
+			t.Fatalf("series %s sampled differently with the same seed", series)
+		}
+		if a.selected(series) != c.selected(series) {
+			differ = true
+		}
+	}
+	if !differ {
+		t.Fatal("expected a different seed to select different series")
+	}
+	if n, total := a.Verified(); total != 10000 || n < 900 || n > 1100 {
+		t.Fatalf("unexpected series verified: %d of %d", n, total)
+	}
+
+	if s := newSeriesSampler(0.01, 0).confidence(); s != "95% that fewer than 299 series differ" {
+		t.Fatalf("unexpected confidence: %s", s)
+	} else if s := newSeriesSampler(1, 0).confidence(); s != "every series compared" {
+		t.Fatalf("unexpected confidence: %s", s)
+	}
+}
+
+// Ensure only backups of conversions verified before the cutoff are removed.
+func TestRemoveExpiredBackups(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for _, b := range []struct {
+		path     string
+		verified time.Duration
+	}{
+		{path: filepath.Join(dir, "db0", "rp0", "1"), verified: 10 * 24 * time.Hour},
+		{path: filepath.Join(dir, "db0", "rp0", "2"), verified: 24 * time.Hour},
+		{path: filepath.Join(dir, "db0", "rp0", "3")},
+	} {
+		MustWriteFile(filepath.Join(b.path, "000000001-000000001.tsm"), "data")
+		if b.verified == 0 {
+			continue
+		}
+		if err := markVerified(b.path); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-b.verified)
+		if err := os.Chtimes(b.path+verifiedSuffix, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := removeExpiredBackups(dir, 7*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []string{filepath.Join(dir, "db0", "rp0", "1")}; !reflect.DeepEqual(removed, exp) {
+		t.Fatalf("unexpected removed backups: %v", removed)
+	}
+	MustNotExist(t, filepath.Join(dir, "db0", "rp0", "1"))
+	MustNotExist(t, filepath.Join(dir, "db0", "rp0", "1"+verifiedSuffix))
+	for _, path := range []string{
+		filepath.Join(dir, "db0", "rp0", "2"),
+		filepath.Join(dir, "db0", "rp0", "2"+verifiedSuffix),
+		filepath.Join(dir, "db0", "rp0", "3"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept: %v", path, err)
+		}
+	}
+}
@@ -8,14 +8,29 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/models"
 )
 
 const batchSize = 5000
 
+// maxFormatVersion is the latest export format version the importer reads.
+// Exports without a # FORMAT-VERSION header are version 1.
+const maxFormatVersion = 3
+
+// exportPrecisions maps the units named by the # PRECISION header of an
+// export to the precisions of writes.
+var exportPrecisions = map[string]string{
+	"ns": "ns",
+	"us": "u",
+	"ms": "ms",
+	"s":  "s",
+}
+
 // Config is the config used to initialize a Importer importer
 type Config struct {
 	Username         string
@@ -47,6 +62,7 @@ type Importer struct {
 	throttlePointsWritten int
 	lastWrite             time.Time
 	throttle              *time.Ticker
+	escapedNewlines       bool
 }
 
 // NewImporter will return an intialized Importer struct
@@ -114,7 +130,9 @@ func (i *Importer) Import() error {
 	scanner := bufio.NewScanner(r)
 
 	// Process the DDL
-	i.processDDL(scanner)
+	if err := i.processDDL(scanner); err != nil {
+		return err
+	}
 
 	// Set up our throttle channel.  Since there is effectively no other activity at this point
 	// the smaller resolution gets us much closer to the requested PPS
@@ -135,12 +153,25 @@ func (i *Importer) Import() error {
 	return nil
 }
 
-func (i *Importer) processDDL(scanner *bufio.Scanner) {
+func (i *Importer) processDDL(scanner *bufio.Scanner) error {
 	for scanner.Scan() {
 		line := scanner.Text()
 		// If we find the DML token, we are done with DDL
 		if strings.HasPrefix(line, "# DML") {
-			return
+			return nil
+		}
+		if strings.HasPrefix(line, "# FORMAT-VERSION:") {
+			if err := checkFormatVersion(strings.TrimPrefix(line, "# FORMAT-VERSION:")); err != nil {
+				return err
+			}
+		}
+		if strings.HasPrefix(line, "# PRECISION:") {
+			if err := i.checkPrecision(strings.TrimPrefix(line, "# PRECISION:")); err != nil {
+				return err
+			}
+		}
+		if strings.HasPrefix(line, "# ESCAPED-NEWLINES") {
+			i.escapedNewlines = true
 		}
 		if strings.HasPrefix(line, "#") {
 			continue
@@ -151,6 +182,39 @@ func (i *Importer) processDDL(scanner *bufio.Scanner) {
 		}
 		i.queryExecutor(line)
 	}
+	return nil
+}
+
+// checkFormatVersion returns an error if the export format version in the
+// # FORMAT-VERSION header cannot be imported.
+func checkFormatVersion(s string) error {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || v < 1 {
+		return fmt.Errorf("invalid export format version %q", s)
+	} else if v > maxFormatVersion {
+		return fmt.Errorf("export format version %d is newer than the latest version this importer reads (%d), use a newer influx to import it", v, maxFormatVersion)
+	}
+	return nil
+}
+
+// checkPrecision returns an error if the timestamps of an export, in the unit
+// named by its # PRECISION header, would be misread with the precision the
+// importer writes with. Writes without a precision are in nanoseconds.
+func (i *Importer) checkPrecision(s string) error {
+	unit := strings.TrimSpace(s)
+	p, ok := exportPrecisions[unit]
+	if !ok {
+		return fmt.Errorf("invalid export precision %q", s)
+	}
+
+	configured := i.config.Precision
+	if configured == "" {
+		configured = "ns"
+	}
+	if configured != p {
+		return fmt.Errorf("export timestamps are in %s but the import precision is %s, import with -precision %s", unit, configured, p)
+	}
+	return nil
 }
 
 func (i *Importer) processDML(scanner *bufio.Scanner) {
@@ -170,6 +234,9 @@ func (i *Importer) processDML(scanner *bufio.Scanner) {
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
+		if i.escapedNewlines {
+			line = models.UnescapeStringFieldNewlines(line)
+		}
 		i.batchAccumulator(line, start)
 	}
 	// Call batchWrite one last time to flush anything out in the batch
@@ -0,0 +1,132 @@
+package v8
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Ensure exports in versions the importer can not read are rejected before
+// anything is written.
+func TestImporter_Import_FormatVersion(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		err    string
+	}{
+		{header: "# FORMAT-VERSION:3"},
+		{header: "# FORMAT-VERSION:4", err: "export format version 4 is newer than the latest version this importer reads (3)"},
+		{header: "# FORMAT-VERSION:x", err: `invalid export format version "x"`},
+		{header: "# FORMAT-VERSION:0", err: `invalid export format version "0"`},
+	} {
+		s := NewServer()
+		err := s.Import(&Config{}, tt.header+"\n# DDL\n# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 10\n")
+		s.Close()
+
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.header, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: unexpected error: got %v, exp %q", tt.header, err, tt.err)
+		} else if tt.err != "" && len(s.Writes) > 0 {
+			t.Errorf("%s: unexpected writes: %q", tt.header, s.Writes)
+		}
+	}
+}
+
+// Ensure an export is only imported with the precision of its timestamps.
+func TestImporter_Import_Precision(t *testing.T) {
+	for _, tt := range []struct {
+		header    string
+		precision string
+		err       string
+	}{
+		{header: "# PRECISION:ns"},
+		{header: "# PRECISION:ns", precision: "ns"},
+		{header: "# PRECISION:us", precision: "u"},
+		{header: "# PRECISION:s", precision: "s"},
+		{header: "# PRECISION:ms", err: "export timestamps are in ms but the import precision is ns, import with -precision ms"},
+		{header: "# PRECISION:ns", precision: "s", err: "export timestamps are in ns but the import precision is s, import with -precision ns"},
+		{header: "# PRECISION:m", err: `invalid export precision "m"`},
+	} {
+		s := NewServer()
+		err := s.Import(&Config{Precision: tt.precision}, "# FORMAT-VERSION:3\n"+tt.header+"\n# DML\n# CONTEXT-DATABASE:db0\ncpu value=1 10\n")
+		s.Close()
+
+		if tt.err == "" && err != nil {
+			t.Errorf("%s with %q: unexpected error: %s", tt.header, tt.precision, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s with %q: unexpected error: got %v, exp %q", tt.header, tt.precision, err, tt.err)
+		}
+	}
+}
+
+// Ensure newlines escaped in string field values are restored once an
+// export declares them escaped, and left alone otherwise.
+func TestImporter_Import_EscapedNewlines(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	if err := s.Import(&Config{}, "# ESCAPED-NEWLINES\n# DDL\n# DML\n# CONTEXT-DATABASE:db0\n"+`log msg="a\nb\\n\rc",n=1i 10`+"\n"); err != nil {
+		t.Fatal(err)
+	} else if exp := "log msg=\"a\nb\\\\n\rc\",n=1i 10"; len(s.Writes) != 1 || s.Writes[0] != exp {
+		t.Fatalf("unexpected writes: %q", s.Writes)
+	}
+
+	s.Writes = nil
+	if err := s.Import(&Config{}, "# DDL\n# DML\n# CONTEXT-DATABASE:db0\n"+`log msg="a\nb" 10`+"\n"); err != nil {
+		t.Fatal(err)
+	} else if exp := `log msg="a\nb" 10`; len(s.Writes) != 1 || s.Writes[0] != exp {
+		t.Fatalf("unexpected writes: %q", s.Writes)
+	}
+}
+
+// Server is a test HTTP server recording the bodies of the writes it
+// receives.
+type Server struct {
+	*httptest.Server
+	Writes []string
+}
+
+// NewServer returns a new, running test server.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/write":
+			body, _ := ioutil.ReadAll(r.Body)
+			s.Writes = append(s.Writes, string(body))
+			w.WriteHeader(http.StatusNoContent)
+		case "/query":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"results":[{}]}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	return s
+}
+
+// Import imports the export data into the server with the settings of c.
+func (s *Server) Import(c *Config, data string) error {
+	f, err := ioutil.TempFile("", "influxdb-import-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	c.URL = *u
+	c.Path = f.Name()
+	return NewImporter(c).Import()
+}
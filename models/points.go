@@ -1154,6 +1154,61 @@ func unescapeStringField(in string) string {
 	return string(out)
 }
 
+// EscapeStringFieldNewlines returns a copy of in with any newlines or
+// carriage returns replaced by \n and \r. in is expected to have been escaped
+// with EscapeStringField first so that the sequences are unambiguous.
+func EscapeStringFieldNewlines(in string) string {
+	if strings.IndexAny(in, "\n\r") == -1 {
+		return in
+	}
+
+	out := make([]byte, 0, len(in)+2)
+	for i := 0; i < len(in); i++ {
+		switch in[i] {
+		case '\n':
+			out = append(out, '\\', 'n')
+		case '\r':
+			out = append(out, '\\', 'r')
+		default:
+			out = append(out, in[i])
+		}
+	}
+	return string(out)
+}
+
+// UnescapeStringFieldNewlines returns a copy of the line protocol in with any
+// \n and \r sequences inside string field values replaced by newlines and
+// carriage returns. It reverses EscapeStringFieldNewlines.
+func UnescapeStringFieldNewlines(in string) string {
+	if strings.IndexByte(in, '\\') == -1 {
+		return in
+	}
+
+	out := make([]byte, 0, len(in))
+	fields, quoted := false, false
+	for i := 0; i < len(in); i++ {
+		if in[i] == '\\' && i+1 < len(in) {
+			if quoted && in[i+1] == 'n' {
+				out = append(out, '\n')
+			} else if quoted && in[i+1] == 'r' {
+				out = append(out, '\r')
+			} else {
+				out = append(out, in[i], in[i+1])
+			}
+			i++
+			continue
+		}
+
+		if in[i] == ' ' {
+			fields = true
+		} else if in[i] == '"' && fields {
+			quoted = !quoted
+		}
+		out = append(out, in[i])
+	}
+	return string(out)
+}
+
 // NewPoint returns a new point with the given measurement name, tags, fields and timestamp.  If
 // an unsupported field value (NaN) or out of range time is passed, this function returns an error.
 func NewPoint(name string, tags Tags, fields Fields, t time.Time) (Point, error) {
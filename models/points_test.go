@@ -1177,6 +1177,29 @@ func TestParsePointEscapedStringsAndCommas(t *testing.T) {
 	)
 }
 
+func TestStringFieldNewlines_RoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"no newlines",
+		"line one\nline two",
+		"crlf\r\nend\n",
+		`literal \n and \r "quoted"`,
+	} {
+		line := `cpu\ a,host=\n value="` + models.EscapeStringFieldNewlines(models.EscapeStringField(s)) + `" 1000000000`
+		if strings.ContainsAny(line, "\n\r") {
+			t.Fatalf("escaped line contains a newline: %q", line)
+		}
+
+		test(t, models.UnescapeStringFieldNewlines(line),
+			NewTestPoint(
+				"cpu a",
+				models.NewTags(map[string]string{"host": `\n`}),
+				models.Fields{"value": s},
+				time.Unix(1, 0)),
+		)
+	}
+}
+
 func TestParsePointWithStringWithEquals(t *testing.T) {
 	test(t, `cpu,host=serverA,region=us-east str="foo=bar",value=1.0 1000000000`,
 		NewTestPoint(
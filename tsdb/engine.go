@@ -53,6 +53,23 @@ type Engine interface {
 	io.WriterTo
 }
 
+// EngineInspector is implemented by engines that can report on their data
+// from block metadata and read points without a query, as used by the
+// inspection tools. Shard methods needing it return ErrInspectionUnsupported
+// if the engine does not implement it.
+type EngineInspector interface {
+	ValueCount() (int64, error)
+	ValueCountByMeasurement() (map[string]int64, error)
+	TimeRange() (min, max int64)
+	MeasurementTimeRange(name string) (min, max int64)
+	SeriesTimeRange(name string, seriesKeys []string) (min, max int64)
+	MeasurementSize(name string) int64
+	SeriesBlockStats(key string) (SeriesBlockStats, error)
+	Stats() (ShardStats, error)
+	CompactionState() CompactionState
+	ReadPoints(measurement string, seriesKeys, fields []string, min, max int64, ascending bool, fn func(points []models.Point) error) error
+}
+
 // EngineFormat represents the format for an engine.
 type EngineFormat int
 
@@ -61,6 +78,73 @@ const (
 	TSM1Format EngineFormat = 2
 )
 
+// String returns the name of the format.
+func (f EngineFormat) String() string {
+	switch f {
+	case TSM1Format:
+		return "tsm1"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(f))
+	}
+}
+
+// CompactionStatus describes how compacted a shard's files are.
+type CompactionStatus int
+
+const (
+	// CompactionOptimal means the shard's files are a single generation
+	// without tombstones, so no compaction is needed.
+	CompactionOptimal CompactionStatus = iota
+
+	// CompactionNeeded means the shard's files span several generations or
+	// have tombstones, so they will be compacted.
+	CompactionNeeded
+
+	// CompactionPartial means temporary files left by an interrupted
+	// compaction or snapshot were found when the shard was opened. They are
+	// removed on open, and the compaction is planned again.
+	CompactionPartial
+)
+
+// String returns the name of the status.
+func (s CompactionStatus) String() string {
+	switch s {
+	case CompactionOptimal:
+		return "optimal"
+	case CompactionNeeded:
+		return "needs-compaction"
+	case CompactionPartial:
+		return "partial-temp-files"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// CompactionState describes the compaction state of a shard's files.
+type CompactionState struct {
+	Status CompactionStatus
+
+	// Files is the number of data files and Generations the number of
+	// generations they belong to. Tombstones is the number of data files
+	// with tombstones.
+	Files       int
+	Generations int
+	Tombstones  int
+
+	// PlannedFiles is the number of files the engine would compact now.
+	PlannedFiles int
+
+	// TempFiles is the number of temporary files and directories left by
+	// interrupted compactions and snapshots, found when the shard was opened.
+	TempFiles int
+
+	// WALSegments is the number of non-empty WAL segment files and WALPoints
+	// the number of points loaded from them into the cache, yet to be
+	// written to data files. Tools reading only the data files miss them.
+	WALSegments int
+	WALPoints   int64
+}
+
 // NewEngineFunc creates a new engine.
 type NewEngineFunc func(path string, walPath string, options EngineOptions) Engine
 
@@ -116,6 +200,11 @@ func NewEngine(path string, walPath string, options EngineOptions) (Engine, erro
 type EngineOptions struct {
 	EngineVersion string
 
+	// ReadOnly opens the engine without changing its files: the WAL is
+	// replayed but not written, leftover temporary files are kept, and
+	// compactions never start. Writes and deletes return ErrEngineReadOnly.
+	ReadOnly bool
+
 	Config Config
 }
 
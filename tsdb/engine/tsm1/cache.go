@@ -519,6 +519,10 @@ func (c *Cache) entry(key string) *entry {
 type CacheLoader struct {
 	files []string
 
+	// ReadOnly opens the segment files for reading only. A corrupt segment
+	// is then read up to the corruption but not truncated.
+	ReadOnly bool
+
 	Logger *log.Logger
 }
 
@@ -532,12 +536,16 @@ func NewCacheLoader(files []string) *CacheLoader {
 
 // Load returns a cache loaded with the data contained within the segment files.
 // If, during reading of a segment file, corruption is encountered, that segment
-// file is truncated up to and including the last valid byte, and processing
-// continues with the next segment file.
+// file is truncated up to and including the last valid byte, unless the loader
+// is read-only, and processing continues with the next segment file.
 func (cl *CacheLoader) Load(cache *Cache) error {
 	for _, fn := range cl.files {
 		if err := func() error {
-			f, err := os.OpenFile(fn, os.O_CREATE|os.O_RDWR, 0666)
+			flag := os.O_CREATE | os.O_RDWR
+			if cl.ReadOnly {
+				flag = os.O_RDONLY
+			}
+			f, err := os.OpenFile(fn, flag, 0666)
 			if err != nil {
 				return err
 			}
@@ -556,6 +564,10 @@ func (cl *CacheLoader) Load(cache *Cache) error {
 				entry, err := r.Read()
 				if err != nil {
 					n := r.Count()
+					if cl.ReadOnly {
+						cl.Logger.Printf("file %s corrupt at position %d, skipping the rest", f.Name(), n)
+						break
+					}
 					cl.Logger.Printf("file %s corrupt at position %d, truncating", f.Name(), n)
 					if err := f.Truncate(n); err != nil {
 						return err
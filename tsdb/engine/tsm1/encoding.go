@@ -161,6 +161,23 @@ func (a Values) Encode(buf []byte) ([]byte, error) {
 	return nil, fmt.Errorf("unsupported value type %T", a[0])
 }
 
+// BlockTypeToInfluxQLDataType returns the influxql.DataType of the values
+// stored in blocks of typ, or influxql.Unknown if typ is not a block type.
+func BlockTypeToInfluxQLDataType(typ byte) influxql.DataType {
+	switch typ {
+	case BlockFloat64:
+		return influxql.Float
+	case BlockInteger:
+		return influxql.Integer
+	case BlockBoolean:
+		return influxql.Boolean
+	case BlockString:
+		return influxql.String
+	default:
+		return influxql.Unknown
+	}
+}
+
 // InfluxQLType returns the influxql.DataType the values map to.
 func (a Values) InfluxQLType() (influxql.DataType, error) {
 	if len(a) == 0 {
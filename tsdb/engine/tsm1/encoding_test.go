@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/influxdata/influxdb/influxql"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 )
 
@@ -256,6 +257,36 @@ func TestEncoding_Count(t *testing.T) {
 	}
 }
 
+// Ensure the type of each block maps to the field type names reported by
+// SHOW FIELD KEYS.
+func TestBlockTypeToInfluxQLDataType(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		exp   string
+	}{
+		{value: 1.5, exp: "float"},
+		{value: int64(1), exp: "integer"},
+		{value: true, exp: "boolean"},
+		{value: "a", exp: "string"},
+	} {
+		b, err := tsm1.Values{tsm1.NewValue(0, tt.value)}.Encode(nil)
+		if err != nil {
+			t.Fatalf("%T: unexpected error: %v", tt.value, err)
+		}
+		typ, err := tsm1.BlockType(b)
+		if err != nil {
+			t.Fatalf("%T: unexpected error: %v", tt.value, err)
+		}
+		if got := tsm1.BlockTypeToInfluxQLDataType(typ); got.String() != tt.exp {
+			t.Fatalf("%T: unexpected type: got %s, exp %s", tt.value, got, tt.exp)
+		}
+	}
+
+	if got := tsm1.BlockTypeToInfluxQLDataType(255); got != influxql.Unknown {
+		t.Fatalf("unexpected type of unknown block: %s", got)
+	}
+}
+
 func TestValues_MergeFloat(t *testing.T) {
 	tests := []struct {
 		a, b, exp []tsm1.Value
@@ -30,8 +30,9 @@ func init() {
 	tsdb.RegisterEngine("tsm1", NewEngine)
 }
 
-// Ensure Engine implements the interface.
+// Ensure Engine implements the interfaces.
 var _ tsdb.Engine = &Engine{}
+var _ tsdb.EngineInspector = &Engine{}
 
 const (
 	// keyFieldSeparator separates the series key from the field name in the composite key
@@ -93,6 +94,15 @@ type Engine struct {
 	// Controls whether to enabled compactions when the engine is open
 	enableCompactionsOnOpen bool
 
+	// readOnly keeps the engine from changing its files, see
+	// tsdb.EngineOptions.ReadOnly.
+	readOnly bool
+
+	// tempFiles is the number of temporary files and directories left by
+	// interrupted compactions and snapshots, removed when the engine opened
+	// unless it is read-only.
+	tempFiles int
+
 	stats *EngineStatistics
 }
 
@@ -130,6 +140,7 @@ func NewEngine(path string, walPath string, opt tsdb.EngineOptions) tsdb.Engine
 		CacheFlushMemorySizeThreshold: opt.Config.CacheSnapshotMemorySize,
 		CacheFlushWriteColdDuration:   time.Duration(opt.Config.CacheSnapshotWriteColdDuration),
 		enableCompactionsOnOpen:       true,
+		readOnly:                      opt.ReadOnly,
 		stats: &EngineStatistics{},
 	}
 
@@ -151,6 +162,11 @@ func (e *Engine) SetEnabled(enabled bool) {
 // all running compactions are aborted and new compactions stop running.
 func (e *Engine) SetCompactionsEnabled(enabled bool) {
 	if enabled {
+		// A read-only engine never compacts.
+		if e.readOnly {
+			return
+		}
+
 		e.mu.Lock()
 		if e.compactionsEnabled {
 			e.mu.Unlock()
@@ -229,6 +245,378 @@ func (e *Engine) Format() tsdb.EngineFormat {
 	return tsdb.TSM1Format
 }
 
+// TimeRange returns the minimum and maximum timestamps held by the engine's
+// TSM files and cache. If the engine holds no data then min is greater than max.
+func (e *Engine) TimeRange() (min, max int64) {
+	min, max = math.MaxInt64, math.MinInt64
+	for _, st := range e.FileStore.Stats() {
+		if st.MinTime < min {
+			min = st.MinTime
+		}
+		if st.MaxTime > max {
+			max = st.MaxTime
+		}
+	}
+
+	for _, key := range e.Cache.Keys() {
+		values := e.Cache.Values(key)
+		if len(values) == 0 {
+			continue
+		}
+		if t := values[0].UnixNano(); t < min {
+			min = t
+		}
+		if t := values[len(values)-1].UnixNano(); t > max {
+			max = t
+		}
+	}
+	return min, max
+}
+
+// MeasurementTimeRange returns the minimum and maximum timestamps of the
+// named measurement's data held by the engine's TSM files and cache. Only
+// block metadata is read from the TSM files. If the engine holds no data for
+// the measurement then min is greater than max.
+func (e *Engine) MeasurementTimeRange(name string) (min, max int64) {
+	e.mu.RLock()
+	m := e.index.Measurement(name)
+	e.mu.RUnlock()
+	if m == nil {
+		return math.MaxInt64, math.MinInt64
+	}
+	return e.SeriesTimeRange(name, m.SeriesKeys())
+}
+
+// SeriesTimeRange returns the minimum and maximum timestamps of the data of
+// the named measurement's series with the given keys, read as by
+// MeasurementTimeRange. If the engine holds no data for the series then min
+// is greater than max.
+func (e *Engine) SeriesTimeRange(name string, seriesKeys []string) (min, max int64) {
+	min, max = math.MaxInt64, math.MinInt64
+
+	e.mu.RLock()
+	mf := e.measurementFields[name]
+	e.mu.RUnlock()
+	if mf == nil {
+		return min, max
+	}
+
+	fields := mf.FieldSet()
+	keys := make([]string, 0, len(seriesKeys)*len(fields))
+	for _, sk := range seriesKeys {
+		for field := range fields {
+			keys = append(keys, SeriesFieldKey(sk, field))
+		}
+	}
+
+	min, max = e.FileStore.KeysTimeRange(keys)
+	for _, key := range keys {
+		values := e.Cache.Values(key)
+		if len(values) == 0 {
+			continue
+		}
+		if t := values[0].UnixNano(); t < min {
+			min = t
+		}
+		if t := values[len(values)-1].UnixNano(); t > max {
+			max = t
+		}
+	}
+	return min, max
+}
+
+// MeasurementSize returns the total size of the blocks in the engine's TSM
+// files holding the named measurement's data. Only the TSM indexes are read.
+func (e *Engine) MeasurementSize(name string) int64 {
+	e.mu.RLock()
+	m, mf := e.index.Measurement(name), e.measurementFields[name]
+	e.mu.RUnlock()
+	if m == nil || mf == nil {
+		return 0
+	}
+
+	fields := mf.FieldSet()
+	seriesKeys := m.SeriesKeys()
+	keys := make([]string, 0, len(seriesKeys)*len(fields))
+	for _, sk := range seriesKeys {
+		for field := range fields {
+			keys = append(keys, SeriesFieldKey(sk, field))
+		}
+	}
+	return e.FileStore.KeysSize(keys)
+}
+
+// SeriesBlockStats returns the number of points of the series with the given
+// key in the engine's TSM files, their size before compression and the size
+// of the blocks holding them.
+func (e *Engine) SeriesBlockStats(key string) (tsdb.SeriesBlockStats, error) {
+	e.mu.RLock()
+	mf := e.measurementFields[tsdb.MeasurementFromSeriesKey(key)]
+	e.mu.RUnlock()
+	if mf == nil {
+		return tsdb.SeriesBlockStats{}, nil
+	}
+
+	var stats tsdb.SeriesBlockStats
+	for field := range mf.FieldSet() {
+		n, raw, size, err := e.FileStore.KeyBlockStats(SeriesFieldKey(key, field))
+		if err != nil {
+			return tsdb.SeriesBlockStats{}, err
+		}
+		stats.Values += n
+		stats.RawBytes += raw
+		stats.DiskBytes += size
+	}
+	return stats, nil
+}
+
+// Stats returns the number of values and series held by the engine's TSM
+// files and cache, and the number of values of each field. Value counts are
+// read from the block headers of the TSM files, so only blocks overlapping
+// deleted time ranges are decoded, to leave out the deleted values.
+func (e *Engine) Stats() (tsdb.ShardStats, error) {
+	stats := tsdb.ShardStats{
+		Fields: make(map[string]map[string]int64),
+		Exact:  true,
+	}
+	series := make(map[string]struct{})
+	ranges := make(map[string][]TimeRange)
+
+	add := func(key string, n int) {
+		seriesKey, field := SeriesAndFieldFromCompositeKey([]byte(key))
+		series[string(seriesKey)] = struct{}{}
+
+		name := tsdb.MeasurementFromSeriesKey(string(seriesKey))
+		fields := stats.Fields[name]
+		if fields == nil {
+			fields = make(map[string]int64)
+			stats.Fields[name] = fields
+		}
+		fields[field] += int64(n)
+		stats.Values += int64(n)
+	}
+
+	if err := e.FileStore.walkBlockCounts(func(key string, minTime, maxTime int64, n int) {
+		add(key, n)
+		ranges[key] = append(ranges[key], TimeRange{Min: minTime, Max: maxTime})
+	}); err != nil {
+		return tsdb.ShardStats{}, err
+	}
+
+	for _, key := range e.Cache.Keys() {
+		values := e.Cache.Values(key)
+		if len(values) == 0 {
+			continue
+		}
+		add(key, len(values))
+		ranges[key] = append(ranges[key], TimeRange{Min: values[0].UnixNano(), Max: values[len(values)-1].UnixNano()})
+	}
+
+	// Values written more than once are counted once for each block holding
+	// them until they are compacted, so the counts are only exact if no
+	// blocks of a key overlap.
+	for _, a := range ranges {
+		if !stats.Exact {
+			break
+		}
+		if len(a) > 1 && timeRangesOverlap(a) {
+			stats.Exact = false
+		}
+	}
+
+	stats.Series = len(series)
+	return stats, nil
+}
+
+// timeRangesOverlap returns true if any of the time ranges in a overlap. The
+// ranges are sorted in place.
+func timeRangesOverlap(a []TimeRange) bool {
+	sort.Sort(timeRanges(a))
+	for i := 1; i < len(a); i++ {
+		if a[i].Min <= a[i-1].Max {
+			return true
+		}
+	}
+	return false
+}
+
+type timeRanges []TimeRange
+
+func (a timeRanges) Len() int           { return len(a) }
+func (a timeRanges) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a timeRanges) Less(i, j int) bool { return a[i].Min < a[j].Min }
+
+// CompactionState returns the compaction state of the engine's TSM files,
+// and the number of temporary files removed when the engine was opened.
+func (e *Engine) CompactionState() tsdb.CompactionState {
+	e.mu.RLock()
+	state := tsdb.CompactionState{TempFiles: e.tempFiles}
+	e.mu.RUnlock()
+
+	generations := make(map[int]struct{})
+	for _, st := range e.FileStore.Stats() {
+		state.Files++
+		if st.HasTombstone {
+			state.Tombstones++
+		}
+		if gen, _, err := ParseTSMFileName(st.Path); err == nil {
+			generations[gen] = struct{}{}
+		}
+	}
+	state.Generations = len(generations)
+
+	// Count the files the level and optimize planners would compact now.
+	for level := 1; level <= 3; level++ {
+		for _, group := range e.CompactionPlan.PlanLevel(level) {
+			state.PlannedFiles += len(group)
+		}
+	}
+	for _, group := range e.CompactionPlan.PlanOptimize() {
+		state.PlannedFiles += len(group)
+	}
+
+	// Opening the WAL starts a new, empty segment, which is not counted.
+	if segments, err := segmentFileNames(e.WAL.Path()); err == nil {
+		for _, path := range segments {
+			if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+				state.WALSegments++
+			}
+		}
+	}
+	for _, key := range e.Cache.Keys() {
+		state.WALPoints += int64(len(e.Cache.Values(key)))
+	}
+
+	switch {
+	case state.TempFiles > 0:
+		state.Status = tsdb.CompactionPartial
+	case state.Generations > 1 || state.Tombstones > 0:
+		state.Status = tsdb.CompactionNeeded
+	default:
+		state.Status = tsdb.CompactionOptimal
+	}
+	return state
+}
+
+// readPointsBatchSize is the most points passed to each call of the function
+// given to ReadPoints.
+const readPointsBatchSize = 1000
+
+// ReadPoints calls fn with the points of each series of the named measurement
+// that have values between min and max, inclusive, for any of fields, in
+// batches of up to readPointsBatchSize points. The points of each series are
+// in time order, newest first unless ascending is set. Values from the TSM
+// files and cache are merged, and values written at the same time are
+// combined into a single point. The fields of a series are read together as
+// the points are built, so reading stops as soon as fn returns an error.
+func (e *Engine) ReadPoints(name string, seriesKeys, fields []string, min, max int64, ascending bool, fn func(points []models.Point) error) error {
+	e.mu.RLock()
+	mf := e.measurementFields[name]
+	e.mu.RUnlock()
+	if mf == nil {
+		return nil
+	}
+
+	// Points can not be written outside of MinTime and MaxTime, and seeking
+	// from beyond them would overflow the cursors' read ranges.
+	if min < influxql.MinTime {
+		min = influxql.MinTime
+	}
+	if max > influxql.MaxTime {
+		max = influxql.MaxTime
+	}
+
+	opt := influxql.IteratorOptions{StartTime: min, EndTime: max, Ascending: ascending}
+	for _, key := range seriesKeys {
+		if err := e.readSeriesPoints(name, key, mf, fields, opt, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSeriesPoints calls fn with the points of the series key built from the
+// values of fields within the time range of opt, as by ReadPoints.
+func (e *Engine) readSeriesPoints(name, key string, mf *tsdb.MeasurementFields, fields []string, opt influxql.IteratorOptions, fn func(points []models.Point) error) error {
+	// Each field's cursor is advanced as its current value is used, so only
+	// one value per field is held at a time.
+	type fieldCursor struct {
+		name string
+		cur  cursor
+		t    int64
+		v    interface{}
+	}
+	var curs []*fieldCursor
+	defer func() {
+		for _, c := range curs {
+			c.cur.close()
+		}
+	}()
+	inRange := func(t int64) bool {
+		return t != tsdb.EOF && t >= opt.StartTime && t <= opt.EndTime
+	}
+	for _, field := range fields {
+		f := mf.Field(field)
+		if f == nil {
+			continue
+		}
+		cur := e.buildCursor(name, key, &influxql.VarRef{Val: field, Type: f.Type}, opt)
+		if cur == nil {
+			continue
+		}
+		c := &fieldCursor{name: field, cur: cur}
+		c.t, c.v = cur.next()
+		curs = append(curs, c)
+	}
+
+	if len(curs) == 0 {
+		return nil
+	}
+	_, tags, err := models.ParseKey([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	var batch []models.Point
+	for {
+		// The next point is at the earliest, or in descending order the
+		// latest, time of any field.
+		t, ok := int64(0), false
+		for _, c := range curs {
+			if inRange(c.t) && (!ok || (opt.Ascending && c.t < t) || (!opt.Ascending && c.t > t)) {
+				t, ok = c.t, true
+			}
+		}
+		if !ok {
+			break
+		}
+
+		values := make(models.Fields)
+		for _, c := range curs {
+			if c.t == t {
+				values[c.name] = c.v
+				c.t, c.v = c.cur.next()
+			}
+		}
+
+		p, err := models.NewPoint(name, tags, values, time.Unix(0, t))
+		if err != nil {
+			return err
+		}
+		batch = append(batch, p)
+		if len(batch) >= readPointsBatchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return fn(batch)
+}
+
 // EngineStatistics maintains statistics for the engine.
 type EngineStatistics struct {
 	CacheCompactions              int64
@@ -274,16 +662,22 @@ func (e *Engine) Statistics(tags map[string]string) []models.Statistic {
 func (e *Engine) Open() error {
 	e.done = make(chan struct{})
 
-	if err := os.MkdirAll(e.path, 0777); err != nil {
-		return err
+	if !e.readOnly {
+		if err := os.MkdirAll(e.path, 0777); err != nil {
+			return err
+		}
 	}
 
 	if err := e.cleanup(); err != nil {
 		return err
 	}
 
-	if err := e.WAL.Open(); err != nil {
-		return err
+	// A read-only engine only replays the WAL, so it is not opened for
+	// writing, which would start a new segment.
+	if !e.readOnly {
+		if err := e.WAL.Open(); err != nil {
+			return err
+		}
 	}
 
 	if err := e.FileStore.Open(); err != nil {
@@ -456,6 +850,10 @@ func (e *Engine) writeFileToBackup(f os.FileInfo, shardRelativePath, fullPath st
 // Only files that match basePath will be copied into the directory. This obtains
 // a write lock so no operations can be performed while restoring.
 func (e *Engine) Restore(r io.Reader, basePath string) error {
+	if e.readOnly {
+		return tsdb.ErrEngineReadOnly
+	}
+
 	// Copy files from archive while under lock to prevent reopening.
 	if err := func() error {
 		e.mu.Lock()
@@ -530,7 +928,7 @@ func (e *Engine) addToIndexFromKey(shardID uint64, key []byte, fieldType influxq
 	measurement := tsdb.MeasurementFromSeriesKey(string(seriesKey))
 
 	m := index.CreateMeasurementIndexIfNotExists(measurement)
-	m.SetFieldName(field)
+	m.SetFieldType(field, fieldType)
 
 	mf := e.measurementFields[measurement]
 	if mf == nil {
@@ -564,6 +962,10 @@ func (e *Engine) addToIndexFromKey(shardID uint64, key []byte, fieldType influxq
 // WritePoints writes metadata and point data into the engine.
 // Returns an error if new points are added to an existing key.
 func (e *Engine) WritePoints(points []models.Point) error {
+	if e.readOnly {
+		return tsdb.ErrEngineReadOnly
+	}
+
 	values := make(map[string][]Value, len(points))
 	var keyBuf []byte
 	var baseLen int
@@ -639,6 +1041,9 @@ func (e *Engine) DeleteSeries(seriesKeys []string) error {
 
 // DeleteSeriesRange removes the values between min and max (inclusive) from all series.
 func (e *Engine) DeleteSeriesRange(seriesKeys []string, min, max int64) error {
+	if e.readOnly {
+		return tsdb.ErrEngineReadOnly
+	}
 	if len(seriesKeys) == 0 {
 		return nil
 	}
@@ -707,6 +1112,10 @@ func (e *Engine) DeleteSeriesRange(seriesKeys []string, min, max int64) error {
 
 // DeleteMeasurement deletes a measurement and all related series.
 func (e *Engine) DeleteMeasurement(name string, seriesKeys []string) error {
+	if e.readOnly {
+		return tsdb.ErrEngineReadOnly
+	}
+
 	e.mu.Lock()
 	delete(e.measurementFields, name)
 	e.mu.Unlock()
@@ -719,10 +1128,42 @@ func (e *Engine) SeriesCount() (n int, err error) {
 	return e.index.SeriesN(), nil
 }
 
+// ValueCount returns the number of values in the TSM files and the cache.
+// Values in the cache that overwrite values in a TSM file are counted twice
+// until they are compacted.
+func (e *Engine) ValueCount() (int64, error) {
+	n, err := e.FileStore.ValueCount()
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range e.Cache.Keys() {
+		n += int64(len(e.Cache.Values(key)))
+	}
+	return n, nil
+}
+
+// ValueCountByMeasurement returns the number of values of each measurement
+// in the TSM files and the cache, counted as by ValueCount.
+func (e *Engine) ValueCountByMeasurement() (map[string]int64, error) {
+	counts, err := e.FileStore.ValueCountByMeasurement()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range e.Cache.Keys() {
+		seriesKey, _ := SeriesAndFieldFromCompositeKey([]byte(key))
+		counts[tsdb.MeasurementFromSeriesKey(string(seriesKey))] += int64(len(e.Cache.Values(key)))
+	}
+	return counts, nil
+}
+
 func (e *Engine) WriteTo(w io.Writer) (n int64, err error) { panic("not implemented") }
 
 // WriteSnapshot will snapshot the cache and write a new TSM file with its contents, releasing the snapshot when done.
 func (e *Engine) WriteSnapshot() error {
+	if e.readOnly {
+		return tsdb.ErrEngineReadOnly
+	}
+
 	// Lock and grab the cache snapshot along with all the closed WAL
 	// filenames associated with the snapshot
 
@@ -1078,6 +1519,7 @@ func (e *Engine) reloadCache() error {
 	e.Cache.SetMaxSize(0)
 
 	loader := NewCacheLoader(files)
+	loader.ReadOnly = e.readOnly
 	loader.SetLogOutput(e.logOutput)
 	if err := loader.Load(e.Cache); err != nil {
 		return err
@@ -1093,12 +1535,18 @@ func (e *Engine) cleanup() error {
 		return err
 	}
 
+	e.tempFiles = 0
 	for _, f := range allfiles {
 		// Check to see if there are any `.tmp` directories that were left over from failed shard snapshots
 		if f.IsDir() && strings.HasSuffix(f.Name(), ".tmp") {
+			if e.readOnly {
+				e.tempFiles++
+				continue
+			}
 			if err := os.RemoveAll(filepath.Join(e.path, f.Name())); err != nil {
 				return fmt.Errorf("error removing tmp snapshot directory %q: %s", f.Name(), err)
 			}
+			e.tempFiles++
 		}
 	}
 
@@ -1112,9 +1560,17 @@ func (e *Engine) cleanupTempTSMFiles() error {
 	}
 
 	for _, f := range files {
+		if e.readOnly {
+			// Snapshot directories left in place were counted by cleanup.
+			if fi, err := os.Stat(f); err == nil && !fi.IsDir() {
+				e.tempFiles++
+			}
+			continue
+		}
 		if err := os.Remove(f); err != nil {
 			return fmt.Errorf("error removing temp compaction files: %v", err)
 		}
+		e.tempFiles++
 	}
 	return nil
 }
@@ -1507,18 +1963,10 @@ func SeriesFieldKey(seriesKey, field string) string {
 }
 
 func tsmFieldTypeToInfluxQLDataType(typ byte) (influxql.DataType, error) {
-	switch typ {
-	case BlockFloat64:
-		return influxql.Float, nil
-	case BlockInteger:
-		return influxql.Integer, nil
-	case BlockBoolean:
-		return influxql.Boolean, nil
-	case BlockString:
-		return influxql.String, nil
-	default:
-		return influxql.Unknown, fmt.Errorf("unknown block type: %v", typ)
+	if t := BlockTypeToInfluxQLDataType(typ); t != influxql.Unknown {
+		return t, nil
 	}
+	return influxql.Unknown, fmt.Errorf("unknown block type: %v", typ)
 }
 
 func SeriesAndFieldFromCompositeKey(key []byte) ([]byte, string) {
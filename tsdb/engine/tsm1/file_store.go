@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb"
 )
 
 type TSMFile interface {
@@ -494,6 +495,100 @@ func (f *FileStore) Stats() []FileStat {
 	return stats
 }
 
+// ValueCount returns the number of values in the TSM files, counted as by
+// walkBlockCounts. Each field of a point is a separate value.
+func (f *FileStore) ValueCount() (int64, error) {
+	var n int64
+	if err := f.walkBlockCounts(func(key string, minTime, maxTime int64, count int) {
+		n += int64(count)
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ValueCountByMeasurement returns the number of values in the TSM files of
+// each measurement, counted as by ValueCount.
+func (f *FileStore) ValueCountByMeasurement() (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if err := f.walkBlockCounts(func(key string, minTime, maxTime int64, n int) {
+		seriesKey, _ := SeriesAndFieldFromCompositeKey([]byte(key))
+		counts[tsdb.MeasurementFromSeriesKey(string(seriesKey))] += int64(n)
+	}); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// KeysTimeRange returns the minimum and maximum timestamps of the blocks
+// holding any of keys, using only the TSM indexes. Tombstones are not
+// considered. If no blocks are found then min is greater than max.
+func (f *FileStore) KeysTimeRange(keys []string) (min, max int64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	min, max = math.MaxInt64, math.MinInt64
+	var entries []IndexEntry
+	for _, fd := range f.files {
+		for _, key := range keys {
+			fd.ReadEntries(key, &entries)
+			for _, e := range entries {
+				if e.MinTime < min {
+					min = e.MinTime
+				}
+				if e.MaxTime > max {
+					max = e.MaxTime
+				}
+			}
+		}
+	}
+	return min, max
+}
+
+// KeyBlockStats returns the number of values in the blocks holding key, the
+// size of the values before compression and the size of the blocks. The
+// blocks are decoded, so this is much slower than KeysSize.
+func (f *FileStore) KeyBlockStats(key string) (n, raw, size int64, err error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var entries []IndexEntry
+	var values []Value
+	for _, fd := range f.files {
+		fd.ReadEntries(key, &entries)
+		for i := range entries {
+			values, err = fd.ReadAt(&entries[i], values[:0])
+			if err != nil {
+				return 0, 0, 0, fmt.Errorf("file %s: key %s: %s", fd.Path(), key, err)
+			}
+			for _, v := range values {
+				raw += int64(v.Size())
+			}
+			n += int64(len(values))
+			size += int64(entries[i].Size)
+		}
+	}
+	return n, raw, size, nil
+}
+
+// KeysSize returns the total size of the blocks holding any of keys.
+func (f *FileStore) KeysSize(keys []string) int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var n int64
+	var entries []IndexEntry
+	for _, fd := range f.files {
+		for _, key := range keys {
+			fd.ReadEntries(key, &entries)
+			for _, e := range entries {
+				n += int64(e.Size)
+			}
+		}
+	}
+	return n
+}
+
 func (f *FileStore) Replace(oldFiles, newFiles []string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -648,6 +743,65 @@ func (f *FileStore) BlockCount(path string, idx int) int {
 	return 0
 }
 
+// walkBlockCounts calls fn with the key, time range and number of values of
+// every block in the store, in file order. The count of each block is read
+// from its timestamp header without decoding its values; only blocks
+// overlapping a deleted time range are decoded, to leave out the deleted
+// values.
+func (f *FileStore) walkBlockCounts(fn func(key string, minTime, maxTime int64, n int)) error {
+	f.mu.RLock()
+	files := make([]TSMFile, len(f.files))
+	copy(files, f.files)
+	for _, r := range files {
+		r.Ref()
+	}
+	f.mu.RUnlock()
+	defer func() {
+		for _, r := range files {
+			r.Unref()
+		}
+	}()
+
+	var values []Value
+	for _, r := range files {
+		iter := r.BlockIterator()
+		for iter.Next() {
+			key, minTime, maxTime, _, b, err := iter.Read()
+			if err != nil {
+				return fmt.Errorf("file %s: %s", r.Path(), err)
+			}
+
+			var deleted []TimeRange
+			for _, t := range r.TombstoneRange(key) {
+				if t.Min <= maxTime && t.Max >= minTime {
+					deleted = append(deleted, t)
+				}
+			}
+			if len(deleted) == 0 {
+				if len(b) <= encodedBlockHeaderSize {
+					return fmt.Errorf("file %s: short block for key %s", r.Path(), key)
+				}
+				tb, _, err := unpackBlock(b[encodedBlockHeaderSize:])
+				if err != nil {
+					return fmt.Errorf("file %s: key %s: %s", r.Path(), key, err)
+				}
+				fn(key, minTime, maxTime, CountTimestamps(tb))
+				continue
+			}
+
+			values, err = DecodeBlock(b, values[:0])
+			if err != nil {
+				return fmt.Errorf("file %s: key %s: %s", r.Path(), key, err)
+			}
+			for _, t := range deleted {
+				values = Values(values).Exclude(t.Min, t.Max)
+			}
+			fn(key, minTime, maxTime, len(values))
+		}
+	}
+	return nil
+}
+
 // walkFiles calls fn for every files in filestore in parallel
 func (f *FileStore) walkFiles(fn func(f TSMFile) error) error {
 	f.mu.RLock()
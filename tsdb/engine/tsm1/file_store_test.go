@@ -1773,6 +1773,74 @@ func TestKeyCursor_TombstoneRange(t *testing.T) {
 	}
 }
 
+// Ensure the value count of the files leaves out deleted values.
+func TestFileStore_ValueCount(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	fs := tsm1.NewFileStore(dir)
+
+	data := []keyValues{
+		keyValues{"cpu", []tsm1.Value{
+			tsm1.NewValue(0, 1.0),
+			tsm1.NewValue(1, 2.0),
+			tsm1.NewValue(2, 3.0)}},
+		keyValues{"mem", []tsm1.Value{
+			tsm1.NewValue(0, int64(1)),
+			tsm1.NewValue(1, int64(2))}},
+	}
+
+	files, err := newFiles(dir, data...)
+	if err != nil {
+		t.Fatalf("unexpected error creating files: %v", err)
+	}
+	fs.Add(files...)
+
+	if n, err := fs.ValueCount(); err != nil {
+		t.Fatalf("unexpected error counting values: %v", err)
+	} else if n != 5 {
+		t.Fatalf("value count mismatch: got %v, exp %v", n, 5)
+	}
+
+	if err := fs.DeleteRange([]string{"cpu"}, 1, 1); err != nil {
+		t.Fatalf("unexpected error delete range: %v", err)
+	}
+	if n, err := fs.ValueCount(); err != nil {
+		t.Fatalf("unexpected error counting values: %v", err)
+	} else if n != 4 {
+		t.Fatalf("value count mismatch: got %v, exp %v", n, 4)
+	}
+}
+
+func TestFileStore_ValueCountByMeasurement(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	fs := tsm1.NewFileStore(dir)
+
+	data := []keyValues{
+		keyValues{tsm1.SeriesFieldKey("cpu,host=A", "value"), []tsm1.Value{
+			tsm1.NewValue(0, 1.0),
+			tsm1.NewValue(1, 2.0)}},
+		keyValues{tsm1.SeriesFieldKey("cpu,host=B", "value"), []tsm1.Value{
+			tsm1.NewValue(0, 3.0)}},
+		keyValues{tsm1.SeriesFieldKey("mem", "free"), []tsm1.Value{
+			tsm1.NewValue(0, int64(1)),
+			tsm1.NewValue(1, int64(2)),
+			tsm1.NewValue(2, int64(3))}},
+	}
+
+	files, err := newFiles(dir, data...)
+	if err != nil {
+		t.Fatalf("unexpected error creating files: %v", err)
+	}
+	fs.Add(files...)
+
+	if counts, err := fs.ValueCountByMeasurement(); err != nil {
+		t.Fatalf("unexpected error counting values: %v", err)
+	} else if len(counts) != 2 || counts["cpu"] != 3 || counts["mem"] != 3 {
+		t.Fatalf("value counts mismatch: got %v, exp %v", counts, map[string]int64{"cpu": 3, "mem": 3})
+	}
+}
+
 func TestKeyCursor_TombstoneRange_PartialFloat(t *testing.T) {
 	dir := MustTempDir()
 	defer os.RemoveAll(dir)
@@ -0,0 +1,145 @@
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockFileName is the name of the lock file taken in a data directory by the
+// tools working on it, so that two of them cannot run on the same data at the
+// same time.
+const LockFileName = ".influx.lock"
+
+// LockedError is returned when a data directory is locked by another process.
+// The details of the holder are zero if its lock file could not be read.
+type LockedError struct {
+	Path    string
+	PID     int
+	Since   time.Time
+	Command string
+}
+
+// Error returns a string representation of the error.
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by pid %d (%s) since %s",
+		e.Path, e.PID, e.Command, e.Since.Format(time.RFC3339))
+}
+
+// errLocked is returned by lockFile if another process holds the lock.
+var errLocked = errors.New("locked")
+
+// DirLock is an advisory lock on a data directory. It is held on the open
+// lock file, so the operating system releases it if the holder exits without
+// unlocking, and a lock can never be left behind by a process that is gone.
+type DirLock struct {
+	f *os.File
+}
+
+// LockDir takes the lock on the data directory dir. If the lock is held by
+// another process, a *LockedError is returned. The lock file is created if
+// needed and records the process ID, start time and command line of the
+// holder. If force is set and the lock is held, a DirLock that does not
+// hold the lock is returned instead, so the caller can go ahead anyway,
+// which is needed when a lock on a network filesystem is not released after
+// its holder's host went down.
+func LockDir(dir string, force bool) (*DirLock, error) {
+	path := filepath.Join(dir, LockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, true); err == errLocked {
+		f.Close()
+		if force {
+			return &DirLock{}, nil
+		}
+		return nil, readLock(path)
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// The details of a previous holder are replaced with ours.
+	l := &DirLock{f: f}
+	if err := f.Truncate(0); err != nil {
+		l.Unlock()
+		return nil, err
+	} else if _, err := fmt.Fprintf(f, "%d %s %s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339), strings.Join(os.Args, " ")); err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	return l, nil
+}
+
+// CheckLock returns a *LockedError if the data directory dir is locked by
+// another process, without taking the lock or creating the lock file, for
+// read-only tools that must not write to the directory.
+func CheckLock(dir string) error {
+	path := filepath.Join(dir, LockFileName)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := lockFile(f, false); err == errLocked {
+		return readLock(path)
+	} else if err != nil {
+		return err
+	}
+	return unlockFile(f)
+}
+
+// Unlock releases the lock. The lock file is emptied but left in place, as
+// removing it could let two processes lock different files of the same name.
+func (l *DirLock) Unlock() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Truncate(0)
+	if e := unlockFile(l.f); e != nil && err == nil {
+		err = e
+	}
+	if e := l.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	l.f = nil
+	return err
+}
+
+// readLock returns a *LockedError naming the process holding the lock file at
+// path. Only the path is set if the file cannot be read, such as while the
+// holder is writing it.
+func readLock(path string) *LockedError {
+	e := &LockedError{Path: filepath.Dir(path)}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return e
+	}
+
+	a := strings.SplitN(strings.TrimSpace(string(buf)), " ", 3)
+	if len(a) != 3 {
+		return e
+	}
+	pid, err := strconv.Atoi(a[0])
+	if err != nil {
+		return e
+	}
+	since, err := time.Parse(time.RFC3339, a[1])
+	if err != nil {
+		return e
+	}
+	e.PID, e.Since, e.Command = pid, since, a[2]
+	return e
+}
@@ -0,0 +1,32 @@
+// +build solaris
+
+package tsdb
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive or shared lock on f without waiting, returning
+// errLocked if another process holds a conflicting lock. Solaris has no
+// flock, so the lock is a POSIX record lock over the whole file, which is
+// only exclusive between processes.
+func lockFile(f *os.File, exclusive bool) error {
+	lk := unix.Flock_t{Type: unix.F_RDLCK}
+	if exclusive {
+		lk.Type = unix.F_WRLCK
+	}
+	if err := unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lk); err == unix.EAGAIN || err == unix.EACCES {
+		return errLocked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases the lock taken on f by lockFile.
+func unlockFile(f *os.File) error {
+	lk := unix.Flock_t{Type: unix.F_UNLCK}
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lk)
+}
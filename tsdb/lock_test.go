@@ -0,0 +1,88 @@
+package tsdb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// Ensure a lock can be checked without taking it.
+func TestCheckLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An unlocked directory is left unlocked.
+	if err := tsdb.CheckLock(dir); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(filepath.Join(dir, tsdb.LockFileName)); !os.IsNotExist(err) {
+		t.Fatalf("unexpected lock file: %v", err)
+	}
+
+	lock, err := tsdb.LockDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := tsdb.CheckLock(dir).(*tsdb.LockedError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err.PID != os.Getpid() {
+		t.Fatalf("unexpected lock holder: %d", err.PID)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	} else if err := tsdb.CheckLock(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a lock is held until it is released, and a held lock is only
+// passed over when forced.
+func TestLockDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A lock file left behind without a holder is taken over.
+	if err := ioutil.WriteFile(filepath.Join(dir, tsdb.LockFileName), []byte("1 2016-01-01T00:00:00Z influx_tsm\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	l0, err := tsdb.LockDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tsdb.LockDir(dir, false); err == nil {
+		t.Fatal("expected error")
+	} else if err, ok := err.(*tsdb.LockedError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err.PID != os.Getpid() {
+		t.Fatalf("unexpected lock holder: %d", err.PID)
+	}
+
+	// A forced lock does not take the lock from its holder.
+	if l, err := tsdb.LockDir(dir, true); err != nil {
+		t.Fatal(err)
+	} else if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tsdb.CheckLock(dir).(*tsdb.LockedError); !ok {
+		t.Fatal("expected lock to still be held")
+	}
+
+	if err := l0.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l1, err := tsdb.LockDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1.Unlock()
+}
@@ -0,0 +1,28 @@
+// +build !windows,!plan9,!solaris
+
+package tsdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive or shared lock on f without waiting, returning
+// errLocked if another process holds a conflicting lock.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		return errLocked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases the lock taken on f by lockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
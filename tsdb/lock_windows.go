@@ -0,0 +1,52 @@
+package tsdb
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockOffsetHigh is the high word of the offset of the byte that is locked.
+// Byte range locks are mandatory on Windows, so a byte far past the end of
+// the file is locked, leaving the holder's details readable.
+const lockOffsetHigh = 0x7fffffff
+
+// lockFile takes an exclusive or shared lock on f without waiting, returning
+// errLocked if another process holds a conflicting lock.
+func lockFile(f *os.File, exclusive bool) error {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	ol := &syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r != 0 {
+		return nil
+	} else if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock taken on f by lockFile.
+func unlockFile(f *os.File) error {
+	ol := &syscall.Overlapped{OffsetHigh: lockOffsetHigh}
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
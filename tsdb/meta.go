@@ -35,6 +35,11 @@ type DatabaseIndex struct {
 
 	name string // name of the database represented by this index
 
+	// number of series assigned to each shard, kept up to date as series
+	// are assigned and unassigned
+	shardSeriesMu sync.Mutex
+	shardSeriesN  map[uint64]int
+
 	stats       *IndexStatistics
 	defaultTags models.StatisticTags
 }
@@ -44,6 +49,7 @@ func NewDatabaseIndex(name string) *DatabaseIndex {
 	return &DatabaseIndex{
 		measurements: make(map[string]*Measurement),
 		series:       make(map[string]*Series),
+		shardSeriesN: make(map[uint64]int),
 		name:         name,
 		stats:        &IndexStatistics{},
 		defaultTags:  models.StatisticTags{"database": name},
@@ -84,6 +90,26 @@ func (d *DatabaseIndex) SeriesBytes(key []byte) *Series {
 	return s
 }
 
+// SeriesKey returns the key of the series with the given ID, or an empty
+// string if there is no such series. Every measurement is checked, so when
+// the measurement is known Measurement.SeriesByID is faster.
+//
+// Series IDs are assigned in memory as series are added to the index, in the
+// order they are loaded when the store opens, and are not persisted. An ID is
+// only stable while the index is open: the same series may have a different
+// ID after the store is opened again, and IDs of different databases are
+// unrelated. IDs must not be stored or compared across opens.
+func (d *DatabaseIndex) SeriesKey(id uint64) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, m := range d.measurements {
+		if s := m.SeriesByID(id); s != nil {
+			return s.Key
+		}
+	}
+	return ""
+}
+
 func (d *DatabaseIndex) SeriesKeys() []string {
 	d.mu.RLock()
 	s := make([]string, 0, len(d.series))
@@ -101,6 +127,19 @@ func (d *DatabaseIndex) SeriesN() int {
 	return len(d.series)
 }
 
+// ForEachSeries calls fn with each series in the index, one measurement at a
+// time and in ascending order of ID within a measurement, without collecting
+// their keys. It stops at the first error returned by fn. fn must not modify
+// the index.
+func (d *DatabaseIndex) ForEachSeries(fn func(s *Series) error) error {
+	for _, m := range d.Measurements() {
+		if err := m.forEachSeries(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Measurement returns the measurement object from the index by the name
 func (d *DatabaseIndex) Measurement(name string) *Measurement {
 	d.mu.RLock()
@@ -144,6 +183,95 @@ func (d *DatabaseIndex) SeriesShardN(shardID uint64) int {
 	return n
 }
 
+// ShardSeriesCount returns the number of series assigned to a shard. Unlike
+// SeriesShardN the count is maintained as series are assigned and unassigned,
+// so the index is not scanned.
+func (d *DatabaseIndex) ShardSeriesCount(shardID uint64) int {
+	d.shardSeriesMu.Lock()
+	defer d.shardSeriesMu.Unlock()
+	return d.shardSeriesN[shardID]
+}
+
+// FindDuplicateSeries returns the keys of series registered more than once in
+// the index, sorted. A series is registered more than once if the index holds
+// series with different IDs for the same key, or for keys naming the same
+// measurement and tags in a different order. Each key is returned once, with
+// its tags sorted.
+func (d *DatabaseIndex) FindDuplicateSeries() []string {
+	var keys []string
+	for k, a := range d.seriesByCanonicalKey() {
+		if len(a) > 1 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DuplicateSeries returns the series registered in the index for key, or for
+// keys naming the same measurement and tags in a different order, ordered by
+// ID. It returns nil if the series is not registered more than once.
+func (d *DatabaseIndex) DuplicateSeries(key string) []*Series {
+	a := d.seriesByCanonicalKey()[canonicalSeriesKey(key)]
+	if len(a) < 2 {
+		return nil
+	}
+	sort.Sort(seriesByID(a))
+	return a
+}
+
+// seriesByCanonicalKey returns every series registered in the index or its
+// measurements, grouped by canonical key.
+func (d *DatabaseIndex) seriesByCanonicalKey() map[string][]*Series {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	seen := make(map[*Series]struct{}, len(d.series))
+	m := make(map[string][]*Series)
+	add := func(s *Series) {
+		if _, ok := seen[s]; ok {
+			return
+		}
+		seen[s] = struct{}{}
+		k := canonicalSeriesKey(s.Key)
+		m[k] = append(m[k], s)
+	}
+
+	for _, s := range d.series {
+		add(s)
+	}
+	for _, mm := range d.measurements {
+		mm.mu.RLock()
+		for _, s := range mm.seriesByID {
+			add(s)
+		}
+		mm.mu.RUnlock()
+	}
+	return m
+}
+
+// canonicalSeriesKey returns key with its measurement name escaped and its
+// tags sorted.
+func canonicalSeriesKey(key string) string {
+	name, tags, err := models.ParseKey([]byte(key))
+	if err != nil {
+		return key
+	}
+	sort.Sort(tags)
+	return string(models.MakeKey([]byte(name), tags))
+}
+
+// addShardSeriesN adjusts the number of series assigned to a shard by delta.
+func (d *DatabaseIndex) addShardSeriesN(shardID uint64, delta int) {
+	d.shardSeriesMu.Lock()
+	if n := d.shardSeriesN[shardID] + delta; n > 0 {
+		d.shardSeriesN[shardID] = n
+	} else {
+		delete(d.shardSeriesN, shardID)
+	}
+	d.shardSeriesMu.Unlock()
+}
+
 // CreateSeriesIndexIfNotExists adds the series for the given measurement to the index and sets its ID or returns the existing series object
 func (d *DatabaseIndex) CreateSeriesIndexIfNotExists(measurementName string, series *Series) *Series {
 	d.mu.RLock()
@@ -172,6 +300,7 @@ func (d *DatabaseIndex) CreateSeriesIndexIfNotExists(measurementName string, ser
 
 	series.measurement = m
 	d.series[series.Key] = series
+	series.attach(d)
 
 	m.AddSeries(series)
 
@@ -243,6 +372,7 @@ func (d *DatabaseIndex) UnassignShard(k string, shardID uint64) {
 				// Remove the series key from the series index
 				d.mu.Lock()
 				delete(d.series, k)
+				ss.detach()
 				atomic.AddInt64(&d.stats.NumSeries, -1)
 				d.mu.Unlock()
 			}
@@ -488,6 +618,7 @@ func (d *DatabaseIndex) dropMeasurement(name string) {
 	delete(d.measurements, name)
 	for _, s := range m.seriesByID {
 		delete(d.series, s.Key)
+		s.detach()
 	}
 
 	atomic.AddInt64(&d.stats.NumSeries, int64(-len(m.seriesByID)))
@@ -511,6 +642,7 @@ func (d *DatabaseIndex) DropSeries(keys []string) {
 		}
 		series.measurement.DropSeries(series)
 		delete(d.series, k)
+		series.detach()
 		nDeleted++
 
 		// If there are no more series in the measurement then we'll
@@ -544,6 +676,10 @@ type Measurement struct {
 	Name       string `json:"name,omitempty"`
 	fieldNames map[string]struct{}
 
+	// fieldTypes holds the type of each field recorded by SetFieldType, or
+	// Unknown for a field recorded with different types.
+	fieldTypes map[string]influxql.DataType
+
 	// in-memory index fields
 	seriesByID          map[uint64]*Series              // lookup table for series by their id
 	seriesByTagKeyValue map[string]map[string]SeriesIDs // map from tag key to value to sorted set of series ids
@@ -555,6 +691,7 @@ func NewMeasurement(name string) *Measurement {
 	return &Measurement{
 		Name:       name,
 		fieldNames: make(map[string]struct{}),
+		fieldTypes: make(map[string]influxql.DataType),
 
 		seriesByID:          make(map[uint64]*Series),
 		seriesByTagKeyValue: make(map[string]map[string]SeriesIDs),
@@ -603,6 +740,37 @@ func (m *Measurement) AppendSeriesKeysByID(dst []string, ids []uint64) []string
 	return dst
 }
 
+// SeriesIDs returns the IDs of every series in this measurement, in
+// ascending order. IDs are only stable while the index is open; see
+// DatabaseIndex.SeriesKey.
+func (m *Measurement) SeriesIDs() []uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]uint64, len(m.seriesIDs))
+	copy(ids, m.seriesIDs)
+	return ids
+}
+
+// SeriesN returns the number of series in this measurement.
+func (m *Measurement) SeriesN() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.seriesByID)
+}
+
+// forEachSeries calls fn with each series in this measurement, in ascending
+// order of ID, stopping at the first error returned by fn.
+func (m *Measurement) forEachSeries(fn func(s *Series) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, id := range m.seriesIDs {
+		if err := fn(m.seriesByID[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SeriesKeys returns the keys of every series in this measurement
 func (m *Measurement) SeriesKeys() []string {
 	m.mu.RLock()
@@ -614,6 +782,29 @@ func (m *Measurement) SeriesKeys() []string {
 	return keys
 }
 
+// SeriesKeysMatching returns the keys of the series in this measurement
+// whose tags match every filter, in sorted order. Only the matching keys are
+// collected and sorted.
+func (m *Measurement) SeriesKeysMatching(filters []*TagFilter) []string {
+	return m.seriesKeysMatching(filters, nil)
+}
+
+// seriesKeysMatching returns the sorted keys of the series whose tags match
+// every filter and, if fn is not nil, for which fn returns true.
+func (m *Measurement) seriesKeysMatching(filters []*TagFilter, fn func(s *Series) bool) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for _, s := range m.seriesByID {
+		if s.matches(filters) && (fn == nil || fn(s)) {
+			keys = append(keys, s.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // ValidateGroupBy ensures that the GROUP BY is not a field.
 func (m *Measurement) ValidateGroupBy(stmt *influxql.SelectStatement) error {
 	for _, d := range stmt.Dimensions {
@@ -1464,7 +1655,8 @@ type Series struct {
 	Tags        models.Tags
 	ID          uint64
 	measurement *Measurement
-	shardIDs    []uint64 // shards that have this series defined
+	shardIDs    []uint64       // shards that have this series defined
+	index       *DatabaseIndex // index counting the series' shards, if any
 }
 
 // NewSeries returns an initialized series struct
@@ -1480,6 +1672,9 @@ func (s *Series) AssignShard(shardID uint64) {
 	if !s.assigned(shardID) {
 		s.shardIDs = append(s.shardIDs, shardID)
 		sort.Sort(uint64Slice(s.shardIDs))
+		if s.index != nil {
+			s.index.addShardSeriesN(shardID, 1)
+		}
 	}
 	s.mu.Unlock()
 }
@@ -1489,12 +1684,66 @@ func (s *Series) UnassignShard(shardID uint64) {
 	for i, v := range s.shardIDs {
 		if v == shardID {
 			s.shardIDs = append(s.shardIDs[:i], s.shardIDs[i+1:]...)
+			if s.index != nil {
+				s.index.addShardSeriesN(shardID, -1)
+			}
 			break
 		}
 	}
 	s.mu.Unlock()
 }
 
+// attach adds the series' shards to the shard series counts of d.
+func (s *Series) attach(d *DatabaseIndex) {
+	s.mu.Lock()
+	s.index = d
+	for _, id := range s.shardIDs {
+		d.addShardSeriesN(id, 1)
+	}
+	s.mu.Unlock()
+}
+
+// detach removes the series' shards from the shard series counts of its index.
+func (s *Series) detach() {
+	s.mu.Lock()
+	if s.index != nil {
+		for _, id := range s.shardIDs {
+			s.index.addShardSeriesN(id, -1)
+		}
+		s.index = nil
+	}
+	s.mu.Unlock()
+}
+
+// matches returns true if the tags of the series match every filter. A tag
+// the series does not have is matched as an empty value.
+func (s *Series) matches(filters []*TagFilter) bool {
+	for _, f := range filters {
+		v := s.Tags.GetString(f.Key)
+		switch f.Op {
+		case influxql.EQ:
+			if v != f.Value {
+				return false
+			}
+		case influxql.NEQ:
+			if v == f.Value {
+				return false
+			}
+		case influxql.EQREGEX:
+			if !f.Regex.MatchString(v) {
+				return false
+			}
+		case influxql.NEQREGEX:
+			if f.Regex.MatchString(v) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Series) Assigned(shardID uint64) bool {
 	s.mu.RLock()
 	b := s.assigned(shardID)
@@ -1507,6 +1756,13 @@ func (s *Series) assigned(shardID uint64) bool {
 	return i < len(s.shardIDs) && s.shardIDs[i] == shardID
 }
 
+// ShardIDs returns the IDs of the shards the series is assigned to, sorted.
+func (s *Series) ShardIDs() []uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]uint64(nil), s.shardIDs...)
+}
+
 func (s *Series) ShardN() int {
 	s.mu.RLock()
 	n := len(s.shardIDs)
@@ -1844,6 +2100,35 @@ func (m *Measurement) SetFieldName(name string) {
 	m.mu.Unlock()
 }
 
+// SetFieldType adds the field name to the measurement and records its type.
+// A field recorded with another type before, such as by another shard, has
+// its type recorded as Unknown from then on.
+func (m *Measurement) SetFieldType(name string, typ influxql.DataType) {
+	m.mu.RLock()
+	existing, ok := m.fieldTypes[name]
+	m.mu.RUnlock()
+	if ok && existing == typ {
+		return
+	}
+
+	m.mu.Lock()
+	m.fieldNames[name] = struct{}{}
+	if existing, ok := m.fieldTypes[name]; ok && existing != typ {
+		typ = influxql.Unknown
+	}
+	m.fieldTypes[name] = typ
+	m.mu.Unlock()
+}
+
+// FieldType returns the type of the named field as recorded by SetFieldType,
+// so the type is known without reading any points. Unknown is returned if
+// no type was recorded, or if the field was recorded with different types.
+func (m *Measurement) FieldType(name string) influxql.DataType {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fieldTypes[name]
+}
+
 // FieldNames returns a list of the measurement's field names
 func (m *Measurement) FieldNames() []string {
 	m.mu.RLock()
@@ -1976,6 +2261,12 @@ func (a uint64Slice) Len() int           { return len(a) }
 func (a uint64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a uint64Slice) Less(i, j int) bool { return a[i] < a[j] }
 
+type seriesByID []*Series
+
+func (a seriesByID) Len() int           { return len(a) }
+func (a seriesByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a seriesByID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+
 type byTagKey []*influxql.TagSet
 
 func (t byTagKey) Len() int           { return len(t) }
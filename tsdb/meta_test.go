@@ -2,7 +2,10 @@ package tsdb_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -93,6 +96,55 @@ func Test_SeriesIDs_Reject(t *testing.T) {
 	}
 }
 
+// Ensure a measurement reports the fields it has been given.
+func TestMeasurement_HasField(t *testing.T) {
+	m := tsdb.NewMeasurement("cpu")
+	m.SetFieldName("value")
+
+	if !m.HasField("value") {
+		t.Fatal("expected field: value")
+	} else if m.HasField("idle") {
+		t.Fatal("unexpected field: idle")
+	}
+}
+
+// Ensure a measurement reports the types of its fields, and Unknown for a
+// field given different types.
+func TestMeasurement_FieldType(t *testing.T) {
+	m := tsdb.NewMeasurement("cpu")
+	m.SetFieldType("value", influxql.Float)
+	m.SetFieldType("value", influxql.Float)
+	m.SetFieldType("count", influxql.Integer)
+	m.SetFieldType("count", influxql.String)
+	m.SetFieldType("count", influxql.Integer)
+
+	if typ := m.FieldType("value"); typ != influxql.Float {
+		t.Fatalf("unexpected type for value: %s", typ)
+	} else if typ := m.FieldType("count"); typ != influxql.Unknown {
+		t.Fatalf("unexpected type for count: %s", typ)
+	} else if typ := m.FieldType("idle"); typ != influxql.Unknown {
+		t.Fatalf("unexpected type for idle: %s", typ)
+	} else if !m.HasField("count") {
+		t.Fatal("expected field: count")
+	}
+}
+
+// Ensure a measurement reports the tag keys of its series.
+func TestMeasurement_HasTagKey(t *testing.T) {
+	m := tsdb.NewMeasurement("cpu")
+	s := tsdb.NewSeries("cpu,host=a", models.NewTags(map[string]string{"host": "a"}))
+	s.ID = 1
+	m.AddSeries(s)
+
+	if !m.HasTagKey("host") {
+		t.Fatal("expected tag key: host")
+	} else if m.HasTagKey("region") {
+		t.Fatal("unexpected tag key: region")
+	} else if m.HasTagKey("a") {
+		t.Fatal("unexpected tag key: a")
+	}
+}
+
 func BenchmarkMeasurement_SeriesIDForExp_EQRegex(b *testing.B) {
 	m := tsdb.NewMeasurement("cpu")
 	for i := 0; i < 100000; i++ {
@@ -234,6 +286,41 @@ func benchmarkCreateSeriesIndex(b *testing.B, series []*TestSeries) {
 	}
 }
 
+// Compare collecting every series key of an index with streaming its series,
+// as influx_inspect summary -series-compression does.
+func BenchmarkDatabaseIndex_SeriesKeys_100K(b *testing.B) {
+	idx := mustCreateSeriesIndex(genTestSeries(32, 5, 5))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range idx.SeriesKeys() {
+			if s := idx.Series(key); s == nil {
+				b.Fatalf("series not found: %s", key)
+			}
+		}
+	}
+}
+
+func BenchmarkDatabaseIndex_ForEachSeries_100K(b *testing.B) {
+	idx := mustCreateSeriesIndex(genTestSeries(32, 5, 5))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := idx.ForEachSeries(func(s *tsdb.Series) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// mustCreateSeriesIndex returns an index holding series.
+func mustCreateSeriesIndex(series []*TestSeries) *tsdb.DatabaseIndex {
+	idx := tsdb.NewDatabaseIndex("db0")
+	for _, s := range series {
+		idx.CreateSeriesIndexIfNotExists(s.Measurement, s.Series)
+	}
+	return idx
+}
+
 type TestSeries struct {
 	Measurement string
 	Series      *tsdb.Series
@@ -365,3 +452,108 @@ func MustParseExpr(s string) influxql.Expr {
 func strref(s string) *string {
 	return &s
 }
+
+// Ensure the index reports series registered more than once.
+func TestDatabaseIndex_SeriesKey(t *testing.T) {
+	idx := tsdb.NewDatabaseIndex("db0")
+	for _, key := range []string{"cpu,host=serverA", "mem,host=serverA", "cpu,host=serverB"} {
+		name, tags, _ := models.ParseKey([]byte(key))
+		idx.CreateSeriesIndexIfNotExists(name, tsdb.NewSeries(key, tags))
+	}
+
+	ids := idx.Measurement("cpu").SeriesIDs()
+	if !reflect.DeepEqual(ids, []uint64{1, 3}) {
+		t.Fatalf("unexpected series IDs: %v", ids)
+	}
+	var keys []string
+	for _, id := range ids {
+		keys = append(keys, idx.SeriesKey(id))
+	}
+	if exp := []string{"cpu,host=serverA", "cpu,host=serverB"}; !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("unexpected series keys: %v", keys)
+	}
+
+	if key := idx.SeriesKey(2); key != "mem,host=serverA" {
+		t.Fatalf("unexpected series key: %q", key)
+	} else if key := idx.SeriesKey(4); key != "" {
+		t.Fatalf("unexpected series key for unknown ID: %q", key)
+	}
+
+	// The returned IDs are a copy.
+	ids[0] = 100
+	if ids := idx.Measurement("cpu").SeriesIDs(); ids[0] != 1 {
+		t.Fatalf("unexpected series IDs: %v", ids)
+	}
+}
+
+// Ensure every series is visited once, and that iteration stops at the first
+// error.
+func TestDatabaseIndex_ForEachSeries(t *testing.T) {
+	idx := tsdb.NewDatabaseIndex("db0")
+	for _, key := range []string{"cpu,host=serverA", "mem,host=serverA", "cpu,host=serverB"} {
+		name, tags, _ := models.ParseKey([]byte(key))
+		idx.CreateSeriesIndexIfNotExists(name, tsdb.NewSeries(key, tags))
+	}
+
+	var keys []string
+	if err := idx.ForEachSeries(func(s *tsdb.Series) error {
+		keys = append(keys, s.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if exp := []string{"cpu,host=serverA", "cpu,host=serverB", "mem,host=serverA"}; !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("unexpected series keys: %v", keys)
+	}
+	if n := idx.Measurement("cpu").SeriesN(); n != 2 {
+		t.Fatalf("unexpected series count: %d", n)
+	}
+
+	var n int
+	errStop := errors.New("stop")
+	if err := idx.ForEachSeries(func(s *tsdb.Series) error {
+		n++
+		return errStop
+	}); err != errStop || n != 1 {
+		t.Fatalf("unexpected result: err=%v, series=%d", err, n)
+	}
+}
+
+func TestDatabaseIndex_FindDuplicateSeries(t *testing.T) {
+	idx := tsdb.NewDatabaseIndex("db0")
+	for _, key := range []string{"cpu,host=serverA,region=west", "cpu,host=serverB", "mem,host=serverA"} {
+		name, tags, _ := models.ParseKey([]byte(key))
+		idx.CreateSeriesIndexIfNotExists(name, tsdb.NewSeries(key, tags)).AssignShard(1)
+	}
+	if a := idx.FindDuplicateSeries(); len(a) != 0 {
+		t.Fatalf("unexpected duplicates: %v", a)
+	}
+
+	// Register the same tags in a different order.
+	tags := models.NewTags(map[string]string{"host": "serverA", "region": "west"})
+	idx.CreateSeriesIndexIfNotExists("cpu", tsdb.NewSeries("cpu,region=west,host=serverA", tags)).AssignShard(2)
+
+	// Register a key a second time under a different ID.
+	s := tsdb.NewSeries("mem,host=serverA", models.NewTags(map[string]string{"host": "serverA"}))
+	s.ID = 100
+	s.AssignShard(3)
+	idx.Measurement("mem").AddSeries(s)
+
+	if a, exp := idx.FindDuplicateSeries(), []string{"cpu,host=serverA,region=west", "mem,host=serverA"}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected duplicates: %v", a)
+	}
+
+	a := idx.DuplicateSeries("cpu,region=west,host=serverA")
+	if len(a) != 2 || a[0].Key != "cpu,host=serverA,region=west" || a[1].Key != "cpu,region=west,host=serverA" {
+		t.Fatalf("unexpected series: %v", a)
+	} else if ids := a[1].ShardIDs(); !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Fatalf("unexpected shards: %v", ids)
+	}
+	if a := idx.DuplicateSeries("mem,host=serverA"); len(a) != 2 || a[1].ID != 100 {
+		t.Fatalf("unexpected series: %v", a)
+	}
+	if a := idx.DuplicateSeries("cpu,host=serverB"); a != nil {
+		t.Fatalf("unexpected series: %v", a)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -46,6 +47,10 @@ var (
 	// ErrFieldNotFound is returned when a field cannot be found.
 	ErrFieldNotFound = errors.New("field not found")
 
+	// ErrFieldsNotFound is returned when a shard holds a series but has no
+	// fields recorded for its measurement, so its points cannot be read.
+	ErrFieldsNotFound = errors.New("no fields recorded for measurement")
+
 	// ErrFieldUnmappedID is returned when the system is presented, during decode, with a field ID
 	// there is no mapping for.
 	ErrFieldUnmappedID = errors.New("field ID not mapped")
@@ -57,6 +62,14 @@ var (
 	// ErrShardDisabled is returned when a the shard is not available for
 	// queries or writes.
 	ErrShardDisabled = errors.New("shard is disabled")
+
+	// ErrEngineReadOnly is returned when a caller attempts to change the
+	// data of an engine opened read-only.
+	ErrEngineReadOnly = errors.New("engine is read-only")
+
+	// ErrInspectionUnsupported is returned when inspecting a shard whose
+	// engine does not implement EngineInspector.
+	ErrInspectionUnsupported = errors.New("engine does not support inspection")
 )
 
 var (
@@ -211,6 +224,15 @@ func (s *Shard) Statistics(tags map[string]string) []models.Statistic {
 	return statistics
 }
 
+// ID returns the shard's ID.
+func (s *Shard) ID() uint64 { return s.id }
+
+// Database returns the database of the shard.
+func (s *Shard) Database() string { return s.database }
+
+// RetentionPolicy returns the retention policy of the shard.
+func (s *Shard) RetentionPolicy() string { return s.retentionPolicy }
+
 // Path returns the path set on the shard when it was created.
 func (s *Shard) Path() string { return s.path }
 
@@ -248,7 +270,7 @@ func (s *Shard) Open() error {
 			return err
 		}
 
-		count := s.index.SeriesShardN(s.id)
+		count := s.index.ShardSeriesCount(s.id)
 		atomic.AddInt64(&s.stats.SeriesCreated, int64(count))
 
 		s.engine = e
@@ -349,9 +371,221 @@ func (s *Shard) DiskSize() (int64, error) {
 		return err
 	})
 
+	// A shard opened read-only does not create its WAL directory.
+	if os.IsNotExist(err) {
+		err = nil
+	}
 	return size, err
 }
 
+// Format returns the format of the shard's engine.
+func (s *Shard) Format() (EngineFormat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.engine == nil {
+		return 0, ErrEngineClosed
+	}
+	return s.engine.Format(), nil
+}
+
+// TimeRange returns the minimum and maximum timestamps stored in the shard.
+// The range is read from the engine's file and cache metadata so no data is
+// decoded. If the shard holds no data then min is greater than max.
+func (s *Shard) TimeRange() (min, max int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return 0, 0, err
+	}
+	min, max = e.TimeRange()
+	return min, max, nil
+}
+
+// MeasurementTimeRange returns the minimum and maximum timestamps of the
+// named measurement's data in the shard, read from block metadata. If
+// filters are given, only the series whose tags match every filter are
+// included. If the shard holds no data for the measurement then min is
+// greater than max.
+func (s *Shard) MeasurementTimeRange(name string, filters []*TagFilter) (min, max int64, err error) {
+	if len(filters) == 0 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		e, err := s.inspector()
+		if err != nil {
+			return 0, 0, err
+		}
+		min, max = e.MeasurementTimeRange(name)
+		return min, max, nil
+	}
+
+	keys, err := s.SeriesKeysMatching(name, filters)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return 0, 0, err
+	}
+	min, max = e.SeriesTimeRange(name, keys)
+	return min, max, nil
+}
+
+// ShardStats holds the number of values and series stored in a shard. Each
+// field of a point is counted as a separate value, as by ValueCount.
+type ShardStats struct {
+	Values int64
+	Series int
+
+	// Fields holds the number of values of each field, keyed by measurement
+	// and then field name.
+	Fields map[string]map[string]int64
+
+	// Exact is true if the counts are exact. The counts are only estimates
+	// if the shard has values for the same series and field in blocks with
+	// overlapping time ranges that are yet to be compacted. Overlapping
+	// values are counted once for each block. Deleted values are never
+	// counted.
+	Exact bool
+}
+
+// Stats returns the number of values and series stored in the shard, and
+// the number of values of each field. The counts are read from block
+// metadata so no values are decoded. An empty shard returns zero counts.
+func (s *Shard) Stats() (ShardStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return ShardStats{}, err
+	}
+	return e.Stats()
+}
+
+// MeasurementSize returns the estimated bytes on disk of the named
+// measurement's data in the shard, summed from the sizes of the blocks
+// holding it. Data only held in the cache is not included.
+func (s *Shard) MeasurementSize(name string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return 0, err
+	}
+	return e.MeasurementSize(name), nil
+}
+
+// SeriesBlockStats holds the size of a series' data in a shard's TSM files.
+type SeriesBlockStats struct {
+	// Values is the number of values of the series, counting each field
+	// of a point separately.
+	Values int64
+
+	// RawBytes is the size of the values before compression, counting
+	// 8 bytes for each timestamp plus the size of each value.
+	RawBytes int64
+
+	// DiskBytes is the size of the blocks holding the values.
+	DiskBytes int64
+}
+
+// SeriesBlockStats returns the number of values of the series with the given
+// key in the shard's TSM files, their size before compression and the size
+// of the blocks holding them. The blocks are decoded to size string values.
+// Data only held in the cache is not included.
+func (s *Shard) SeriesBlockStats(key string) (SeriesBlockStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return SeriesBlockStats{}, err
+	}
+	return e.SeriesBlockStats(key)
+}
+
+// CompactionState returns the compaction state of the shard's files.
+func (s *Shard) CompactionState() (CompactionState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return CompactionState{}, err
+	}
+	return e.CompactionState(), nil
+}
+
+// ReadPoints calls fn with the points of each of the measurement's series
+// in the shard that have values between min and max, inclusive, for any of
+// fields, oldest first.
+func (s *Shard) ReadPoints(measurement string, seriesKeys, fields []string, min, max int64, fn func(points []models.Point) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return err
+	}
+	return e.ReadPoints(measurement, seriesKeys, fields, min, max, true, fn)
+}
+
+// inspector returns the shard's engine as an EngineInspector. The caller
+// must hold s.mu.
+func (s *Shard) inspector() (EngineInspector, error) {
+	if s.engine == nil {
+		return nil, ErrEngineClosed
+	}
+	e, ok := s.engine.(EngineInspector)
+	if !ok {
+		return nil, ErrInspectionUnsupported
+	}
+	return e, nil
+}
+
+// ReadSeries calls fn with the points of a single series in the shard that
+// have values between min and max, inclusive, for any of the fields of its
+// measurement, oldest first unless ascending is false. Reading stops as soon
+// as fn returns an error. No index is consulted, so it is the quickest way to
+// read one series.
+func (s *Shard) ReadSeries(key string, min, max int64, ascending bool, fn func(points []models.Point) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, err := s.inspector()
+	if err != nil {
+		return err
+	}
+
+	name := MeasurementFromSeriesKey(key)
+	fieldSet := s.engine.MeasurementFields(name).FieldSet()
+	fields := make([]string, 0, len(fieldSet))
+	for f := range fieldSet {
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		if ss := s.index.Series(key); ss != nil && ss.Assigned(s.id) {
+			return ErrFieldsNotFound
+		}
+		return nil
+	}
+	return e.ReadPoints(name, []string{key}, fields, min, max, ascending, fn)
+}
+
+// FieldTypes returns the type of each field of the measurement in the shard.
+func (s *Shard) FieldTypes(measurement string) (map[string]influxql.DataType, error) {
+	return s.fieldSet(measurement)
+}
+
+// fieldSet returns the types of the measurement's fields in the shard.
+func (s *Shard) fieldSet(measurement string) (map[string]influxql.DataType, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.engine == nil {
+		return nil, ErrEngineClosed
+	}
+	return s.engine.MeasurementFields(measurement).FieldSet(), nil
+}
+
 // FieldCreate holds information for a field to create on a measurement
 type FieldCreate struct {
 	Measurement string
@@ -459,7 +693,7 @@ func (s *Shard) createFieldsAndMeasurements(fieldsToCreate []*FieldCreate) error
 
 		// ensure the measurement is in the index and the field is there
 		measurement := s.index.CreateMeasurementIndexIfNotExists(f.Measurement)
-		measurement.SetFieldName(f.Field.Name)
+		measurement.SetFieldType(f.Field.Name, f.Field.Type)
 	}
 
 	return nil
@@ -575,6 +809,180 @@ func (s *Shard) SeriesCount() (int, error) {
 	return s.engine.SeriesCount()
 }
 
+// ValueCount returns the number of values stored in the shard, counting each
+// field of a point separately. The count is read from the block headers of
+// the shard's TSM files, and the cache, rather than by iterating the series.
+func (s *Shard) ValueCount() (int64, error) {
+	if err := s.ready(); err != nil {
+		return 0, err
+	}
+	e, ok := s.engine.(EngineInspector)
+	if !ok {
+		return 0, ErrInspectionUnsupported
+	}
+	return e.ValueCount()
+}
+
+// ValueCountByMeasurement returns the number of values stored in the shard
+// for each measurement, counted as by ValueCount.
+func (s *Shard) ValueCountByMeasurement() (map[string]int64, error) {
+	if err := s.ready(); err != nil {
+		return nil, err
+	}
+	e, ok := s.engine.(EngineInspector)
+	if !ok {
+		return nil, ErrInspectionUnsupported
+	}
+	return e.ValueCountByMeasurement()
+}
+
+// PointCount returns the number of points stored in the shard, counting the
+// values of a series written at the same time as a single point. Points of a
+// measurement with values for a single field are counted from the block
+// headers, as by Stats. The points of other measurements, or of every
+// measurement if the shard has overlapping blocks, are read from the engine,
+// which costs as much as reading their series.
+func (s *Shard) PointCount() (int64, error) {
+	counts, err := s.PointCountByMeasurement()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, c := range counts {
+		n += c
+	}
+	return n, nil
+}
+
+// PointCountByMeasurement returns the number of points stored in the shard
+// for each measurement, counted as by PointCount. Measurements without
+// points in the shard are left out.
+func (s *Shard) PointCountByMeasurement() (map[string]int64, error) {
+	if err := s.ready(); err != nil {
+		return nil, err
+	}
+
+	stats, err := s.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	for _, m := range s.index.Measurements() {
+		// Each point of a measurement with a single field holds one value of
+		// it, so the points equal the values unless blocks overlap.
+		if fields := stats.Fields[m.Name]; stats.Exact && len(fields) <= 1 {
+			for _, n := range fields {
+				if n > 0 {
+					counts[m.Name] = n
+				}
+			}
+			continue
+		}
+
+		n, err := s.measurementPointCount(m.Name)
+		if err != nil {
+			return nil, err
+		} else if n > 0 {
+			counts[m.Name] = n
+		}
+	}
+	return counts, nil
+}
+
+// measurementPointCount returns the number of points of the measurement in
+// the shard, counted as by PointCount.
+func (s *Shard) measurementPointCount(measurement string) (int64, error) {
+	keys, err := s.SeriesKeysMatching(measurement, nil)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	set, err := s.fieldSet(measurement)
+	if err != nil {
+		return 0, err
+	}
+	fields := make([]string, 0, len(set))
+	for field := range set {
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	if err := s.ReadPoints(measurement, keys, fields, math.MinInt64, math.MaxInt64, func(points []models.Point) error {
+		n += int64(len(points))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// SeriesKeyCount returns the number of series stored in the shard. Unlike
+// SeriesCount, which counts every series in the database's index, only the
+// shard's series are counted, and the count is maintained as series are
+// added and removed so no keys are loaded to compute it.
+func (s *Shard) SeriesKeyCount() (int, error) {
+	if err := s.ready(); err != nil {
+		return 0, err
+	}
+	return s.index.ShardSeriesCount(s.id), nil
+}
+
+// SeriesKeysMatching returns the keys of the measurement's series in the
+// shard whose tags match every filter, in sorted order. Unlike filtering the
+// keys of the database's index, which holds the series of every shard, only
+// the matching keys are collected and sorted.
+func (s *Shard) SeriesKeysMatching(measurement string, filters []*TagFilter) ([]string, error) {
+	if err := s.ready(); err != nil {
+		return nil, err
+	}
+
+	m := s.index.Measurement(measurement)
+	if m == nil {
+		return nil, nil
+	}
+	return m.seriesKeysMatching(filters, func(ss *Series) bool { return ss.Assigned(s.id) }), nil
+}
+
+// TagKeyCardinality returns the number of distinct values of each tag key
+// across the measurement's series in the shard. Unlike the database index,
+// which holds the tag values of every shard, only the shard's series are
+// counted.
+func (s *Shard) TagKeyCardinality(measurement string) (map[string]int, error) {
+	if err := s.ready(); err != nil {
+		return nil, err
+	}
+
+	m := s.index.Measurement(measurement)
+	if m == nil {
+		return map[string]int{}, nil
+	}
+
+	values := make(map[string]map[string]struct{})
+	for _, key := range m.SeriesKeys() {
+		ss := s.index.Series(key)
+		if ss == nil || !ss.Assigned(s.id) {
+			continue
+		}
+		for _, t := range ss.Tags {
+			if values[string(t.Key)] == nil {
+				values[string(t.Key)] = make(map[string]struct{})
+			}
+			values[string(t.Key)][string(t.Value)] = struct{}{}
+		}
+	}
+
+	n := make(map[string]int, len(values))
+	for k, v := range values {
+		n[k] = len(v)
+	}
+	return n, nil
+}
+
 // WriteTo writes the shard's data to w.
 func (s *Shard) WriteTo(w io.Writer) (int64, error) {
 	if err := s.ready(); err != nil {
@@ -748,6 +1156,27 @@ func (s *Shard) Restore(r io.Reader, basePath string) error {
 	return s.Open()
 }
 
+// WriteSnapshotTo writes a tar archive of the shard's TSM and tombstone files
+// to w. The cache is first flushed to a new TSM file, so no data written
+// before the call is lost, and the files are captured under the file store's
+// lock so the archive is consistent while the shard continues to accept
+// writes. File names in the archive are relative to the shard's directory.
+func (s *Shard) WriteSnapshotTo(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.engine == nil {
+		return ErrEngineClosed
+	}
+	return s.engine.Backup(w, "", time.Time{})
+}
+
+// RestoreSnapshot restores an archive written by WriteSnapshotTo into the
+// shard. Files in the archive replace shard files of the same name and the
+// shard is reopened.
+func (s *Shard) RestoreSnapshot(r io.Reader) error {
+	return s.Restore(r, "")
+}
+
 // CreateSnapshot will return a path to a temp directory
 // containing hard links to the underlying shard files
 func (s *Shard) CreateSnapshot() (string, error) {
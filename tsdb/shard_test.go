@@ -306,6 +306,12 @@ func TestShardWriteAddNewField(t *testing.T) {
 	if len(index.Measurement("cpu").FieldNames()) != 2 {
 		t.Fatalf("field names wasn't saved to measurement index")
 	}
+
+	if types, err := sh.FieldTypes("cpu"); err != nil {
+		t.Fatal(err)
+	} else if exp := map[string]influxql.DataType{"value": influxql.Float, "value2": influxql.Float}; !reflect.DeepEqual(types, exp) {
+		t.Fatalf("unexpected field types: %v", types)
+	}
 }
 
 // Ensures that when a shard is closed, it removes any series meta-data
@@ -554,6 +560,172 @@ func TestShard_Disabled_WriteQuery(t *testing.T) {
 	}
 }
 
+// Ensure a shard snapshot includes cached data and can be restored.
+func TestShard_WriteSnapshotTo(t *testing.T) {
+	sh := MustOpenShard()
+	defer sh.Close()
+
+	sh.MustWritePointsString(`
+cpu,host=serverA value=1 10
+cpu,host=serverB value=2 20
+`)
+
+	var buf bytes.Buffer
+	if err := sh.WriteSnapshotTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	other := MustOpenShard()
+	defer other.Close()
+
+	if err := other.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := other.SeriesCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected series count: %d", n)
+	}
+
+	if min, max, err := other.TimeRange(); err != nil {
+		t.Fatal(err)
+	} else if min != 10*int64(time.Second) || max != 20*int64(time.Second) {
+		t.Fatalf("unexpected time range: %d - %d", min, max)
+	}
+}
+
+// Ensure the value count of a shard counts each field value, whether it is
+// cached or in a TSM file.
+func TestShard_ValueCount(t *testing.T) {
+	sh := MustOpenShard()
+	defer sh.Close()
+
+	sh.MustWritePointsString(`
+cpu,host=serverA value=1,idle=2 10
+cpu,host=serverA value=3 20
+cpu,host=serverB value=4 20
+`)
+
+	if n, err := sh.ValueCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("unexpected value count: %d", n)
+	}
+
+	var buf bytes.Buffer
+	if err := sh.WriteSnapshotTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	other := MustOpenShard()
+	defer other.Close()
+	if err := other.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := other.ValueCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("unexpected value count after restore: %d", n)
+	}
+}
+
+// Ensure inspecting a shard whose engine does not implement
+// tsdb.EngineInspector returns an error rather than failing to build.
+func TestShard_Inspection_Unsupported(t *testing.T) {
+	tsdb.RegisterEngine("uninspectable", func(path string, walPath string, opt tsdb.EngineOptions) tsdb.Engine {
+		opt.EngineVersion = tsdb.DefaultEngine
+		e, err := tsdb.NewEngine(path, walPath, opt)
+		if err != nil {
+			panic(err)
+		}
+		// Hide every method but those of tsdb.Engine.
+		return struct{ tsdb.Engine }{e}
+	})
+
+	path, err := ioutil.TempDir("", "influxdb-tsdb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := tsdb.NewEngineOptions()
+	opt.EngineVersion = "uninspectable"
+	opt.Config.WALDir = filepath.Join(path, "wal")
+	sh := &Shard{
+		Shard: tsdb.NewShard(0,
+			tsdb.NewDatabaseIndex("db"),
+			filepath.Join(path, "data", "db0", "rp0", "1"),
+			filepath.Join(path, "wal", "db0", "rp0", "1"),
+			opt,
+		),
+		path: path,
+	}
+	if err := sh.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	sh.MustWritePointsString(`cpu,host=serverA value=1 10`)
+
+	if _, err := sh.ValueCount(); err != tsdb.ErrInspectionUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, _, err := sh.TimeRange(); err != tsdb.ErrInspectionUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := sh.Stats(); err != tsdb.ErrInspectionUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := sh.ReadSeries("cpu,host=serverA", 0, 20, true, func([]models.Point) error { return nil }); err != tsdb.ErrInspectionUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the point count of a shard counts the fields of a point written
+// together once, whether it is cached or in a TSM file.
+func TestShard_PointCount(t *testing.T) {
+	sh := MustOpenShard()
+	defer sh.Close()
+
+	sh.MustWritePointsString(`
+cpu,host=serverA value=1,idle=2 10
+cpu,host=serverA value=3 20
+cpu,host=serverB value=4 20
+mem,host=serverA free=5 10
+`)
+
+	if n, err := sh.PointCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("unexpected point count: %d", n)
+	}
+	if counts, err := sh.PointCountByMeasurement(); err != nil {
+		t.Fatal(err)
+	} else if exp := map[string]int64{"cpu": 3, "mem": 1}; !reflect.DeepEqual(counts, exp) {
+		t.Fatalf("unexpected point counts: %v", counts)
+	}
+
+	var buf bytes.Buffer
+	if err := sh.WriteSnapshotTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	other := MustOpenShard()
+	defer other.Close()
+	if err := other.RestoreSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := other.PointCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("unexpected point count after restore: %d", n)
+	}
+
+	// An overwritten point is counted once, though its value is held in
+	// both the cache and a TSM file.
+	other.MustWritePointsString(`mem,host=serverA free=6 10`)
+	if counts, err := other.PointCountByMeasurement(); err != nil {
+		t.Fatal(err)
+	} else if exp := map[string]int64{"cpu": 3, "mem": 1}; !reflect.DeepEqual(counts, exp) {
+		t.Fatalf("unexpected point counts after overwrite: %v", counts)
+	}
+}
+
 func BenchmarkWritePoints_NewSeries_1K(b *testing.B)   { benchmarkWritePoints(b, 38, 3, 3, 1) }
 func BenchmarkWritePoints_NewSeries_100K(b *testing.B) { benchmarkWritePoints(b, 32, 5, 5, 1) }
 func BenchmarkWritePoints_NewSeries_250K(b *testing.B) { benchmarkWritePoints(b, 80, 5, 5, 1) }
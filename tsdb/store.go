@@ -1,11 +1,16 @@
 package tsdb // import "github.com/influxdata/influxdb/tsdb"
 
 import (
+	"archive/tar"
+	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -17,6 +22,7 @@ import (
 
 	"github.com/influxdata/influxdb/influxql"
 	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
 	"github.com/influxdata/influxdb/pkg/limiter"
 )
 
@@ -25,6 +31,12 @@ var (
 	ErrShardNotFound = fmt.Errorf("shard not found")
 	// ErrStoreClosed gets returned when trying to use a closed Store.
 	ErrStoreClosed = fmt.Errorf("store is closed")
+	// ErrMetadataNotFound gets returned when an operation requires the
+	// store's metadata but no MetaClient is set.
+	ErrMetadataNotFound = fmt.Errorf("metadata not found")
+	// ErrStoreReadOnly gets returned when trying to add or remove shards
+	// of a store opened with OpenReadOnly.
+	ErrStoreReadOnly = fmt.Errorf("store is read-only")
 )
 
 // Store manages shards and indexes for databases.
@@ -40,6 +52,26 @@ type Store struct {
 	EngineOptions EngineOptions
 	Logger        *log.Logger
 
+	// MetaClient is an optional source of cluster metadata. It is used to
+	// report shard ownership. When nil, the store is treated as standalone.
+	MetaClient MetaClient
+
+	// NodeID is the ID of the node the store belongs to.
+	NodeID uint64
+
+	// WriteProtected makes Open take the lock on the store's directory, so
+	// that tools cannot open a store another tool is working on. The lock is
+	// released by Close. If ForceLock is set, the store is opened even if
+	// another process holds the lock, without taking it.
+	WriteProtected bool
+	ForceLock      bool
+	lock           *DirLock
+
+	// ShardFilter, if set, limits the shards opened by Open to those for
+	// which it returns true, so tools inspecting a few shards need not open
+	// every shard of a large store.
+	ShardFilter func(database, retentionPolicy string, id uint64) bool
+
 	// logOutput is where output from the underlying databases will go.
 	logOutput io.Writer
 
@@ -61,6 +93,40 @@ func NewStore(path string) *Store {
 	}
 }
 
+// OpenForTooling opens the store of a node for tools reading it offline. The
+// node's root storage path is expected to hold the data in a data directory
+// and the WAL in a wal directory. The store is opened with these engine
+// options:
+//
+//	Config.Dir               rootPath/data
+//	Config.WALDir            rootPath/wal
+//	Config.WALLoggingEnabled false
+//	Config.QueryLogEnabled   false
+//
+// and all log output is discarded. The store is opened with OpenReadOnly, so
+// no file of the node is changed and writes are refused. It does not take the
+// lock on the data directory; tools that must not run alongside others take
+// it with LockDir first.
+func OpenForTooling(rootPath string) (*Store, error) {
+	s := NewStoreForTooling(rootPath)
+	if err := s.OpenReadOnly(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStoreForTooling returns the store of a node configured as by
+// OpenForTooling, without opening it, so that it can be configured further.
+func NewStoreForTooling(rootPath string) *Store {
+	s := NewStore(filepath.Join(rootPath, "data"))
+	s.EngineOptions.Config.Dir = filepath.Join(rootPath, "data")
+	s.EngineOptions.Config.WALDir = filepath.Join(rootPath, "wal")
+	s.EngineOptions.Config.WALLoggingEnabled = false
+	s.EngineOptions.Config.QueryLogEnabled = false
+	s.SetLogOutput(ioutil.Discard)
+	return s
+}
+
 // SetLogOutput sets the writer to which all logs are written. It is safe for
 // concurrent use.
 func (s *Store) SetLogOutput(w io.Writer) {
@@ -110,16 +176,30 @@ func (s *Store) Open() error {
 	s.Logger.Printf("Using data dir: %v", s.Path())
 
 	// Create directory.
-	if err := os.MkdirAll(s.path, 0777); err != nil {
+	if s.EngineOptions.ReadOnly {
+		if _, err := os.Stat(s.path); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(s.path, 0777); err != nil {
 		return err
 	}
 
+	if s.WriteProtected && !s.EngineOptions.ReadOnly {
+		lock, err := LockDir(s.path, s.ForceLock)
+		if err != nil {
+			return err
+		}
+		s.lock = lock
+	}
+
 	// TODO: Start AE for Node
 	if err := s.loadIndexes(); err != nil {
+		s.unlock()
 		return err
 	}
 
 	if err := s.loadShards(); err != nil {
+		s.unlock()
 		return err
 	}
 
@@ -128,13 +208,26 @@ func (s *Store) Open() error {
 	return nil
 }
 
+// OpenReadOnly opens the store for inspecting data that must not change,
+// such as a copy being taken of the directory. It neither creates the data
+// directory nor takes its lock, replays the WAL without writing to it, keeps
+// temporary files left by interrupted compactions and snapshots, and never
+// starts compactions. Shards cannot be created or deleted, and writes and
+// deletes return ErrEngineReadOnly.
+func (s *Store) OpenReadOnly() error {
+	s.EngineOptions.ReadOnly = true
+	return s.Open()
+}
+
 func (s *Store) loadIndexes() error {
 	dbs, err := ioutil.ReadDir(s.path)
 	if err != nil {
 		return err
 	}
 	for _, db := range dbs {
-		if !db.IsDir() {
+		if db.Name() == LockFileName {
+			continue
+		} else if !db.IsDir() {
 			s.Logger.Printf("Skipping database dir: %s. Not a directory", db.Name())
 			continue
 		}
@@ -174,6 +267,12 @@ func (s *Store) loadShards() error {
 				return err
 			}
 			for _, sh := range shards {
+				if s.ShardFilter != nil {
+					if id, err := strconv.ParseUint(sh.Name(), 10, 64); err == nil && !s.ShardFilter(db, rp.Name(), id) {
+						continue
+					}
+				}
+
 				n++
 				go func(index *DatabaseIndex, db, rp, sh string) {
 					t.Take()
@@ -238,7 +337,17 @@ func (s *Store) Close() error {
 	s.shards = nil
 	s.databaseIndexes = nil
 
-	return nil
+	return s.unlock()
+}
+
+// unlock releases the lock on the store's directory, if it was taken.
+func (s *Store) unlock() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Unlock()
+	s.lock = nil
+	return err
 }
 
 // DatabaseIndexN returns the number of databases indicies in the store.
@@ -292,6 +401,10 @@ func (s *Store) CreateShard(database, retentionPolicy string, shardID uint64, en
 	default:
 	}
 
+	if s.EngineOptions.ReadOnly {
+		return ErrStoreReadOnly
+	}
+
 	// shard already exists
 	if _, ok := s.shards[shardID]; ok {
 		return nil
@@ -352,6 +465,10 @@ func (s *Store) SetShardEnabled(shardID uint64, enabled bool) error {
 
 // DeleteShard removes a shard from disk.
 func (s *Store) DeleteShard(shardID uint64) error {
+	if s.EngineOptions.ReadOnly {
+		return ErrStoreReadOnly
+	}
+
 	sh := s.Shard(shardID)
 	if sh == nil {
 		return nil
@@ -381,6 +498,281 @@ func (s *Store) DeleteShard(shardID uint64) error {
 	return nil
 }
 
+// MetaClient is the cluster metadata a store uses to report shard ownership,
+// check its shards against the metadata and export retention policies. It
+// is declared here, rather than taken from services/meta, so that tsdb does
+// not depend on the cluster metadata.
+type MetaClient interface {
+	// Shards returns the shards of the live shard groups.
+	Shards() []ShardMeta
+
+	// RetentionPolicy returns the settings of a retention policy, or nil if
+	// the metadata does not hold it.
+	RetentionPolicy(database, name string) *RetentionPolicyMeta
+}
+
+// MetaSnapshotter is implemented by a MetaClient whose metadata can be
+// saved and replaced by SnapshotMetadata and RestoreMetadata.
+type MetaSnapshotter interface {
+	// MarshalMetadata returns the encoded metadata.
+	MarshalMetadata() ([]byte, error)
+
+	// RestoreMetadata replaces the metadata with the encoded metadata in
+	// buf, as returned by MarshalMetadata.
+	RestoreMetadata(buf []byte) error
+}
+
+// ShardGroupMerger is implemented by a MetaClient whose shard groups can be
+// merged by MergeShards.
+type ShardGroupMerger interface {
+	// MergeShardGroups checks that the shard groups of the shards in ids can
+	// be merged into the group of the first, and returns a function updating
+	// the metadata so that group covers the time ranges of all of them and
+	// the others are deleted. The metadata is unchanged until it is called.
+	MergeShardGroups(database, policy string, ids []uint64) (func() error, error)
+}
+
+// ShardMeta describes a shard in the cluster metadata.
+type ShardMeta struct {
+	ID              uint64
+	Database        string
+	RetentionPolicy string
+
+	// Owners holds the IDs of the owning nodes, in metadata order. It is
+	// empty if the metadata records no owners.
+	Owners []uint64
+}
+
+// OwnedBy returns whether the shard is owned by the node.
+func (si ShardMeta) OwnedBy(nodeID uint64) bool {
+	for _, id := range si.Owners {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionPolicyMeta holds the settings of a retention policy in the
+// cluster metadata.
+type RetentionPolicyMeta struct {
+	Duration           time.Duration
+	ShardGroupDuration time.Duration
+	ReplicaN           int
+
+	// Default is set if the policy is the default of its database.
+	Default bool
+}
+
+// ShardOwnership describes which nodes hold a copy of a shard.
+type ShardOwnership struct {
+	// Standalone is set when no cluster metadata describes the owners of
+	// the shard, as is the case for a single node.
+	Standalone bool
+
+	// Owners holds the IDs of the owning nodes, in metadata order.
+	Owners []uint64
+
+	// Local is set when this node is one of the owners and Primary when it
+	// is the first of them.
+	Local   bool
+	Primary bool
+}
+
+// String returns "standalone", "owner", "replica" or "remote".
+func (o ShardOwnership) String() string {
+	switch {
+	case o.Standalone:
+		return "standalone"
+	case o.Primary:
+		return "owner"
+	case o.Local:
+		return "replica"
+	default:
+		return "remote"
+	}
+}
+
+// ShardOwners returns the ownership of a shard as recorded in the store's
+// metadata. A standalone ownership is returned if the store has no metadata
+// or the metadata records no owners for the shard.
+func (s *Store) ShardOwners(id uint64) (ShardOwnership, error) {
+	standalone := ShardOwnership{Standalone: true, Local: true, Primary: true}
+	if s.MetaClient == nil {
+		return standalone, nil
+	}
+
+	for _, si := range s.MetaClient.Shards() {
+		if si.ID != id {
+			continue
+		} else if len(si.Owners) == 0 {
+			return standalone, nil
+		}
+
+		var o ShardOwnership
+		for i, owner := range si.Owners {
+			o.Owners = append(o.Owners, owner)
+			if owner == s.NodeID {
+				o.Local, o.Primary = true, i == 0
+			}
+		}
+		return o, nil
+	}
+	return ShardOwnership{}, ErrShardNotFound
+}
+
+// InconsistencyType identifies a kind of mismatch between the shards on disk
+// and the store's metadata.
+type InconsistencyType int
+
+const (
+	// OrphanedShard is a shard directory with no metadata entry.
+	OrphanedShard InconsistencyType = iota + 1
+
+	// MissingShard is a metadata entry for a local shard with no directory.
+	MissingShard
+
+	// MisplacedShard is a shard directory under a different database or
+	// retention policy than its metadata entry.
+	MisplacedShard
+)
+
+// String returns the name of the inconsistency type.
+func (t InconsistencyType) String() string {
+	switch t {
+	case OrphanedShard:
+		return "orphaned"
+	case MissingShard:
+		return "missing"
+	case MisplacedShard:
+		return "misplaced"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// Inconsistency describes a mismatch between a shard on disk and the
+// store's metadata, along with a suggested fix.
+type Inconsistency struct {
+	Type    InconsistencyType
+	ShardID uint64
+
+	// Database and RetentionPolicy are taken from the metadata, except for
+	// orphaned shards where they are taken from the shard's path.
+	Database        string
+	RetentionPolicy string
+
+	// Path is the shard's directory on disk, or where it was expected to be
+	// for missing shards.
+	Path string
+
+	Fix string
+}
+
+// String returns a human readable description of the inconsistency.
+func (i Inconsistency) String() string {
+	return fmt.Sprintf("%s shard %d (%s/%s) at %s: %s", i.Type, i.ShardID, i.Database, i.RetentionPolicy, i.Path, i.Fix)
+}
+
+// ValidateMetadataConsistency compares the shard directories on disk with
+// the shards recorded in the store's metadata. It reports shard directories
+// with no metadata, local shards in metadata with no directory, and shards
+// stored under the wrong database or retention policy. Inconsistencies are
+// returned ordered by shard ID.
+func (s *Store) ValidateMetadataConsistency() ([]Inconsistency, error) {
+	if s.MetaClient == nil {
+		return nil, ErrMetadataNotFound
+	}
+
+	type location struct{ db, rp string }
+
+	// Collect the shards expected on this node from live shard groups.
+	expected := make(map[uint64]location)
+	for _, si := range s.MetaClient.Shards() {
+		if len(si.Owners) == 0 || si.OwnedBy(s.NodeID) {
+			expected[si.ID] = location{si.Database, si.RetentionPolicy}
+		}
+	}
+
+	// Collect shard directories from disk, including any that failed to open.
+	onDisk := make(map[uint64]location)
+	dbs, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, db := range dbs {
+		if !db.IsDir() {
+			continue
+		}
+		rps, err := ioutil.ReadDir(filepath.Join(s.path, db.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, rp := range rps {
+			if !rp.IsDir() {
+				continue
+			}
+			shards, err := ioutil.ReadDir(filepath.Join(s.path, db.Name(), rp.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, sh := range shards {
+				id, err := strconv.ParseUint(sh.Name(), 10, 64)
+				if err != nil {
+					continue
+				}
+				onDisk[id] = location{db.Name(), rp.Name()}
+			}
+		}
+	}
+
+	var a []Inconsistency
+	for id, loc := range onDisk {
+		path := filepath.Join(s.path, loc.db, loc.rp, strconv.FormatUint(id, 10))
+		exp, ok := expected[id]
+		if !ok {
+			a = append(a, Inconsistency{
+				Type:            OrphanedShard,
+				ShardID:         id,
+				Database:        loc.db,
+				RetentionPolicy: loc.rp,
+				Path:            path,
+				Fix:             fmt.Sprintf("archive and remove %s, or restore the metadata for shard %d", path, id),
+			})
+		} else if exp != loc {
+			a = append(a, Inconsistency{
+				Type:            MisplacedShard,
+				ShardID:         id,
+				Database:        exp.db,
+				RetentionPolicy: exp.rp,
+				Path:            path,
+				Fix:             fmt.Sprintf("move %s to %s", path, filepath.Join(s.path, exp.db, exp.rp, strconv.FormatUint(id, 10))),
+			})
+		}
+	}
+	for id, exp := range expected {
+		if _, ok := onDisk[id]; ok {
+			continue
+		}
+		a = append(a, Inconsistency{
+			Type:            MissingShard,
+			ShardID:         id,
+			Database:        exp.db,
+			RetentionPolicy: exp.rp,
+			Path:            filepath.Join(s.path, exp.db, exp.rp, strconv.FormatUint(id, 10)),
+			Fix:             fmt.Sprintf("restore shard %d from a backup, or run DROP SHARD %d", id, id),
+		})
+	}
+	sort.Sort(inconsistencies(a))
+	return a, nil
+}
+
+type inconsistencies []Inconsistency
+
+func (a inconsistencies) Len() int           { return len(a) }
+func (a inconsistencies) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a inconsistencies) Less(i, j int) bool { return a[i].ShardID < a[j].ShardID }
+
 // ShardIteratorCreator returns an iterator creator for a shard.
 func (s *Store) ShardIteratorCreator(id uint64, opt *influxql.SelectOptions) influxql.IteratorCreator {
 	sh := s.Shard(id)
@@ -395,6 +787,10 @@ func (s *Store) ShardIteratorCreator(id uint64, opt *influxql.SelectOptions) inf
 
 // DeleteDatabase will close all shards associated with a database and remove the directory and files from disk.
 func (s *Store) DeleteDatabase(name string) error {
+	if s.EngineOptions.ReadOnly {
+		return ErrStoreReadOnly
+	}
+
 	s.mu.RLock()
 	shards := s.filterShards(func(sh *Shard) bool {
 		return sh.database == name
@@ -432,6 +828,10 @@ func (s *Store) DeleteDatabase(name string) error {
 // provided retention policy, remove the retention policy directories on
 // both the DB and WAL, and remove all shard files from disk.
 func (s *Store) DeleteRetentionPolicy(database, name string) error {
+	if s.EngineOptions.ReadOnly {
+		return ErrStoreReadOnly
+	}
+
 	s.mu.RLock()
 	shards := s.filterShards(func(sh *Shard) bool {
 		return sh.database == database && sh.retentionPolicy == name
@@ -624,19 +1024,1023 @@ func (s *Store) DiskSize() (int64, error) {
 	return size, nil
 }
 
-// BackupShard will get the shard and have the engine backup since the passed in time to the writer
-func (s *Store) BackupShard(id uint64, since time.Time, w io.Writer) error {
-	shard := s.Shard(id)
-	if shard == nil {
-		return fmt.Errorf("shard %d doesn't exist on this server", id)
+// DiskSizeByShard returns the size on disk of each shard, keyed by shard ID.
+func (s *Store) DiskSizeByShard() (map[uint64]int64, error) {
+	s.mu.RLock()
+	shards := s.shardsSlice()
+	s.mu.RUnlock()
+
+	sizes := make(map[uint64]int64, len(shards))
+	for _, sh := range shards {
+		sz, err := sh.DiskSize()
+		if err != nil {
+			return nil, err
+		}
+		sizes[sh.id] = sz
 	}
+	return sizes, nil
+}
 
-	path, err := relativePath(s.path, shard.path)
+// ShardTimeRange returns the minimum and maximum timestamps stored in a shard.
+// If the shard holds no data then min is greater than max.
+func (s *Store) ShardTimeRange(id uint64) (min, max int64, err error) {
+	sh := s.Shard(id)
+	if sh == nil {
+		return 0, 0, ErrShardNotFound
+	}
+	return sh.TimeRange()
+}
+
+// ShardInfo describes a shard of the store.
+type ShardInfo struct {
+	ID              uint64
+	Database        string
+	RetentionPolicy string
+	Path            string
+
+	// Format is the format of the shard's engine.
+	Format EngineFormat
+
+	// Size is the size on disk of the shard's files and WAL, in bytes.
+	Size int64
+}
+
+// ShardInfo returns the database, retention policy, engine format and size on
+// disk of a shard. Only the shard's directories are read, so no data is
+// decoded.
+func (s *Store) ShardInfo(id uint64) (*ShardInfo, error) {
+	sh := s.Shard(id)
+	if sh == nil {
+		return nil, ErrShardNotFound
+	}
+
+	format, err := sh.Format()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	size, err := sh.DiskSize()
+	if err != nil {
+		return nil, err
 	}
+	return &ShardInfo{
+		ID:              sh.ID(),
+		Database:        sh.Database(),
+		RetentionPolicy: sh.RetentionPolicy(),
+		Path:            sh.Path(),
+		Format:          format,
+		Size:            size,
+	}, nil
+}
 
-	return shard.engine.Backup(w, path, since)
+// CompactionStatus returns the compaction state of each open shard, keyed by
+// shard ID. Shards needing compaction, or left partially compacted, will be
+// compacted once the engine runs, which can be costly for large shards.
+func (s *Store) CompactionStatus() map[uint64]CompactionState {
+	s.mu.RLock()
+	shards := s.shardsSlice()
+	s.mu.RUnlock()
+
+	m := make(map[uint64]CompactionState, len(shards))
+	for _, sh := range shards {
+		state, err := sh.CompactionState()
+		if err != nil {
+			continue
+		}
+		m[sh.id] = state
+	}
+	return m
+}
+
+// MeasurementTimeBounds returns the earliest and latest timestamps of any
+// series of a measurement across all shards of a database. If filters are
+// given, only the series whose tags match every filter are included. Bounds
+// are read from block metadata rather than by scanning points. If the
+// measurement has no data then first is greater than last.
+func (s *Store) MeasurementTimeBounds(database, measurement string, filters []*TagFilter) (first, last int64, err error) {
+	first, last = math.MaxInt64, math.MinInt64
+
+	s.mu.RLock()
+	shards := s.filterShards(func(sh *Shard) bool {
+		return sh.database == database
+	})
+	s.mu.RUnlock()
+
+	for _, sh := range shards {
+		min, max, err := sh.MeasurementTimeRange(measurement, filters)
+		if err != nil {
+			return 0, 0, err
+		}
+		if min < first {
+			first = min
+		}
+		if max > last {
+			last = max
+		}
+	}
+	return first, last, nil
+}
+
+// MeasurementSize returns the estimated bytes on disk of a measurement's data
+// across all shards of a database, summed from the sizes of the blocks
+// holding it. Data only held in the cache is not included.
+func (s *Store) MeasurementSize(database, measurement string) (int64, error) {
+	s.mu.RLock()
+	shards := s.filterShards(func(sh *Shard) bool {
+		return sh.database == database
+	})
+	s.mu.RUnlock()
+
+	var n int64
+	for _, sh := range shards {
+		sz, err := sh.MeasurementSize(measurement)
+		if err != nil {
+			return 0, err
+		}
+		n += sz
+	}
+	return n, nil
+}
+
+// MeasurementValueCounts returns the number of values of each measurement in
+// the database, summed across its shards. Each field of a point is counted
+// separately, and values in a shard's cache that overwrite values in its TSM
+// files are counted twice until they are compacted.
+func (s *Store) MeasurementValueCounts(database string) (map[string]int64, error) {
+	s.mu.RLock()
+	shards := s.filterShards(func(sh *Shard) bool {
+		return sh.database == database
+	})
+	s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, sh := range shards {
+		n, err := sh.ValueCountByMeasurement()
+		if err != nil {
+			return nil, err
+		}
+		for name, c := range n {
+			counts[name] += c
+		}
+	}
+	return counts, nil
+}
+
+// MeasurementPointCounts returns the number of points of each measurement in
+// the database, summed across its shards. Unlike MeasurementValueCounts, the
+// fields of a series written at the same time are counted as one point, so
+// the series of measurements with more than one field are read to count
+// them, as by Shard.PointCount.
+func (s *Store) MeasurementPointCounts(database string) (map[string]int64, error) {
+	s.mu.RLock()
+	shards := s.filterShards(func(sh *Shard) bool {
+		return sh.database == database
+	})
+	s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, sh := range shards {
+		n, err := sh.PointCountByMeasurement()
+		if err != nil {
+			return nil, err
+		}
+		for name, c := range n {
+			counts[name] += c
+		}
+	}
+	return counts, nil
+}
+
+// ImportOptions selects the data copied by Store.ImportFrom.
+type ImportOptions struct {
+	// Databases and Measurements limit the import to the named databases
+	// and measurements. Everything is imported if they are empty.
+	Databases    []string
+	Measurements []string
+
+	// MinTime and MaxTime limit the import to points within the range,
+	// inclusive. A zero time leaves that end of the range unbounded.
+	MinTime time.Time
+	MaxTime time.Time
+
+	// Progress, if set, is called with the running totals after each shard
+	// has been imported.
+	Progress func(stats ImportStats)
+}
+
+// timeRange returns the time range of the import in nanoseconds.
+func (opts ImportOptions) timeRange() (min, max int64) {
+	return nanoTimeRange(opts.MinTime, opts.MaxTime)
+}
+
+// nanoTimeRange returns the range from minTime to maxTime in nanoseconds. A
+// zero time leaves that end of the range unbounded.
+func nanoTimeRange(minTime, maxTime time.Time) (min, max int64) {
+	min, max = math.MinInt64, math.MaxInt64
+	if !minTime.IsZero() {
+		min = minTime.UnixNano()
+	}
+	if !maxTime.IsZero() {
+		max = maxTime.UnixNano()
+	}
+	return min, max
+}
+
+// ImportStats holds the totals of an import.
+type ImportStats struct {
+	Shards int
+	Series int
+	Points int
+
+	// Conflicts holds the fields that were not imported because their type
+	// differs from the type of the field in the destination shard.
+	Conflicts []FieldConflict
+}
+
+// FieldConflict describes a field whose type differs between the source and
+// destination shards of an import.
+type FieldConflict struct {
+	ShardID     uint64
+	Database    string
+	Measurement string
+	Field       string
+	SourceType  influxql.DataType
+	DestType    influxql.DataType
+}
+
+// String returns a human readable description of the conflict.
+func (c FieldConflict) String() string {
+	return fmt.Sprintf("shard %d (%s): field %q of %q is %s but %s in the destination", c.ShardID, c.Database, c.Field, c.Measurement, c.SourceType, c.DestType)
+}
+
+// importBatchSize is the number of points written to a shard at once by
+// Store.ImportFrom.
+const importBatchSize = 5000
+
+// ImportFrom copies the points selected by opts from the shards of src into
+// the shards with the same IDs in the store, creating them if needed. Shards
+// are copied one at a time in ID order and points are written directly
+// without being serialized. Fields whose type conflicts with the destination
+// shard are skipped and reported in the returned stats.
+func (s *Store) ImportFrom(src *Store, opts ImportOptions) (ImportStats, error) {
+	var stats ImportStats
+	if src == s {
+		return stats, errors.New("cannot import a store into itself")
+	}
+	min, max := opts.timeRange()
+
+	databases := make(map[string]struct{}, len(opts.Databases))
+	for _, db := range opts.Databases {
+		databases[db] = struct{}{}
+	}
+
+	src.mu.RLock()
+	shards := src.filterShards(func(sh *Shard) bool {
+		_, ok := databases[sh.database]
+		return len(databases) == 0 || ok
+	})
+	src.mu.RUnlock()
+	sort.Sort(Shards(shards))
+
+	for _, sh := range shards {
+		if dst := s.Shard(sh.id); dst != nil && (dst.database != sh.database || dst.retentionPolicy != sh.retentionPolicy) {
+			return stats, fmt.Errorf("shard %d belongs to %s/%s in the destination, not %s/%s", sh.id, dst.database, dst.retentionPolicy, sh.database, sh.retentionPolicy)
+		}
+		if err := s.CreateShard(sh.database, sh.retentionPolicy, sh.id, true); err != nil {
+			return stats, err
+		}
+		if err := s.importShard(sh, opts.Measurements, min, max, &stats); err != nil {
+			return stats, fmt.Errorf("import shard %d: %s", sh.id, err)
+		}
+
+		stats.Shards++
+		if opts.Progress != nil {
+			opts.Progress(stats)
+		}
+	}
+	return stats, nil
+}
+
+// importShard copies the points of the measurements of src between min and
+// max into the shard with the same ID in the store.
+func (s *Store) importShard(src *Shard, measurements []string, min, max int64, stats *ImportStats) error {
+	dst := s.Shard(src.id)
+	if dst == nil {
+		return ErrShardNotFound
+	}
+
+	if len(measurements) == 0 {
+		for _, m := range src.index.Measurements() {
+			measurements = append(measurements, m.Name)
+		}
+		sort.Strings(measurements)
+	}
+
+	var batch []models.Point
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := dst.WritePoints(batch); err != nil {
+			return err
+		}
+		stats.Points += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for _, name := range measurements {
+		m := src.index.Measurement(name)
+		if m == nil {
+			continue
+		}
+
+		srcFields, err := src.fieldSet(name)
+		if err != nil {
+			return err
+		}
+		dstFields, err := dst.fieldSet(name)
+		if err != nil {
+			return err
+		}
+
+		// Report conflicting fields rather than letting the writes fail.
+		fields := make([]string, 0, len(srcFields))
+		for field, typ := range srcFields {
+			if dstTyp, ok := dstFields[field]; ok && dstTyp != typ {
+				stats.Conflicts = append(stats.Conflicts, FieldConflict{
+					ShardID:     src.id,
+					Database:    src.database,
+					Measurement: name,
+					Field:       field,
+					SourceType:  typ,
+					DestType:    dstTyp,
+				})
+				continue
+			}
+			fields = append(fields, field)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		sort.Strings(fields)
+
+		var keys []string
+		for _, key := range m.SeriesKeys() {
+			if ss := src.index.Series(key); ss != nil && ss.Assigned(src.id) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		// Series are read one at a time so that each is counted once,
+		// however many batches its points are read in.
+		for _, key := range keys {
+			var read bool
+			if err := src.ReadPoints(name, []string{key}, fields, min, max, func(points []models.Point) error {
+				if !read {
+					stats.Series++
+					read = true
+				}
+				batch = append(batch, points...)
+				if len(batch) >= importBatchSize {
+					return flush()
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// ExportOptions selects the data written by Store.Export.
+type ExportOptions struct {
+	// Databases and Measurements limit the export to the named databases
+	// and measurements. Everything is exported if they are empty.
+	Databases    []string
+	Measurements []string
+
+	// MinTime and MaxTime limit the export to points within the range,
+	// inclusive. A zero time leaves that end of the range unbounded.
+	MinTime time.Time
+	MaxTime time.Time
+}
+
+// Export writes the points selected by opts to w as line protocol, in the
+// format read by influx -import. A DDL section creates each exported
+// database and retention policy, as by WriteExportDDL with the store's
+// metadata, and the points of each retention policy follow context lines
+// naming it. Shards are written in database, retention policy and ID order,
+// and the series of each measurement in key order.
+func (s *Store) Export(w io.Writer, opts ExportOptions) error {
+	min, max := nanoTimeRange(opts.MinTime, opts.MaxTime)
+
+	databases := make(map[string]struct{}, len(opts.Databases))
+	for _, db := range opts.Databases {
+		databases[db] = struct{}{}
+	}
+
+	s.mu.RLock()
+	shards := s.filterShards(func(sh *Shard) bool {
+		_, ok := databases[sh.database]
+		return len(databases) == 0 || ok
+	})
+	s.mu.RUnlock()
+	sort.Sort(shardsByRetentionPolicy(shards))
+
+	rps := make(map[string][]string)
+	for i, sh := range shards {
+		if i == 0 || sh.database != shards[i-1].database || sh.retentionPolicy != shards[i-1].retentionPolicy {
+			rps[sh.database] = append(rps[sh.database], sh.retentionPolicy)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintln(bw, "# DDL"); err != nil {
+		return err
+	} else if err := WriteExportDDL(bw, rps, s.MetaClient); err != nil {
+		return err
+	} else if _, err := fmt.Fprintln(bw, "# DML"); err != nil {
+		return err
+	}
+
+	for i, sh := range shards {
+		if i == 0 || sh.database != shards[i-1].database || sh.retentionPolicy != shards[i-1].retentionPolicy {
+			if err := WriteExportContext(bw, sh.database, sh.retentionPolicy); err != nil {
+				return err
+			}
+		}
+		if err := exportShard(bw, sh, opts.Measurements, min, max); err != nil {
+			return fmt.Errorf("export shard %d: %s", sh.id, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteExportDDL writes statements creating each database in rps, followed
+// by each of its retention policies, ordered by name, as in the DDL section
+// of an export. Retention policy settings are taken from c when it is not
+// nil and holds the policy; otherwise policies are created with an infinite
+// duration and a replication factor of 1.
+func WriteExportDDL(w io.Writer, rps map[string][]string, c MetaClient) error {
+	dbs := make([]string, 0, len(rps))
+	for db := range rps {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	for _, db := range dbs {
+		if _, err := fmt.Fprintln(w, (&influxql.CreateDatabaseStatement{Name: db}).String()); err != nil {
+			return err
+		}
+
+		names := append([]string(nil), rps[db]...)
+		sort.Strings(names)
+		for _, rp := range names {
+			stmt := &influxql.CreateRetentionPolicyStatement{Name: rp, Database: db, Replication: 1}
+			if c != nil {
+				if rpi := c.RetentionPolicy(db, rp); rpi != nil {
+					stmt.Duration = rpi.Duration
+					stmt.Replication = rpi.ReplicaN
+					stmt.ShardGroupDuration = rpi.ShardGroupDuration
+					stmt.Default = rpi.Default
+				}
+			}
+			if _, err := fmt.Fprintln(w, stmt.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteExportContext writes the headers naming the database and retention
+// policy of the points that follow them in an export.
+func WriteExportContext(w io.Writer, database, retentionPolicy string) error {
+	_, err := fmt.Fprintf(w, "# CONTEXT-DATABASE:%s\n# CONTEXT-RETENTION-POLICY:%s\n", database, retentionPolicy)
+	return err
+}
+
+// FormatExportLine returns the line of an export holding a point of the
+// series at time t, ending in a newline. The fields are the point's fields
+// as returned by FormatExportField, joined by commas.
+func FormatExportLine(series []byte, fields string, t int64) string {
+	return string(series) + " " + fields + " " + strconv.FormatInt(t, 10) + "\n"
+}
+
+// FormatExportField returns the line protocol representation of a field and
+// its value in an export. Field keys are stored unescaped, unlike series
+// keys, so they are escaped here. Newlines in string values are escaped if
+// escapeNewlines is set, so that each point stays on one line.
+func FormatExportField(field string, v interface{}, escapeNewlines bool) string {
+	key := escape.String(field)
+	switch v := v.(type) {
+	case int64:
+		return key + "=" + strconv.FormatInt(v, 10) + "i"
+	case string:
+		v = models.EscapeStringField(v)
+		if escapeNewlines {
+			v = models.EscapeStringFieldNewlines(v)
+		}
+		return key + "=\"" + v + "\""
+	default:
+		return key + "=" + fmt.Sprintf("%v", v)
+	}
+}
+
+// exportShard writes the points of the measurements of sh between min and
+// max to w as line protocol.
+func exportShard(w io.Writer, sh *Shard, measurements []string, min, max int64) error {
+	if len(measurements) == 0 {
+		for _, m := range sh.index.Measurements() {
+			measurements = append(measurements, m.Name)
+		}
+		sort.Strings(measurements)
+	}
+
+	for _, name := range measurements {
+		m := sh.index.Measurement(name)
+		if m == nil {
+			continue
+		}
+
+		keys, err := sh.SeriesKeysMatching(name, nil)
+		if err != nil {
+			return err
+		} else if len(keys) == 0 {
+			continue
+		}
+
+		set, err := sh.fieldSet(name)
+		if err != nil {
+			return err
+		}
+		fields := make([]string, 0, len(set))
+		for field := range set {
+			fields = append(fields, field)
+		}
+		if len(fields) == 0 {
+			// The measurement's series can not be read without its fields,
+			// so they are noted rather than silently left out.
+			if _, err := fmt.Fprintf(w, "# WARNING: skipped %d series of measurement %s in shard %d: %s\n", len(keys), name, sh.id, ErrFieldsNotFound); err != nil {
+				return err
+			}
+			continue
+		}
+		sort.Strings(fields)
+
+		if err := sh.ReadPoints(name, keys, fields, min, max, func(points []models.Point) error {
+			for _, p := range points {
+				values := p.Fields()
+				pairs := make([]string, 0, len(values))
+				for _, field := range fields {
+					if v, ok := values[field]; ok {
+						pairs = append(pairs, FormatExportField(field, v, false))
+					}
+				}
+				if _, err := io.WriteString(w, FormatExportLine(p.Key(), strings.Join(pairs, ","), p.UnixNano())); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardsByRetentionPolicy sorts shards by database, retention policy and ID.
+type shardsByRetentionPolicy []*Shard
+
+func (a shardsByRetentionPolicy) Len() int      { return len(a) }
+func (a shardsByRetentionPolicy) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a shardsByRetentionPolicy) Less(i, j int) bool {
+	if a[i].database != a[j].database {
+		return a[i].database < a[j].database
+	} else if a[i].retentionPolicy != a[j].retentionPolicy {
+		return a[i].retentionPolicy < a[j].retentionPolicy
+	}
+	return a[i].id < a[j].id
+}
+
+// Metadata snapshots hold the store's metadata, the databases, retention
+// policies, shard groups and shard owners, without any shard data. A
+// snapshot starts with a header of the magic number, the format version and
+// the length of the metadata, each a big-endian uint64, followed by the
+// metadata and a CRC32 checksum of it.
+const (
+	// MetadataSnapshotMagic identifies a metadata snapshot.
+	MetadataSnapshotMagic = 0x59590201
+
+	// MetadataSnapshotVersion is the format version of metadata snapshots
+	// written by SnapshotMetadata. Snapshots with a later version are not
+	// read.
+	MetadataSnapshotVersion = 1
+)
+
+// SnapshotMetadata writes the store's metadata to w as a metadata snapshot,
+// so that the logical layout of the store can be backed up and rebuilt
+// separately from its shards.
+func (s *Store) SnapshotMetadata(w io.Writer) error {
+	if s.MetaClient == nil {
+		return ErrMetadataNotFound
+	}
+	snapshotter, ok := s.MetaClient.(MetaSnapshotter)
+	if !ok {
+		return errors.New("metadata cannot be snapshotted")
+	}
+	buf, err := snapshotter.MarshalMetadata()
+	if err != nil {
+		return err
+	}
+
+	var hdr [24]byte
+	binary.BigEndian.PutUint64(hdr[0:8], MetadataSnapshotMagic)
+	binary.BigEndian.PutUint64(hdr[8:16], MetadataSnapshotVersion)
+	binary.BigEndian.PutUint64(hdr[16:24], uint64(len(buf)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf))
+	_, err = w.Write(sum[:])
+	return err
+}
+
+// ReadMetadataSnapshot reads the encoded metadata from a metadata snapshot
+// written by SnapshotMetadata. It returns an error if the snapshot is
+// truncated, corrupt or written in a later format version.
+func ReadMetadataSnapshot(r io.Reader) ([]byte, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("read metadata snapshot header: %s", err)
+	}
+	if magic := binary.BigEndian.Uint64(hdr[0:8]); magic != MetadataSnapshotMagic {
+		return nil, fmt.Errorf("not a metadata snapshot: invalid magic %#x", magic)
+	}
+	if version := binary.BigEndian.Uint64(hdr[8:16]); version < 1 || version > MetadataSnapshotVersion {
+		return nil, fmt.Errorf("unsupported metadata snapshot version %d, expected 1 to %d", version, MetadataSnapshotVersion)
+	}
+
+	// Guard against allocating a huge buffer for a corrupt length.
+	n := binary.BigEndian.Uint64(hdr[16:24])
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(n&math.MaxInt64)))
+	if err != nil {
+		return nil, err
+	} else if uint64(len(buf)) != n {
+		return nil, fmt.Errorf("metadata snapshot truncated: %d of %d bytes", len(buf), n)
+	}
+
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return nil, fmt.Errorf("read metadata snapshot checksum: %s", err)
+	} else if binary.BigEndian.Uint32(sum[:]) != crc32.ChecksumIEEE(buf) {
+		return nil, errors.New("metadata snapshot checksum mismatch")
+	}
+	return buf, nil
+}
+
+// RestoreMetadata replaces the store's metadata with the metadata in the
+// snapshot read from r. The metadata is only replaced once the whole
+// snapshot has been read and verified. Shard data is not touched, so shards
+// missing from the restored metadata are reported by
+// ValidateMetadataConsistency.
+func (s *Store) RestoreMetadata(r io.Reader) error {
+	if s.MetaClient == nil {
+		return ErrMetadataNotFound
+	}
+	snapshotter, ok := s.MetaClient.(MetaSnapshotter)
+	if !ok {
+		return errors.New("metadata cannot be updated")
+	}
+
+	buf, err := ReadMetadataSnapshot(r)
+	if err != nil {
+		return err
+	}
+	return snapshotter.RestoreMetadata(buf)
+}
+
+// MergeShards merges the shards with the given IDs into the one with the
+// lowest ID and deletes the others, returning the ID of the merged shard.
+// It is intended for reducing the shard count of an over-sharded node while
+// it is not serving writes. The shards must belong to the same database and
+// retention policy.
+//
+// The store's metadata is updated so the merged shard's group covers the
+// time ranges of all the merged groups, and the other groups are deleted.
+// Nothing is changed if the store is read-only, if the metadata cannot be
+// updated, if the shard groups hold other shards or the merged group would
+// overlap another group, or if a field has different types in different
+// shards. These are all checked before any data is written. If writing the
+// merged data or updating the metadata then fails, the shard merged into is
+// restored from a snapshot taken beforehand. The other shards are only
+// deleted once the metadata is updated.
+func (s *Store) MergeShards(ids []uint64) (uint64, error) {
+	if s.EngineOptions.ReadOnly {
+		return 0, ErrStoreReadOnly
+	}
+
+	ids = append([]uint64(nil), ids...)
+	sort.Sort(uint64Slice(ids))
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			return 0, fmt.Errorf("shard %d is listed more than once", ids[i])
+		}
+	}
+	if len(ids) < 2 {
+		return 0, errors.New("at least two shards are required to merge")
+	}
+
+	if s.MetaClient == nil {
+		return 0, ErrMetadataNotFound
+	}
+	merger, ok := s.MetaClient.(ShardGroupMerger)
+	if !ok {
+		return 0, errors.New("metadata cannot be updated")
+	}
+
+	shards := s.Shards(ids)
+	if len(shards) != len(ids) {
+		return 0, ErrShardNotFound
+	}
+	dst := shards[0]
+	for _, sh := range shards[1:] {
+		if sh.database != dst.database || sh.retentionPolicy != dst.retentionPolicy {
+			return 0, fmt.Errorf("shard %d belongs to %s/%s, not %s/%s", sh.id, sh.database, sh.retentionPolicy, dst.database, dst.retentionPolicy)
+		}
+	}
+
+	updateMeta, err := merger.MergeShardGroups(dst.database, dst.retentionPolicy, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	fields, err := mergeFieldSets(shards)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the files of the shard merged into, to undo a failed merge. The
+	// snapshot is moved out of the shard's directory, where closing the
+	// shard would remove it.
+	backup := dst.path + ".merge"
+	if err := os.RemoveAll(backup); err != nil {
+		return 0, err
+	}
+	snapshot, err := dst.CreateSnapshot()
+	if err != nil {
+		return 0, err
+	} else if err := os.Rename(snapshot, backup); err != nil {
+		os.RemoveAll(snapshot)
+		return 0, err
+	}
+	defer os.RemoveAll(backup)
+
+	n, err := s.mergeShardData(dst, shards[1:], fields)
+	if err == nil {
+		err = updateMeta()
+	}
+	if err != nil {
+		if e := s.restoreShard(dst, backup); e != nil {
+			return 0, fmt.Errorf("merge into shard %d: %s; restoring it failed: %s", dst.id, err, e)
+		}
+		return 0, fmt.Errorf("merge into shard %d: %s", dst.id, err)
+	}
+
+	// The data of the other shards is in the merged shard and their groups
+	// are gone from the metadata, so one that cannot be deleted is only
+	// left over on disk.
+	for _, sh := range shards[1:] {
+		if err := s.DeleteShard(sh.id); err != nil {
+			return 0, fmt.Errorf("shard %d was merged into shard %d but could not be deleted: %s", sh.id, dst.id, err)
+		}
+	}
+
+	s.Logger.Printf("merged %d points from shards %v into shard %d", n, ids[1:], dst.id)
+	return dst.id, nil
+}
+
+// restoreShard replaces the data of sh with the files of the snapshot in dir,
+// taken by Shard.CreateSnapshot, and reopens it, undoing any writes since the
+// snapshot was taken.
+func (s *Store) restoreShard(sh *Shard, dir string) error {
+	sh.UnloadIndex()
+	if err := sh.Close(); err != nil {
+		return err
+	}
+
+	// The cache was written to TSM files by the snapshot, so the WAL only
+	// holds later writes.
+	if err := os.RemoveAll(sh.walPath); err != nil {
+		return err
+	} else if err := os.MkdirAll(sh.walPath, 0700); err != nil {
+		return err
+	}
+
+	fis, err := ioutil.ReadDir(sh.path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := os.RemoveAll(filepath.Join(sh.path, fi.Name())); err != nil {
+			return err
+		}
+	}
+	fis, err = ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := os.Rename(filepath.Join(dir, fi.Name()), filepath.Join(sh.path, fi.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		return err
+	}
+
+	restored := NewShard(sh.id, sh.index, sh.path, sh.walPath, s.EngineOptions)
+	restored.SetLogOutput(s.logOutput)
+	if err := restored.Open(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.shards[sh.id] = restored
+	s.mu.Unlock()
+	return nil
+}
+
+// mergeFieldSets returns the fields of each measurement in the shards. It
+// returns an error describing the first field found with different types
+// in different shards.
+func mergeFieldSets(shards []*Shard) (map[string]map[string]influxql.DataType, error) {
+	sets := make(map[string]map[string]influxql.DataType)
+	owner := make(map[string]uint64)
+	for _, sh := range shards {
+		for _, m := range sh.index.Measurements() {
+			fields, err := sh.fieldSet(m.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			set := sets[m.Name]
+			if set == nil {
+				set = make(map[string]influxql.DataType)
+				sets[m.Name] = set
+			}
+			for field, typ := range fields {
+				if other, ok := set[field]; ok && other != typ {
+					return nil, fmt.Errorf("cannot merge: field %q of %q is %s in shard %d but %s in shard %d", field, m.Name, other, owner[m.Name+"\x00"+field], typ, sh.id)
+				} else if !ok {
+					set[field] = typ
+					owner[m.Name+"\x00"+field] = sh.id
+				}
+			}
+		}
+	}
+	return sets, nil
+}
+
+// mergeShardData writes the points of the srcs shards into dst, returning
+// the number of points written. Series are merged in key order, reading
+// each series from every source shard holding it before the next series.
+func (s *Store) mergeShardData(dst *Shard, srcs []*Shard, fields map[string]map[string]influxql.DataType) (int, error) {
+	var n int
+	var batch []models.Point
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := dst.WritePoints(batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := dst.index.Measurement(name)
+		if m == nil {
+			continue
+		}
+
+		// Only read the fields each source shard has.
+		srcFields := make([][]string, len(srcs))
+		for i, src := range srcs {
+			set, err := src.fieldSet(name)
+			if err != nil {
+				return n, err
+			}
+			for field := range set {
+				srcFields[i] = append(srcFields[i], field)
+			}
+			sort.Strings(srcFields[i])
+		}
+
+		keys := m.SeriesKeys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			ss := dst.index.Series(key)
+			if ss == nil {
+				continue
+			}
+			for i, src := range srcs {
+				if len(srcFields[i]) == 0 || !ss.Assigned(src.id) {
+					continue
+				}
+				if err := src.ReadPoints(name, []string{key}, srcFields[i], math.MinInt64, math.MaxInt64, func(points []models.Point) error {
+					batch = append(batch, points...)
+					if len(batch) >= importBatchSize {
+						return flush()
+					}
+					return nil
+				}); err != nil {
+					return n, err
+				}
+			}
+		}
+	}
+	return n, flush()
+}
+
+// BackupShard will get the shard and have the engine backup since the passed in time to the writer
+func (s *Store) BackupShard(id uint64, since time.Time, w io.Writer) error {
+	shard := s.Shard(id)
+	if shard == nil {
+		return fmt.Errorf("shard %d doesn't exist on this server", id)
+	}
+
+	path, err := relativePath(s.path, shard.path)
+	if err != nil {
+		return err
+	}
+
+	return shard.engine.Backup(w, path, since)
+}
+
+// CopyShard copies the TSM and tombstone files of a shard into destDir,
+// which is created if it does not exist. Unlike copying the shard's
+// directory, the files are taken from a snapshot written by
+// Shard.WriteSnapshotTo, so no file is copied while it is being written or
+// compacted and the copy is consistent while the shard accepts writes.
+func (s *Store) CopyShard(id uint64, destDir string) error {
+	sh := s.Shard(id)
+	if sh == nil {
+		return ErrShardNotFound
+	}
+
+	if err := os.MkdirAll(destDir, 0777); err != nil {
+		return err
+	}
+
+	// Closing the reader on an early return stops the snapshot.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(sh.WriteSnapshotTo(pw))
+	}()
+
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := copyShardFile(tr, filepath.Join(destDir, filepath.Base(hdr.Name))); err != nil {
+			return err
+		}
+	}
+}
+
+// copyShardFile writes the contents of r to a new file at path.
+func copyShardFile(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // RestoreShard restores a backup from r to a given shard.
@@ -2,10 +2,15 @@ package tsdb_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -178,6 +183,257 @@ func TestStore_Open_InvalidDatabaseFile(t *testing.T) {
 	}
 }
 
+// Ensure a write protected store cannot be opened while another holds the
+// lock on its directory, unless the lock is forced.
+func TestStore_Open_WriteProtected(t *testing.T) {
+	s0 := NewStore()
+	defer s0.Close()
+	s0.WriteProtected = true
+	if err := s0.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second store on the same directory is refused.
+	s1 := &Store{Store: tsdb.NewStore(s0.Path())}
+	s1.EngineOptions.Config.WALDir = filepath.Join(s0.Path(), "wal")
+	s1.WriteProtected = true
+	if err, ok := s1.Open().(*tsdb.LockedError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err.PID != os.Getpid() {
+		t.Fatalf("unexpected lock holder: %d", err.PID)
+	}
+
+	// The lock file is not taken for a database.
+	if n := s0.DatabaseIndexN(); n != 0 {
+		t.Fatalf("unexpected database index count: %d", n)
+	}
+
+	// The lock can be forced, which leaves it with its holder.
+	s1.ForceLock = true
+	if err := s1.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := s1.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tsdb.CheckLock(s0.Path()).(*tsdb.LockedError); !ok {
+		t.Fatal("expected lock to still be held")
+	}
+
+	// A store that is not write protected ignores the lock.
+	s2 := &Store{Store: tsdb.NewStore(s0.Path())}
+	s2.EngineOptions.Config.WALDir = filepath.Join(s0.Path(), "wal")
+	if err := s2.Open(); err != nil {
+		t.Fatal(err)
+	}
+	s2.Store.Close()
+
+	// The lock is released on close.
+	if err := s0.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s1.ForceLock = false
+	if err := s1.Open(); err != nil {
+		t.Fatal(err)
+	} else if err := s1.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure only the shards selected by the shard filter are opened.
+func TestStore_Open_ShardFilter(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1, `cpu,host=a value=1 10`)
+	s.MustCreateShardWithData("db0", "rp0", 2, `cpu,host=a value=2 20`)
+	s.MustCreateShardWithData("db1", "rp0", 3, `cpu,host=a value=3 30`)
+	if err := s.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Store = tsdb.NewStore(s.Path())
+	s.EngineOptions.Config.WALDir = filepath.Join(s.Path(), "wal")
+	var calls []string
+	s.ShardFilter = func(database, retentionPolicy string, id uint64) bool {
+		calls = append(calls, fmt.Sprintf("%s/%s/%d", database, retentionPolicy, id))
+		return id != 2
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := s.ShardIDs(); len(ids) != 2 || s.Shard(1) == nil || s.Shard(3) == nil {
+		t.Fatalf("unexpected shards opened: %v", ids)
+	}
+	sort.Strings(calls)
+	if exp := []string{"db0/rp0/1", "db0/rp0/2", "db1/rp0/3"}; !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("unexpected filter calls: %v", calls)
+	}
+}
+
+// Ensure a shard copied by CopyShard can be opened in another store.
+func TestStore_CopyShard(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 10`,
+		`cpu,host=serverB value=2 20`,
+	)
+
+	other := NewStore()
+	defer other.Close()
+	if err := s.CopyShard(1, filepath.Join(other.Path(), "db0", "rp0", "1")); err != nil {
+		t.Fatal(err)
+	} else if err := s.CopyShard(2, other.Path()); err != tsdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := other.Open(); err != nil {
+		t.Fatal(err)
+	}
+	sh := other.Shard(1)
+	if sh == nil {
+		t.Fatal("expected shard 1")
+	}
+	if n, err := sh.ValueCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected value count: %d", n)
+	}
+}
+
+// Ensure a store can be opened read-only for tooling from a node's root
+// storage path, including data only held in the WAL.
+func TestOpenForTooling(t *testing.T) {
+	root, err := ioutil.TempDir("", "influxdb-tsdb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	s := &Store{Store: tsdb.NewStore(filepath.Join(root, "data"))}
+	s.EngineOptions.Config.WALDir = filepath.Join(root, "wal")
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 10`,
+		`cpu,host=serverB value=2 20`,
+	)
+	if err := s.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := tsdb.OpenForTooling(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if cfg := store.EngineOptions.Config; cfg.Dir != filepath.Join(root, "data") || cfg.WALDir != filepath.Join(root, "wal") {
+		t.Fatalf("unexpected directories: %s, %s", cfg.Dir, cfg.WALDir)
+	} else if cfg.WALLoggingEnabled || cfg.QueryLogEnabled {
+		t.Fatalf("unexpected logging: %#v", cfg)
+	}
+	sh := store.Shard(1)
+	if sh == nil {
+		t.Fatal("expected shard 1")
+	}
+	if n, err := sh.SeriesCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected series count: %d", n)
+	}
+
+	pt := models.MustNewPoint("cpu", models.Tags{}, map[string]interface{}{"value": 3.0}, time.Unix(0, 30))
+	if err := store.WriteToShard(1, []models.Point{pt}); err == nil || !strings.Contains(err.Error(), tsdb.ErrEngineReadOnly.Error()) {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+}
+
+// Ensure a store opened read-only reads the WAL and shards without changing
+// any file and refuses writes.
+func TestStore_OpenReadOnly(t *testing.T) {
+	root, err := ioutil.TempDir("", "influxdb-tsdb-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	s := &Store{Store: tsdb.NewStore(filepath.Join(root, "data"))}
+	s.EngineOptions.Config.WALDir = filepath.Join(root, "wal")
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 10`,
+		`cpu,host=serverB value=2 20`,
+	)
+	if err := s.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave temporary files as an interrupted snapshot and compaction would.
+	shardDir := filepath.Join(root, "data", "db0", "rp0", "1")
+	if err := os.Mkdir(filepath.Join(shardDir, "1.tmp"), 0777); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(shardDir, "000000001-000000002.tsm.tmp"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	listFiles := func() map[string]int64 {
+		files := make(map[string]int64)
+		if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			files[path] = info.Size()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	before := listFiles()
+
+	store := tsdb.NewStoreForTooling(root)
+	if err := store.OpenReadOnly(); err != nil {
+		t.Fatal(err)
+	}
+
+	sh := store.Shard(1)
+	if sh == nil {
+		t.Fatal("expected shard 1")
+	}
+	if n, err := sh.ValueCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected value count: %d", n)
+	}
+	if state, err := sh.CompactionState(); err != nil {
+		t.Fatal(err)
+	} else if state.TempFiles != 2 {
+		t.Fatalf("unexpected temp files: %d", state.TempFiles)
+	}
+
+	pt := models.MustNewPoint("cpu", models.Tags{}, map[string]interface{}{"value": 3.0}, time.Unix(0, 30))
+	if err := store.WriteToShard(1, []models.Point{pt}); err == nil || !strings.Contains(err.Error(), tsdb.ErrEngineReadOnly.Error()) {
+		t.Fatalf("unexpected write error: %v", err)
+	} else if err := store.CreateShard("db0", "rp0", 2, true); err != tsdb.ErrStoreReadOnly {
+		t.Fatalf("unexpected create shard error: %v", err)
+	} else if err := store.DeleteDatabase("db0"); err != tsdb.ErrStoreReadOnly {
+		t.Fatalf("unexpected delete database error: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after := listFiles(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("files changed:\nbefore: %v\nafter:  %v", before, after)
+	}
+}
+
 // Ensure the store reports an error when it can't open a retention policy.
 func TestStore_Open_InvalidRetentionPolicy(t *testing.T) {
 	s := NewStore()
@@ -372,140 +628,1193 @@ func TestStore_BackupRestoreShard(t *testing.T) {
 	}
 }
 
-func BenchmarkStoreOpen_200KSeries_100Shards(b *testing.B) { benchmarkStoreOpen(b, 64, 5, 5, 1, 100) }
-
-func benchmarkStoreOpen(b *testing.B, mCnt, tkCnt, tvCnt, pntCnt, shardCnt int) {
-	var path string
-	if err := func() error {
-		store := MustOpenStore()
-		defer store.Store.Close()
-		path = store.Path()
+// Ensure the store reports standalone ownership when it has no metadata.
+func TestStore_ShardOwners_Standalone(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
 
-		// Generate test series (measurements + unique tag sets).
-		series := genTestSeries(mCnt, tkCnt, tvCnt)
+	if o, err := s.ShardOwners(1); err != nil {
+		t.Fatal(err)
+	} else if !o.Standalone || o.String() != "standalone" {
+		t.Fatalf("unexpected ownership: %#v", o)
+	}
+}
 
-		// Generate point data to write to the shards.
-		points := []models.Point{}
-		for _, s := range series {
-			for val := 0.0; val < float64(pntCnt); val++ {
-				p := models.MustNewPoint(s.Measurement, s.Series.Tags, map[string]interface{}{"value": val}, time.Now())
-				points = append(points, p)
-			}
-		}
+// Ensure the store reports shard ownership from its metadata.
+func TestStore_ShardOwners(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
 
-		// Create requested number of shards in the store & write points.
-		for shardID := 0; shardID < shardCnt; shardID++ {
-			if err := store.CreateShard("mydb", "myrp", uint64(shardID), true); err != nil {
-				return fmt.Errorf("create shard: %s", err)
-			}
-			if err := store.BatchWrite(shardID, points); err != nil {
-				return fmt.Errorf("batch write: %s", err)
+	s.NodeID = 2
+	s.MetaClient = &MetaClient{
+		ShardsFn: func() []tsdb.ShardMeta {
+			return []tsdb.ShardMeta{
+				{ID: 1, Database: "db0", RetentionPolicy: "rp0", Owners: []uint64{2, 3}},
+				{ID: 2, Database: "db0", RetentionPolicy: "rp0", Owners: []uint64{1, 2}},
+				{ID: 3, Database: "db0", RetentionPolicy: "rp0", Owners: []uint64{1}},
+				{ID: 4, Database: "db0", RetentionPolicy: "rp0"},
 			}
-		}
-		return nil
-	}(); err != nil {
-		b.Fatal(err)
+		},
 	}
-	defer os.RemoveAll(path)
 
-	// Run the benchmark loop.
-	b.ResetTimer()
-	for n := 0; n < b.N; n++ {
-		store := tsdb.NewStore(path)
-		if err := store.Open(); err != nil {
-			b.Fatalf("open store error: %s", err)
+	for id, exp := range map[uint64]string{1: "owner", 2: "replica", 3: "remote", 4: "standalone"} {
+		if o, err := s.ShardOwners(id); err != nil {
+			t.Fatal(err)
+		} else if got := o.String(); got != exp {
+			t.Errorf("shard %d: got %q, exp %q", id, got, exp)
 		}
+	}
 
-		b.StopTimer()
-		store.Close()
-		b.StartTimer()
+	if _, err := s.ShardOwners(5); err != tsdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Store is a test wrapper for tsdb.Store.
-type Store struct {
-	*tsdb.Store
-}
+// Ensure the store reports shards that differ between disk and metadata.
+func TestStore_ValidateMetadataConsistency(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
 
-// NewStore returns a new instance of Store with a temporary path.
-func NewStore() *Store {
-	path, err := ioutil.TempDir("", "influxdb-tsdb-")
-	if err != nil {
-		panic(err)
+	if _, err := s.ValidateMetadataConsistency(); err != tsdb.ErrMetadataNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	s := &Store{Store: tsdb.NewStore(path)}
-	s.EngineOptions.Config.WALDir = filepath.Join(path, "wal")
-	return s
-}
-
-// MustOpenStore returns a new, open Store at a temporary path.
-func MustOpenStore() *Store {
-	s := NewStore()
-	if err := s.Open(); err != nil {
-		panic(err)
+	// Shard 1 is consistent, shard 2 is misplaced and shard 3 is orphaned.
+	for _, sh := range []struct {
+		db, rp string
+		id     uint64
+	}{{"db0", "rp0", 1}, {"db1", "rp0", 2}, {"db0", "rp0", 3}} {
+		if err := s.CreateShard(sh.db, sh.rp, sh.id, true); err != nil {
+			t.Fatal(err)
+		}
 	}
-	return s
-}
 
-// Reopen closes and reopens the store as a new store.
-func (s *Store) Reopen() error {
-	if err := s.Store.Close(); err != nil {
-		return err
+	s.NodeID = 1
+	s.MetaClient = &MetaClient{
+		ShardsFn: func() []tsdb.ShardMeta {
+			return []tsdb.ShardMeta{
+				{ID: 1, Database: "db0", RetentionPolicy: "rp0"},
+				{ID: 2, Database: "db0", RetentionPolicy: "rp0"},
+				{ID: 4, Database: "db0", RetentionPolicy: "rp0", Owners: []uint64{1}},
+				{ID: 5, Database: "db0", RetentionPolicy: "rp0", Owners: []uint64{2}},
+			}
+		},
 	}
-	s.Store = tsdb.NewStore(s.Path())
-	s.EngineOptions.Config.WALDir = filepath.Join(s.Path(), "wal")
-	return s.Open()
-}
 
-// Close closes the store and removes the underlying data.
-func (s *Store) Close() error {
-	defer os.RemoveAll(s.Path())
-	return s.Store.Close()
-}
+	a, err := s.ValidateMetadataConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-// MustCreateShardWithData creates a shard and writes line protocol data to it.
-func (s *Store) MustCreateShardWithData(db, rp string, shardID int, data ...string) {
-	if err := s.CreateShard(db, rp, uint64(shardID), true); err != nil {
-		panic(err)
+	exp := []struct {
+		typ    tsdb.InconsistencyType
+		id     uint64
+		db, rp string
+		path   string
+	}{
+		{tsdb.MisplacedShard, 2, "db0", "rp0", filepath.Join(s.Path(), "db1", "rp0", "2")},
+		{tsdb.OrphanedShard, 3, "db0", "rp0", filepath.Join(s.Path(), "db0", "rp0", "3")},
+		{tsdb.MissingShard, 4, "db0", "rp0", filepath.Join(s.Path(), "db0", "rp0", "4")},
+	}
+	if len(a) != len(exp) {
+		t.Fatalf("unexpected inconsistencies: %v", a)
+	}
+	for i := range exp {
+		if a[i].Type != exp[i].typ || a[i].ShardID != exp[i].id || a[i].Database != exp[i].db || a[i].RetentionPolicy != exp[i].rp || a[i].Path != exp[i].path {
+			t.Errorf("%d. unexpected inconsistency: %v", i, a[i])
+		} else if a[i].Fix == "" {
+			t.Errorf("%d. expected a suggested fix: %v", i, a[i])
+		}
 	}
-	s.MustWriteToShardString(shardID, data...)
 }
 
-// MustWriteToShardString parses the line protocol (with second precision) and
-// inserts the resulting points into a shard. Panic on error.
-func (s *Store) MustWriteToShardString(shardID int, data ...string) {
-	var points []models.Point
-	for i := range data {
-		a, err := models.ParsePointsWithPrecision([]byte(strings.TrimSpace(data[i])), time.Time{}, "s")
-		if err != nil {
-			panic(err)
+// Ensure each shard counts only its own series and the counts match a full
+// scan of the index as series are added, removed and reloaded.
+func TestStore_SeriesKeyCount(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 0`,
+		`cpu,host=serverB value=1 0`,
+	)
+	s.MustCreateShardWithData("db0", "rp0", 2,
+		`cpu,host=serverA value=1 0`,
+		`mem,host=serverA value=1 0`,
+		`disk,host=serverA value=1 0`,
+	)
+
+	validate := func(exp map[uint64]int) {
+		index := s.DatabaseIndex("db0")
+		for id, n := range exp {
+			if got, err := s.Shard(id).SeriesKeyCount(); err != nil {
+				t.Fatal(err)
+			} else if got != n {
+				t.Fatalf("shard %d: unexpected count: got=%d exp=%d", id, got, n)
+			} else if full := index.SeriesShardN(id); got != full {
+				t.Fatalf("shard %d: count does not match index: got=%d exp=%d", id, got, full)
+			}
 		}
-		points = append(points, a...)
 	}
+	validate(map[uint64]int{1: 2, 2: 3})
 
-	if err := s.WriteToShard(uint64(shardID), points); err != nil {
-		panic(err)
+	if n, err := s.Shard(1).SeriesCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("unexpected database series count: %d", n)
 	}
-}
 
-// BatchWrite writes points to a shard in chunks.
-func (s *Store) BatchWrite(shardID int, points []models.Point) error {
-	nPts := len(points)
-	chunkSz := 10000
-	start := 0
-	end := chunkSz
+	if err := s.DeleteSeries("db0", []influxql.Source{&influxql.Measurement{Name: "cpu"}}, influxql.MustParseExpr(`host = 'serverA'`)); err != nil {
+		t.Fatal(err)
+	}
+	validate(map[uint64]int{1: 1, 2: 2})
 
-	for {
-		if end > nPts {
-			end = nPts
-		}
-		if end-start == 0 {
-			break
-		}
+	if err := s.DeleteMeasurement("db0", "disk"); err != nil {
+		t.Fatal(err)
+	}
+	validate(map[uint64]int{1: 1, 2: 1})
 
-		if err := s.WriteToShard(uint64(shardID), points[start:end]); err != nil {
-			return err
+	if err := s.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	validate(map[uint64]int{1: 1, 2: 1})
+}
+
+// Ensure the store reports the time range of a shard from its files and cache.
+func TestStore_ShardTimeRange(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 10`,
+		`cpu,host=serverB value=2 20`,
+	)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteToShardString(1, `mem,host=serverA value=3 30`)
+
+	if min, max, err := s.ShardTimeRange(1); err != nil {
+		t.Fatal(err)
+	} else if min != 10*int64(time.Second) || max != 30*int64(time.Second) {
+		t.Fatalf("unexpected time range: %d - %d", min, max)
+	}
+
+	// An empty shard reports an inverted range.
+	if err := s.CreateShard("db0", "rp0", 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if min, max, err := s.ShardTimeRange(2); err != nil {
+		t.Fatal(err)
+	} else if min <= max {
+		t.Fatalf("unexpected time range for empty shard: %d - %d", min, max)
+	}
+
+	if _, _, err := s.ShardTimeRange(3); err != tsdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a shard's database, retention policy, format and size are reported.
+func TestStore_ShardInfo(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1, `cpu,host=serverA value=1 10`)
+
+	info, err := s.ShardInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := s.Shard(1).DiskSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (tsdb.ShardInfo{
+		ID:              1,
+		Database:        "db0",
+		RetentionPolicy: "rp0",
+		Path:            filepath.Join(s.Path(), "db0", "rp0", "1"),
+		Format:          tsdb.TSM1Format,
+		Size:            size,
+	}); *info != exp || size == 0 {
+		t.Fatalf("unexpected shard info: %+v", *info)
+	}
+	if info.Format.String() != "tsm1" {
+		t.Fatalf("unexpected format: %s", info.Format)
+	}
+
+	if _, err := s.ShardInfo(2); err != tsdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a shard reports its value, series and field counts from both its
+// TSM files and cache.
+func TestShard_Stats(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1,idle=2 10`,
+		`cpu,host=serverB value=2 20`,
+	)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteToShardString(1, `mem,host=serverA free=3 30`, `cpu,host=serverA value=4 40`)
+
+	stats, err := s.Shard(1).Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := tsdb.ShardStats{
+		Values: 5,
+		Series: 3,
+		Fields: map[string]map[string]int64{
+			"cpu": {"value": 3, "idle": 1},
+			"mem": {"free": 1},
+		},
+		Exact: true,
+	}
+	if !reflect.DeepEqual(stats, exp) {
+		t.Fatalf("unexpected stats:\n\ngot=%#v\n\nexp=%#v", stats, exp)
+	}
+
+	// Overwriting a value counts it twice until it is compacted.
+	s.MustWriteToShardString(1, `cpu,host=serverB value=5 20`)
+	if stats, err := s.Shard(1).Stats(); err != nil {
+		t.Fatal(err)
+	} else if stats.Values != 6 || stats.Exact {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	// Deleted values are not counted.
+	if err := s.Shard(1).DeleteSeriesRange([]string{"cpu,host=serverA"}, 0, 15e9); err != nil {
+		t.Fatal(err)
+	} else if stats, err := s.Shard(1).Stats(); err != nil {
+		t.Fatal(err)
+	} else if stats.Values != 4 || stats.Fields["cpu"]["idle"] != 0 {
+		t.Fatalf("unexpected stats after delete: %#v", stats)
+	}
+
+	// Values deleted from part of a block are not counted either.
+	s.MustCreateShardWithData("db0", "rp0", 3,
+		`cpu,host=serverA value=1 10`,
+		`cpu,host=serverA value=2 20`,
+		`cpu,host=serverA value=3 30`,
+	)
+	if _, err := s.CreateShardSnapshot(3); err != nil {
+		t.Fatal(err)
+	} else if err := s.Shard(3).DeleteSeriesRange([]string{"cpu,host=serverA"}, 15e9, 25e9); err != nil {
+		t.Fatal(err)
+	} else if stats, err := s.Shard(3).Stats(); err != nil {
+		t.Fatal(err)
+	} else if stats.Values != 2 || stats.Fields["cpu"]["value"] != 2 || !stats.Exact {
+		t.Fatalf("unexpected stats after partial delete: %#v", stats)
+	}
+
+	// An empty shard reports zero counts.
+	if err := s.CreateShard("db0", "rp0", 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := s.Shard(2).Stats(); err != nil {
+		t.Fatal(err)
+	} else if stats.Values != 0 || stats.Series != 0 || len(stats.Fields) != 0 || !stats.Exact {
+		t.Fatalf("unexpected stats for empty shard: %#v", stats)
+	}
+}
+
+// Ensure a shard reports the raw and on-disk size of a series' blocks.
+func TestShard_SeriesBlockStats(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1,msg="hello" 10`,
+		`cpu,host=serverA value=2 20`,
+		`cpu,host=serverB value=3 10`,
+	)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.Shard(1).SeriesBlockStats("cpu,host=serverA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two float values of 16 bytes and a string value of 8+5 bytes.
+	if stats.Values != 3 || stats.RawBytes != 45 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	} else if stats.DiskBytes == 0 {
+		t.Fatalf("expected block size: %#v", stats)
+	}
+
+	// Unknown series and measurements have no blocks.
+	for _, key := range []string{"cpu,host=serverC", "mem,host=serverA"} {
+		if stats, err := s.Shard(1).SeriesBlockStats(key); err != nil {
+			t.Fatal(err)
+		} else if stats != (tsdb.SeriesBlockStats{}) {
+			t.Fatalf("unexpected stats for %s: %#v", key, stats)
+		}
+	}
+}
+
+// Ensure a single series can be read from a shard, from the cache and files.
+func TestShard_ReadSeries(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1,msg="hello" 10`,
+		`cpu,host=serverB value=3 10`,
+	)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteToShardString(1, `cpu,host=serverA value=2 20`, `cpu,host=serverA value=4 30`)
+
+	var got []string
+	if err := s.Shard(1).ReadSeries("cpu,host=serverA", 10e9, 20e9, true, func(points []models.Point) error {
+		for _, p := range points {
+			got = append(got, p.String())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{
+		`cpu,host=serverA msg="hello",value=1 10000000000`,
+		`cpu,host=serverA value=2 20000000000`,
+	}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected points:\n\ngot=%q\n\nexp=%q", got, exp)
+	}
+
+	// Points can be read newest first.
+	got = nil
+	if err := s.Shard(1).ReadSeries("cpu,host=serverA", math.MinInt64, math.MaxInt64, false, func(points []models.Point) error {
+		for _, p := range points {
+			got = append(got, p.String())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{
+		`cpu,host=serverA value=4 30000000000`,
+		`cpu,host=serverA value=2 20000000000`,
+		`cpu,host=serverA msg="hello",value=1 10000000000`,
+	}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected points:\n\ngot=%q\n\nexp=%q", got, exp)
+	}
+
+	// Reading stops at the first error from fn.
+	errStop := errors.New("stop")
+	var calls int
+	if err := s.Shard(1).ReadPoints("cpu", []string{"cpu,host=serverA", "cpu,host=serverB"}, []string{"value"}, math.MinInt64, math.MaxInt64, func(points []models.Point) error {
+		calls++
+		return errStop
+	}); err != errStop {
+		t.Fatalf("unexpected error: %v", err)
+	} else if calls != 1 {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+
+	// Unknown series and measurements have no points.
+	for _, key := range []string{"cpu,host=serverC", "mem,host=serverA"} {
+		if err := s.Shard(1).ReadSeries(key, math.MinInt64, math.MaxInt64, true, func(points []models.Point) error {
+			t.Fatalf("unexpected points for %s: %v", key, points)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A series of the shard whose measurement has no fields can not be read.
+	s.DatabaseIndex("db0").CreateSeriesIndexIfNotExists("disk", tsdb.NewSeries("disk,host=serverA", nil)).AssignShard(1)
+	if err := s.Shard(1).ReadSeries("disk,host=serverA", math.MinInt64, math.MaxInt64, true, func(points []models.Point) error {
+		return nil
+	}); err != tsdb.ErrFieldsNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Export(&buf, tsdb.ExportOptions{Measurements: []string{"disk"}}); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(buf.String(), "# WARNING: skipped 1 series of measurement disk in shard 1: no fields recorded for measurement\n") {
+		t.Fatalf("unexpected export:\n%s", buf.String())
+	}
+}
+
+// Ensure the store sums the block sizes of a measurement across shards.
+func TestStore_MeasurementSize(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 10`,
+		`mem,host=serverA value=1 10`,
+	)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	one, err := s.MeasurementSize("db0", "cpu")
+	if err != nil {
+		t.Fatal(err)
+	} else if one <= 0 {
+		t.Fatalf("unexpected size: %d", one)
+	}
+
+	// Data only held in the cache is not counted.
+	s.MustCreateShardWithData("db0", "rp0", 2, `cpu,host=serverA value=2 20`)
+	if n, err := s.MeasurementSize("db0", "cpu"); err != nil {
+		t.Fatal(err)
+	} else if n != one {
+		t.Fatalf("unexpected size with cached data: %d, exp %d", n, one)
+	}
+
+	if _, err := s.CreateShardSnapshot(2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.MeasurementSize("db0", "cpu"); err != nil {
+		t.Fatal(err)
+	} else if n <= one {
+		t.Fatalf("unexpected size across shards: %d", n)
+	}
+
+	if n, err := s.MeasurementSize("db0", "disk"); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("unexpected size for missing measurement: %d", n)
+	}
+}
+
+// Ensure the store reports the compaction state of each shard.
+func TestStore_CompactionStatus(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1, `cpu,host=serverA value=1 10`)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	s.MustCreateShardWithData("db0", "rp0", 2, `cpu,host=serverA value=1 10`)
+	if _, err := s.CreateShardSnapshot(2); err != nil {
+		t.Fatal(err)
+	}
+	s.MustCreateShardWithData("db0", "rp0", 3, `cpu,host=serverA value=1 10`)
+	if _, err := s.CreateShardSnapshot(3); err != nil {
+		t.Fatal(err)
+	}
+	s.MustCreateShardWithData("db0", "rp0", 4, `cpu,host=serverA value=1 10`, `cpu,host=serverA value=2 20`)
+
+	path2, path := s.Shard(2).Path(), s.Shard(3).Path()
+	if err := s.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give shard 2 a second generation. Generations of the same level would
+	// be compacted as soon as the shard is opened, so the first generation
+	// is moved to level 2.
+	buf, err := ioutil.ReadFile(filepath.Join(path2, "000000001-000000001.tsm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(path2, "000000001-000000001.tsm"), filepath.Join(path2, "000000001-000000002.tsm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path2, "000000002-000000001.tsm"), buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave a temporary compaction file behind in shard 3.
+	if err := ioutil.WriteFile(filepath.Join(path, "000000002-000000002.tsm.tmp"), []byte("partial"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s.Store = tsdb.NewStore(s.Path())
+	s.EngineOptions.Config.WALDir = filepath.Join(s.Path(), "wal")
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := s.CompactionStatus()
+	if len(m) != 4 {
+		t.Fatalf("unexpected shards: %v", m)
+	}
+	if st := m[1]; st.Status != tsdb.CompactionOptimal || st.Files != 1 || st.Generations != 1 || st.TempFiles != 0 {
+		t.Fatalf("unexpected state for shard 1: %+v", st)
+	}
+	if st := m[2]; st.Status != tsdb.CompactionNeeded || st.Files != 2 || st.Generations != 2 || st.TempFiles != 0 {
+		t.Fatalf("unexpected state for shard 2: %+v", st)
+	}
+	if st := m[3]; st.Status != tsdb.CompactionPartial || st.Files != 1 || st.TempFiles != 1 {
+		t.Fatalf("unexpected state for shard 3: %+v", st)
+	}
+	if st := m[1]; st.WALSegments != 0 || st.WALPoints != 0 {
+		t.Fatalf("unexpected WAL state for shard 1: %+v", st)
+	}
+	if st := m[4]; st.Files != 0 || st.WALSegments != 1 || st.WALPoints != 2 {
+		t.Fatalf("unexpected WAL state for shard 4: %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(path, "000000002-000000002.tsm.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary file to be removed: %v", err)
+	}
+}
+
+// Ensure the store reports the time bounds of a measurement across shards.
+func TestStore_MeasurementTimeBounds(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=serverA value=1 10`,
+		`mem,host=serverA value=1 5`,
+	)
+	if _, err := s.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	s.MustCreateShardWithData("db0", "rp0", 2,
+		`cpu,host=serverB value=1 30`,
+		`mem,host=serverA value=1 50`,
+	)
+	s.MustCreateShardWithData("db1", "rp0", 3, `cpu,host=serverA value=1 1`)
+
+	if first, last, err := s.MeasurementTimeBounds("db0", "cpu", nil); err != nil {
+		t.Fatal(err)
+	} else if first != 10*int64(time.Second) || last != 30*int64(time.Second) {
+		t.Fatalf("unexpected bounds: %d - %d", first, last)
+	}
+
+	// Only the series matching the filters are included.
+	filters := []*tsdb.TagFilter{{Op: influxql.EQ, Key: "host", Value: "serverB"}}
+	if first, last, err := s.MeasurementTimeBounds("db0", "cpu", filters); err != nil {
+		t.Fatal(err)
+	} else if first != 30*int64(time.Second) || last != 30*int64(time.Second) {
+		t.Fatalf("unexpected bounds for serverB: %d - %d", first, last)
+	}
+	filters[0].Value = "serverC"
+	if first, last, err := s.MeasurementTimeBounds("db0", "cpu", filters); err != nil {
+		t.Fatal(err)
+	} else if first <= last {
+		t.Fatalf("unexpected bounds for serverC: %d - %d", first, last)
+	}
+
+	// A measurement with no data reports inverted bounds.
+	if first, last, err := s.MeasurementTimeBounds("db0", "disk", nil); err != nil {
+		t.Fatal(err)
+	} else if first <= last {
+		t.Fatalf("unexpected bounds for missing measurement: %d - %d", first, last)
+	}
+}
+
+// Ensure points are imported from another store, skipping conflicting fields.
+func TestStore_ImportFrom(t *testing.T) {
+	src := MustOpenStore()
+	defer src.Close()
+
+	src.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=a value=1,idle=5 10`,
+		`mem,host=a value=3 10`,
+	)
+	if _, err := src.CreateShardSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+	src.MustWriteToShardString(1,
+		`cpu,host=b value=2 20`,
+		`cpu,host=b value=9 100`,
+	)
+	src.MustCreateShardWithData("db1", "rp0", 2, `cpu,host=a value=1 10`)
+
+	dst := MustOpenStore()
+	defer dst.Close()
+	dst.MustCreateShardWithData("db0", "rp0", 1, `cpu,host=c idle=1i 5`)
+
+	var progress []tsdb.ImportStats
+	stats, err := dst.ImportFrom(src.Store, tsdb.ImportOptions{
+		Databases:    []string{"db0"},
+		Measurements: []string{"cpu"},
+		MaxTime:      time.Unix(50, 0),
+		Progress:     func(stats tsdb.ImportStats) { progress = append(progress, stats) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.Shards != 1 || stats.Series != 2 || stats.Points != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	} else if len(progress) != 1 || progress[0].Shards != 1 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	} else if len(stats.Conflicts) != 1 {
+		t.Fatalf("unexpected conflicts: %v", stats.Conflicts)
+	} else if c := stats.Conflicts[0]; c.Field != "idle" || c.SourceType != influxql.Float || c.DestType != influxql.Integer {
+		t.Fatalf("unexpected conflict: %s", c)
+	} else if dst.Shard(2) != nil {
+		t.Fatal("unexpected shard imported from db1")
+	}
+
+	var got []string
+	if err := dst.Shard(1).ReadPoints("cpu", []string{"cpu,host=a", "cpu,host=b", "cpu,host=c"}, []string{"idle", "value"}, math.MinInt64, math.MaxInt64, func(points []models.Point) error {
+		for _, p := range points {
+			got = append(got, p.String())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"cpu,host=a value=1 10000000000",
+		"cpu,host=b value=2 20000000000",
+		"cpu,host=c idle=1i 5000000000",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected points:\n\ngot=%q\n\nexp=%q", got, exp)
+	}
+
+	// Importing a store into itself is not allowed.
+	if _, err := src.ImportFrom(src.Store, tsdb.ImportOptions{}); err == nil {
+		t.Fatal("expected error importing store into itself")
+	}
+}
+
+// Ensure each series is counted once when its points are read and written
+// in several batches.
+func TestStore_ImportFrom_Batches(t *testing.T) {
+	src := MustOpenStore()
+	defer src.Close()
+
+	// Each series holds more points than are read or written at once.
+	var lines []string
+	for _, host := range []string{"a", "b"} {
+		for i := 0; i < 2500; i++ {
+			lines = append(lines, fmt.Sprintf("cpu,host=%s value=%d %d", host, i, i))
+		}
+	}
+	src.MustCreateShardWithData("db0", "rp0", 1, lines...)
+
+	dst := MustOpenStore()
+	defer dst.Close()
+
+	stats, err := dst.ImportFrom(src.Store, tsdb.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.Shards != 1 || stats.Series != 2 || stats.Points != 5000 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	var n int
+	if err := dst.Shard(1).ReadPoints("cpu", []string{"cpu,host=a", "cpu,host=b"}, []string{"value"}, math.MinInt64, math.MaxInt64, func(points []models.Point) error {
+		n += len(points)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if n != 5000 {
+		t.Fatalf("unexpected points imported: %d", n)
+	}
+}
+
+// Ensure points are exported as line protocol under the context of their
+// database and retention policy, limited to the selected data.
+func TestStore_Export(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp1", 3, `cpu,host=a value=4 30`)
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=b value=2 20`,
+		`cpu,host=a value=1,idle=5 10`,
+		`mem,host=a free=3i 10`,
+	)
+	s.MustCreateShardWithData("db0", "rp0", 2, `cpu,host=a value=9 100`)
+	s.MustCreateShardWithData("db1", "rp0", 4, `cpu,host=a value=1 10`)
+
+	var buf bytes.Buffer
+	if err := s.Export(&buf, tsdb.ExportOptions{Databases: []string{"db0"}}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := `# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 0s REPLICATION 1
+CREATE RETENTION POLICY rp1 ON db0 DURATION 0s REPLICATION 1
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu,host=a idle=5,value=1 10000000000
+cpu,host=b value=2 20000000000
+mem,host=a free=3i 10000000000
+cpu,host=a value=9 100000000000
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp1
+cpu,host=a value=4 30000000000
+`; buf.String() != exp {
+		t.Fatalf("unexpected export:\n\ngot=%s\n\nexp=%s", buf.String(), exp)
+	}
+
+	buf.Reset()
+	if err := s.Export(&buf, tsdb.ExportOptions{
+		Measurements: []string{"cpu"},
+		MinTime:      time.Unix(20, 0),
+		MaxTime:      time.Unix(50, 0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := `# DDL
+CREATE DATABASE db0
+CREATE RETENTION POLICY rp0 ON db0 DURATION 0s REPLICATION 1
+CREATE RETENTION POLICY rp1 ON db0 DURATION 0s REPLICATION 1
+CREATE DATABASE db1
+CREATE RETENTION POLICY rp0 ON db1 DURATION 0s REPLICATION 1
+# DML
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp0
+cpu,host=b value=2 20000000000
+# CONTEXT-DATABASE:db0
+# CONTEXT-RETENTION-POLICY:rp1
+cpu,host=a value=4 30000000000
+# CONTEXT-DATABASE:db1
+# CONTEXT-RETENTION-POLICY:rp0
+`; buf.String() != exp {
+		t.Fatalf("unexpected export:\n\ngot=%s\n\nexp=%s", buf.String(), exp)
+	}
+
+	// Retention policies are created with their settings from the metadata.
+	s.MetaClient = &MetaClient{
+		RetentionPolicyFn: func(database, name string) *tsdb.RetentionPolicyMeta {
+			if database != "db1" || name != "rp0" {
+				return nil
+			}
+			return &tsdb.RetentionPolicyMeta{
+				Duration:           7 * 24 * time.Hour,
+				ShardGroupDuration: 24 * time.Hour,
+				ReplicaN:           2,
+				Default:            true,
+			}
+		},
+	}
+	buf.Reset()
+	if err := s.Export(&buf, tsdb.ExportOptions{Databases: []string{"db1"}}); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(buf.String(), "# DDL\nCREATE DATABASE db1\nCREATE RETENTION POLICY rp0 ON db1 DURATION 1w REPLICATION 2 SHARD DURATION 1d DEFAULT\n# DML\n") {
+		t.Fatalf("unexpected export:\n%s", buf.String())
+	}
+
+	// Write errors are returned.
+	if err := s.Export(errWriter{}, tsdb.ExportOptions{}); err != errWrite {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+var errWrite = errors.New("write failed")
+
+// errWriter is an io.Writer that always fails.
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) { return 0, errWrite }
+
+// Ensure shards are merged into the shard with the lowest ID and the
+// metadata is only updated once the data is merged.
+func TestStore_MergeShards(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1, `cpu,host=a value=1 1`)
+	s.MustCreateShardWithData("db0", "rp0", 2, `cpu,host=a value=2 11`, `cpu,host=b value=3 12`)
+	s.MustCreateShardWithData("db0", "rp0", 3, `mem,host=a free=4i 21`)
+	s.MustCreateShardWithData("db0", "rp0", 4, `mem,host=a free=5 31`)
+
+	var merged []uint64
+	var mergeErr, updateErr error
+	s.MetaClient = &MetaClient{
+		MergeShardGroupsFn: func(database, policy string, ids []uint64) (func() error, error) {
+			if database != "db0" || policy != "rp0" {
+				t.Fatalf("unexpected retention policy: %s/%s", database, policy)
+			} else if mergeErr != nil {
+				return nil, mergeErr
+			}
+			return func() error {
+				if updateErr != nil {
+					return updateErr
+				}
+				merged = ids
+				return nil
+			}, nil
+		},
+	}
+
+	// Shards with conflicting field types or groups that cannot be merged
+	// are not merged.
+	if _, err := s.MergeShards([]uint64{4, 3}); err == nil || !strings.Contains(err.Error(), `field "free"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mergeErr = errors.New("merged shard group would overlap shard group 2")
+	if _, err := s.MergeShards([]uint64{1, 3}); err != mergeErr {
+		t.Fatalf("unexpected error: %v", err)
+	} else if merged != nil || s.ShardN() != 4 {
+		t.Fatal("unexpected change after failed merge")
+	}
+	mergeErr = nil
+
+	// A merge failing after its data is written restores the shard merged
+	// into, and keeps the other shards.
+	updateErr = errors.New("metadata is unavailable")
+	if _, err := s.MergeShards([]uint64{1, 2}); err == nil || !strings.Contains(err.Error(), "metadata is unavailable") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s.ShardN() != 4 {
+		t.Fatalf("unexpected shards: %v", s.ShardIDs())
+	} else if n, err := s.Shard(1).ValueCount(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("unexpected value count of restored shard: %d", n)
+	} else if _, err := os.Stat(filepath.Join(s.Path(), "db0", "rp0", "1.merge")); !os.IsNotExist(err) {
+		t.Fatalf("expected snapshot to be removed: %v", err)
+	}
+	updateErr = nil
+
+	if id, err := s.MergeShards([]uint64{3, 1, 2}); err != nil {
+		t.Fatal(err)
+	} else if id != 1 {
+		t.Fatalf("unexpected shard id: %d", id)
+	} else if s.Shard(2) != nil || s.Shard(3) != nil || s.ShardN() != 2 {
+		t.Fatalf("unexpected shards: %v", s.ShardIDs())
+	}
+
+	var got []string
+	for _, m := range []struct {
+		name   string
+		keys   []string
+		fields []string
+	}{
+		{"cpu", []string{"cpu,host=a", "cpu,host=b"}, []string{"value"}},
+		{"mem", []string{"mem,host=a"}, []string{"free"}},
+	} {
+		if err := s.Shard(1).ReadPoints(m.name, m.keys, m.fields, math.MinInt64, math.MaxInt64, func(points []models.Point) error {
+			for _, p := range points {
+				got = append(got, p.String())
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := []string{
+		"cpu,host=a value=1 1000000000",
+		"cpu,host=a value=2 11000000000",
+		"cpu,host=b value=3 12000000000",
+		"mem,host=a free=4i 21000000000",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected points:\n\ngot=%q\n\nexp=%q", got, exp)
+	}
+
+	if !reflect.DeepEqual(merged, []uint64{1, 2, 3}) {
+		t.Fatalf("unexpected shard groups merged: %v", merged)
+	}
+}
+
+// Ensure tag key cardinality only counts the series of each shard.
+func TestShard_TagKeyCardinality(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=a,region=x value=1 10`,
+		`mem,host=z value=1 10`,
+	)
+	s.MustCreateShardWithData("db0", "rp0", 2,
+		`cpu,host=a,region=x value=1 20`,
+		`cpu,host=b,region=x value=1 20`,
+		`cpu,host=c value=1 20`,
+	)
+
+	for _, tt := range []struct {
+		id  uint64
+		exp map[string]int
+	}{
+		{id: 1, exp: map[string]int{"host": 1, "region": 1}},
+		{id: 2, exp: map[string]int{"host": 3, "region": 1}},
+	} {
+		if n, err := s.Shard(tt.id).TagKeyCardinality("cpu"); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(n, tt.exp) {
+			t.Fatalf("shard %d: unexpected cardinality: %v", tt.id, n)
+		}
+	}
+
+	if n, err := s.Shard(2).TagKeyCardinality("mem"); err != nil {
+		t.Fatal(err)
+	} else if len(n) != 0 {
+		t.Fatalf("unexpected cardinality: %v", n)
+	}
+}
+
+// Ensure series keys are looked up by measurement and tag filters, limited
+// to the series of the shard and sorted.
+func TestShard_SeriesKeysMatching(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1,
+		`cpu,host=d,region=x value=1 10`,
+	)
+	s.MustCreateShardWithData("db0", "rp0", 2,
+		`cpu,host=c value=1 20`,
+		`cpu,host=b,region=x value=1 20`,
+		`cpu,host=a,region=y value=1 20`,
+		`mem,host=a,region=x value=1 20`,
+	)
+
+	for i, tt := range []struct {
+		filters []*tsdb.TagFilter
+		exp     []string
+	}{
+		{exp: []string{"cpu,host=a,region=y", "cpu,host=b,region=x", "cpu,host=c"}},
+		{
+			filters: []*tsdb.TagFilter{{Op: influxql.EQ, Key: "region", Value: "x"}},
+			exp:     []string{"cpu,host=b,region=x"},
+		},
+		{
+			filters: []*tsdb.TagFilter{{Op: influxql.NEQ, Key: "region", Value: "x"}},
+			exp:     []string{"cpu,host=a,region=y", "cpu,host=c"},
+		},
+		{
+			filters: []*tsdb.TagFilter{{Op: influxql.EQ, Key: "region", Value: ""}},
+			exp:     []string{"cpu,host=c"},
+		},
+		{
+			filters: []*tsdb.TagFilter{
+				{Op: influxql.EQREGEX, Key: "host", Regex: regexp.MustCompile(`^[ab]$`)},
+				{Op: influxql.NEQREGEX, Key: "region", Regex: regexp.MustCompile(`y`)},
+			},
+			exp: []string{"cpu,host=b,region=x"},
+		},
+	} {
+		if keys, err := s.Shard(2).SeriesKeysMatching("cpu", tt.filters); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(keys, tt.exp) {
+			t.Errorf("%d. unexpected keys: %v", i, keys)
+		}
+	}
+
+	if keys, err := s.Shard(2).SeriesKeysMatching("disk", nil); err != nil {
+		t.Fatal(err)
+	} else if keys != nil {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+// Ensure the store reports the disk size of each shard.
+func TestStore_DiskSizeByShard(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	s.MustCreateShardWithData("db0", "rp0", 1, `cpu,host=serverA value=1 10`)
+	s.MustCreateShardWithData("db1", "rp0", 2, `cpu,host=serverA value=1 10`)
+
+	sizes, err := s.DiskSizeByShard()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sizes) != 2 {
+		t.Fatalf("unexpected sizes: %v", sizes)
+	}
+	for _, id := range []uint64{1, 2} {
+		exp, err := s.Shard(id).DiskSize()
+		if err != nil {
+			t.Fatal(err)
+		} else if sizes[id] != exp || exp == 0 {
+			t.Fatalf("unexpected size for shard %d: got=%d exp=%d", id, sizes[id], exp)
+		}
+	}
+}
+
+// Ensure the store's metadata can be snapshotted and restored, and that
+// damaged snapshots are rejected.
+func TestStore_SnapshotMetadata(t *testing.T) {
+	s := MustOpenStore()
+	defer s.Close()
+
+	data := []byte("databases, retention policies and shard groups")
+	var restored []byte
+	s.MetaClient = &MetaClient{
+		MarshalMetadataFn: func() ([]byte, error) { return data, nil },
+		RestoreMetadataFn: func(buf []byte) error { restored = buf; return nil },
+	}
+
+	var buf bytes.Buffer
+	if err := s.SnapshotMetadata(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	if err := s.RestoreMetadata(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	} else if restored == nil {
+		t.Fatal("expected metadata to be restored")
+	}
+	if !bytes.Equal(restored, data) {
+		t.Fatalf("unexpected metadata: %q", restored)
+	}
+
+	// Damaged snapshots and later versions are not restored.
+	corrupt := append([]byte(nil), snapshot...)
+	corrupt[30]++
+	later := append([]byte(nil), snapshot...)
+	later[15]++
+	for _, tt := range []struct {
+		name string
+		buf  []byte
+		err  string
+	}{
+		{"truncated", snapshot[:len(snapshot)-8], "truncated"},
+		{"corrupt", corrupt, "checksum mismatch"},
+		{"later version", later, "unsupported metadata snapshot version 2"},
+		{"not a snapshot", []byte("# INFLUXDB EXPORT: 1970-01-01T00:00:00Z"), "not a metadata snapshot"},
+	} {
+		restored = nil
+		if err := s.RestoreMetadata(bytes.NewReader(tt.buf)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		} else if restored != nil {
+			t.Fatalf("%s: unexpected restore", tt.name)
+		}
+	}
+
+	s.MetaClient = nil
+	if err := s.SnapshotMetadata(&buf); err != tsdb.ErrMetadataNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func BenchmarkStoreOpen_200KSeries_100Shards(b *testing.B) { benchmarkStoreOpen(b, 64, 5, 5, 1, 100) }
+
+func benchmarkStoreOpen(b *testing.B, mCnt, tkCnt, tvCnt, pntCnt, shardCnt int) {
+	var path string
+	if err := func() error {
+		store := MustOpenStore()
+		defer store.Store.Close()
+		path = store.Path()
+
+		// Generate test series (measurements + unique tag sets).
+		series := genTestSeries(mCnt, tkCnt, tvCnt)
+
+		// Generate point data to write to the shards.
+		points := []models.Point{}
+		for _, s := range series {
+			for val := 0.0; val < float64(pntCnt); val++ {
+				p := models.MustNewPoint(s.Measurement, s.Series.Tags, map[string]interface{}{"value": val}, time.Now())
+				points = append(points, p)
+			}
+		}
+
+		// Create requested number of shards in the store & write points.
+		for shardID := 0; shardID < shardCnt; shardID++ {
+			if err := store.CreateShard("mydb", "myrp", uint64(shardID), true); err != nil {
+				return fmt.Errorf("create shard: %s", err)
+			}
+			if err := store.BatchWrite(shardID, points); err != nil {
+				return fmt.Errorf("batch write: %s", err)
+			}
+		}
+		return nil
+	}(); err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	// Run the benchmark loop.
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		store := tsdb.NewStore(path)
+		if err := store.Open(); err != nil {
+			b.Fatalf("open store error: %s", err)
+		}
+
+		b.StopTimer()
+		store.Close()
+		b.StartTimer()
+	}
+}
+
+// Store is a test wrapper for tsdb.Store.
+type Store struct {
+	*tsdb.Store
+}
+
+// NewStore returns a new instance of Store with a temporary path.
+func NewStore() *Store {
+	path, err := ioutil.TempDir("", "influxdb-tsdb-")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Store{Store: tsdb.NewStore(path)}
+	s.EngineOptions.Config.WALDir = filepath.Join(path, "wal")
+	return s
+}
+
+// MustOpenStore returns a new, open Store at a temporary path.
+func MustOpenStore() *Store {
+	s := NewStore()
+	if err := s.Open(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Reopen closes and reopens the store as a new store.
+func (s *Store) Reopen() error {
+	if err := s.Store.Close(); err != nil {
+		return err
+	}
+	s.Store = tsdb.NewStore(s.Path())
+	s.EngineOptions.Config.WALDir = filepath.Join(s.Path(), "wal")
+	return s.Open()
+}
+
+// Close closes the store and removes the underlying data.
+func (s *Store) Close() error {
+	defer os.RemoveAll(s.Path())
+	return s.Store.Close()
+}
+
+// MustCreateShardWithData creates a shard and writes line protocol data to it.
+func (s *Store) MustCreateShardWithData(db, rp string, shardID int, data ...string) {
+	if err := s.CreateShard(db, rp, uint64(shardID), true); err != nil {
+		panic(err)
+	}
+	s.MustWriteToShardString(shardID, data...)
+}
+
+// MustWriteToShardString parses the line protocol (with second precision) and
+// inserts the resulting points into a shard. Panic on error.
+func (s *Store) MustWriteToShardString(shardID int, data ...string) {
+	var points []models.Point
+	for i := range data {
+		a, err := models.ParsePointsWithPrecision([]byte(strings.TrimSpace(data[i])), time.Time{}, "s")
+		if err != nil {
+			panic(err)
+		}
+		points = append(points, a...)
+	}
+
+	if err := s.WriteToShard(uint64(shardID), points); err != nil {
+		panic(err)
+	}
+}
+
+// BatchWrite writes points to a shard in chunks.
+func (s *Store) BatchWrite(shardID int, points []models.Point) error {
+	nPts := len(points)
+	chunkSz := 10000
+	start := 0
+	end := chunkSz
+
+	for {
+		if end > nPts {
+			end = nPts
+		}
+		if end-start == 0 {
+			break
+		}
+
+		if err := s.WriteToShard(uint64(shardID), points[start:end]); err != nil {
+			return err
 		}
 		start = end
 		end += chunkSz
@@ -513,6 +1822,26 @@ func (s *Store) BatchWrite(shardID int, points []models.Point) error {
 	return nil
 }
 
+// MetaClient is a mock implementation of tsdb.MetaClient,
+// tsdb.MetaSnapshotter and tsdb.ShardGroupMerger.
+type MetaClient struct {
+	ShardsFn           func() []tsdb.ShardMeta
+	RetentionPolicyFn  func(database, name string) *tsdb.RetentionPolicyMeta
+	MarshalMetadataFn  func() ([]byte, error)
+	RestoreMetadataFn  func(buf []byte) error
+	MergeShardGroupsFn func(database, policy string, ids []uint64) (func() error, error)
+}
+
+func (c *MetaClient) Shards() []tsdb.ShardMeta { return c.ShardsFn() }
+func (c *MetaClient) RetentionPolicy(database, name string) *tsdb.RetentionPolicyMeta {
+	return c.RetentionPolicyFn(database, name)
+}
+func (c *MetaClient) MarshalMetadata() ([]byte, error) { return c.MarshalMetadataFn() }
+func (c *MetaClient) RestoreMetadata(buf []byte) error { return c.RestoreMetadataFn(buf) }
+func (c *MetaClient) MergeShardGroups(database, policy string, ids []uint64) (func() error, error) {
+	return c.MergeShardGroupsFn(database, policy, ids)
+}
+
 // ParseTags returns an instance of Tags for a comma-delimited list of key/values.
 func ParseTags(s string) influxql.Tags {
 	m := make(map[string]string)